@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -16,6 +17,65 @@ type Config struct {
 	JWTSecret   string
 	CORSOrigins string
 	AppEnv      string
+	SiteURL     string
+	APIURL      string
+	SiteTitle   string
+	SiteDesc    string
+
+	// Post translations
+	DefaultLocale    string
+	SupportedLocales []string
+
+	// Media library
+	MediaDir       string
+	MediaURL       string
+	MaxUploadBytes int64
+	// Images are rejected above this many pixels, as a small file can
+	// declare dimensions that take gigabytes to decode
+	MaxImagePixels int64
+
+	// Payments
+	Currency             string
+	PaymentProvider      string
+	PaymentWebhookSecret string
+	StripeURL            string
+	StripeSecretKey      string
+	ExchangeRatesFile    string
+
+	// Taxes
+	PricesIncludeTax  bool
+	TaxDefaultCountry string
+
+	// Invoices are stored outside the public media directory
+	InvoiceDir           string
+	InvoiceSellerName    string
+	InvoiceSellerAddress []string
+	InvoiceSellerTaxID   string
+	InvoiceSellerEmail   string
+
+	// Responses to requests with an Idempotency-Key are replayed to retries
+	// for this many hours
+	IdempotencyTTLHours int64
+
+	// Returns are accepted for this many days after delivery
+	ReturnWindowDays int64
+
+	// Checkout holds stock for unpaid orders for this many minutes
+	StockReservationMinutes int64
+
+	// Product import uploads and export files are kept here, outside the
+	// public media directory
+	ProductJobDir string
+
+	// Deleted products, posts, orders and users are purged after this many
+	// days in the trash, unless something still refers to them
+	TrashRetentionDays int64
+
+	// Comment spam checks
+	AkismetURL         string
+	AkismetKey         string
+	CommentBlocklist   []string
+	CommentAutoApprove bool
 }
 
 func Load() *Config {
@@ -30,6 +90,49 @@ func Load() *Config {
 		JWTSecret:   getEnv("JWT_SECRET", "default-secret"),
 		CORSOrigins: getEnv("CORS_ORIGINS", "http://localhost:3000"),
 		AppEnv:      getEnv("APP_ENV", "development"),
+		SiteURL:     strings.TrimRight(getEnv("SITE_URL", "http://localhost:3000"), "/"),
+		APIURL:      strings.TrimRight(getEnv("API_BASE_URL", "http://localhost:"+getEnv("PORT", "8080")), "/"),
+		SiteTitle:   getEnv("SITE_TITLE", "NT App"),
+		SiteDesc:    getEnv("SITE_DESCRIPTION", "Latest posts from NT App"),
+
+		DefaultLocale:    strings.ToLower(getEnv("DEFAULT_LOCALE", "vi")),
+		SupportedLocales: splitList(strings.ToLower(getEnv("SUPPORTED_LOCALES", "vi,en"))),
+
+		MediaDir:       getEnv("MEDIA_DIR", "./uploads"),
+		MediaURL:       strings.TrimRight(getEnv("MEDIA_BASE_URL", "/media"), "/"),
+		MaxUploadBytes: getEnvInt64("MAX_UPLOAD_BYTES", 20<<20),
+		MaxImagePixels: getEnvInt64("MAX_IMAGE_PIXELS", 50_000_000),
+
+		Currency:             strings.ToUpper(getEnv("CURRENCY", "USD")),
+		PaymentProvider:      getEnv("PAYMENT_PROVIDER", "fake"),
+		PaymentWebhookSecret: getEnv("PAYMENT_WEBHOOK_SECRET", "whsec_fake_dev"),
+		StripeURL:            getEnv("STRIPE_API_URL", ""),
+		StripeSecretKey:      getEnv("STRIPE_SECRET_KEY", ""),
+		ExchangeRatesFile:    getEnv("EXCHANGE_RATES_FILE", ""),
+
+		PricesIncludeTax:  getEnv("PRICES_INCLUDE_TAX", "false") == "true",
+		TaxDefaultCountry: strings.ToUpper(getEnv("TAX_DEFAULT_COUNTRY", "")),
+
+		InvoiceDir:           getEnv("INVOICE_DIR", "./invoices"),
+		InvoiceSellerName:    getEnv("INVOICE_SELLER_NAME", getEnv("SITE_TITLE", "NT App")),
+		InvoiceSellerAddress: splitLines(getEnv("INVOICE_SELLER_ADDRESS", "")),
+		InvoiceSellerTaxID:   getEnv("INVOICE_SELLER_TAX_ID", ""),
+		InvoiceSellerEmail:   getEnv("INVOICE_SELLER_EMAIL", ""),
+
+		IdempotencyTTLHours: getEnvInt64("IDEMPOTENCY_TTL_HOURS", 24),
+
+		ReturnWindowDays: getEnvInt64("RETURN_WINDOW_DAYS", 30),
+
+		StockReservationMinutes: getEnvInt64("STOCK_RESERVATION_MINUTES", 30),
+
+		ProductJobDir: getEnv("PRODUCT_JOB_DIR", "./product-jobs"),
+
+		TrashRetentionDays: getEnvInt64("TRASH_RETENTION_DAYS", 30),
+
+		AkismetURL:         getEnv("AKISMET_URL", ""),
+		AkismetKey:         getEnv("AKISMET_API_KEY", ""),
+		CommentBlocklist:   splitList(getEnv("COMMENT_BLOCKLIST", "")),
+		CommentAutoApprove: getEnv("COMMENT_AUTO_APPROVE", "false") == "true",
 	}
 }
 
@@ -40,6 +143,34 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitLines splits a multi-line value written on one line with "|"
+func splitLines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, "|") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
 func (c *Config) GetCORSOrigins() []string {
 	return strings.Split(c.CORSOrigins, ",")
 }
@@ -33,7 +33,7 @@ func Connect(cfg *config.Config) (*gorm.DB, error) {
 	}
 
 	// Auto-migrate models
-	if err := AutoMigrate(db); err != nil {
+	if err := AutoMigrate(db, cfg.Currency); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
@@ -41,15 +41,63 @@ func Connect(cfg *config.Config) (*gorm.DB, error) {
 	return db, nil
 }
 
-func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+// AutoMigrate brings the schema up to date; currency is the shop's base
+// currency, which amounts stored before multi-currency support are in
+func AutoMigrate(db *gorm.DB, currency string) error {
+	if err := renameLegacyColumns(db); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Post{},
+		&models.Tag{},
 		&models.Comment{},
+		&models.PostTranslation{},
+		&models.MediaAsset{},
+		&models.MediaReference{},
+		&models.Reaction{},
+		&models.Bookmark{},
+		&models.Category{},
 		&models.Product{},
+		&models.ProductPrice{},
+		&models.ProductOption{},
+		&models.ProductVariant{},
+		&models.PriceHistory{},
+		&models.PriceSchedule{},
+		&models.Warehouse{},
+		&models.InventoryLevel{},
+		&models.StockMovement{},
+		&models.StockReservation{},
+		&models.StockAlert{},
+		&models.Review{},
+		&models.ReviewVote{},
+		&models.ProductJob{},
+		&models.ProductJobError{},
+		&models.ExchangeRate{},
+		&models.TaxRate{},
+		&models.Address{},
+		&models.ShippingZone{},
+		&models.ShippingMethod{},
+		&models.ShippingRate{},
+		&models.Invoice{},
+		&models.DocumentSequence{},
 		&models.Order{},
 		&models.OrderItem{},
+		&models.OrderEvent{},
+		&models.ReturnRequest{},
+		&models.ReturnItem{},
+		&models.Cart{},
+		&models.CartItem{},
+		&models.Promotion{},
+		&models.OrderDiscount{},
+		&models.PromotionRedemption{},
+		&models.Payment{},
+		&models.WebhookEvent{},
+		&models.IdempotencyKey{},
 		&models.AnalyticsEvent{},
 		&models.CVE{},
-	)
+	); err != nil {
+		return err
+	}
+	return migrateLegacy(db, currency)
 }
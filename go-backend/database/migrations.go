@@ -0,0 +1,249 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/search"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// legacyMoneyColumn is a decimal(10,2) amount replaced by an embedded
+// money.Money with <prefix>amount and <prefix>currency columns
+type legacyMoneyColumn struct {
+	table, column, prefix string
+}
+
+var legacyMoneyColumns = []legacyMoneyColumn{
+	{"products", "price", "price_"},
+	{"cart_items", "added_price", "added_price_"},
+	{"orders", "subtotal", "subtotal_"},
+	{"orders", "discount_total", "discount_total_"},
+	{"orders", "total_amount", "total_"},
+	{"order_items", "price", "price_"},
+	{"order_items", "line_total", "line_total_"},
+	{"order_items", "discount", "discount_"},
+	{"promotions", "min_order_value", "min_order_"},
+	{"order_discounts", "amount", "discount_"},
+	{"payments", "amount", "intent_"},
+	{"payments", "amount_captured", "captured_"},
+	{"payments", "amount_refunded", "refunded_"},
+}
+
+// renameLegacyColumns runs before AutoMigrate and moves decimal amount
+// columns out of the way as legacy_<column>, since some new money columns
+// (orders.total_amount) reuse their names
+func renameLegacyColumns(db *gorm.DB) error {
+	m := db.Migrator()
+	for _, c := range legacyMoneyColumns {
+		if !m.HasTable(c.table) || !m.HasColumn(c.table, c.column) || m.HasColumn(c.table, c.prefix+"currency") {
+			continue
+		}
+		if err := m.RenameColumn(c.table, c.column, "legacy_"+c.column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLegacy moves data out of columns that newer models replaced. Each
+// step checks for the old column first so it is safe to run on every start.
+// currency is what legacy decimal amounts were priced in.
+func migrateLegacy(db *gorm.DB, currency string) error {
+	m := db.Migrator()
+
+	// comments.is_approved became the comments.status moderation state
+	if m.HasColumn("comments", "is_approved") {
+		if err := db.Exec("UPDATE comments SET status = 'approved' WHERE is_approved = true").Error; err != nil {
+			return err
+		}
+		if err := m.DropColumn("comments", "is_approved"); err != nil {
+			return err
+		}
+	}
+
+	// Decimal amounts became integer minor units with a currency
+	scale := math.Pow10(money.Exponent(currency))
+	for _, c := range legacyMoneyColumns {
+		legacy := "legacy_" + c.column
+		if !m.HasColumn(c.table, legacy) {
+			continue
+		}
+		currencyExpr := "?"
+		if c.table == "payments" {
+			// Payments always recorded their currency
+			currencyExpr = "COALESCE(NULLIF(currency, ''), ?)"
+		}
+		sql := fmt.Sprintf("UPDATE %s SET %samount = ROUND(COALESCE(%s, 0) * ?), %scurrency = %s",
+			c.table, c.prefix, legacy, c.prefix, currencyExpr)
+		if err := db.Exec(sql, scale, currency).Error; err != nil {
+			return err
+		}
+		if err := m.DropColumn(c.table, legacy); err != nil {
+			return err
+		}
+	}
+
+	// Fixed amount promotions kept their amount in value
+	if err := db.Exec("UPDATE promotions SET off_amount = ROUND(value * ?), off_currency = ?, value = 0 WHERE type = 'fixed_amount' AND COALESCE(off_currency, '') = ''",
+		scale, currency).Error; err != nil {
+		return err
+	}
+	if err := db.Exec("UPDATE orders SET currency = total_currency WHERE COALESCE(currency, '') = ''").Error; err != nil {
+		return err
+	}
+
+	// Orders started keeping a running refunded total
+	if err := db.Exec(`UPDATE orders o SET refunded_total_currency = o.currency,
+		refunded_total_amount = COALESCE((SELECT SUM(p.refunded_amount) FROM payments p WHERE p.order_id = o.id), 0)
+		WHERE COALESCE(o.refunded_total_currency, '') = ''`).Error; err != nil {
+		return err
+	}
+
+	// Payments started counting refunds when they are requested
+	if err := db.Exec(`UPDATE payments SET refund_requested_amount = COALESCE(refunded_amount, 0), refund_requested_currency = currency
+		WHERE COALESCE(refund_requested_currency, '') = ''`).Error; err != nil {
+		return err
+	}
+
+	// Cart lines became unique per product variant
+	if m.HasIndex("cart_items", "idx_cart_items_cart_product") {
+		if err := m.DropIndex("cart_items", "idx_cart_items_cart_product"); err != nil {
+			return err
+		}
+	}
+
+	// Stock moved into per-warehouse inventory levels. The first start
+	// creates a main warehouse holding what products and variants had.
+	if err := seedWarehouse(db); err != nil {
+		return err
+	}
+
+	// Free text product categories became top level categories of the
+	// category tree
+	if err := fileLegacyCategories(db); err != nil {
+		return err
+	}
+
+	// Price history starts with the prices products and variants have
+	if err := seedPriceHistory(db); err != nil {
+		return err
+	}
+
+	// Product SKUs are optional but unique when set
+	if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_products_sku ON products (sku) WHERE sku <> ''").Error; err != nil {
+		return err
+	}
+
+	// One price list entry per product and currency
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_product_prices_product_currency ON product_prices (product_id, price_currency)").Error
+}
+
+// seedWarehouse creates the MAIN warehouse when there is none and books
+// the existing product and variant stock into it as opening balances
+func seedWarehouse(db *gorm.DB) error {
+	var warehouses int64
+	if err := db.Table("warehouses").Count(&warehouses).Error; err != nil {
+		return err
+	}
+	if warehouses > 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO warehouses (id, code, name, priority, is_active, created_at, updated_at)
+			VALUES (gen_random_uuid(), 'MAIN', 'Main warehouse', 0, true, NOW(), NOW())`).Error; err != nil {
+			return err
+		}
+		// Products without variants keep stock on the product, the others
+		// per variant
+		items := `SELECT p.id AS product_id, '00000000-0000-0000-0000-000000000000'::uuid AS variant_id, p.stock FROM products p
+			WHERE p.stock > 0 AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)
+			UNION ALL
+			SELECT v.product_id, v.id, v.stock FROM product_variants v WHERE v.stock > 0`
+		if err := tx.Exec(`INSERT INTO inventory_levels (id, warehouse_id, product_id, variant_id, on_hand, reserved, low_stock_threshold, created_at, updated_at)
+			SELECT gen_random_uuid(), w.id, i.product_id, i.variant_id, i.stock, 0, 0, NOW(), NOW()
+			FROM (` + items + `) i, warehouses w WHERE w.code = 'MAIN'`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`INSERT INTO stock_movements (id, warehouse_id, product_id, variant_id, type, quantity, on_hand_after, note, created_at)
+			SELECT gen_random_uuid(), l.warehouse_id, l.product_id, NULLIF(l.variant_id, '00000000-0000-0000-0000-000000000000'::uuid),
+				'adjustment', l.on_hand, l.on_hand, 'Opening balance', NOW()
+			FROM inventory_levels l`).Error
+	})
+}
+
+// fileLegacyCategories files products that only have a category name under
+// the category of that name, creating top level categories as needed
+func fileLegacyCategories(db *gorm.DB) error {
+	var names []string
+	if err := db.Table("products").
+		Where("category_id IS NULL AND TRIM(category) <> ''").
+		Distinct().Pluck("TRIM(category)", &names).Error; err != nil {
+		return err
+	}
+	for _, name := range names {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var category struct {
+				ID   uuid.UUID
+				Name string
+			}
+			if err := tx.Table("categories").Select("id, name").
+				Where("LOWER(name) = LOWER(?)", name).Order("path").Limit(1).
+				Scan(&category).Error; err != nil {
+				return err
+			}
+			if category.ID == uuid.Nil {
+				slug := search.Slug(name)
+				if slug == "" {
+					slug = "category"
+				}
+				base := strings.TrimSuffix(slug[:min(len(slug), 90)], "-")
+				for i := 2; ; i++ {
+					var taken int64
+					if err := tx.Table("categories").Where("slug = ?", slug).Count(&taken).Error; err != nil {
+						return err
+					}
+					if taken == 0 {
+						break
+					}
+					slug = fmt.Sprintf("%s-%d", base, i)
+				}
+				category.ID, category.Name = uuid.New(), name
+				if err := tx.Exec(`INSERT INTO categories (id, name, slug, path, description, position, created_at, updated_at)
+					VALUES (?, ?, ?, ?, '', 0, NOW(), NOW())`, category.ID, name, slug, slug).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Table("products").
+				Where("category_id IS NULL AND TRIM(category) = ?", name).
+				Updates(map[string]any{"category_id": category.ID, "category": category.Name}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedPriceHistory records the current price of products without any price
+// history, and that of their variants, as of their last update
+func seedPriceHistory(db *gorm.DB) error {
+	// One statement, so that neither half sees the other's rows
+	return db.Exec(`INSERT INTO price_histories (id, product_id, variant_id, price_amount, price_currency, source, effective_at, created_at)
+		SELECT gen_random_uuid(), p.id, NULL::uuid, p.price_amount, p.price_currency, ?, p.updated_at, NOW()
+		FROM products p
+		WHERE NOT EXISTS (SELECT 1 FROM price_histories h WHERE h.product_id = p.id)
+		UNION ALL
+		SELECT gen_random_uuid(), v.product_id, v.id,
+			CASE WHEN COALESCE(v.price_currency, '') = '' THEN p.price_amount ELSE v.price_amount END,
+			CASE WHEN COALESCE(v.price_currency, '') = '' THEN p.price_currency ELSE v.price_currency END,
+			?, p.updated_at, NOW()
+		FROM product_variants v JOIN products p ON p.id = v.product_id
+		WHERE NOT EXISTS (SELECT 1 FROM price_histories h WHERE h.product_id = p.id)`,
+		models.PriceSourceInitial, models.PriceSourceInitial).Error
+}
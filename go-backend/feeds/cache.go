@@ -0,0 +1,87 @@
+package feeds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a rendered document together with its validators
+type Entry struct {
+	Body         []byte
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	cachedAt     time.Time
+}
+
+// NewEntry builds an entry with a strong ETag derived from the body
+func NewEntry(body []byte, contentType string, lastModified time.Time) *Entry {
+	sum := sha256.Sum256(body)
+	return &Entry{
+		Body:         body,
+		ContentType:  contentType,
+		ETag:         `"` + hex.EncodeToString(sum[:16]) + `"`,
+		LastModified: lastModified.UTC().Truncate(time.Second),
+	}
+}
+
+// NotModified reports whether a request carrying the given If-None-Match and
+// If-Modified-Since headers already has this version. If-None-Match wins when
+// both are present, as RFC 9110 requires.
+func (e *Entry) NotModified(ifNoneMatch, ifModifiedSince string) bool {
+	if ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == e.ETag {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince != "" && !e.LastModified.IsZero() {
+		if since, err := http.ParseTime(ifModifiedSince); err == nil {
+			return !e.LastModified.After(since)
+		}
+	}
+	return false
+}
+
+// Cache keeps rendered feeds until the next invalidation. Entries also expire
+// after ttl so posts scheduled for a future publish time show up on their own.
+type Cache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]*Entry
+}
+
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, entries: map[string]*Entry{}}
+}
+
+func (c *Cache) Get(key string) (*Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.cachedAt) > c.ttl {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *Cache) Set(key string, e *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.cachedAt = time.Now()
+	c.entries[key] = e
+}
+
+// Invalidate drops every cached document
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*Entry{}
+}
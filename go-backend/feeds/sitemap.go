@@ -0,0 +1,52 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// MaxSitemapURLs is the protocol limit of URLs in a single sitemap file
+const MaxSitemapURLs = 50000
+
+// SitemapURL is one <url> (or <sitemap> in an index) entry
+type SitemapURL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapLoc `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func toLocs(urls []SitemapURL) []sitemapLoc {
+	locs := make([]sitemapLoc, 0, len(urls))
+	for _, u := range urls {
+		loc := sitemapLoc{Loc: u.Loc}
+		if !u.LastMod.IsZero() {
+			loc.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		locs = append(locs, loc)
+	}
+	return locs
+}
+
+// Sitemap renders a <urlset> document
+func Sitemap(urls []SitemapURL) ([]byte, error) {
+	return marshalXML(urlSet{URLs: toLocs(urls)})
+}
+
+// SitemapIndex renders a <sitemapindex> document pointing at child sitemaps
+func SitemapIndex(sitemaps []SitemapURL) ([]byte, error) {
+	return marshalXML(sitemapIndex{Sitemaps: toLocs(sitemaps)})
+}
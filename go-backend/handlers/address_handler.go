@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+
+	"go-backend/models"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AddressHandler manages the current user's address book
+type AddressHandler struct {
+	db *gorm.DB
+}
+
+func NewAddressHandler(db *gorm.DB) *AddressHandler {
+	return &AddressHandler{db: db}
+}
+
+// GetMyAddresses lists the user's addresses, the default first
+func (h *AddressHandler) GetMyAddresses(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	addresses := []models.Address{}
+	if err := h.db.Where("user_id = ?", userID).Order("is_default DESC, created_at ASC").Find(&addresses).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch addresses"})
+	}
+	return c.JSON(addresses)
+}
+
+// CreateAddress adds an address; the first one becomes the default
+func (h *AddressHandler) CreateAddress(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var address models.Address
+	if err := c.BodyParser(&address); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	address.ID = uuid.New()
+	address.UserID = userID
+	if err := services.ValidateAddress(&address.PostalAddress); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Address{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			address.IsDefault = true
+		}
+		if err := tx.Create(&address).Error; err != nil {
+			return err
+		}
+		return h.keepSingleDefault(tx, &address)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create address"})
+	}
+	return c.Status(201).JSON(address)
+}
+
+func (h *AddressHandler) UpdateAddress(c *fiber.Ctx) error {
+	address, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+	id, userID := address.ID, address.UserID
+	if err := c.BodyParser(address); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	address.ID, address.UserID = id, userID
+	if err := services.ValidateAddress(&address.PostalAddress); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(address).Error; err != nil {
+			return err
+		}
+		return h.keepSingleDefault(tx, address)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update address"})
+	}
+	return c.JSON(address)
+}
+
+// DeleteAddress removes an address. Orders keep their own copy, so nothing
+// else changes; if it was the default, the oldest remaining one takes over.
+func (h *AddressHandler) DeleteAddress(c *fiber.Ctx) error {
+	address, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(address).Error; err != nil {
+			return err
+		}
+		if !address.IsDefault {
+			return nil
+		}
+		var next models.Address
+		err := tx.Where("user_id = ?", address.UserID).Order("created_at ASC").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&next).Update("is_default", true).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete address"})
+	}
+	return c.JSON(fiber.Map{"message": "Address deleted successfully"})
+}
+
+// find loads one of the current user's addresses, writing the error
+// response when it isn't theirs or doesn't exist
+func (h *AddressHandler) find(c *fiber.Ctx) (*models.Address, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+		return nil, false
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid address ID"})
+		return nil, false
+	}
+	var address models.Address
+	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&address).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Address not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return nil, false
+	}
+	return &address, true
+}
+
+// keepSingleDefault clears the default flag on the user's other addresses
+// when address is the default
+func (h *AddressHandler) keepSingleDefault(tx *gorm.DB, address *models.Address) error {
+	if !address.IsDefault {
+		return nil
+	}
+	return tx.Model(&models.Address{}).
+		Where("user_id = ? AND id <> ? AND is_default = ?", address.UserID, address.ID, true).
+		Update("is_default", false).Error
+}
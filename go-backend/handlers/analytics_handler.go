@@ -1,24 +1,28 @@
 package handlers
 
 import (
+	"encoding/json"
 	"go-backend/models"
+	"go-backend/services"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type AnalyticsHandler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	views *services.ViewCounter
 }
 
-func NewAnalyticsHandler(db *gorm.DB) *AnalyticsHandler {
-	return &AnalyticsHandler{db: db}
+func NewAnalyticsHandler(db *gorm.DB, views *services.ViewCounter) *AnalyticsHandler {
+	return &AnalyticsHandler{db: db, views: views}
 }
 
 func (h *AnalyticsHandler) GetAnalytics(c *fiber.Ctx) error {
 	var events []models.AnalyticsEvent
-	if err := h.db.Preload("User").Find(&events).Error; err != nil {
+	if err := h.db.Preload("User", withTrashed).Find(&events).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch analytics events"})
 	}
 	return c.JSON(events)
@@ -34,6 +38,18 @@ func (h *AnalyticsHandler) CreateEvent(c *fiber.Ctx) error {
 	event.IPAddress = c.IP()
 	event.UserAgent = c.Get("User-Agent")
 
+	// Post views are deduplicated and written in batches by the view counter
+	if event.EventType == services.EventPostView && h.views != nil {
+		var data struct {
+			PostID uuid.UUID `json:"post_id"`
+		}
+		if err := json.Unmarshal([]byte(event.EventData), &data); err != nil || data.PostID == uuid.Nil {
+			return c.Status(400).JSON(fiber.Map{"error": "post_view events need event_data.post_id"})
+		}
+		counted := h.views.Record(data.PostID, event)
+		return c.Status(202).JSON(fiber.Map{"counted": counted})
+	}
+
 	if err := h.db.Create(&event).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create analytics event"})
 	}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"go-backend/models"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Anonymous carts are identified by a token the client sends back in the
+// X-Cart-Token header or the cart_token cookie
+const (
+	cartTokenHeader = "X-Cart-Token"
+	cartTokenCookie = "cart_token"
+)
+
+type CartHandler struct {
+	db    *gorm.DB
+	carts *services.Carts
+}
+
+func NewCartHandler(db *gorm.DB, carts *services.Carts) *CartHandler {
+	return &CartHandler{db: db, carts: carts}
+}
+
+// GetCart returns the current shopper's cart with live prices and stock
+func (h *CartHandler) GetCart(c *fiber.Ctx) error {
+	cart, err := h.carts.Find(c.UserContext(), optionalUserID(c), cartToken(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if cart == nil {
+		return c.JSON(services.CartView{Lines: []services.CartLine{}})
+	}
+	return h.respond(c, 200, cart)
+}
+
+// AddCartItem adds a product to the cart, creating the cart if needed
+func (h *CartHandler) AddCartItem(c *fiber.Ctx) error {
+	var input struct {
+		ProductID uuid.UUID  `json:"product_id"`
+		VariantID *uuid.UUID `json:"variant_id"`
+		Quantity  int        `json:"quantity"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.ProductID == uuid.Nil {
+		return c.Status(400).JSON(fiber.Map{"error": "product_id is required"})
+	}
+	if input.Quantity == 0 {
+		input.Quantity = 1
+	}
+
+	cart, err := h.carts.FindOrCreate(c.UserContext(), optionalUserID(c), cartToken(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create cart"})
+	}
+	if err := h.carts.AddItem(c.UserContext(), cart, input.ProductID, input.VariantID, input.Quantity); err != nil {
+		return cartError(c, err)
+	}
+	return h.respond(c, 200, cart)
+}
+
+// UpdateCartItem sets a line's quantity; zero removes it. Lines of
+// products with variants are picked with ?variant_id=.
+func (h *CartHandler) UpdateCartItem(c *fiber.Ctx) error {
+	productID, variantID, ok := cartItemParams(c)
+	if !ok {
+		return nil
+	}
+	var input struct {
+		Quantity *int `json:"quantity"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Quantity == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "quantity is required"})
+	}
+
+	cart, ok := h.existingCart(c)
+	if !ok {
+		return nil
+	}
+	if err := h.carts.SetQuantity(c.UserContext(), cart, productID, variantID, *input.Quantity); err != nil {
+		return cartError(c, err)
+	}
+	return h.respond(c, 200, cart)
+}
+
+// RemoveCartItem drops a product, or one ?variant_id= of it, from the cart
+func (h *CartHandler) RemoveCartItem(c *fiber.Ctx) error {
+	productID, variantID, ok := cartItemParams(c)
+	if !ok {
+		return nil
+	}
+
+	cart, ok := h.existingCart(c)
+	if !ok {
+		return nil
+	}
+	if err := h.carts.RemoveItem(c.UserContext(), cart, productID, variantID); err != nil {
+		return cartError(c, err)
+	}
+	return h.respond(c, 200, cart)
+}
+
+// SetCartCurrency switches the currency the cart is priced and checked
+// out in
+func (h *CartHandler) SetCartCurrency(c *fiber.Ctx) error {
+	var input struct {
+		Currency string `json:"currency"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Currency == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "currency is required"})
+	}
+
+	cart, err := h.carts.FindOrCreate(c.UserContext(), optionalUserID(c), cartToken(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create cart"})
+	}
+	if err := h.carts.SetCurrency(c.UserContext(), cart, input.Currency); err != nil {
+		return cartError(c, err)
+	}
+	return h.respond(c, 200, cart)
+}
+
+// CheckoutCart turns the signed-in user's cart into an order and empties it
+func (h *CartHandler) CheckoutCart(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	var opts services.CheckoutOptions
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&opts); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	// Pick up anything added anonymously in this browser before signing in
+	if token := cartToken(c); token != "" {
+		if err := h.carts.Merge(c.UserContext(), userID, token); err != nil {
+			log.Printf("ERROR: failed to merge cart into user %s - %v", userID, err)
+		}
+		clearCartToken(c)
+	}
+
+	order, err := h.carts.Checkout(c.UserContext(), userID, opts)
+	if err != nil {
+		if errors.Is(err, services.ErrEmptyOrder) {
+			return c.Status(400).JSON(fiber.Map{"error": "Cart is empty"})
+		}
+		return checkoutError(c, err)
+	}
+
+	h.db.Preload("Items").Preload("Items.Product", withTrashed).Preload("Discounts").First(order, order.ID)
+	return c.Status(201).JSON(order)
+}
+
+// MergeOnLogin moves the request's anonymous cart into the user's cart; it
+// is registered as a login hook
+func (h *CartHandler) MergeOnLogin(c *fiber.Ctx, user models.User) {
+	token := cartToken(c)
+	if token == "" {
+		return
+	}
+	if err := h.carts.Merge(c.UserContext(), user.ID, token); err != nil {
+		log.Printf("ERROR: failed to merge cart into user %s - %v", user.ID, err)
+		return
+	}
+	clearCartToken(c)
+}
+
+// existingCart loads the shopper's cart, writing a 404 when there is none
+func (h *CartHandler) existingCart(c *fiber.Ctx) (*models.Cart, bool) {
+	cart, err := h.carts.Find(c.UserContext(), optionalUserID(c), cartToken(c))
+	if err != nil {
+		c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+		return nil, false
+	}
+	if cart == nil {
+		c.Status(404).JSON(fiber.Map{"error": "Cart not found"})
+		return nil, false
+	}
+	return cart, true
+}
+
+// respond writes the cart view and, for anonymous carts, hands the token
+// back so the client can keep using the cart
+func (h *CartHandler) respond(c *fiber.Ctx, status int, cart *models.Cart) error {
+	view, err := h.carts.View(c.UserContext(), cart)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if cart.UserID == nil {
+		c.Set(cartTokenHeader, cart.Token)
+		c.Cookie(&fiber.Cookie{
+			Name:     cartTokenCookie,
+			Value:    cart.Token,
+			Path:     "/",
+			Expires:  cart.ExpiresAt,
+			HTTPOnly: true,
+			SameSite: "Lax",
+		})
+	}
+	return c.Status(status).JSON(view)
+}
+
+func cartToken(c *fiber.Ctx) string {
+	if token := c.Get(cartTokenHeader); token != "" {
+		return token
+	}
+	return c.Cookies(cartTokenCookie)
+}
+
+func clearCartToken(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{Name: cartTokenCookie, Path: "/", Expires: time.Unix(0, 0), HTTPOnly: true, SameSite: "Lax"})
+}
+
+// cartItemParams reads the :productId and optional ?variant_id= of a cart
+// line route, writing the error response when either is invalid
+func cartItemParams(c *fiber.Ctx) (uuid.UUID, *uuid.UUID, bool) {
+	productID, err := uuid.Parse(c.Params("productId"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+		return uuid.Nil, nil, false
+	}
+	if c.Query("variant_id") == "" {
+		return productID, nil, true
+	}
+	variantID, err := uuid.Parse(c.Query("variant_id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid variant ID"})
+		return uuid.Nil, nil, false
+	}
+	return productID, &variantID, true
+}
+
+func cartError(c *fiber.Ctx, err error) error {
+	var lineErr *services.CheckoutError
+	switch {
+	case errors.Is(err, services.ErrCartItemNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Product is not in the cart"})
+	case errors.Is(err, services.ErrUnsupportedCurrency):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.As(err, &lineErr):
+		line := lineErr.Lines[0]
+		status := 409
+		switch line.Code {
+		case services.LineInvalidQuantity, services.LineVariantRequired:
+			status = 400
+		case services.LineNotFound:
+			status = 404
+		}
+		return c.Status(status).JSON(fiber.Map{"error": line.Message, "code": line.Code, "available": line.Available})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to update cart"})
+}
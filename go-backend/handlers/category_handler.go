@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"go-backend/models"
+	"go-backend/search"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CategoryHandler manages the product category tree
+type CategoryHandler struct {
+	db      *gorm.DB
+	catalog *services.Catalog
+}
+
+func NewCategoryHandler(db *gorm.DB, catalog *services.Catalog) *CategoryHandler {
+	return &CategoryHandler{db: db, catalog: catalog}
+}
+
+// GetCategories returns the category tree, each level in position order
+func (h *CategoryHandler) GetCategories(c *fiber.Ctx) error {
+	var categories []models.Category
+	if err := h.db.Order("position, name").Find(&categories).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch categories"})
+	}
+	return c.JSON(categoryTree(categories, nil))
+}
+
+// GetCategory returns a category by slug with its subtree and its
+// ancestors from the root down as "breadcrumbs"
+func (h *CategoryHandler) GetCategory(c *fiber.Ctx) error {
+	var category models.Category
+	if err := h.db.Where("slug = ?", c.Params("slug")).First(&category).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	var subtree []models.Category
+	if err := h.db.Where("path LIKE ?", category.Path+"/%").Order("position, name").Find(&subtree).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch categories"})
+	}
+	category.Children = categoryTree(subtree, &category.ID)
+
+	breadcrumbs := []models.Category{}
+	if ancestors := strings.Split(category.Path, "/"); len(ancestors) > 1 {
+		var paths []string
+		for i := 1; i < len(ancestors); i++ {
+			paths = append(paths, strings.Join(ancestors[:i], "/"))
+		}
+		if err := h.db.Where("path IN ?", paths).Order("path").Find(&breadcrumbs).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch categories"})
+		}
+	}
+	return c.JSON(fiber.Map{"category": category, "breadcrumbs": breadcrumbs})
+}
+
+func (h *CategoryHandler) CreateCategory(c *fiber.Ctx) error {
+	var category models.Category
+	if err := c.BodyParser(&category); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	category.ID = uuid.New()
+	category.Children = nil
+	if status, msg := h.validateCategory(&category); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+
+	if err := h.db.Create(&category).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create category"})
+	}
+	return c.Status(201).JSON(category)
+}
+
+// UpdateCategory renames or moves a category. Moving it takes its
+// subtree along; products keep their category.
+func (h *CategoryHandler) UpdateCategory(c *fiber.Ctx) error {
+	var category models.Category
+	if !h.find(c, &category) {
+		return nil
+	}
+	id, oldPath, oldName := category.ID, category.Path, category.Name
+	if err := c.BodyParser(&category); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	category.ID = id
+	category.Children = nil
+	if status, msg := h.validateCategory(&category); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if strings.HasPrefix(category.Path, oldPath+"/") {
+		return c.Status(400).JSON(fiber.Map{"error": "A category can't be moved under itself"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&category).Error; err != nil {
+			return err
+		}
+		if category.Path != oldPath {
+			if err := tx.Exec("UPDATE categories SET path = ? || SUBSTRING(path FROM ?) WHERE path LIKE ?",
+				category.Path, len(oldPath)+1, oldPath+"/%").Error; err != nil {
+				return err
+			}
+		}
+		if category.Name != oldName {
+			return tx.Model(&models.Product{}).Where("category_id = ?", category.ID).Update("category", category.Name).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update category"})
+	}
+	if category.Path != oldPath || category.Name != oldName {
+		h.reindex()
+	}
+	return c.JSON(category)
+}
+
+// DeleteCategory removes a category without subcategories; its products
+// become uncategorized
+func (h *CategoryHandler) DeleteCategory(c *fiber.Ctx) error {
+	var category models.Category
+	if !h.find(c, &category) {
+		return nil
+	}
+	var children int64
+	h.db.Model(&models.Category{}).Where("parent_id = ?", category.ID).Count(&children)
+	if children > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Move or delete the subcategories first"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Product{}).Where("category_id = ?", category.ID).
+			Updates(map[string]any{"category_id": nil, "category": ""}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&category).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete category"})
+	}
+	h.reindex()
+	return c.JSON(fiber.Map{"message": "Category deleted successfully"})
+}
+
+func (h *CategoryHandler) find(c *fiber.Ctx, category *models.Category) bool {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid category ID"})
+		return false
+	}
+	if err := h.db.First(category, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return false
+	}
+	return true
+}
+
+// validateCategory fills the slug from the name when empty, sets the path
+// under the parent and returns the status and message of the first
+// problem, or "" when it is valid
+func (h *CategoryHandler) validateCategory(category *models.Category) (int, string) {
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		return 400, "Name is required"
+	}
+	if category.Slug == "" {
+		category.Slug = search.Slug(category.Name)
+	}
+	if category.Slug == "" || category.Slug != search.Slug(category.Slug) || len(category.Slug) > 100 {
+		return 400, "Slugs are lowercase letters, digits and dashes"
+	}
+
+	category.Path = category.Slug
+	if category.ParentID != nil {
+		var parent models.Category
+		if err := h.db.First(&parent, *category.ParentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 400, "Parent category not found"
+			}
+			return 500, "Database error"
+		}
+		category.Path = parent.Path + "/" + category.Slug
+	}
+
+	var count int64
+	h.db.Model(&models.Category{}).Where("slug = ? AND id <> ?", category.Slug, category.ID).Count(&count)
+	if count > 0 {
+		return 409, "A category with this slug already exists"
+	}
+	return 0, ""
+}
+
+// reindex rebuilds the search index in the background after changes that
+// move products between category subtrees
+func (h *CategoryHandler) reindex() {
+	go func() {
+		if _, err := h.catalog.Reindex(context.Background()); err != nil {
+			log.Printf("ERROR: failed to reindex the catalog - %v", err)
+		}
+	}()
+}
+
+// categoryTree nests categories under parent; categories keep their order
+func categoryTree(categories []models.Category, parent *uuid.UUID) []models.Category {
+	tree := []models.Category{}
+	for _, category := range categories {
+		if (parent == nil) != (category.ParentID == nil) || (parent != nil && *parent != *category.ParentID) {
+			continue
+		}
+		category.Children = categoryTree(categories, &category.ID)
+		tree = append(tree, category)
+	}
+	return tree
+}
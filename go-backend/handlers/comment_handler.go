@@ -1,45 +1,277 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-backend/config"
 	"go-backend/models"
+	"go-backend/spam"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+const (
+	// maxCommentDepth is the deepest reply level; depth 0 is a top-level comment
+	maxCommentDepth = 4
+	// maxCommentLength caps comment bodies in bytes
+	maxCommentLength = 10000
+)
+
 type CommentHandler struct {
-	db *gorm.DB
+	db          *gorm.DB
+	cfg         *config.Config
+	spam        spam.SpamChecker
+	autoApprove bool
+}
+
+func NewCommentHandler(db *gorm.DB, cfg *config.Config) *CommentHandler {
+	h := &CommentHandler{db: db, cfg: cfg, autoApprove: cfg.CommentAutoApprove}
+	h.spam = h.newSpamPipeline()
+	return h
+}
+
+// newSpamPipeline orders checks cheapest first: rate limits and local
+// heuristics run before the optional remote Akismet call
+func (h *CommentHandler) newSpamPipeline() *spam.Pipeline {
+	checkers := []spam.SpamChecker{
+		spam.NewRateLimiter(
+			[]spam.Limit{{Max: 5, Window: time.Minute}, {Max: 30, Window: time.Hour}},
+			[]spam.Limit{{Max: 3, Window: time.Minute}, {Max: 20, Window: time.Hour}},
+		),
+		spam.NewBlocklistChecker(h.cfg.CommentBlocklist),
+		spam.NewLinkChecker(2, 5),
+		spam.NewDuplicateChecker(h.countFingerprint, 24*time.Hour, 20),
+	}
+	if h.cfg.AkismetKey != "" {
+		checkers = append(checkers, spam.NewAkismetChecker(h.cfg.AkismetURL, h.cfg.AkismetKey, h.cfg.SiteURL))
+	}
+	return spam.NewPipeline(checkers...)
+}
+
+// countFingerprint counts recent comments with the same text by the same
+// author, or from the same IP on the same post
+func (h *CommentHandler) countFingerprint(ctx context.Context, in *spam.Input, since time.Time) (int64, error) {
+	query := h.db.WithContext(ctx).Model(&models.Comment{}).
+		Where("content_fingerprint = ? AND created_at >= ? AND status <> ?", in.Fingerprint, since, models.CommentStatusRejected).
+		Where("author_id = ? OR (ip_address = ? AND post_id = ?)", in.AuthorID, in.IP, in.PostID)
+	if in.ID != "" {
+		query = query.Where("id <> ?", in.ID)
+	}
+	var n int64
+	err := query.Count(&n).Error
+	return n, err
+}
+
+// statusForVerdict maps a spam verdict onto the comment moderation state
+func (h *CommentHandler) statusForVerdict(v spam.Verdict) string {
+	switch v {
+	case spam.Ham:
+		if h.autoApprove {
+			return models.CommentStatusApproved
+		}
+		return models.CommentStatusPending
+	case spam.Spam:
+		return models.CommentStatusSpam
+	case spam.Reject:
+		return models.CommentStatusRejected
+	}
+	return models.CommentStatusPending
 }
 
-func NewCommentHandler(db *gorm.DB) *CommentHandler {
-	return &CommentHandler{db: db}
+// CommentNode is a comment together with its visible replies
+type CommentNode struct {
+	models.Comment
+	Replies []*CommentNode `json:"replies"`
 }
 
-func (h *CommentHandler) GetComments(c *fiber.Ctx) error {
+// GetPostComments returns the approved comments of a post as a reply tree,
+// or in thread order with ?format=flat
+func (h *CommentHandler) GetPostComments(c *fiber.Ctx) error {
+	postID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+
 	var comments []models.Comment
-	if err := h.db.Preload("Author").Preload("Post").Find(&comments).Error; err != nil {
+	if err := h.db.Preload("Author", publicAuthor).
+		Where("post_id = ?", postID).
+		Order("thread_path ASC").
+		Find(&comments).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comments"})
 	}
-	return c.JSON(comments)
+
+	if c.Query("format") == "flat" {
+		visible := make([]models.Comment, 0, len(comments))
+		for _, comment := range comments {
+			if comment.Status == models.CommentStatusApproved {
+				visible = append(visible, comment)
+			}
+		}
+		return c.JSON(fiber.Map{"comments": visible, "total": len(visible)})
+	}
+
+	roots, total := buildThread(comments)
+	return c.JSON(fiber.Map{"comments": roots, "total": total})
+}
+
+// buildThread nests approved comments under their nearest approved ancestor.
+// comments must be ordered by thread path so parents precede their replies.
+func buildThread(comments []models.Comment) ([]*CommentNode, int) {
+	byID := make(map[uuid.UUID]*models.Comment, len(comments))
+	for i := range comments {
+		byID[comments[i].ID] = &comments[i]
+	}
+
+	nodes := map[uuid.UUID]*CommentNode{}
+	roots := []*CommentNode{}
+	for _, comment := range comments {
+		if comment.Status != models.CommentStatusApproved {
+			continue
+		}
+		node := &CommentNode{Comment: comment, Replies: []*CommentNode{}}
+		nodes[comment.ID] = node
+
+		// Replies to hidden comments move up to the closest visible ancestor
+		attached := false
+		for parentID := comment.ParentID; parentID != nil; {
+			if parent, ok := nodes[*parentID]; ok {
+				parent.Replies = append(parent.Replies, node)
+				attached = true
+				break
+			}
+			parent, ok := byID[*parentID]
+			if !ok {
+				break
+			}
+			parentID = parent.ParentID
+		}
+		if !attached {
+			roots = append(roots, node)
+		}
+	}
+	return roots, len(nodes)
 }
 
 func (h *CommentHandler) CreateComment(c *fiber.Ctx) error {
-	var comment models.Comment
-	if err := c.BodyParser(&comment); err != nil {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Missing auth token"})
+	}
+	postID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+
+	var input struct {
+		Content  string     `json:"content"`
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	input.Content = strings.TrimSpace(input.Content)
+	if input.Content == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Content is required"})
+	}
+	if len(input.Content) > maxCommentLength {
+		return c.Status(400).JSON(fiber.Map{"error": "Comment is too long"})
+	}
+
+	var post models.Post
+	if err := h.db.Select("id", "status", "slug").First(&post, postID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Post not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch post"})
+	}
+	if post.Status != "published" {
+		return c.Status(403).JSON(fiber.Map{"error": "Comments are closed for this post"})
+	}
+
+	comment := models.Comment{
+		ID:                 uuid.New(),
+		Content:            input.Content,
+		PostID:             postID,
+		AuthorID:           userID,
+		Status:             models.CommentStatusPending,
+		ContentFingerprint: spam.Fingerprint(input.Content),
+		IPAddress:          c.IP(),
+		UserAgent:          c.Get("User-Agent"),
+	}
+
+	pathPrefix := ""
+	if input.ParentID != nil {
+		var parent models.Comment
+		if err := h.db.Where("id = ? AND post_id = ? AND status = ?", *input.ParentID, postID, models.CommentStatusApproved).
+			First(&parent).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return c.Status(400).JSON(fiber.Map{"error": "Parent comment not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comment"})
+		}
+		if parent.Depth >= maxCommentDepth {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Replies are limited to %d levels", maxCommentDepth)})
+		}
+		comment.ParentID = &parent.ID
+		comment.Depth = parent.Depth + 1
+		pathPrefix = parent.ThreadPath + "/"
+	}
+	comment.ThreadPath = pathPrefix + threadSegment(comment.ID, time.Now())
+
+	result := h.checkSpam(c, &comment, post.Slug)
+	if result.Verdict == spam.Throttle {
+		return c.Status(429).JSON(fiber.Map{"error": "Too many comments, please slow down"})
+	}
+	comment.Status = h.statusForVerdict(result.Verdict)
+	comment.ModerationReason = result.Reason
 
 	if err := h.db.Create(&comment).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create comment"})
 	}
 
 	// Load related data
-	h.db.Preload("Author").Preload("Post").First(&comment, comment.ID)
+	h.db.Preload("Author", publicAuthor).First(&comment, comment.ID)
 
 	return c.Status(201).JSON(comment)
 }
 
+// checkSpam runs a new or edited comment on the post with postSlug through
+// the spam pipeline; a failing check sends it to review
+func (h *CommentHandler) checkSpam(c *fiber.Ctx, comment *models.Comment, postSlug string) spam.Result {
+	var author models.User
+	h.db.Select("id", "username", "email").First(&author, "id = ?", comment.AuthorID)
+
+	result, err := h.spam.Check(c.UserContext(), &spam.Input{
+		ID:          comment.ID.String(),
+		Content:     comment.Content,
+		Fingerprint: comment.ContentFingerprint,
+		PostID:      comment.PostID.String(),
+		AuthorID:    comment.AuthorID.String(),
+		AuthorName:  author.Username,
+		AuthorEmail: author.Email,
+		IP:          c.IP(),
+		UserAgent:   c.Get("User-Agent"),
+		Referrer:    c.Get("Referer"),
+		Permalink:   h.cfg.SiteURL + "/posts/" + postSlug,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return spam.Result{Verdict: spam.Review, Reason: "spam check failed"}
+	}
+	return result
+}
+
+// threadSegment is one sortable path element: creation time first so siblings
+// order chronologically, then part of the ID to break ties
+func threadSegment(id uuid.UUID, at time.Time) string {
+	return fmt.Sprintf("%016x-%s", at.UnixNano(), id.String()[:8])
+}
+
 func (h *CommentHandler) GetComment(c *fiber.Ctx) error {
 	id := c.Params("id")
 	commentID, err := uuid.Parse(id)
@@ -48,17 +280,29 @@ func (h *CommentHandler) GetComment(c *fiber.Ctx) error {
 	}
 
 	var comment models.Comment
-	if err := h.db.Preload("Author").Preload("Post").First(&comment, commentID).Error; err != nil {
+	if err := h.db.Preload("Author", publicAuthor).First(&comment, commentID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return c.Status(404).JSON(fiber.Map{"error": "Comment not found"})
 		}
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comment"})
 	}
 
+	// Unapproved comments are only visible to their author and moderators
+	if comment.Status != models.CommentStatusApproved {
+		userID, ok := currentUserID(c)
+		if !ok || (userID != comment.AuthorID && !isStaff(h.db, userID)) {
+			return c.Status(404).JSON(fiber.Map{"error": "Comment not found"})
+		}
+	}
+
 	return c.JSON(comment)
 }
 
 func (h *CommentHandler) UpdateComment(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Missing auth token"})
+	}
 	id := c.Params("id")
 	commentID, err := uuid.Parse(id)
 	if err != nil {
@@ -72,37 +316,164 @@ func (h *CommentHandler) UpdateComment(c *fiber.Ctx) error {
 		}
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comment"})
 	}
+	if comment.AuthorID != userID {
+		return c.Status(403).JSON(fiber.Map{"error": "You can only edit your own comments"})
+	}
 
-	var updateData models.Comment
+	var updateData struct {
+		Content string `json:"content"`
+	}
 	if err := c.BodyParser(&updateData); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	updateData.Content = strings.TrimSpace(updateData.Content)
+	if updateData.Content == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Content is required"})
+	}
+	if len(updateData.Content) > maxCommentLength {
+		return c.Status(400).JSON(fiber.Map{"error": "Comment is too long"})
+	}
 
-	if updateData.Content != "" {
+	// Edited comments go back through the spam checks and moderation, except
+	// those a moderator already turned down
+	if updateData.Content != comment.Content {
 		comment.Content = updateData.Content
+		comment.ContentFingerprint = spam.Fingerprint(comment.Content)
+		if comment.Status == models.CommentStatusApproved || comment.Status == models.CommentStatusPending {
+			var post models.Post
+			h.db.Select("id", "slug").First(&post, comment.PostID)
+			result := h.checkSpam(c, &comment, post.Slug)
+			if result.Verdict == spam.Throttle {
+				return c.Status(429).JSON(fiber.Map{"error": "Too many comments, please slow down"})
+			}
+			comment.Status = h.statusForVerdict(result.Verdict)
+			comment.ModerationReason = result.Reason
+		}
 	}
-	comment.IsApproved = updateData.IsApproved
 
 	if err := h.db.Save(&comment).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update comment"})
 	}
 
 	// Load related data
-	h.db.Preload("Author").Preload("Post").First(&comment, comment.ID)
+	h.db.Preload("Author", publicAuthor).First(&comment, comment.ID)
 
 	return c.JSON(comment)
 }
 
 func (h *CommentHandler) DeleteComment(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Missing auth token"})
+	}
 	id := c.Params("id")
 	commentID, err := uuid.Parse(id)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid comment ID"})
 	}
 
+	var comment models.Comment
+	if err := h.db.Select("id", "author_id").First(&comment, commentID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Comment not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comment"})
+	}
+	if comment.AuthorID != userID && !isStaff(h.db, userID) {
+		return c.Status(403).JSON(fiber.Map{"error": "You can only delete your own comments"})
+	}
+
 	if err := h.db.Delete(&models.Comment{}, commentID).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete comment"})
 	}
 
 	return c.JSON(fiber.Map{"message": "Comment deleted successfully"})
 }
+
+// GetModerationQueue lists comments in a moderation state (pending by default)
+// oldest first, for editors
+func (h *CommentHandler) GetModerationQueue(c *fiber.Ctx) error {
+	status := c.Query("status", models.CommentStatusPending)
+	switch status {
+	case models.CommentStatusPending, models.CommentStatusApproved, models.CommentStatusRejected, models.CommentStatusSpam:
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid status"})
+	}
+
+	page := max(c.QueryInt("page", 1), 1)
+	limit := min(max(c.QueryInt("limit", 20), 1), 100)
+
+	query := h.db.Model(&models.Comment{}).Where("status = ?", status)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comments"})
+	}
+
+	var comments []models.Comment
+	if err := query.Preload("Author", publicAuthor).
+		Preload("Post", func(db *gorm.DB) *gorm.DB { return db.Select("id", "title", "slug") }).
+		Order("created_at ASC").
+		Offset((page - 1) * limit).Limit(limit).
+		Find(&comments).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comments"})
+	}
+
+	return c.JSON(fiber.Map{
+		"comments": comments,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
+
+func (h *CommentHandler) ApproveComment(c *fiber.Ctx) error {
+	return h.moderate(c, models.CommentStatusApproved)
+}
+
+func (h *CommentHandler) RejectComment(c *fiber.Ctx) error {
+	return h.moderate(c, models.CommentStatusRejected)
+}
+
+func (h *CommentHandler) MarkCommentSpam(c *fiber.Ctx) error {
+	return h.moderate(c, models.CommentStatusSpam)
+}
+
+func (h *CommentHandler) moderate(c *fiber.Ctx, status string) error {
+	moderatorID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Missing auth token"})
+	}
+	commentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid comment ID"})
+	}
+
+	var comment models.Comment
+	if err := h.db.First(&comment, commentID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Comment not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch comment"})
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	c.BodyParser(&input)
+
+	now := time.Now()
+	comment.Status = status
+	comment.ModerationReason = strings.TrimSpace(input.Reason)
+	comment.ModeratedBy = &moderatorID
+	comment.ModeratedAt = &now
+	if err := h.db.Save(&comment).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update comment"})
+	}
+
+	h.db.Preload("Author", publicAuthor).First(&comment, comment.ID)
+
+	return c.JSON(comment)
+}
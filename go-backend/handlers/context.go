@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"go-backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// currentUserID returns the authenticated user set by the auth middleware
+func currentUserID(c *fiber.Ctx) (uuid.UUID, bool) {
+	raw, _ := c.Locals("userId").(string)
+	if raw == "" {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// optionalUserID is currentUserID as a pointer for nullable user columns
+func optionalUserID(c *fiber.Ctx) *uuid.UUID {
+	if id, ok := currentUserID(c); ok {
+		return &id
+	}
+	return nil
+}
+
+// isStaff reports whether the user is an editor or admin
+func isStaff(db *gorm.DB, userID uuid.UUID) bool {
+	var user models.User
+	if err := db.Select("id", "role").First(&user, "id = ?", userID).Error; err != nil {
+		return false
+	}
+	return user.Role == models.RoleEditor || user.Role == models.RoleAdmin
+}
+
+// publicAuthor limits preloaded users to fields that are safe to show
+// publicly. Users in the trash still show as the authors of their content.
+func publicAuthor(db *gorm.DB) *gorm.DB {
+	return db.Unscoped().Select("id", "username", "first_name", "last_name", "avatar")
+}
+
+// withTrashed is a preload scope that keeps soft deleted rows, so history
+// such as past orders still shows products and users in the trash
+func withTrashed(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// ownOrder loads the order named by :id if it belongs to the current user
+// or the user is staff, writing the error response otherwise. Other
+// people's orders are reported as not found.
+func ownOrder(c *fiber.Ctx, db *gorm.DB) (*models.Order, bool) {
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid order ID"})
+		return nil, false
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	var order models.Order
+	if err := db.First(&order, orderID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
+		}
+		return nil, false
+	}
+	if order.UserID != userID && !isStaff(db, userID) {
+		c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+		return nil, false
+	}
+	return &order, true
+}
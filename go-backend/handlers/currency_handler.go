@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"strings"
+
+	"go-backend/money"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type CurrencyHandler struct {
+	db         *gorm.DB
+	currencies *services.Currencies
+}
+
+func NewCurrencyHandler(db *gorm.DB, currencies *services.Currencies) *CurrencyHandler {
+	return &CurrencyHandler{db: db, currencies: currencies}
+}
+
+// GetExchangeRates lists the stored rates and the base currency
+func (h *CurrencyHandler) GetExchangeRates(c *fiber.Ctx) error {
+	rates, err := h.currencies.Rates(c.UserContext())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch exchange rates"})
+	}
+	return c.JSON(fiber.Map{"base": h.currencies.Base(), "rates": rates})
+}
+
+// ImportExchangeRates upserts rates from a CSV or JSON body, picked by
+// Content-Type (or ?format=csv|json)
+func (h *CurrencyHandler) ImportExchangeRates(c *fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format"))
+	if format == "" {
+		format = "json"
+		if strings.Contains(strings.ToLower(c.Get(fiber.HeaderContentType)), "csv") {
+			format = "csv"
+		}
+	}
+	source := c.Query("source", "import")
+
+	rates, err := services.ParseRates(bytes.NewReader(c.Body()), format, source)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(rates) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "No rates in request body"})
+	}
+	n, err := h.currencies.Import(c.UserContext(), rates)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to import exchange rates"})
+	}
+	return c.JSON(fiber.Map{"imported": n})
+}
+
+// ConvertAmount converts ?amount= (a decimal in ?from=, default the base
+// currency) to ?to= at the stored rates
+func (h *CurrencyHandler) ConvertAmount(c *fiber.Ctx) error {
+	from, err := services.CheckCurrency(c.Query("from"), h.currencies.Base())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	to, err := services.CheckCurrency(c.Query("to"), "")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "to must be a currency code"})
+	}
+	amount, err := money.Parse(c.Query("amount"), from)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	converted, err := h.currencies.Convert(c.UserContext(), amount, to)
+	if err != nil {
+		return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"from": amount, "to": converted})
+}
@@ -36,7 +36,6 @@ func GetCVEMockData(c *fiber.Ctx) error {
 		EpssPercentile:     9.9,
 		CreatedAt:          time.Date(2025, 7, 31, 11, 52, 18, 891662000, time.UTC),
 		UpdatedAt:          time.Date(2025, 8, 18, 12, 29, 4, 219148000, time.UTC),
-		DeletedAt:          nil,
 	}
 
 	return c.JSON(fiber.Map{
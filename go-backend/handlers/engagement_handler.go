@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"errors"
+	"go-backend/models"
+	"slices"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EngagementHandler serves reactions on posts and comments and user bookmarks
+type EngagementHandler struct {
+	db *gorm.DB
+}
+
+func NewEngagementHandler(db *gorm.DB) *EngagementHandler {
+	return &EngagementHandler{db: db}
+}
+
+// ReactionSummary is the aggregate reaction counts for one target plus the
+// current user's own reaction, if any
+type ReactionSummary struct {
+	TargetType string           `json:"target_type"`
+	TargetID   uuid.UUID        `json:"target_id"`
+	Counts     map[string]int64 `json:"counts"`
+	Total      int64            `json:"total"`
+	Mine       string           `json:"mine,omitempty"`
+}
+
+func (h *EngagementHandler) GetPostReactions(c *fiber.Ctx) error {
+	return h.getReactions(c, models.ReactionTargetPost)
+}
+
+func (h *EngagementHandler) ReactToPost(c *fiber.Ctx) error {
+	return h.react(c, models.ReactionTargetPost)
+}
+
+func (h *EngagementHandler) UnreactToPost(c *fiber.Ctx) error {
+	return h.unreact(c, models.ReactionTargetPost)
+}
+
+func (h *EngagementHandler) GetCommentReactions(c *fiber.Ctx) error {
+	return h.getReactions(c, models.ReactionTargetComment)
+}
+
+func (h *EngagementHandler) ReactToComment(c *fiber.Ctx) error {
+	return h.react(c, models.ReactionTargetComment)
+}
+
+func (h *EngagementHandler) UnreactToComment(c *fiber.Ctx) error {
+	return h.unreact(c, models.ReactionTargetComment)
+}
+
+func (h *EngagementHandler) getReactions(c *fiber.Ctx, targetType string) error {
+	targetID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+	if err := h.targetExists(targetType, targetID); err != nil {
+		return targetError(c, err)
+	}
+
+	summary, err := h.summary(c, targetType, targetID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reactions"})
+	}
+	return c.JSON(summary)
+}
+
+// react sets the user's reaction on a target, replacing any earlier kind
+func (h *EngagementHandler) react(c *fiber.Ctx, targetType string) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	targetID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	var input struct {
+		Kind string `json:"kind"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !slices.Contains(models.ReactionKinds, input.Kind) {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown reaction kind", "kinds": models.ReactionKinds})
+	}
+	if err := h.targetExists(targetType, targetID); err != nil {
+		return targetError(c, err)
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var reaction models.Reaction
+		err := tx.Where("user_id = ? AND target_type = ? AND target_id = ?", userID, targetType, targetID).
+			First(&reaction).Error
+		if err == nil {
+			return tx.Model(&reaction).Update("kind", input.Kind).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		reaction = models.Reaction{UserID: userID, TargetType: targetType, TargetID: targetID, Kind: input.Kind}
+		if err := tx.Create(&reaction).Error; err != nil {
+			return err
+		}
+		return bumpReactionCount(tx, targetType, targetID, 1)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save reaction"})
+	}
+
+	summary, err := h.summary(c, targetType, targetID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reactions"})
+	}
+	return c.JSON(summary)
+}
+
+func (h *EngagementHandler) unreact(c *fiber.Ctx, targetType string) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	targetID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("user_id = ? AND target_type = ? AND target_id = ?", userID, targetType, targetID).
+			Delete(&models.Reaction{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return bumpReactionCount(tx, targetType, targetID, -1)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove reaction"})
+	}
+
+	return c.Status(204).Send(nil)
+}
+
+func (h *EngagementHandler) summary(c *fiber.Ctx, targetType string, targetID uuid.UUID) (ReactionSummary, error) {
+	summary := ReactionSummary{TargetType: targetType, TargetID: targetID, Counts: map[string]int64{}}
+
+	var rows []struct {
+		Kind  string
+		Count int64
+	}
+	if err := h.db.Model(&models.Reaction{}).
+		Select("kind, count(*) as count").
+		Where("target_type = ? AND target_id = ?", targetType, targetID).
+		Group("kind").
+		Scan(&rows).Error; err != nil {
+		return summary, err
+	}
+	for _, row := range rows {
+		summary.Counts[row.Kind] = row.Count
+		summary.Total += row.Count
+	}
+
+	if userID, ok := currentUserID(c); ok {
+		var mine models.Reaction
+		if err := h.db.Select("kind").
+			Where("user_id = ? AND target_type = ? AND target_id = ?", userID, targetType, targetID).
+			Limit(1).Find(&mine).Error; err != nil {
+			return summary, err
+		}
+		summary.Mine = mine.Kind
+	}
+	return summary, nil
+}
+
+// targetExists checks that reactions can be left on the target: published
+// posts and approved comments only
+func (h *EngagementHandler) targetExists(targetType string, targetID uuid.UUID) error {
+	var count int64
+	var err error
+	switch targetType {
+	case models.ReactionTargetPost:
+		err = h.db.Model(&models.Post{}).Where("id = ? AND status = ?", targetID, "published").Count(&count).Error
+	case models.ReactionTargetComment:
+		err = h.db.Model(&models.Comment{}).Where("id = ? AND status = ?", targetID, models.CommentStatusApproved).Count(&count).Error
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func targetError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Not found"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reactions"})
+}
+
+// bumpReactionCount keeps the denormalised post counter used for popularity
+// sorting in step with the reactions table
+func bumpReactionCount(tx *gorm.DB, targetType string, targetID uuid.UUID, delta int) error {
+	if targetType != models.ReactionTargetPost {
+		return nil
+	}
+	return tx.Model(&models.Post{}).Where("id = ?", targetID).
+		UpdateColumn("reaction_count", gorm.Expr("reaction_count + ?", delta)).Error
+}
+
+func (h *EngagementHandler) BookmarkPost(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	postID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+	if err := h.targetExists(models.ReactionTargetPost, postID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": "Post not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch post"})
+	}
+
+	var bookmark models.Bookmark
+	err = h.db.Where(models.Bookmark{UserID: userID, PostID: postID}).FirstOrCreate(&bookmark).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save bookmark"})
+	}
+	return c.Status(201).JSON(bookmark)
+}
+
+func (h *EngagementHandler) UnbookmarkPost(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	postID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+
+	if err := h.db.Where("user_id = ? AND post_id = ?", userID, postID).Delete(&models.Bookmark{}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove bookmark"})
+	}
+	return c.Status(204).Send(nil)
+}
+
+// GetMyBookmarks lists the current user's bookmarked posts, newest first
+func (h *EngagementHandler) GetMyBookmarks(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := h.db.Model(&models.Bookmark{}).Where("user_id = ?", userID)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch bookmarks"})
+	}
+
+	var bookmarks []models.Bookmark
+	if err := query.
+		Preload("Post").
+		Preload("Post.Author", publicAuthor).
+		Preload("Post.Tags").
+		Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&bookmarks).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch bookmarks"})
+	}
+
+	return c.JSON(fiber.Map{
+		"bookmarks": bookmarks,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/config"
+	"go-backend/feeds"
+	"go-backend/markdown"
+	"go-backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// feedSize is the number of most recent posts included in a feed
+	feedSize = 50
+	// feedCacheTTL bounds how long a rendered feed is served without rebuilding
+	feedCacheTTL = 15 * time.Minute
+)
+
+var errFeedNotFound = errors.New("feed not found")
+
+var feedContentTypes = map[string]string{
+	"rss":  "application/rss+xml; charset=utf-8",
+	"atom": "application/atom+xml; charset=utf-8",
+	"json": "application/feed+json; charset=utf-8",
+}
+
+// FeedHandler serves RSS, Atom and JSON feeds and sitemaps for published posts
+type FeedHandler struct {
+	db    *gorm.DB
+	cfg   *config.Config
+	cache *feeds.Cache
+}
+
+func NewFeedHandler(db *gorm.DB, cfg *config.Config) *FeedHandler {
+	return &FeedHandler{db: db, cfg: cfg, cache: feeds.NewCache(feedCacheTTL)}
+}
+
+// Invalidate drops cached feeds; it is called whenever published content changes
+func (h *FeedHandler) Invalidate(models.Post) {
+	h.cache.Invalidate()
+}
+
+// GET /feeds/:format
+func (h *FeedHandler) GetFeed(c *fiber.Ctx) error {
+	format := c.Params("format")
+	return h.serve(c, "site:"+format, func() (*feeds.Entry, error) {
+		return h.buildFeed(format, h.cfg.SiteTitle, h.cfg.SiteURL, "/feeds/"+format, nil)
+	})
+}
+
+// GET /feeds/tags/:slug/:format
+func (h *FeedHandler) GetTagFeed(c *fiber.Ctx) error {
+	format, slug := c.Params("format"), c.Params("slug")
+	return h.serve(c, "tag:"+slug+":"+format, func() (*feeds.Entry, error) {
+		var tag models.Tag
+		if err := h.db.Where("slug = ?", slug).First(&tag).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errFeedNotFound
+			}
+			return nil, err
+		}
+		title := fmt.Sprintf("%s - %s", h.cfg.SiteTitle, tag.Name)
+		return h.buildFeed(format, title, h.cfg.SiteURL+"/tags/"+tag.Slug, "/feeds/tags/"+tag.Slug+"/"+format, func(db *gorm.DB) *gorm.DB {
+			return db.Joins("JOIN post_tags ON post_tags.post_id = posts.id").Where("post_tags.tag_id = ?", tag.ID)
+		})
+	})
+}
+
+// GET /feeds/authors/:username/:format
+func (h *FeedHandler) GetAuthorFeed(c *fiber.Ctx) error {
+	format, username := c.Params("format"), c.Params("username")
+	return h.serve(c, "author:"+username+":"+format, func() (*feeds.Entry, error) {
+		var author models.User
+		if err := h.db.Where("username = ?", username).First(&author).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errFeedNotFound
+			}
+			return nil, err
+		}
+		title := fmt.Sprintf("%s - %s", h.cfg.SiteTitle, displayName(author))
+		return h.buildFeed(format, title, h.cfg.SiteURL+"/authors/"+author.Username, "/feeds/authors/"+author.Username+"/"+format, func(db *gorm.DB) *gorm.DB {
+			return db.Where("posts.author_id = ?", author.ID)
+		})
+	})
+}
+
+// GET /sitemap.xml
+func (h *FeedHandler) GetSitemapIndex(c *fiber.Ctx) error {
+	return h.serve(c, "sitemap:index", func() (*feeds.Entry, error) {
+		var stats struct {
+			Count   int64
+			LastMod *time.Time
+		}
+		if err := h.published().Select("count(*) as count, max(posts.updated_at) as last_mod").Scan(&stats).Error; err != nil {
+			return nil, err
+		}
+
+		var lastMod time.Time
+		if stats.LastMod != nil {
+			lastMod = *stats.LastMod
+		}
+
+		pages := int(math.Ceil(float64(stats.Count) / feeds.MaxSitemapURLs))
+		var sitemaps []feeds.SitemapURL
+		for page := 1; page <= max(pages, 1); page++ {
+			sitemaps = append(sitemaps, feeds.SitemapURL{
+				Loc:     fmt.Sprintf("%s/sitemaps/posts-%d.xml", h.cfg.APIURL, page),
+				LastMod: lastMod,
+			})
+		}
+		sitemaps = append(sitemaps, feeds.SitemapURL{Loc: h.cfg.APIURL + "/sitemaps/tags.xml", LastMod: lastMod})
+
+		body, err := feeds.SitemapIndex(sitemaps)
+		if err != nil {
+			return nil, err
+		}
+		return feeds.NewEntry(body, "application/xml; charset=utf-8", lastMod), nil
+	})
+}
+
+// GET /sitemaps/posts-:page.xml
+func (h *FeedHandler) GetPostsSitemap(c *fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Params("page"))
+	if err != nil || page < 1 {
+		return c.Status(404).JSON(fiber.Map{"error": "Sitemap not found"})
+	}
+
+	return h.serve(c, "sitemap:posts:"+strconv.Itoa(page), func() (*feeds.Entry, error) {
+		var posts []models.Post
+		if err := h.published().Select("posts.slug, posts.updated_at").
+			Order("posts.published_at ASC").
+			Offset((page - 1) * feeds.MaxSitemapURLs).Limit(feeds.MaxSitemapURLs).
+			Find(&posts).Error; err != nil {
+			return nil, err
+		}
+		if len(posts) == 0 && page > 1 {
+			return nil, errFeedNotFound
+		}
+
+		var lastMod time.Time
+		urls := make([]feeds.SitemapURL, 0, len(posts))
+		for _, post := range posts {
+			urls = append(urls, feeds.SitemapURL{Loc: h.postURL(post), LastMod: post.UpdatedAt})
+			if post.UpdatedAt.After(lastMod) {
+				lastMod = post.UpdatedAt
+			}
+		}
+
+		body, err := feeds.Sitemap(urls)
+		if err != nil {
+			return nil, err
+		}
+		return feeds.NewEntry(body, "application/xml; charset=utf-8", lastMod), nil
+	})
+}
+
+// GET /sitemaps/tags.xml
+func (h *FeedHandler) GetTagsSitemap(c *fiber.Ctx) error {
+	return h.serve(c, "sitemap:tags", func() (*feeds.Entry, error) {
+		var rows []struct {
+			Slug    string
+			LastMod time.Time
+		}
+		if err := h.published().
+			Joins("JOIN post_tags ON post_tags.post_id = posts.id").
+			Joins("JOIN tags ON tags.id = post_tags.tag_id").
+			Select("tags.slug as slug, max(posts.updated_at) as last_mod").
+			Group("tags.slug").Order("tags.slug").
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+
+		var lastMod time.Time
+		urls := make([]feeds.SitemapURL, 0, len(rows))
+		for _, row := range rows {
+			urls = append(urls, feeds.SitemapURL{Loc: h.cfg.SiteURL + "/tags/" + row.Slug, LastMod: row.LastMod})
+			if row.LastMod.After(lastMod) {
+				lastMod = row.LastMod
+			}
+		}
+
+		body, err := feeds.Sitemap(urls)
+		if err != nil {
+			return nil, err
+		}
+		return feeds.NewEntry(body, "application/xml; charset=utf-8", lastMod), nil
+	})
+}
+
+// serve answers from the cache, building the document on a miss, and honours
+// conditional GET headers
+func (h *FeedHandler) serve(c *fiber.Ctx, key string, build func() (*feeds.Entry, error)) error {
+	entry, ok := h.cache.Get(key)
+	if !ok {
+		var err error
+		entry, err = build()
+		if err != nil {
+			if errors.Is(err, errFeedNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "Feed not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to build feed"})
+		}
+		h.cache.Set(key, entry)
+	}
+
+	c.Set("ETag", entry.ETag)
+	if !entry.LastModified.IsZero() {
+		c.Set("Last-Modified", entry.LastModified.Format(http.TimeFormat))
+	}
+	c.Set("Cache-Control", "public, max-age=300")
+
+	if entry.NotModified(c.Get("If-None-Match"), c.Get("If-Modified-Since")) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	c.Set("Content-Type", entry.ContentType)
+	return c.Send(entry.Body)
+}
+
+// buildFeed renders the feed at feedPath. Absolute URLs come from the
+// configuration, never the request's Host, as the result is cached for
+// everyone.
+func (h *FeedHandler) buildFeed(format, title, link, feedPath string, scope func(*gorm.DB) *gorm.DB) (*feeds.Entry, error) {
+	contentType, ok := feedContentTypes[format]
+	if !ok {
+		return nil, errFeedNotFound
+	}
+
+	query := h.published().Preload("Author", withTrashed).Preload("Tags")
+	if scope != nil {
+		query = scope(query)
+	}
+	var posts []models.Post
+	if err := query.Order("posts.published_at DESC").Limit(feedSize).Find(&posts).Error; err != nil {
+		return nil, err
+	}
+
+	feed := feeds.Feed{
+		Title:       title,
+		Link:        link,
+		FeedURL:     h.cfg.APIURL + feedPath,
+		Description: h.cfg.SiteDesc,
+		Language:    "vi",
+		Updated:     time.Now(),
+	}
+	var lastMod time.Time
+	for _, post := range posts {
+		if post.ContentHTML == "" && post.Content != "" {
+			renderContent(&post, nil) // predates the media library, so no asset references
+		}
+		item := feeds.Item{
+			ID:          "urn:uuid:" + post.ID.String(),
+			Title:       post.Title,
+			Link:        h.postURL(post),
+			Summary:     markdown.Excerpt(post.ContentHTML, 280),
+			ContentHTML: post.ContentHTML,
+			Author:      feeds.Author{Name: displayName(post.Author), URL: h.cfg.SiteURL + "/authors/" + post.Author.Username},
+			Published:   *post.PublishedAt,
+			Updated:     post.UpdatedAt,
+		}
+		for _, tag := range post.Tags {
+			item.Tags = append(item.Tags, tag.Name)
+		}
+		feed.Items = append(feed.Items, item)
+
+		if post.UpdatedAt.After(lastMod) {
+			lastMod = post.UpdatedAt
+		}
+	}
+	if !lastMod.IsZero() {
+		feed.Updated = lastMod
+	}
+
+	var body []byte
+	var err error
+	switch format {
+	case "rss":
+		body, err = feed.RSS()
+	case "atom":
+		body, err = feed.Atom()
+	default:
+		body, err = feed.JSON()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return feeds.NewEntry(body, contentType, lastMod), nil
+}
+
+// published scopes a query to posts that are publicly visible
+func (h *FeedHandler) published() *gorm.DB {
+	return h.db.Model(&models.Post{}).Where("posts.status = ? AND posts.published_at <= ?", "published", time.Now())
+}
+
+func (h *FeedHandler) postURL(post models.Post) string {
+	return h.cfg.SiteURL + "/posts/" + post.Slug
+}
+
+func displayName(u models.User) string {
+	if name := strings.TrimSpace(u.FirstName + " " + u.LastName); name != "" {
+		return name
+	}
+	return u.Username
+}
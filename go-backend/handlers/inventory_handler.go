@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"go-backend/models"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// InventoryHandler manages warehouses and their stock for staff
+type InventoryHandler struct {
+	db        *gorm.DB
+	inventory *services.Inventory
+}
+
+func NewInventoryHandler(db *gorm.DB, inventory *services.Inventory) *InventoryHandler {
+	return &InventoryHandler{db: db, inventory: inventory}
+}
+
+// GetWarehouses lists warehouses in the order orders are filled from
+func (h *InventoryHandler) GetWarehouses(c *fiber.Ctx) error {
+	warehouses := []models.Warehouse{}
+	if err := h.db.Order("priority, code").Find(&warehouses).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch warehouses"})
+	}
+	return c.JSON(warehouses)
+}
+
+func (h *InventoryHandler) CreateWarehouse(c *fiber.Ctx) error {
+	var warehouse models.Warehouse
+	if err := c.BodyParser(&warehouse); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	warehouse.ID = uuid.New()
+	if status, msg := h.validateWarehouse(&warehouse); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+
+	if err := h.db.Create(&warehouse).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create warehouse"})
+	}
+	return c.Status(201).JSON(warehouse)
+}
+
+// UpdateWarehouse changes a warehouse; (de)activating it changes what
+// products have in stock
+func (h *InventoryHandler) UpdateWarehouse(c *fiber.Ctx) error {
+	var warehouse models.Warehouse
+	if !h.findWarehouse(c, &warehouse) {
+		return nil
+	}
+	id, active := warehouse.ID, warehouse.IsActive
+	if err := c.BodyParser(&warehouse); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	warehouse.ID = id
+	if status, msg := h.validateWarehouse(&warehouse); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+
+	if err := h.db.Save(&warehouse).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update warehouse"})
+	}
+	if warehouse.IsActive != active {
+		if err := h.inventory.SyncWarehouse(c.UserContext(), warehouse.ID); err != nil {
+			log.Printf("ERROR: failed to recount stock for warehouse %s - %v", warehouse.Code, err)
+		}
+	}
+	return c.JSON(warehouse)
+}
+
+// DeleteWarehouse removes an empty warehouse; stock has to be moved out
+// first. Its ledger entries are kept.
+func (h *InventoryHandler) DeleteWarehouse(c *fiber.Ctx) error {
+	var warehouse models.Warehouse
+	if !h.findWarehouse(c, &warehouse) {
+		return nil
+	}
+	var stocked int64
+	if err := h.db.Model(&models.InventoryLevel{}).
+		Where("warehouse_id = ? AND (on_hand <> 0 OR reserved <> 0)", warehouse.ID).
+		Count(&stocked).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	if stocked > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "The warehouse still holds or reserves stock; transfer it out first"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("warehouse_id = ?", warehouse.ID).Delete(&models.InventoryLevel{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&warehouse).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete warehouse"})
+	}
+	return c.JSON(fiber.Map{"message": "Warehouse deleted successfully"})
+}
+
+// GetInventory lists stock levels, optionally for one ?warehouse_id=,
+// ?product_id= or ?variant_id=; ?low=true only lists levels at or below
+// their low stock threshold
+func (h *InventoryHandler) GetInventory(c *fiber.Ctx) error {
+	query := h.db.Preload("Warehouse")
+	for _, column := range []string{"warehouse_id", "product_id", "variant_id"} {
+		if value := c.Query(column); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid " + column})
+			}
+			query = query.Where(column+" = ?", id)
+		}
+	}
+	if c.QueryBool("low") {
+		query = query.Where("low_stock_threshold > 0 AND on_hand - reserved <= low_stock_threshold")
+	}
+
+	levels := []models.InventoryLevel{}
+	if err := query.Order("product_id, variant_id").Find(&levels).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch inventory"})
+	}
+	for i := range levels {
+		levels[i].Available = levels[i].OnHand - levels[i].Reserved
+	}
+	return c.JSON(levels)
+}
+
+// CreateStockMovement records a receipt, adjustment or transfer
+func (h *InventoryHandler) CreateStockMovement(c *fiber.Ctx) error {
+	var change services.StockChange
+	if err := c.BodyParser(&change); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	change.Note = strings.TrimSpace(change.Note)
+	change.ActorID = optionalUserID(c)
+
+	movements, err := h.inventory.Move(c.UserContext(), change)
+	if err != nil {
+		return stockError(c, err, "Failed to record stock movement")
+	}
+	return c.Status(201).JSON(movements)
+}
+
+// GetStockMovements pages through the ledger, newest first, optionally
+// for one ?warehouse_id=, ?product_id=, ?variant_id=, ?order_id= or ?type=
+func (h *InventoryHandler) GetStockMovements(c *fiber.Ctx) error {
+	page := max(c.QueryInt("page", 1), 1)
+	limit := min(max(c.QueryInt("limit", 50), 1), 200)
+
+	query := h.db.Model(&models.StockMovement{})
+	for _, column := range []string{"warehouse_id", "product_id", "variant_id", "order_id"} {
+		if value := c.Query(column); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid " + column})
+			}
+			query = query.Where(column+" = ?", id)
+		}
+	}
+	if kind := c.Query("type"); kind != "" {
+		query = query.Where("type = ?", kind)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stock movements"})
+	}
+	movements := []models.StockMovement{}
+	if err := query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&movements).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stock movements"})
+	}
+
+	return c.JSON(fiber.Map{
+		"movements": movements,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
+
+// SetLowStockThreshold sets when an item's stock in a warehouse counts as
+// low; zero turns alerts off
+func (h *InventoryHandler) SetLowStockThreshold(c *fiber.Ctx) error {
+	var input struct {
+		WarehouseID uuid.UUID  `json:"warehouse_id"`
+		ProductID   uuid.UUID  `json:"product_id"`
+		VariantID   *uuid.UUID `json:"variant_id"`
+		Threshold   int        `json:"threshold"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	level, err := h.inventory.SetThreshold(c.UserContext(), input.WarehouseID, input.ProductID, input.VariantID, input.Threshold)
+	if err != nil {
+		return stockError(c, err, "Failed to set low stock threshold")
+	}
+	return c.JSON(level)
+}
+
+// GetStockReservations lists reservations, newest first, by ?status=
+// (active by default) or for one ?order_id=
+func (h *InventoryHandler) GetStockReservations(c *fiber.Ctx) error {
+	query := h.db.Model(&models.StockReservation{})
+	if value := c.Query("order_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid order_id"})
+		}
+		query = query.Where("order_id = ?", id)
+	} else {
+		query = query.Where("status = ?", c.Query("status", models.ReservationActive))
+	}
+
+	reservations := []models.StockReservation{}
+	if err := query.Order("created_at DESC").Limit(500).Find(&reservations).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stock reservations"})
+	}
+	return c.JSON(reservations)
+}
+
+// GetStockAlerts lists open low stock alerts; ?all=true includes resolved
+// ones
+func (h *InventoryHandler) GetStockAlerts(c *fiber.Ctx) error {
+	query := h.db.Model(&models.StockAlert{})
+	if !c.QueryBool("all") {
+		query = query.Where("resolved_at IS NULL")
+	}
+
+	alerts := []models.StockAlert{}
+	if err := query.Order("created_at DESC").Limit(500).Find(&alerts).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stock alerts"})
+	}
+	return c.JSON(alerts)
+}
+
+func (h *InventoryHandler) findWarehouse(c *fiber.Ctx, warehouse *models.Warehouse) bool {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid warehouse ID"})
+		return false
+	}
+	if err := h.db.First(warehouse, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Warehouse not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return false
+	}
+	return true
+}
+
+// validateWarehouse normalizes a warehouse and returns the status and
+// message of the first problem, or "" when it is valid
+func (h *InventoryHandler) validateWarehouse(w *models.Warehouse) (int, string) {
+	w.Code = strings.ToUpper(strings.TrimSpace(w.Code))
+	w.Name = strings.TrimSpace(w.Name)
+	if w.Code == "" || len(w.Code) > 32 || w.Name == "" {
+		return 400, "Code (at most 32 characters) and name are required"
+	}
+	var count int64
+	h.db.Model(&models.Warehouse{}).Where("code = ? AND id <> ?", w.Code, w.ID).Count(&count)
+	if count > 0 {
+		return 409, "A warehouse with this code already exists"
+	}
+	return 0, ""
+}
+
+// stockError maps inventory errors to responses, logging unexpected ones
+// with fallback as the message
+func stockError(c *fiber.Ctx, err error, fallback string) error {
+	switch {
+	case errors.Is(err, services.ErrWarehouseNotFound):
+		return c.Status(400).JSON(fiber.Map{"error": "Warehouse not found or inactive"})
+	case errors.Is(err, services.ErrNoWarehouse):
+		return c.Status(409).JSON(fiber.Map{"error": "Create or activate a warehouse to hold stock first"})
+	case errors.Is(err, services.ErrInvalidMovement):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInsufficientStock):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("ERROR: %s - %v", strings.ToLower(fallback), err)
+	return c.Status(500).JSON(fiber.Map{"error": fallback})
+}
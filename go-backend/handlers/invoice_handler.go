@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	"go-backend/models"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type InvoiceHandler struct {
+	db       *gorm.DB
+	invoices *services.Invoices
+}
+
+func NewInvoiceHandler(db *gorm.DB, invoices *services.Invoices) *InvoiceHandler {
+	return &InvoiceHandler{db: db, invoices: invoices}
+}
+
+// GetInvoices lists issued documents for accounting, newest first;
+// ?year=2026 and ?kind=invoice|credit_note narrow it down
+func (h *InvoiceHandler) GetInvoices(c *fiber.Ctx) error {
+	query := h.db.Model(&models.Invoice{})
+	if year := c.QueryInt("year"); year > 0 {
+		query = query.Where("year = ?", year)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	docs := []models.Invoice{}
+	if err := query.Order("issued_at DESC, sequence DESC").Find(&docs).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invoices"})
+	}
+	return c.JSON(docs)
+}
+
+// GetOrderInvoices lists an order's invoice and credit notes
+func (h *InvoiceHandler) GetOrderInvoices(c *fiber.Ctx) error {
+	order, ok := ownOrder(c, h.db)
+	if !ok {
+		return nil
+	}
+	docs, err := h.invoices.ForOrder(c.UserContext(), order.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invoices"})
+	}
+	return c.JSON(docs)
+}
+
+// GetOrderInvoice downloads the order's invoice; ?format=html for the web
+// version, PDF otherwise unless it has text the PDF can't show
+func (h *InvoiceHandler) GetOrderInvoice(c *fiber.Ctx) error {
+	return h.download(c, "")
+}
+
+// GetCreditNote downloads one of the order's credit notes by number
+func (h *InvoiceHandler) GetCreditNote(c *fiber.Ctx) error {
+	return h.download(c, c.Params("number"))
+}
+
+func (h *InvoiceHandler) download(c *fiber.Ctx, number string) error {
+	order, ok := ownOrder(c, h.db)
+	if !ok {
+		return nil
+	}
+	format := c.Query("format", services.InvoiceFormatPDF)
+	if format != services.InvoiceFormatPDF && format != services.InvoiceFormatHTML {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be pdf or html"})
+	}
+
+	doc, err := h.invoices.Find(c.UserContext(), order.ID, number)
+	if errors.Is(err, services.ErrInvoiceNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "No invoice has been issued for this order yet"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invoice"})
+	}
+
+	r, format, err := h.invoices.Open(c.UserContext(), doc, format)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to render invoice"})
+	}
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read invoice"})
+	}
+
+	if format == services.InvoiceFormatHTML {
+		c.Set(fiber.HeaderContentType, "text/html; charset=utf-8")
+	} else {
+		c.Set(fiber.HeaderContentType, "application/pdf")
+	}
+	c.Set(fiber.HeaderContentDisposition, "inline; filename="+strconv.Quote(doc.Number+"."+format))
+	// Documents never change once rendered
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.Send(body)
+}
@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go-backend/config"
+	"go-backend/markdown"
+	"go-backend/media"
+	"go-backend/models"
+	"go-backend/storage"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// allowedUploadTypes maps accepted sniffed content types to the extension
+// they are stored with. SVG is deliberately absent since it can carry script.
+var allowedUploadTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+	"text/plain":      ".txt",
+	"audio/mpeg":      ".mp3",
+	"video/mp4":       ".mp4",
+}
+
+type MediaHandler struct {
+	db    *gorm.DB
+	cfg   *config.Config
+	store storage.BlobStore
+}
+
+func NewMediaHandler(db *gorm.DB, cfg *config.Config, store storage.BlobStore) *MediaHandler {
+	return &MediaHandler{db: db, cfg: cfg, store: store}
+}
+
+// UploadMedia stores a multipart "file" upload with optional alt_text and
+// caption fields. Images get their dimensions recorded and resized variants.
+func (h *MediaHandler) UploadMedia(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "A file is required"})
+	}
+	if header.Size > h.cfg.MaxUploadBytes {
+		return c.Status(413).JSON(fiber.Map{"error": fmt.Sprintf("File is larger than %d bytes", h.cfg.MaxUploadBytes)})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read upload"})
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, h.cfg.MaxUploadBytes+1))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read upload"})
+	}
+	if int64(len(data)) > h.cfg.MaxUploadBytes {
+		return c.Status(413).JSON(fiber.Map{"error": fmt.Sprintf("File is larger than %d bytes", h.cfg.MaxUploadBytes)})
+	}
+
+	// Trust the bytes, not the client's Content-Type
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	ext, ok := allowedUploadTypes[contentType]
+	if !ok {
+		return c.Status(415).JSON(fiber.Map{"error": "Unsupported file type " + contentType})
+	}
+
+	sum := sha256.Sum256(data)
+	asset := models.MediaAsset{
+		ID:          uuid.New(),
+		Filename:    path.Base(header.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Checksum:    hex.EncodeToString(sum[:]),
+		AltText:     c.FormValue("alt_text"),
+		Caption:     c.FormValue("caption"),
+		UploaderID:  userID,
+	}
+	base := time.Now().UTC().Format("2006/01/") + asset.ID.String()
+	asset.StorageKey = base + ext
+
+	if info, ok := media.Inspect(data); ok {
+		if info.Pixels() > h.cfg.MaxImagePixels {
+			return c.Status(413).JSON(fiber.Map{"error": fmt.Sprintf("Image is larger than %d pixels", h.cfg.MaxImagePixels)})
+		}
+		asset.Width, asset.Height = info.Width, info.Height
+	}
+
+	ctx := c.UserContext()
+	stored := []string{asset.StorageKey}
+	if err := h.store.Put(ctx, asset.StorageKey, bytes.NewReader(data), contentType); err != nil {
+		log.Printf("ERROR: failed to store upload %s - %v", asset.StorageKey, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to store file"})
+	}
+
+	// Variants are generated for formats the standard library can decode
+	if asset.IsImage() && contentType != "image/webp" {
+		variants, err := media.Variants(data, media.VariantWidths, h.cfg.MaxImagePixels)
+		if err != nil {
+			log.Printf("WARN: no variants for %s - %v", asset.StorageKey, err)
+		}
+		for _, v := range variants {
+			key := base + v.Suffix()
+			if err := h.store.Put(ctx, key, bytes.NewReader(v.Data), v.ContentType); err != nil {
+				h.removeBlobs(ctx, stored)
+				log.Printf("ERROR: failed to store variant %s - %v", key, err)
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to store file"})
+			}
+			stored = append(stored, key)
+			asset.Variants = append(asset.Variants, models.MediaVariant{
+				Width:       v.Width,
+				Height:      v.Height,
+				ContentType: v.ContentType,
+				StorageKey:  key,
+			})
+		}
+	}
+
+	if err := h.db.Create(&asset).Error; err != nil {
+		h.removeBlobs(ctx, stored)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save media"})
+	}
+
+	h.withURLs(&asset)
+	return c.Status(201).JSON(asset)
+}
+
+// GetMedia lists the library, newest first. ?type=image|file filters by
+// kind, ?q= matches filenames and alt text, ?mine=true limits to own uploads.
+func (h *MediaHandler) GetMedia(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "30"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 30
+	}
+
+	query := h.db.Model(&models.MediaAsset{})
+	switch c.Query("type") {
+	case "image":
+		query = query.Where("content_type LIKE ?", "image/%")
+	case "file":
+		query = query.Where("content_type NOT LIKE ?", "image/%")
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("filename ILIKE ? OR alt_text ILIKE ?", like, like)
+	}
+	if c.Query("mine") == "true" {
+		userID, _ := currentUserID(c)
+		query = query.Where("uploader_id = ?", userID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch media"})
+	}
+
+	var assets []models.MediaAsset
+	if err := query.Preload("Uploader", publicAuthor).
+		Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&assets).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch media"})
+	}
+	for i := range assets {
+		h.withURLs(&assets[i])
+	}
+
+	return c.JSON(fiber.Map{
+		"media": assets,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
+
+// GetMediaAsset returns an asset with the posts that reference it
+func (h *MediaHandler) GetMediaAsset(c *fiber.Ctx) error {
+	asset, err := h.find(c)
+	if err != nil {
+		return err
+	}
+
+	posts, err := h.referencingPosts(asset.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch references"})
+	}
+
+	return c.JSON(fiber.Map{"asset": asset, "used_in": posts})
+}
+
+// UpdateMediaAsset edits alt text and caption. Posts that fall back to the
+// asset's alt text are re-rendered.
+func (h *MediaHandler) UpdateMediaAsset(c *fiber.Ctx) error {
+	asset, err := h.find(c)
+	if err != nil {
+		return err
+	}
+	if !h.canManage(c, asset) {
+		return c.Status(403).JSON(fiber.Map{"error": "Forbidden"})
+	}
+
+	var input struct {
+		AltText *string `json:"alt_text"`
+		Caption *string `json:"caption"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	altChanged := input.AltText != nil && *input.AltText != asset.AltText
+	if input.AltText != nil {
+		asset.AltText = *input.AltText
+	}
+	if input.Caption != nil {
+		asset.Caption = *input.Caption
+	}
+	if err := h.db.Save(asset).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update media"})
+	}
+
+	if altChanged {
+		if err := rerenderReferencingPosts(h.db, h.store, asset.ID); err != nil {
+			log.Printf("ERROR: failed to re-render posts using asset %s - %v", asset.ID, err)
+		}
+	}
+
+	return c.JSON(asset)
+}
+
+// DeleteMediaAsset removes an asset and its files; assets still referenced
+// by a post are refused with 409 and the list of posts
+func (h *MediaHandler) DeleteMediaAsset(c *fiber.Ctx) error {
+	asset, err := h.find(c)
+	if err != nil {
+		return err
+	}
+	if !h.canManage(c, asset) {
+		return c.Status(403).JSON(fiber.Map{"error": "Forbidden"})
+	}
+
+	posts, err := h.referencingPosts(asset.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch references"})
+	}
+	if len(posts) > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error":   "Media is used by posts; remove it from them first",
+			"used_in": posts,
+		})
+	}
+
+	if err := h.db.Delete(asset).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete media"})
+	}
+
+	keys := []string{asset.StorageKey}
+	for _, v := range asset.Variants {
+		keys = append(keys, v.StorageKey)
+	}
+	h.removeBlobs(c.UserContext(), keys)
+
+	return c.JSON(fiber.Map{"message": "Media deleted successfully"})
+}
+
+// find loads the :id asset; its errors are fiber errors carrying the status
+func (h *MediaHandler) find(c *fiber.Ctx) (*models.MediaAsset, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, fiber.NewError(400, "Invalid media ID")
+	}
+
+	var asset models.MediaAsset
+	if err := h.db.Preload("Uploader", publicAuthor).First(&asset, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fiber.NewError(404, "Media not found")
+		}
+		return nil, fiber.NewError(500, "Failed to fetch media")
+	}
+	h.withURLs(&asset)
+	return &asset, nil
+}
+
+func (h *MediaHandler) canManage(c *fiber.Ctx, asset *models.MediaAsset) bool {
+	userID, ok := currentUserID(c)
+	return ok && (asset.UploaderID == userID || isStaff(h.db, userID))
+}
+
+func (h *MediaHandler) referencingPosts(assetID uuid.UUID) ([]models.Post, error) {
+	posts := []models.Post{}
+	err := h.db.Select("id", "title", "slug", "status").
+		Where("id IN (?)", h.db.Model(&models.MediaReference{}).Select("post_id").Where("asset_id = ?", assetID)).
+		Find(&posts).Error
+	return posts, err
+}
+
+func (h *MediaHandler) withURLs(asset *models.MediaAsset) {
+	assetURLs(h.store, asset)
+}
+
+func (h *MediaHandler) removeBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := h.store.Delete(ctx, key); err != nil {
+			log.Printf("ERROR: failed to delete blob %s - %v", key, err)
+		}
+	}
+}
+
+// assetURLs fills in the public URLs of an asset and its variants
+func assetURLs(store storage.BlobStore, asset *models.MediaAsset) {
+	asset.URL = store.URL(asset.StorageKey)
+	for i := range asset.Variants {
+		asset.Variants[i].URL = store.URL(asset.Variants[i].StorageKey)
+	}
+}
+
+// assetResolver loads the assets referenced in contents with one query and
+// resolves asset: destinations to their URLs, with a srcset for images
+func assetResolver(db *gorm.DB, store storage.BlobStore, contents ...string) markdown.Resolver {
+	if db == nil || store == nil {
+		return nil
+	}
+	var ids []uuid.UUID
+	for _, content := range contents {
+		ids = append(ids, media.References(content)...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var assets []models.MediaAsset
+	if err := db.Where("id IN ?", ids).Find(&assets).Error; err != nil {
+		log.Printf("ERROR: failed to load referenced media - %v", err)
+		return nil
+	}
+	byID := make(map[uuid.UUID]models.MediaAsset, len(assets))
+	for _, asset := range assets {
+		assetURLs(store, &asset)
+		byID[asset.ID] = asset
+	}
+
+	return func(dest string) (markdown.Image, bool) {
+		id, ok := media.ParseReference(dest)
+		if !ok {
+			return markdown.Image{}, false
+		}
+		asset, ok := byID[id]
+		if !ok {
+			return markdown.Image{}, false
+		}
+
+		img := markdown.Image{Src: asset.URL, Alt: asset.AltText, Width: asset.Width, Height: asset.Height}
+		if len(asset.Variants) > 0 {
+			candidates := make([]string, 0, len(asset.Variants)+1)
+			for _, v := range asset.Variants {
+				candidates = append(candidates, v.URL+" "+strconv.Itoa(v.Width)+"w")
+			}
+			candidates = append(candidates, asset.URL+" "+strconv.Itoa(asset.Width)+"w")
+			img.Srcset = strings.Join(candidates, ", ")
+			img.Sizes = media.DefaultSizes
+		}
+		return img, true
+	}
+}
+
+// syncMediaReferences makes the post's reference rows match the assets used
+// in its content and translations
+func syncMediaReferences(db *gorm.DB, postID uuid.UUID) error {
+	var post models.Post
+	if err := db.Select("id", "content").Preload("Translations", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "post_id", "content")
+	}).First(&post, postID).Error; err != nil {
+		return err
+	}
+
+	ids := media.References(post.Content)
+	for _, t := range post.Translations {
+		ids = append(ids, media.References(t.Content)...)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		stale := tx.Where("post_id = ?", postID)
+		if len(ids) > 0 {
+			stale = stale.Where("asset_id NOT IN ?", ids)
+		}
+		if err := stale.Delete(&models.MediaReference{}).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		// Only reference assets that exist; unknown IDs render as written
+		var existing []uuid.UUID
+		if err := tx.Model(&models.MediaAsset{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			return nil
+		}
+		refs := make([]models.MediaReference, 0, len(existing))
+		for _, id := range existing {
+			refs = append(refs, models.MediaReference{AssetID: id, PostID: postID})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&refs).Error
+	})
+}
+
+// rerenderReferencingPosts renders every post and translation using the
+// asset again, e.g. after its alt text changed
+func rerenderReferencingPosts(db *gorm.DB, store storage.BlobStore, assetID uuid.UUID) error {
+	var posts []models.Post
+	if err := db.Preload("Translations").
+		Where("id IN (?)", db.Model(&models.MediaReference{}).Select("post_id").Where("asset_id = ?", assetID)).
+		Find(&posts).Error; err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		renderContent(&post, assetResolver(db, store, post.Content))
+		if err := db.Model(&post).Select("content_html", "toc", "reading_time").Updates(&post).Error; err != nil {
+			return err
+		}
+		for _, t := range post.Translations {
+			renderTranslation(&t, assetResolver(db, store, t.Content))
+			if err := db.Model(&t).Select("content_html", "toc", "reading_time").Updates(&t).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"go-backend/models"
+	"go-backend/services"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -9,93 +12,272 @@ import (
 )
 
 type OrderHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	checkout *services.Checkout
+	orders   *services.Orders
 }
 
-func NewOrderHandler(db *gorm.DB) *OrderHandler {
-	return &OrderHandler{db: db}
+func NewOrderHandler(db *gorm.DB, checkout *services.Checkout) *OrderHandler {
+	return &OrderHandler{db: db, checkout: checkout, orders: services.NewOrders(db)}
 }
 
+// GetOrders lists every order for staff and only their own orders for
+// customers
 func (h *OrderHandler) GetOrders(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	query := h.db.Preload("User", withTrashed).Preload("Items").Preload("Items.Product", withTrashed)
+	if !isStaff(h.db, userID) {
+		query = query.Where("user_id = ?", userID)
+	}
 	var orders []models.Order
-	if err := h.db.Preload("User").Preload("Items").Preload("Items.Product").Find(&orders).Error; err != nil {
+	if err := query.Find(&orders).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch orders"})
 	}
 	return c.JSON(orders)
 }
 
+// CreateOrder accepts the legacy order payload but only uses its item
+// product IDs and quantities; the order is always the current user's and
+// prices and totals come from checkout
 func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
-	var order models.Order
-	if err := c.BodyParser(&order); err != nil {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var input models.Order
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	lines := make([]services.CheckoutLine, len(input.Items))
+	for i, item := range input.Items {
+		lines[i] = services.CheckoutLine{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity}
+	}
+
+	return h.placeOrder(c, userID, lines, services.CheckoutOptions{})
+}
+
+// Checkout places an order for the current user from product IDs and
+// quantities, with optional promotion codes
+func (h *OrderHandler) Checkout(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var input struct {
+		Items []services.CheckoutLine `json:"items"`
+		services.CheckoutOptions
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	return h.placeOrder(c, userID, input.Items, input.CheckoutOptions)
+}
+
+// QuoteOrder prices items and promotion codes without placing an order
+func (h *OrderHandler) QuoteOrder(c *fiber.Ctx) error {
+	var input struct {
+		Items []services.CheckoutLine `json:"items"`
+		services.CheckoutOptions
+	}
+	if err := c.BodyParser(&input); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
-	if err := h.db.Create(&order).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create order"})
+	quote, err := h.checkout.Quote(c.UserContext(), optionalUserID(c), input.Items, input.CheckoutOptions)
+	if err != nil {
+		return checkoutError(c, err)
+	}
+	return c.JSON(quote)
+}
+
+func (h *OrderHandler) placeOrder(c *fiber.Ctx, userID uuid.UUID, lines []services.CheckoutLine, opts services.CheckoutOptions) error {
+	if userID == uuid.Nil {
+		return c.Status(400).JSON(fiber.Map{"error": "User is required"})
+	}
+
+	order, err := h.checkout.PlaceOrder(c.UserContext(), userID, lines, opts)
+	if err != nil {
+		return checkoutError(c, err)
 	}
 
 	// Load related data
-	h.db.Preload("User").Preload("Items").Preload("Items.Product").First(&order, order.ID)
+	h.db.Preload("User", withTrashed).Preload("Items").Preload("Items.Product", withTrashed).Preload("Discounts").First(order, order.ID)
 
 	return c.Status(201).JSON(order)
 }
 
+// checkoutError maps checkout failures to responses; line errors are 409 so
+// the client can show which items to fix, rejected codes and shipping
+// choices are 422
+func checkoutError(c *fiber.Ctx, err error) error {
+	var lineErr *services.CheckoutError
+	var promoErr *services.PromotionError
+	switch {
+	case errors.Is(err, services.ErrEmptyOrder):
+		return c.Status(400).JSON(fiber.Map{"error": "Order has no items"})
+	case errors.Is(err, services.ErrUnsupportedCurrency), errors.Is(err, services.ErrInvalidAddress):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrAddressNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Address not found"})
+	case errors.Is(err, services.ErrNoWarehouse), errors.Is(err, services.ErrInsufficientStock):
+		return c.Status(409).JSON(fiber.Map{"error": "Some items can't be reserved right now"})
+	case errors.Is(err, services.ErrAddressRequired), errors.Is(err, services.ErrShippingMethodRequired), errors.Is(err, services.ErrShippingUnavailable):
+		return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+	case errors.As(err, &promoErr):
+		return c.Status(422).JSON(fiber.Map{"error": promoErr.Message, "promotion": promoErr})
+	case errors.As(err, &lineErr):
+		status := 409
+		for _, line := range lineErr.Lines {
+			if line.Code == services.LineInvalidQuantity || line.Code == services.LineVariantRequired {
+				status = 400
+			}
+		}
+		return c.Status(status).JSON(fiber.Map{"error": "Some items can't be ordered", "lines": lineErr.Lines})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to create order"})
+}
+
+// GetOrder returns an order to its owner and staff
 func (h *OrderHandler) GetOrder(c *fiber.Ctx) error {
-	id := c.Params("id")
-	orderID, err := uuid.Parse(id)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid order ID"})
+	order, ok := ownOrder(c, h.db)
+	if !ok {
+		return nil
 	}
 
-	var order models.Order
-	if err := h.db.Preload("User").Preload("Items").Preload("Items.Product").First(&order, orderID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
-		}
+	if err := h.db.Preload("User", withTrashed).Preload("Items").Preload("Items.Product", withTrashed).Preload("Discounts").First(order, order.ID).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
 	}
 
 	return c.JSON(order)
 }
 
+// UpdateOrder only changes the status, through the same state machine as
+// TransitionOrder. Totals are computed at checkout and can't be edited.
 func (h *OrderHandler) UpdateOrder(c *fiber.Ctx) error {
-	id := c.Params("id")
-	orderID, err := uuid.Parse(id)
+	var updateData struct {
+		Status      string          `json:"status"`
+		TotalAmount json.RawMessage `json:"total_amount"`
+	}
+	if err := c.BodyParser(&updateData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if len(updateData.TotalAmount) > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Order totals are computed at checkout and can't be changed"})
+	}
+	if updateData.Status == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Nothing to update"})
+	}
+
+	return h.transition(c, services.Transition{To: updateData.Status})
+}
+
+// TransitionOrder moves an order to another status. Staff can make any
+// allowed move except to paid or refunded, which only payments set;
+// customers can only cancel their own unpaid orders.
+func (h *OrderHandler) TransitionOrder(c *fiber.Ctx) error {
+	var t services.Transition
+	if err := c.BodyParser(&t); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	return h.transition(c, t)
+}
+
+func (h *OrderHandler) transition(c *fiber.Ctx, t services.Transition) error {
+	orderID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid order ID"})
 	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
 
-	var order models.Order
-	if err := h.db.First(&order, orderID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
+	if !isStaff(h.db, userID) {
+		var order models.Order
+		if err := h.db.Select("id", "user_id", "status").First(&order, orderID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
+		}
+		if order.UserID != userID {
 			return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
 		}
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
+		unpaid := order.Status == models.OrderStatusPending || order.Status == models.OrderStatusPaymentFailed
+		if t.To != models.OrderStatusCancelled || !unpaid {
+			return c.Status(403).JSON(fiber.Map{"error": "Only unpaid orders can be cancelled by the customer"})
+		}
 	}
 
-	var updateData models.Order
-	if err := c.BodyParser(&updateData); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	t.ActorID = &userID
+	order, err := h.orders.Transition(c.UserContext(), orderID, t)
+	if err != nil {
+		return transitionError(c, err)
 	}
 
-	// Update fields
-	if updateData.Status != "" {
-		order.Status = updateData.Status
+	// Load related data
+	h.db.Preload("User", withTrashed).Preload("Items").Preload("Items.Product", withTrashed).First(order, order.ID)
+
+	return c.JSON(order)
+}
+
+func transitionError(c *fiber.Ctx, err error) error {
+	var invalid *services.TransitionError
+	switch {
+	case errors.Is(err, services.ErrOrderNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+	case errors.Is(err, services.ErrTrackingRequired):
+		return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+	case errors.As(err, &invalid):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "allowed": invalid.Allowed})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to update order"})
+}
+
+// GetOrderEvents returns the order's timeline to its owner and staff
+func (h *OrderHandler) GetOrderEvents(c *fiber.Ctx) error {
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid order ID"})
 	}
-	if updateData.TotalAmount > 0 {
-		order.TotalAmount = updateData.TotalAmount
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 
-	if err := h.db.Save(&order).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to update order"})
+	var order models.Order
+	if err := h.db.Select("id", "user_id", "status").First(&order, orderID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
+	}
+	if order.UserID != userID && !isStaff(h.db, userID) {
+		return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
 	}
 
-	// Load related data
-	h.db.Preload("User").Preload("Items").Preload("Items.Product").First(&order, order.ID)
+	events, err := h.orders.Events(c.UserContext(), order.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order events"})
+	}
 
-	return c.JSON(order)
+	return c.JSON(fiber.Map{
+		"order_id": order.ID,
+		"status":   order.Status,
+		"allowed":  services.ManualTransitions(order.Status),
+		"events":   events,
+	})
 }
 
+// DeleteOrder moves an order to the trash; its items, payments and
+// invoices are kept
 func (h *OrderHandler) DeleteOrder(c *fiber.Ctx) error {
 	id := c.Params("id")
 	orderID, err := uuid.Parse(id)
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"go-backend/config"
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/payments"
+	"go-backend/services"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PaymentHandler struct {
+	db       *gorm.DB
+	payments *services.Payments
+	fake     *payments.FakeProvider
+}
+
+// NewPaymentHandler uses the provider named by PAYMENT_PROVIDER. The fake
+// provider delivers its webhooks straight to the webhook processing.
+func NewPaymentHandler(db *gorm.DB, cfg *config.Config) *PaymentHandler {
+	h := &PaymentHandler{db: db}
+
+	var provider payments.PaymentProvider
+	switch cfg.PaymentProvider {
+	case "stripe":
+		provider = payments.NewStripeProvider(cfg.StripeURL, cfg.StripeSecretKey, cfg.PaymentWebhookSecret)
+	default:
+		h.fake = payments.NewFakeProvider(cfg.PaymentWebhookSecret)
+		h.fake.OnEvent = func(payload []byte, signature string) {
+			if _, err := h.payments.HandleWebhook(context.Background(), payload, signature); err != nil {
+				log.Printf("ERROR: fake payment webhook failed - %v", err)
+			}
+		}
+		provider = h.fake
+	}
+
+	h.payments = services.NewPayments(db, provider)
+	return h
+}
+
+// Payments is the payment service the handler's provider is wired into
+func (h *PaymentHandler) Payments() *services.Payments {
+	return h.payments
+}
+
+// StartPayment creates (or returns the open) payment for the current user's
+// order; the client secret is used to confirm it with the provider
+func (h *PaymentHandler) StartPayment(c *fiber.Ctx) error {
+	order, ok := ownOrder(c, h.db)
+	if !ok {
+		return nil
+	}
+
+	payment, err := h.payments.StartPayment(c.UserContext(), order)
+	if err != nil {
+		if errors.Is(err, services.ErrNotPayable) {
+			return c.Status(409).JSON(fiber.Map{"error": "Order is not awaiting payment", "status": order.Status})
+		}
+		log.Printf("ERROR: failed to start payment for order %s - %v", order.ID, err)
+		return c.Status(502).JSON(fiber.Map{"error": "Payment provider unavailable"})
+	}
+
+	return c.Status(201).JSON(payment)
+}
+
+// GetOrderPayments lists an order's payment attempts
+func (h *PaymentHandler) GetOrderPayments(c *fiber.Ctx) error {
+	order, ok := ownOrder(c, h.db)
+	if !ok {
+		return nil
+	}
+
+	var list []models.Payment
+	if err := h.db.Where("order_id = ?", order.ID).Order("created_at DESC").Find(&list).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch payments"})
+	}
+	return c.JSON(list)
+}
+
+// CapturePayment collects a payment authorised with manual capture
+func (h *PaymentHandler) CapturePayment(c *fiber.Ctx) error {
+	paymentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid payment ID"})
+	}
+
+	payment, err := h.payments.Capture(c.UserContext(), paymentID)
+	if err != nil {
+		return paymentError(c, err)
+	}
+	return c.Status(202).JSON(payment)
+}
+
+// RefundPayment refunds all or part of a captured payment
+func (h *PaymentHandler) RefundPayment(c *fiber.Ctx) error {
+	paymentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid payment ID"})
+	}
+
+	// amount is minor units with a currency, or a decimal in the payment's
+	// currency; zero refunds everything left
+	var input struct {
+		Amount money.Money `json:"amount"`
+		Reason string      `json:"reason"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.Amount.IsNegative() {
+		return c.Status(400).JSON(fiber.Map{"error": "Amount can't be negative"})
+	}
+
+	// A client retrying with the same Idempotency-Key gets the same refund
+	key := ""
+	if k := c.Get("Idempotency-Key"); k != "" {
+		key = "payment-" + paymentID.String() + "-" + k
+	}
+	refund, err := h.payments.Refund(c.UserContext(), paymentID, input.Amount, input.Reason, key)
+	if err != nil {
+		return paymentError(c, err)
+	}
+	return c.Status(202).JSON(refund)
+}
+
+// Webhook receives provider events. Redelivered events are acknowledged
+// with 200 without being applied again.
+func (h *PaymentHandler) Webhook(c *fiber.Ctx) error {
+	signature := c.Get("Stripe-Signature")
+	if signature == "" {
+		signature = c.Get("X-Signature")
+	}
+
+	duplicate, err := h.payments.HandleWebhook(c.UserContext(), c.Body(), signature)
+	if err != nil {
+		if errors.Is(err, payments.ErrInvalidSignature) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid signature"})
+		}
+		log.Printf("ERROR: failed to process payment webhook - %v", err)
+		// A 5xx makes the provider retry later
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to process event"})
+	}
+
+	return c.JSON(fiber.Map{"received": true, "duplicate": duplicate})
+}
+
+// DevConfirmPayment confirms a fake provider intent as the customer's
+// browser would; amounts ending in .02 are declined
+func (h *PaymentHandler) DevConfirmPayment(c *fiber.Ctx) error {
+	if h.fake == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Fake payment provider is not in use"})
+	}
+
+	intent, err := h.fake.Confirm(c.Params("ref"))
+	if err != nil {
+		if errors.Is(err, payments.ErrNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": "Intent not found"})
+		}
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": intent.ID, "status": intent.Status, "failure_reason": intent.FailureReason})
+}
+
+// paymentError writes the response for a failed payment operation
+func paymentError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrPaymentNotFound), errors.Is(err, payments.ErrNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Payment not found"})
+	case errors.Is(err, payments.ErrDeclined):
+		return c.Status(402).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+}
@@ -1,7 +1,14 @@
 package handlers
 
 import (
+	"go-backend/config"
+	"go-backend/i18n"
+	"go-backend/markdown"
 	"go-backend/models"
+	"go-backend/services"
+	"go-backend/storage"
+	"log"
+	"slices"
 	"strings"
 	"time"
 
@@ -11,18 +18,66 @@ import (
 )
 
 type PostHandler struct {
-	db *gorm.DB
+	db           *gorm.DB
+	cfg          *config.Config
+	views        *services.ViewCounter
+	store        storage.BlobStore
+	publishHooks []func(models.Post)
 }
 
-func NewPostHandler(db *gorm.DB) *PostHandler {
-	return &PostHandler{db: db}
+func NewPostHandler(db *gorm.DB, cfg *config.Config, views *services.ViewCounter, store storage.BlobStore) *PostHandler {
+	return &PostHandler{db: db, cfg: cfg, views: views, store: store}
+}
+
+// render renders the post's content, resolving media library references
+func (h *PostHandler) render(post *models.Post) {
+	renderContent(post, assetResolver(h.db, h.store, post.Content))
+}
+
+// syncMedia records which media assets the post uses; failures are logged
+// since they only affect the delete guard, not the post itself
+func (h *PostHandler) syncMedia(postID uuid.UUID) {
+	if err := syncMediaReferences(h.db, postID); err != nil {
+		log.Printf("ERROR: failed to sync media references for post %s - %v", postID, err)
+	}
+}
+
+// OnPublish registers fn to be called whenever a published post is created,
+// edited or deleted, or a post becomes published
+func (h *PostHandler) OnPublish(fn func(models.Post)) {
+	h.publishHooks = append(h.publishHooks, fn)
+}
+
+func (h *PostHandler) notifyPublished(post models.Post) {
+	for _, fn := range h.publishHooks {
+		fn(post)
+	}
+}
+
+// postSorts maps the ?sort= values accepted by GetPosts to ORDER BY clauses.
+// Popularity weights a reaction like ten views.
+var postSorts = map[string]string{
+	"newest":  "created_at DESC",
+	"oldest":  "created_at ASC",
+	"popular": "(view_count + reaction_count * 10) DESC, published_at DESC",
 }
 
 func (h *PostHandler) GetPosts(c *fiber.Ctx) error {
+	order, ok := postSorts[c.Query("sort", "newest")]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid sort, expected newest, oldest or popular"})
+	}
+
 	var posts []models.Post
-	if err := h.db.Preload("Author").Find(&posts).Error; err != nil {
+	if err := h.db.Preload("Author", withTrashed).Preload("Tags").Preload("Translations").Order(order).Find(&posts).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch posts"})
 	}
+
+	chain := h.localeChain(c)
+	for i := range posts {
+		localizePost(&posts[i], chain)
+	}
+	c.Set(fiber.HeaderContentLanguage, chain[0])
 	return c.JSON(posts)
 }
 
@@ -34,13 +89,37 @@ func (h *PostHandler) CreatePost(c *fiber.Ctx) error {
 
 	// Generate slug from title
 	post.Slug = generateSlug(post.Title)
+	post.Locale = i18n.Normalize(post.Locale)
+	if post.Locale == "" {
+		post.Locale = h.cfg.DefaultLocale
+	}
+	if !slices.Contains(h.cfg.SupportedLocales, post.Locale) {
+		return c.Status(400).JSON(fiber.Map{"error": "Unsupported locale", "supported": h.cfg.SupportedLocales})
+	}
+	h.render(&post)
+	post.Translations = nil // added through the translations endpoints
+	if post.Status == "published" && post.PublishedAt == nil {
+		now := time.Now()
+		post.PublishedAt = &now
+	}
+
+	tags, err := resolveTags(h.db, post.Tags)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save tags"})
+	}
+	post.Tags = tags
 
 	if err := h.db.Create(&post).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create post"})
 	}
+	h.syncMedia(post.ID)
 
 	// Load author data
-	h.db.Preload("Author").First(&post, post.ID)
+	h.db.Preload("Author", withTrashed).Preload("Tags").First(&post, post.ID)
+
+	if post.Status == "published" {
+		h.notifyPublished(post)
+	}
 
 	return c.Status(201).JSON(post)
 }
@@ -53,13 +132,29 @@ func (h *PostHandler) GetPost(c *fiber.Ctx) error {
 	}
 
 	var post models.Post
-	if err := h.db.Preload("Author").First(&post, postID).Error; err != nil {
+	if err := h.db.Preload("Author", withTrashed).Preload("Tags").Preload("Translations").First(&post, postID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return c.Status(404).JSON(fiber.Map{"error": "Post not found"})
 		}
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch post"})
 	}
 
+	// Posts saved before the render pipeline existed have no HTML yet
+	if post.ContentHTML == "" && post.Content != "" {
+		h.render(&post)
+	}
+
+	c.Set(fiber.HeaderContentLanguage, localizePost(&post, h.localeChain(c)))
+
+	if post.Status == "published" && h.views != nil {
+		h.views.Record(post.ID, models.AnalyticsEvent{
+			UserID:    optionalUserID(c),
+			IPAddress: c.IP(),
+			UserAgent: c.Get("User-Agent"),
+		})
+		post.ViewCount += h.views.Pending(post.ID)
+	}
+
 	return c.JSON(post)
 }
 
@@ -83,6 +178,8 @@ func (h *PostHandler) UpdatePost(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	wasPublished := post.Status == "published"
+
 	// Update fields
 	if updateData.Title != "" {
 		post.Title = updateData.Title
@@ -90,6 +187,7 @@ func (h *PostHandler) UpdatePost(c *fiber.Ctx) error {
 	}
 	if updateData.Content != "" {
 		post.Content = updateData.Content
+		h.render(&post)
 	}
 	if updateData.Status != "" {
 		post.Status = updateData.Status
@@ -102,9 +200,26 @@ func (h *PostHandler) UpdatePost(c *fiber.Ctx) error {
 	if err := h.db.Save(&post).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update post"})
 	}
+	if updateData.Content != "" {
+		h.syncMedia(post.ID)
+	}
+
+	if updateData.Tags != nil {
+		tags, err := resolveTags(h.db, updateData.Tags)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save tags"})
+		}
+		if err := h.db.Model(&post).Association("Tags").Replace(tags); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save tags"})
+		}
+	}
 
 	// Load author data
-	h.db.Preload("Author").First(&post, post.ID)
+	h.db.Preload("Author", withTrashed).Preload("Tags").First(&post, post.ID)
+
+	if wasPublished || post.Status == "published" {
+		h.notifyPublished(post)
+	}
 
 	return c.JSON(post)
 }
@@ -116,13 +231,62 @@ func (h *PostHandler) DeletePost(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid post ID"})
 	}
 
-	if err := h.db.Delete(&models.Post{}, postID).Error; err != nil {
+	var post models.Post
+	if err := h.db.First(&post, postID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Post not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch post"})
+	}
+
+	// The post goes to the trash with its translations and media
+	// references, so the assets it uses stay protected until it is purged
+	if err := h.db.Delete(&post).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete post"})
 	}
 
+	if post.Status == "published" {
+		h.notifyPublished(post)
+	}
+
 	return c.JSON(fiber.Map{"message": "Post deleted successfully"})
 }
 
+// renderContent renders the post's Markdown source into sanitized HTML, a
+// table of contents and a reading time estimate
+func renderContent(post *models.Post, resolve markdown.Resolver) {
+	result := markdown.RenderWith(post.Content, markdown.Options{Resolve: resolve})
+
+	post.ContentHTML = result.HTML
+	post.ReadingTime = result.ReadingTime
+	post.TOC = make([]models.TOCEntry, 0, len(result.TOC))
+	for _, h := range result.TOC {
+		post.TOC = append(post.TOC, models.TOCEntry{Level: h.Level, Text: h.Text, ID: h.ID})
+	}
+}
+
+// resolveTags maps the requested tags (by name) onto existing rows, creating
+// any that don't exist yet
+func resolveTags(db *gorm.DB, requested []models.Tag) ([]models.Tag, error) {
+	tags := make([]models.Tag, 0, len(requested))
+	seen := map[string]bool{}
+	for _, t := range requested {
+		name := strings.TrimSpace(t.Name)
+		slug := generateSlug(name)
+		if name == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+
+		tag := models.Tag{Name: name, Slug: slug}
+		if err := db.Where("slug = ?", slug).FirstOrCreate(&tag).Error; err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
 func generateSlug(title string) string {
 	// Convert to lowercase and replace spaces with hyphens
 	slug := strings.ToLower(title)
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"errors"
+	"go-backend/i18n"
+	"go-backend/markdown"
+	"go-backend/models"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// localeChain negotiates the locales to try for this request and marks the
+// response as varying on Accept-Language
+func (h *PostHandler) localeChain(c *fiber.Ctx) []string {
+	c.Vary(fiber.HeaderAcceptLanguage)
+	chain := i18n.Negotiate(c.Query("lang"), c.Get(fiber.HeaderAcceptLanguage), h.cfg.SupportedLocales, h.cfg.DefaultLocale)
+	if len(chain) == 0 {
+		// DEFAULT_LOCALE is not in SUPPORTED_LOCALES; serve canonical content
+		chain = []string{h.cfg.DefaultLocale}
+	}
+	return chain
+}
+
+// localizePost replaces the post's title and content with the first locale
+// in chain it is available in and returns that locale. Posts available in
+// none of them keep their canonical content.
+func localizePost(post *models.Post, chain []string) string {
+	post.AvailableLocales = []string{post.Locale}
+	for _, t := range post.Translations {
+		post.AvailableLocales = append(post.AvailableLocales, t.Locale)
+	}
+
+	for _, locale := range chain {
+		if locale == post.Locale {
+			break
+		}
+		for _, t := range post.Translations {
+			if t.Locale != locale {
+				continue
+			}
+			post.Locale = t.Locale
+			post.Title = t.Title
+			post.Slug = t.Slug
+			post.Content = t.Content
+			post.ContentHTML = t.ContentHTML
+			post.TOC = t.TOC
+			post.ReadingTime = t.ReadingTime
+			post.Translations = nil
+			return locale
+		}
+	}
+	post.Translations = nil
+	return post.Locale
+}
+
+// GetPostTranslations lists every translation of a post
+func (h *PostHandler) GetPostTranslations(c *fiber.Ctx) error {
+	postID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Translations", func(db *gorm.DB) *gorm.DB {
+		return db.Order("locale")
+	}).First(&post, postID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Post not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch post"})
+	}
+
+	return c.JSON(fiber.Map{
+		"post_id":      post.ID,
+		"locale":       post.Locale,
+		"translations": post.Translations,
+	})
+}
+
+// UpsertPostTranslation creates or replaces the post's translation for the
+// :locale path parameter
+func (h *PostHandler) UpsertPostTranslation(c *fiber.Ctx) error {
+	postID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+	locale := i18n.Normalize(c.Params("locale"))
+	if !slices.Contains(h.cfg.SupportedLocales, locale) {
+		return c.Status(400).JSON(fiber.Map{"error": "Unsupported locale", "supported": h.cfg.SupportedLocales})
+	}
+
+	var input struct {
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if strings.TrimSpace(input.Title) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Title is required"})
+	}
+
+	var post models.Post
+	if err := h.db.First(&post, postID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Post not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch post"})
+	}
+	if post.Locale == locale {
+		return c.Status(400).JSON(fiber.Map{"error": "Locale is the post's own language; edit the post instead"})
+	}
+
+	var translation models.PostTranslation
+	err = h.db.Where("post_id = ? AND locale = ?", post.ID, locale).First(&translation).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch translation"})
+	}
+	created := errors.Is(err, gorm.ErrRecordNotFound)
+
+	translation.PostID = post.ID
+	translation.Locale = locale
+	translation.Title = input.Title
+	translation.Slug = generateSlug(input.Title)
+	translation.Content = input.Content
+	translation.SourceUpdatedAt = post.UpdatedAt
+	renderTranslation(&translation, assetResolver(h.db, h.store, translation.Content))
+
+	if err := h.db.Save(&translation).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save translation"})
+	}
+	h.syncMedia(post.ID)
+
+	if post.Status == "published" {
+		h.notifyPublished(post)
+	}
+
+	if created {
+		return c.Status(201).JSON(translation)
+	}
+	return c.JSON(translation)
+}
+
+func (h *PostHandler) DeletePostTranslation(c *fiber.Ctx) error {
+	postID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+	locale := i18n.Normalize(c.Params("locale"))
+
+	res := h.db.Where("post_id = ? AND locale = ?", postID, locale).Delete(&models.PostTranslation{})
+	if res.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete translation"})
+	}
+	if res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Translation not found"})
+	}
+	h.syncMedia(postID)
+
+	return c.JSON(fiber.Map{"message": "Translation deleted successfully"})
+}
+
+// MissingTranslation is a post lacking, or with an outdated, translation in
+// one or more supported locales
+type MissingTranslation struct {
+	PostID   uuid.UUID `json:"post_id"`
+	Title    string    `json:"title"`
+	Slug     string    `json:"slug"`
+	Status   string    `json:"status"`
+	Locale   string    `json:"locale"`
+	Missing  []string  `json:"missing"`
+	Outdated []string  `json:"outdated"`
+}
+
+// GetMissingTranslations lists posts that lack a translation in a supported
+// locale, or whose translation predates the last edit of the post.
+// ?locale= narrows the check to one locale and ?status= to one post status.
+func (h *PostHandler) GetMissingTranslations(c *fiber.Ctx) error {
+	locales := h.cfg.SupportedLocales
+	if locale := c.Query("locale"); locale != "" {
+		locale = i18n.Normalize(locale)
+		if !slices.Contains(locales, locale) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unsupported locale", "supported": locales})
+		}
+		locales = []string{locale}
+	}
+
+	query := h.db.Select("id", "title", "slug", "status", "locale", "updated_at").
+		Preload("Translations", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "post_id", "locale", "source_updated_at")
+		}).
+		Order("created_at DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var posts []models.Post
+	if err := query.Find(&posts).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch posts"})
+	}
+
+	result := []MissingTranslation{}
+	for _, post := range posts {
+		entry := MissingTranslation{
+			PostID:   post.ID,
+			Title:    post.Title,
+			Slug:     post.Slug,
+			Status:   post.Status,
+			Locale:   post.Locale,
+			Missing:  []string{},
+			Outdated: []string{},
+		}
+		for _, locale := range locales {
+			if locale == post.Locale {
+				continue
+			}
+			i := slices.IndexFunc(post.Translations, func(t models.PostTranslation) bool { return t.Locale == locale })
+			switch {
+			case i < 0:
+				entry.Missing = append(entry.Missing, locale)
+			case post.Translations[i].SourceUpdatedAt.Before(post.UpdatedAt):
+				entry.Outdated = append(entry.Outdated, locale)
+			}
+		}
+		if len(entry.Missing) > 0 || len(entry.Outdated) > 0 {
+			result = append(result, entry)
+		}
+	}
+
+	return c.JSON(fiber.Map{"posts": result, "locales": locales})
+}
+
+func renderTranslation(t *models.PostTranslation, resolve markdown.Resolver) {
+	result := markdown.RenderWith(t.Content, markdown.Options{Resolve: resolve})
+
+	t.ContentHTML = result.HTML
+	t.ReadingTime = result.ReadingTime
+	t.TOC = make([]models.TOCEntry, 0, len(result.TOC))
+	for _, h := range result.TOC {
+		t.TOC = append(t.TOC, models.TOCEntry{Level: h.Level, Text: h.Text, ID: h.ID})
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GetPriceHistory returns the price history of a product, or of its
+// ?variant_id= variant, over the last ?days= (90 by default) for charting,
+// with the lowest price of the 30 days before the current one
+func (h *ProductHandler) GetPriceHistory(c *fiber.Ctx) error {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return nil
+	}
+	variantID, ok := h.priceVariant(c, product, c.Query("variant_id"))
+	if !ok {
+		return nil
+	}
+	days := min(max(c.QueryInt("days", 90), 1), 3650)
+
+	history, err := services.PriceHistoryFor(h.db, product.ID, variantID, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch price history"})
+	}
+	lowest, err := services.LowestPrices(h.db, []uuid.UUID{product.ID})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch price history"})
+	}
+	target := services.PriceTarget{ProductID: product.ID}
+	if variantID != nil {
+		target.VariantID = *variantID
+	}
+	var lowest30d *money.Money
+	if low, ok := lowest[target]; ok {
+		lowest30d = &low
+	}
+
+	return c.JSON(fiber.Map{
+		"product_id":       product.ID,
+		"variant_id":       variantID,
+		"history":          history,
+		"lowest_price_30d": lowest30d,
+	})
+}
+
+// GetPriceSchedules lists a product's scheduled price changes and sales,
+// soonest first, optionally of one ?status=
+func (h *ProductHandler) GetPriceSchedules(c *fiber.Ctx) error {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return nil
+	}
+	query := h.db.Where("product_id = ?", product.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	schedules := []models.PriceSchedule{}
+	if err := query.Order("starts_at").Find(&schedules).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch price schedules"})
+	}
+	return c.JSON(schedules)
+}
+
+// CreatePriceSchedule schedules a new price for a product or one of its
+// variants at starts_at. With ends_at it is a sale and the price it
+// replaces comes back when it ends.
+func (h *ProductHandler) CreatePriceSchedule(c *fiber.Ctx) error {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return nil
+	}
+	var input struct {
+		VariantID string      `json:"variant_id"`
+		Price     money.Money `json:"price"`
+		StartsAt  *time.Time  `json:"starts_at"`
+		EndsAt    *time.Time  `json:"ends_at"`
+		Note      string      `json:"note"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	variantID, ok := h.priceVariant(c, product, input.VariantID)
+	if !ok {
+		return nil
+	}
+
+	// A price without a currency is in the one it replaces
+	currency := product.Price.Currency
+	if variantID != nil {
+		if v, _ := product.Variant(*variantID); v.Price.Currency != "" {
+			currency = v.Price.Currency
+		}
+	}
+	input.Price = input.Price.OrCurrency(currency)
+	if msg := validateProductPrice(input.Price); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	now := time.Now()
+	if input.StartsAt == nil {
+		input.StartsAt = &now
+	}
+	if input.EndsAt != nil && !input.EndsAt.After(*input.StartsAt) {
+		return c.Status(400).JSON(fiber.Map{"error": "ends_at must be after starts_at"})
+	}
+	if input.EndsAt != nil && !input.EndsAt.After(now) {
+		return c.Status(400).JSON(fiber.Map{"error": "The sale would already be over"})
+	}
+
+	schedule := models.PriceSchedule{
+		ProductID:   product.ID,
+		VariantID:   variantID,
+		Price:       input.Price,
+		StartsAt:    *input.StartsAt,
+		EndsAt:      input.EndsAt,
+		Note:        input.Note,
+		CreatedByID: optionalUserID(c),
+	}
+	err := h.prices.Schedule(c.UserContext(), &schedule)
+	switch {
+	case errors.Is(err, services.ErrPriceScheduleConflict):
+		return c.Status(409).JSON(fiber.Map{"error": "Overlaps another scheduled price change or sale of this product or variant"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	case err != nil:
+		log.Printf("ERROR: failed to schedule price change - %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to schedule price change"})
+	}
+	return c.Status(201).JSON(schedule)
+}
+
+// CancelPriceSchedule cancels a scheduled price change; a running sale ends
+// now and the price it replaced comes back
+func (h *ProductHandler) CancelPriceSchedule(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	id, err := uuid.Parse(c.Params("scheduleId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid schedule ID"})
+	}
+	schedule, err := h.prices.Cancel(c.UserContext(), productID, id)
+	switch {
+	case errors.Is(err, services.ErrPriceScheduleNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Price schedule not found"})
+	case errors.Is(err, services.ErrPriceScheduleEnded):
+		return c.Status(409).JSON(fiber.Map{"error": "The price schedule has already ended"})
+	case err != nil:
+		log.Printf("ERROR: failed to cancel price schedule %s - %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel price schedule"})
+	}
+	return c.JSON(schedule)
+}
+
+// priceVariant parses an optional variant ID of the product, writing the
+// error response when it is invalid
+func (h *ProductHandler) priceVariant(c *fiber.Ctx, product *models.Product, raw string) (*uuid.UUID, bool) {
+	if raw == "" {
+		return nil, true
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid variant ID"})
+		return nil, false
+	}
+	if _, ok := product.Variant(id); !ok {
+		c.Status(404).JSON(fiber.Map{"error": "Variant not found"})
+		return nil, false
+	}
+	return &id, true
+}
@@ -1,29 +1,119 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"go-backend/config"
 	"go-backend/models"
+	"go-backend/money"
+	"go-backend/services"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProductHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	currency string
+	catalog  *services.Catalog
+	prices   *services.Prices
 }
 
-func NewProductHandler(db *gorm.DB) *ProductHandler {
-	return &ProductHandler{db: db}
+func NewProductHandler(db *gorm.DB, cfg *config.Config, catalog *services.Catalog, prices *services.Prices) *ProductHandler {
+	return &ProductHandler{db: db, currency: cfg.Currency, catalog: catalog, prices: prices}
 }
 
+// GetProducts lists products with their price lists and variants;
+// ?currency=EUR adds each product's and variant's local_price in that
+// currency
 func (h *ProductHandler) GetProducts(c *fiber.Ctx) error {
 	var products []models.Product
-	if err := h.db.Find(&products).Error; err != nil {
+	if err := h.db.Preload("Prices").Scopes(services.WithVariants).Find(&products).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
 	}
+	if !h.localize(c, products) {
+		return nil
+	}
 	return c.JSON(products)
 }
 
+// SearchProducts searches active products: ?q= text, ?category= slug
+// (with its subcategories), ?min_price= and ?max_price= in ?currency= or
+// the base currency, ?in_stock=true, ?attr.Color=Red,Blue per attribute,
+// and ?sort= relevance, price_asc, price_desc, newest, popularity or
+// rating. The facets count all matches.
+func (h *ProductHandler) SearchProducts(c *fiber.Ctx) error {
+	page := max(c.QueryInt("page", 1), 1)
+	limit := min(max(c.QueryInt("limit", 20), 1), 100)
+
+	query := services.ProductQuery{
+		Text:       strings.TrimSpace(c.Query("q")),
+		Category:   c.Query("category"),
+		InStock:    c.QueryBool("in_stock"),
+		Attributes: map[string][]string{},
+		Sort:       c.Query("sort"),
+		Page:       page,
+		Limit:      limit,
+	}
+	currency, err := services.CheckCurrency(c.Query("currency"), h.currency)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	for param, dest := range map[string]**money.Money{"min_price": &query.MinPrice, "max_price": &query.MaxPrice} {
+		if value := c.Query(param); value != "" {
+			price, err := money.Parse(value, currency)
+			if err != nil || price.IsNegative() {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid " + param})
+			}
+			*dest = &price
+		}
+	}
+	for key, value := range c.Queries() {
+		name, ok := strings.CutPrefix(key, "attr.")
+		if !ok || name == "" {
+			continue
+		}
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				query.Attributes[name] = append(query.Attributes[name], v)
+			}
+		}
+	}
+
+	res, err := h.catalog.Search(c.UserContext(), query)
+	switch {
+	case errors.Is(err, services.ErrInvalidSort):
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid sort order"})
+	case errors.Is(err, services.ErrCategoryNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+	case errors.Is(err, money.ErrNoRate):
+		return c.Status(400).JSON(fiber.Map{"error": "No exchange rate for " + currency})
+	case err != nil:
+		log.Printf("ERROR: product search failed - %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to search products"})
+	}
+	if !h.localize(c, res.Products) {
+		return nil
+	}
+
+	return c.JSON(fiber.Map{
+		"products":   res.Products,
+		"facets":     res.Attributes,
+		"categories": res.Categories,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      res.Total,
+			TotalPages: (res.Total + limit - 1) / limit,
+		},
+	})
+}
+
 func (h *ProductHandler) CreateProduct(c *fiber.Ctx) error {
 	var product models.Product
 	if err := c.BodyParser(&product); err != nil {
@@ -31,25 +121,67 @@ func (h *ProductHandler) CreateProduct(c *fiber.Ctx) error {
 	}
 
 	product.ID = uuid.New()
-	if err := h.db.Create(&product).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create product"})
+	product.Price = product.Price.OrCurrency(h.currency)
+	if msg := validateProductPrice(product.Price); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	if status, msg := h.validateSKU(product.ID, &product.SKU); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	// Price list entries, options and variants have their own routes;
+	// ratings come from reviews
+	product.Prices, product.Options, product.Variants = nil, nil, nil
+	product.RatingAverage, product.RatingCount, product.RatingHistogram = 0, 0, nil
+	if product.Stock < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Stock can't be negative"})
 	}
+	// Initial stock is received into the default warehouse
+	stock := product.Stock
+	product.Stock = 0
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := services.FileProduct(tx, &product); err != nil {
+			return err
+		}
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		if err := services.RecordPrices(tx, product.ID, models.PriceSourceManual, optionalUserID(c), nil); err != nil {
+			return err
+		}
+		return receiveInitialStock(tx, c, product.ID, nil, stock)
+	})
+	if errors.Is(err, services.ErrCategoryNotFound) {
+		return c.Status(400).JSON(fiber.Map{"error": "Category not found"})
+	}
+	if err != nil {
+		return stockError(c, err, "Failed to create product")
+	}
+	product.Stock = stock
+	h.refresh(product.ID)
 
 	return c.Status(201).JSON(product)
 }
 
+// GetProduct returns a product with its variant matrix: the option types
+// with their values, and each variant's values, SKU, price and stock
 func (h *ProductHandler) GetProduct(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var product models.Product
-	if err := h.db.First(&product, "id = ?", id).Error; err != nil {
+	if err := h.db.Preload("Prices").Scopes(services.WithVariants).First(&product, "id = ?", id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
 		}
 		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
 	}
-	return c.JSON(product)
+	products := []models.Product{product}
+	if !h.localize(c, products) {
+		return nil
+	}
+	return c.JSON(products[0])
 }
 
+// UpdateProduct changes a product's details; stock only changes through
+// inventory movements
 func (h *ProductHandler) UpdateProduct(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var product models.Product
@@ -60,21 +192,469 @@ func (h *ProductHandler) UpdateProduct(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
 	}
 
+	// A bare decimal price stays in the product's currency
+	currency := product.Price.Currency
+	// Parsing writes through the pointer, so parse category_id afresh
+	categoryID, category := product.CategoryID, product.Category
+	product.CategoryID = nil
 	if err := c.BodyParser(&product); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	product.Price = product.Price.OrCurrency(currency)
+	if msg := validateProductPrice(product.Price); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	if status, msg := h.validateSKU(product.ID, &product.SKU); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	// Without a category_id the product stays in its category unless a new
+	// category name refiles it by name
+	if product.CategoryID == nil && product.Category == category {
+		product.CategoryID = categoryID
+	}
 
-	if err := h.db.Save(&product).Error; err != nil {
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := services.FileProduct(tx, &product); err != nil {
+			return err
+		}
+		if err := tx.Omit("Prices", "Options", "Variants", "Stock", "RatingAverage", "RatingCount", "RatingHistogram").Save(&product).Error; err != nil {
+			return err
+		}
+		// Variants without their own price change along with the product
+		return services.RecordPrices(tx, product.ID, models.PriceSourceManual, optionalUserID(c), nil)
+	})
+	if errors.Is(err, services.ErrCategoryNotFound) {
+		return c.Status(400).JSON(fiber.Map{"error": "Category not found"})
+	}
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product"})
 	}
+	h.refresh(product.ID)
 
+	h.db.Preload("Prices").Scopes(services.WithVariants).First(&product, "id = ?", product.ID)
 	return c.JSON(product)
 }
 
+// DeleteProduct moves a product to the trash. It leaves the catalog and
+// carts, but keeps its prices, variants, stock and reviews for a restore.
 func (h *ProductHandler) DeleteProduct(c *fiber.Ctx) error {
-	id := c.Params("id")
-	if err := h.db.Delete(&models.Product{}, "id = ?", id).Error; err != nil {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	res := h.db.Delete(&models.Product{}, "id = ?", productID)
+	if res.Error != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete product"})
 	}
+	if res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	h.refresh(productID)
 	return c.JSON(fiber.Map{"message": "Product deleted successfully"})
 }
+
+// SetProductOptions replaces the product's option types and their values,
+// e.g. [{"name": "Size", "values": ["S", "M", "L"]}]. Existing variants
+// must still fit the new options.
+func (h *ProductHandler) SetProductOptions(c *fiber.Ctx) error {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return nil
+	}
+	var options []models.ProductOption
+	if err := c.BodyParser(&options); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	names := map[string]bool{}
+	for i := range options {
+		o := &options[i]
+		o.ID = uuid.New()
+		o.ProductID = product.ID
+		o.Position = i
+		if msg := services.ValidateProductOption(o); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+		if names[strings.ToLower(o.Name)] {
+			return c.Status(400).JSON(fiber.Map{"error": "Option " + o.Name + " is listed twice"})
+		}
+		names[strings.ToLower(o.Name)] = true
+	}
+
+	var misfits []string
+	for _, v := range product.Variants {
+		if _, err := services.VariantOptionKey(options, v.Options); err != "" {
+			misfits = append(misfits, v.SKU)
+		}
+	}
+	if len(misfits) > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Some variants use options or values that would be removed; update or delete them first", "skus": misfits})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", product.ID).Delete(&models.ProductOption{}).Error; err != nil {
+			return err
+		}
+		if len(options) == 0 {
+			return nil
+		}
+		return tx.Create(&options).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set options"})
+	}
+	h.refresh(product.ID)
+	return c.JSON(options)
+}
+
+// GetProductVariants lists a product's variants; ?currency= adds their
+// local_price
+func (h *ProductHandler) GetProductVariants(c *fiber.Ctx) error {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return nil
+	}
+	products := []models.Product{*product}
+	if !h.localize(c, products) {
+		return nil
+	}
+	return c.JSON(products[0].Variants)
+}
+
+// CreateProductVariant adds a variant with one value for each of the
+// product's options; its stock is received into the default warehouse
+func (h *ProductHandler) CreateProductVariant(c *fiber.Ctx) error {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return nil
+	}
+	var variant models.ProductVariant
+	if err := c.BodyParser(&variant); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	variant.ID = uuid.New()
+	variant.ProductID = product.ID
+	if status, msg := h.validateVariant(product, &variant); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+
+	stock := variant.Stock
+	variant.Stock = 0
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&variant).Error; err != nil {
+			return err
+		}
+		if err := services.RecordPrices(tx, product.ID, models.PriceSourceManual, optionalUserID(c), nil); err != nil {
+			return err
+		}
+		return receiveInitialStock(tx, c, product.ID, &variant.ID, stock)
+	})
+	if err != nil {
+		return stockError(c, err, "Failed to create variant")
+	}
+	variant.Stock = stock
+	h.refresh(product.ID)
+	return c.Status(201).JSON(variant)
+}
+
+// UpdateProductVariant changes a variant; orders keep the SKU and price
+// they were placed with. Stock only changes through inventory movements.
+func (h *ProductHandler) UpdateProductVariant(c *fiber.Ctx) error {
+	product, variant, ok := h.findVariant(c)
+	if !ok {
+		return nil
+	}
+	id, stock := variant.ID, variant.Stock
+	currency := variant.Price.Currency
+	if err := c.BodyParser(variant); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	variant.ID, variant.ProductID, variant.Stock = id, product.ID, stock
+	if currency != "" {
+		variant.Price = variant.Price.OrCurrency(currency)
+	}
+	if status, msg := h.validateVariant(product, variant); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Stock").Save(variant).Error; err != nil {
+			return err
+		}
+		return services.RecordPrices(tx, product.ID, models.PriceSourceManual, optionalUserID(c), nil)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update variant"})
+	}
+	h.refresh(product.ID)
+	return c.JSON(variant)
+}
+
+// DeleteProductVariant removes a variant and drops it from carts
+func (h *ProductHandler) DeleteProductVariant(c *fiber.Ctx) error {
+	_, variant, ok := h.findVariant(c)
+	if !ok {
+		return nil
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.InventoryLevel{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(variant).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete variant"})
+	}
+	h.refresh(variant.ProductID)
+	return c.JSON(fiber.Map{"message": "Variant deleted successfully"})
+}
+
+// receiveInitialStock records the stock a product or variant was created
+// with as a receipt into the default warehouse
+func receiveInitialStock(tx *gorm.DB, c *fiber.Ctx, productID uuid.UUID, variantID *uuid.UUID, stock int) error {
+	if stock == 0 {
+		return nil
+	}
+	_, err := services.MoveStockTx(tx, services.StockChange{
+		Type:      models.StockReceipt,
+		ProductID: productID,
+		VariantID: variantID,
+		Quantity:  stock,
+		Note:      "Initial stock",
+		ActorID:   optionalUserID(c),
+	})
+	return err
+}
+
+// findProduct loads the :id product with its options and variants, writing
+// the error response when it can't
+func (h *ProductHandler) findProduct(c *fiber.Ctx) (*models.Product, bool) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+		return nil, false
+	}
+	var product models.Product
+	if err := h.db.Preload("Prices").Scopes(services.WithVariants).First(&product, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return nil, false
+	}
+	return &product, true
+}
+
+// findVariant loads the :variantId variant of the :id product
+func (h *ProductHandler) findVariant(c *fiber.Ctx) (*models.Product, *models.ProductVariant, bool) {
+	product, ok := h.findProduct(c)
+	if !ok {
+		return nil, nil, false
+	}
+	id, err := uuid.Parse(c.Params("variantId"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid variant ID"})
+		return nil, nil, false
+	}
+	variant, ok := product.Variant(id)
+	if !ok {
+		c.Status(404).JSON(fiber.Map{"error": "Variant not found"})
+		return nil, nil, false
+	}
+	return product, variant, true
+}
+
+// validateVariant normalizes a variant against its product's options and
+// returns the status and message of the first problem, or "" when it is
+// valid
+func (h *ProductHandler) validateVariant(product *models.Product, v *models.ProductVariant) (int, string) {
+	v.Barcode = strings.TrimSpace(v.Barcode)
+	if v.Images == nil {
+		v.Images = []string{}
+	}
+	if strings.TrimSpace(v.SKU) == "" {
+		return 400, "SKU is required and at most 64 characters"
+	}
+	if status, msg := h.validateSKU(v.ID, &v.SKU); msg != "" {
+		return status, msg
+	}
+	switch {
+	case len(product.Options) == 0:
+		return 400, "Set the product's options before adding variants"
+	case v.Stock < 0 || v.WeightGrams < 0:
+		return 400, "Stock and weight can't be negative"
+	}
+	key, msg := services.VariantOptionKey(product.Options, v.Options)
+	if msg != "" {
+		return 400, msg
+	}
+	v.OptionKey = key
+	// A price without a currency is in the product's; none at all keeps
+	// the product's price
+	if v.Price.Amount != 0 || v.Price.Currency != "" {
+		v.Price = v.Price.OrCurrency(product.Price.Currency)
+		if msg := validateProductPrice(v.Price); msg != "" {
+			return 400, msg
+		}
+	}
+
+	var count int64
+	h.db.Model(&models.ProductVariant{}).Where("product_id = ? AND option_key = ? AND id <> ?", v.ProductID, v.OptionKey, v.ID).Count(&count)
+	if count > 0 {
+		return 409, "The product already has a variant with these options"
+	}
+	return 0, ""
+}
+
+// SetProductPrice sets the product's price list entry for a currency,
+// overriding the converted base price there
+func (h *ProductHandler) SetProductPrice(c *fiber.Ctx) error {
+	product, currency, ok := h.findPriceTarget(c)
+	if !ok {
+		return nil
+	}
+	var input struct {
+		Price money.Money `json:"price"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	input.Price = input.Price.OrCurrency(currency)
+	if input.Price.Currency != currency {
+		return c.Status(400).JSON(fiber.Map{"error": "Price currency doesn't match the URL"})
+	}
+	if msg := validateProductPrice(input.Price); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	entry := models.ProductPrice{ProductID: product.ID, Price: input.Price}
+	err := h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_id"}, {Name: "price_currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"price_amount", "updated_at"}),
+	}).Create(&entry).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set price"})
+	}
+
+	h.db.First(&entry, "product_id = ? AND price_currency = ?", product.ID, currency)
+	h.refresh(product.ID)
+	return c.JSON(entry)
+}
+
+// DeleteProductPrice removes a price list entry; the currency falls back to
+// the converted base price
+func (h *ProductHandler) DeleteProductPrice(c *fiber.Ctx) error {
+	product, currency, ok := h.findPriceTarget(c)
+	if !ok {
+		return nil
+	}
+	result := h.db.Where("product_id = ? AND price_currency = ?", product.ID, currency).Delete(&models.ProductPrice{})
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete price"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "No price for this currency"})
+	}
+	h.refresh(product.ID)
+	return c.JSON(fiber.Map{"message": "Price deleted successfully"})
+}
+
+// findPriceTarget loads the product and currency of a price list route,
+// writing the error response when either is invalid
+func (h *ProductHandler) findPriceTarget(c *fiber.Ctx) (*models.Product, string, bool) {
+	currency := money.NormalizeCurrency(c.Params("currency"))
+	if !money.ValidCurrency(currency) {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid currency"})
+		return nil, "", false
+	}
+	var product models.Product
+	if err := h.db.First(&product, "id = ?", c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return nil, "", false
+	}
+	if product.Price.Currency == currency {
+		c.Status(400).JSON(fiber.Map{"error": "This is the product's base currency; update its price instead"})
+		return nil, "", false
+	}
+	return &product, currency, true
+}
+
+// localize fills LowestPrice30d of products and their variants, and their
+// LocalPrice for ?currency=, writing the error response and returning
+// false when the currency is invalid. Products that can't be priced in it
+// are left without one.
+func (h *ProductHandler) localize(c *fiber.Ctx, products []models.Product) bool {
+	if err := services.FillLowestPrices(h.db, products); err != nil {
+		log.Printf("ERROR: failed to load lowest prices - %v", err)
+	}
+	if c.Query("currency") == "" {
+		return true
+	}
+	currency, err := services.CheckCurrency(c.Query("currency"), h.currency)
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		return false
+	}
+	rates, err := services.LoadRates(h.db)
+	if err != nil {
+		c.Status(500).JSON(fiber.Map{"error": "Failed to load exchange rates"})
+		return false
+	}
+	for i := range products {
+		if price, err := services.PriceFor(products[i], currency, rates); err == nil {
+			products[i].LocalPrice = &price
+		}
+		for j := range products[i].Variants {
+			variant := &products[i].Variants[j]
+			if price, err := services.VariantPriceFor(products[i], variant, currency, rates); err == nil {
+				variant.LocalPrice = &price
+			}
+		}
+	}
+	return true
+}
+
+// validateSKU trims an optional SKU and checks that no product or variant
+// other than id uses it
+func (h *ProductHandler) validateSKU(id uuid.UUID, sku *string) (int, string) {
+	*sku = strings.TrimSpace(*sku)
+	if *sku == "" {
+		return 0, ""
+	}
+	if len(*sku) > 64 {
+		return 400, "SKUs are at most 64 characters"
+	}
+	taken, err := services.SKUTaken(h.db, *sku, id)
+	if err != nil {
+		return 500, "Database error"
+	}
+	if taken {
+		return 409, "A product or variant with this SKU already exists"
+	}
+	return 0, ""
+}
+
+func validateProductPrice(price money.Money) string {
+	if !money.ValidCurrency(price.Currency) {
+		return "Invalid currency"
+	}
+	if price.IsNegative() {
+		return "Price can't be negative"
+	}
+	return ""
+}
+
+// refresh updates the search index after products changed; the periodic
+// reindex repairs it if this fails
+func (h *ProductHandler) refresh(ids ...uuid.UUID) {
+	if err := h.catalog.Refresh(context.Background(), ids...); err != nil {
+		log.Printf("ERROR: failed to refresh the search index - %v", err)
+	}
+}
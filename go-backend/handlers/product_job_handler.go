@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"go-backend/models"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ProductJobHandler queues product imports and exports and reports on
+// their progress
+type ProductJobHandler struct {
+	db   *gorm.DB
+	jobs *services.ProductJobs
+}
+
+func NewProductJobHandler(db *gorm.DB, jobs *services.ProductJobs) *ProductJobHandler {
+	return &ProductJobHandler{db: db, jobs: jobs}
+}
+
+// ImportProducts queues an import of a multipart "file" upload or of the
+// raw body. The format comes from ?format=csv|jsonl, else the file
+// extension or Content-Type; ?dry_run=true only validates.
+func (h *ProductJobHandler) ImportProducts(c *fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format"))
+	fileName := "upload"
+	var body io.Reader
+	if header, err := c.FormFile("file"); err == nil {
+		file, err := header.Open()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Failed to read upload"})
+		}
+		defer file.Close()
+		body, fileName = file, path.Base(header.Filename)
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(path.Ext(fileName)), ".")
+		}
+	} else {
+		if len(c.Body()) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Upload a file or send it as the request body"})
+		}
+		body = bytes.NewReader(c.Body())
+		if format == "" {
+			format = models.ProductJobJSONL
+			if strings.Contains(strings.ToLower(c.Get(fiber.HeaderContentType)), "csv") {
+				format = models.ProductJobCSV
+			}
+		}
+	}
+	if format == "ndjson" {
+		format = models.ProductJobJSONL
+	}
+
+	job, err := h.jobs.Import(c.UserContext(), format, fileName, body, c.QueryBool("dry_run"), optionalUserID(c))
+	if errors.Is(err, services.ErrInvalidJobFormat) {
+		return c.Status(400).JSON(fiber.Map{"error": "Format must be csv or jsonl"})
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to queue product import - %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to queue import"})
+	}
+	return c.Status(202).JSON(job)
+}
+
+// ExportProducts queues an export of the catalog, optionally filtered by
+// category slug (with its subcategories), text in the name or SKU, and
+// whether products are active
+func (h *ProductJobHandler) ExportProducts(c *fiber.Ctx) error {
+	var input struct {
+		Format   string `json:"format"`
+		Category string `json:"category"`
+		Query    string `json:"q"`
+		IsActive *bool  `json:"is_active"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	format := strings.ToLower(input.Format)
+	if format == "" {
+		format = models.ProductJobCSV
+	}
+	filter := map[string]string{}
+	if input.Category != "" {
+		filter["category"] = input.Category
+	}
+	if q := strings.TrimSpace(input.Query); q != "" {
+		filter["q"] = q
+	}
+	if input.IsActive != nil {
+		filter["is_active"] = strconv.FormatBool(*input.IsActive)
+	}
+
+	job, err := h.jobs.Export(c.UserContext(), format, filter, optionalUserID(c))
+	switch {
+	case errors.Is(err, services.ErrInvalidJobFormat):
+		return c.Status(400).JSON(fiber.Map{"error": "Format must be csv or jsonl"})
+	case errors.Is(err, services.ErrCategoryNotFound):
+		return c.Status(400).JSON(fiber.Map{"error": "Category not found"})
+	case err != nil:
+		log.Printf("ERROR: failed to queue product export - %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to queue export"})
+	}
+	return c.Status(202).JSON(job)
+}
+
+// GetProductJobs lists imports and exports, newest first, optionally of
+// one ?kind=import|export
+func (h *ProductJobHandler) GetProductJobs(c *fiber.Ctx) error {
+	page := max(c.QueryInt("page", 1), 1)
+	limit := min(max(c.QueryInt("limit", 20), 1), 100)
+
+	query := h.db.Model(&models.ProductJob{})
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product jobs"})
+	}
+	jobs := []models.ProductJob{}
+	if err := query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&jobs).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product jobs"})
+	}
+
+	return c.JSON(fiber.Map{
+		"jobs": jobs,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
+
+// GetProductJob returns a job's status and progress
+func (h *ProductJobHandler) GetProductJob(c *fiber.Ctx) error {
+	job, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+	return c.JSON(job)
+}
+
+// GetProductJobErrors pages through the rows an import rejected, in file
+// order; ?format=csv downloads them all as a report
+func (h *ProductJobHandler) GetProductJobErrors(c *fiber.Ctx) error {
+	job, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+	query := h.db.Model(&models.ProductJobError{}).Where("job_id = ?", job.ID).Order("row")
+
+	if c.Query("format") == models.ProductJobCSV {
+		var rows []models.ProductJobError
+		if err := query.Find(&rows).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch import errors"})
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"row", "sku", "field", "message"})
+		for _, r := range rows {
+			w.Write([]string{strconv.Itoa(r.Row), r.SKU, r.Field, r.Message})
+		}
+		w.Flush()
+		c.Set(fiber.HeaderContentType, services.ProductFileContentType(models.ProductJobCSV))
+		c.Set(fiber.HeaderContentDisposition, "attachment; filename="+strconv.Quote(job.ID.String()+"-errors.csv"))
+		return c.Send(buf.Bytes())
+	}
+
+	page := max(c.QueryInt("page", 1), 1)
+	limit := min(max(c.QueryInt("limit", 50), 1), 500)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch import errors"})
+	}
+	rows := []models.ProductJobError{}
+	if err := query.Offset((page - 1) * limit).Limit(limit).Find(&rows).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch import errors"})
+	}
+
+	return c.JSON(fiber.Map{
+		"errors": rows,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
+
+// DownloadProductExport streams the file of a completed export
+func (h *ProductJobHandler) DownloadProductExport(c *fiber.Ctx) error {
+	job, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+	file, err := h.jobs.OpenExport(c.UserContext(), job)
+	if errors.Is(err, services.ErrJobNotFinished) {
+		return c.Status(409).JSON(fiber.Map{"error": "Only completed exports can be downloaded", "status": job.Status})
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to open export %s - %v", job.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to open export"})
+	}
+
+	c.Set(fiber.HeaderContentType, services.ProductFileContentType(job.Format))
+	c.Set(fiber.HeaderContentDisposition, "attachment; filename="+strconv.Quote(job.FileName))
+	// The response closes file once it has been sent
+	return c.SendStream(file)
+}
+
+func (h *ProductJobHandler) find(c *fiber.Ctx) (*models.ProductJob, bool) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid job ID"})
+		return nil, false
+	}
+	job, err := h.jobs.Find(c.UserContext(), id)
+	if err != nil {
+		if errors.Is(err, services.ErrProductJobNotFound) {
+			c.Status(404).JSON(fiber.Map{"error": "Job not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return nil, false
+	}
+	return job, true
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"go-backend/config"
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/services"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PromotionHandler struct {
+	db       *gorm.DB
+	currency string
+}
+
+func NewPromotionHandler(db *gorm.DB, cfg *config.Config) *PromotionHandler {
+	return &PromotionHandler{db: db, currency: cfg.Currency}
+}
+
+// GetPromotions lists promotions, optionally only the active ones
+func (h *PromotionHandler) GetPromotions(c *fiber.Ctx) error {
+	query := h.db.Model(&models.Promotion{})
+	if c.Query("active") == "true" {
+		query = query.Where("is_active = ?", true)
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where("code ILIKE ? OR name ILIKE ?", "%"+q+"%", "%"+q+"%")
+	}
+
+	var promotions []models.Promotion
+	if err := query.Order("created_at DESC").Find(&promotions).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotions"})
+	}
+	return c.JSON(promotions)
+}
+
+func (h *PromotionHandler) CreatePromotion(c *fiber.Ctx) error {
+	var promotion models.Promotion
+	if err := c.BodyParser(&promotion); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	promotion.ID = uuid.New()
+	promotion.UsedCount = 0
+	if msg := validatePromotion(&promotion, h.currency); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	var existing int64
+	h.db.Model(&models.Promotion{}).Where("code = ?", promotion.Code).Count(&existing)
+	if existing > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "A promotion with this code already exists"})
+	}
+
+	if err := h.db.Create(&promotion).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create promotion"})
+	}
+	return c.Status(201).JSON(promotion)
+}
+
+// GetPromotion returns a promotion with its redemption count
+func (h *PromotionHandler) GetPromotion(c *fiber.Ctx) error {
+	promotion, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+
+	// Orders can be in different currencies, so totals are per currency
+	var rows []struct {
+		Currency string
+		Amount   int64
+	}
+	h.db.Model(&models.OrderDiscount{}).Where("promotion_id = ?", promotion.ID).
+		Select("discount_currency AS currency, COALESCE(SUM(discount_amount), 0) AS amount").
+		Group("discount_currency").Scan(&rows)
+	discounted := make([]money.Money, len(rows))
+	for i, r := range rows {
+		discounted[i] = money.New(r.Amount, r.Currency)
+	}
+
+	return c.JSON(fiber.Map{"promotion": promotion, "total_discounted": discounted})
+}
+
+func (h *PromotionHandler) UpdatePromotion(c *fiber.Ctx) error {
+	promotion, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+
+	code, used := promotion.Code, promotion.UsedCount
+	if err := c.BodyParser(promotion); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	// Usage is counted by checkout; the code is what customers were given
+	promotion.UsedCount = used
+	if used > 0 && services.NormalizePromoCode(promotion.Code) != code {
+		return c.Status(409).JSON(fiber.Map{"error": "The code of a promotion that has been used can't be changed"})
+	}
+	if msg := validatePromotion(promotion, h.currency); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	if err := h.db.Save(promotion).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update promotion"})
+	}
+	return c.JSON(promotion)
+}
+
+// DeletePromotion removes a promotion that was never used; used ones are
+// kept for the orders that reference them and should be deactivated instead
+func (h *PromotionHandler) DeletePromotion(c *fiber.Ctx) error {
+	promotion, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+
+	var used int64
+	h.db.Model(&models.OrderDiscount{}).Where("promotion_id = ?", promotion.ID).Count(&used)
+	if used > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Promotion has been used; deactivate it instead"})
+	}
+
+	if err := h.db.Delete(promotion).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete promotion"})
+	}
+	return c.JSON(fiber.Map{"message": "Promotion deleted successfully"})
+}
+
+func (h *PromotionHandler) find(c *fiber.Ctx) (*models.Promotion, bool) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid promotion ID"})
+		return nil, false
+	}
+	var promotion models.Promotion
+	if err := h.db.First(&promotion, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Promotion not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return nil, false
+	}
+	return &promotion, true
+}
+
+// validatePromotion normalizes the code, puts amounts given as bare
+// decimals in currency, and returns a message describing the first
+// problem, or "" when the promotion is valid
+func validatePromotion(p *models.Promotion, currency string) string {
+	p.Code = services.NormalizePromoCode(p.Code)
+	p.AmountOff = p.AmountOff.OrCurrency(currency)
+	p.MinOrderValue = p.MinOrderValue.OrCurrency(currency)
+	if !money.ValidCurrency(p.AmountOff.Currency) || !money.ValidCurrency(p.MinOrderValue.Currency) {
+		return "Invalid currency"
+	}
+	if p.Code == "" {
+		return "Code is required"
+	}
+	switch p.Type {
+	case models.PromotionPercentage:
+		if p.Value <= 0 || p.Value > 100 {
+			return "Percentage must be between 0 and 100"
+		}
+	case models.PromotionFixedAmount:
+		if !p.AmountOff.IsPositive() {
+			return "amount_off must be positive"
+		}
+	case models.PromotionBuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			return "buy_quantity and get_quantity must be positive"
+		}
+		if p.Value < 0 || p.Value > 100 {
+			return "Percentage must be between 0 and 100"
+		}
+	case models.PromotionFreeShipping:
+	default:
+		return "Type must be one of percentage, fixed_amount, free_shipping, buy_x_get_y"
+	}
+	if p.MinOrderValue.IsNegative() || p.UsageLimit < 0 || p.PerUserLimit < 0 {
+		return "Limits can't be negative"
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return "ends_at must be after starts_at"
+	}
+	return ""
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/payments"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ReturnHandler takes return requests from customers and lets staff
+// approve, receive and refund them
+type ReturnHandler struct {
+	db      *gorm.DB
+	returns *services.Returns
+}
+
+func NewReturnHandler(db *gorm.DB, returns *services.Returns) *ReturnHandler {
+	return &ReturnHandler{db: db, returns: returns}
+}
+
+// CreateReturn opens a return for the current user's delivered order
+func (h *ReturnHandler) CreateReturn(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid order ID"})
+	}
+
+	var input services.ReturnInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	ret, err := h.returns.Request(c.UserContext(), userID, orderID, input)
+	if err != nil {
+		return returnError(c, err)
+	}
+	return c.Status(201).JSON(ret)
+}
+
+// GetOrderReturns lists an order's returns, newest first
+func (h *ReturnHandler) GetOrderReturns(c *fiber.Ctx) error {
+	order, ok := ownOrder(c, h.db)
+	if !ok {
+		return nil
+	}
+
+	returns := []models.ReturnRequest{}
+	if err := h.db.Preload("Items").Where("order_id = ?", order.ID).Order("created_at DESC").Find(&returns).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch returns"})
+	}
+	return c.JSON(returns)
+}
+
+// GetReturns lists returns for staff, optionally by status
+func (h *ReturnHandler) GetReturns(c *fiber.Ctx) error {
+	query := h.db.Preload("Items")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	returns := []models.ReturnRequest{}
+	if err := query.Order("created_at DESC").Find(&returns).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch returns"})
+	}
+	return c.JSON(returns)
+}
+
+// GetReturn shows one return to its customer or to staff
+func (h *ReturnHandler) GetReturn(c *fiber.Ctx) error {
+	ret, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+	return c.JSON(ret)
+}
+
+// CancelReturn withdraws the current user's return while it awaits a decision
+func (h *ReturnHandler) CancelReturn(c *fiber.Ctx) error {
+	ret, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+	userID, _ := currentUserID(c)
+	ret, err := h.returns.Cancel(c.UserContext(), ret.ID, userID)
+	if err != nil {
+		return returnError(c, err)
+	}
+	return c.JSON(ret)
+}
+
+// ApproveReturn accepts a return; {"note": "..."} is shown to the customer
+func (h *ReturnHandler) ApproveReturn(c *fiber.Ctx) error {
+	return h.decide(c, h.returns.Approve)
+}
+
+// RejectReturn declines a return; {"note": "..."} should say why
+func (h *ReturnHandler) RejectReturn(c *fiber.Ctx) error {
+	return h.decide(c, h.returns.Reject)
+}
+
+func (h *ReturnHandler) decide(c *fiber.Ctx, decide func(context.Context, uuid.UUID, *uuid.UUID, string) (*models.ReturnRequest, error)) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid return ID"})
+	}
+	var input struct {
+		Note string `json:"note"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	ret, err := decide(c.UserContext(), id, optionalUserID(c), input.Note)
+	if err != nil {
+		return returnError(c, err)
+	}
+	return c.JSON(ret)
+}
+
+// ReceiveReturn records the goods as back in "warehouse_id" (the default
+// warehouse when empty); "restock" maps return item IDs to the units that
+// can be sold again
+func (h *ReturnHandler) ReceiveReturn(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid return ID"})
+	}
+	var input struct {
+		WarehouseID *uuid.UUID        `json:"warehouse_id"`
+		Restock     map[uuid.UUID]int `json:"restock"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	ret, err := h.returns.Receive(c.UserContext(), id, optionalUserID(c), input.WarehouseID, input.Restock)
+	if err != nil {
+		return returnError(c, err)
+	}
+	return c.JSON(ret)
+}
+
+// RefundReturn refunds a return through the order's payments. amount is
+// minor units with a currency, or a decimal in the order's currency; zero
+// refunds what the returned items were paid for.
+func (h *ReturnHandler) RefundReturn(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid return ID"})
+	}
+	var input struct {
+		Amount money.Money `json:"amount"`
+		Reason string      `json:"reason"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	ret, err := h.returns.Refund(c.UserContext(), id, optionalUserID(c), input.Amount, input.Reason)
+	if err != nil {
+		return returnError(c, err)
+	}
+	return c.Status(202).JSON(ret)
+}
+
+// find loads the :id return if it belongs to the current user or the user
+// is staff, writing the error response otherwise
+func (h *ReturnHandler) find(c *fiber.Ctx) (*models.ReturnRequest, bool) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid return ID"})
+		return nil, false
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	var ret models.ReturnRequest
+	if err := h.db.Preload("Items").First(&ret, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Return not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Failed to fetch return"})
+		}
+		return nil, false
+	}
+	if ret.UserID != userID && !isStaff(h.db, userID) {
+		c.Status(404).JSON(fiber.Map{"error": "Return not found"})
+		return nil, false
+	}
+	return &ret, true
+}
+
+func returnError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrOrderNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+	case errors.Is(err, services.ErrReturnNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Return not found"})
+	case errors.Is(err, services.ErrWarehouseNotFound):
+		return c.Status(400).JSON(fiber.Map{"error": "Warehouse not found or inactive"})
+	case errors.Is(err, services.ErrInvalidReturn):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrNotReturnable), errors.Is(err, services.ErrReturnStatus), errors.Is(err, services.ErrNothingToRefund):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, payments.ErrNotFound), errors.Is(err, payments.ErrDeclined):
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("ERROR: return failed - %v", err)
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to update return"})
+}
@@ -0,0 +1,561 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"go-backend/models"
+	"go-backend/services"
+	"go-backend/storage"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxReviewTitle  = 200
+	maxReviewBody   = 5000
+	maxReviewImages = 6
+)
+
+// ReviewHandler manages product reviews, their moderation and
+// helpfulness votes
+type ReviewHandler struct {
+	db      *gorm.DB
+	catalog *services.Catalog
+	media   storage.BlobStore
+}
+
+func NewReviewHandler(db *gorm.DB, catalog *services.Catalog, media storage.BlobStore) *ReviewHandler {
+	return &ReviewHandler{db: db, catalog: catalog, media: media}
+}
+
+// reviewInput is the body of creating or editing a review; Images are IDs
+// of image assets the reviewer uploaded to the media library
+type reviewInput struct {
+	Rating int         `json:"rating"`
+	Title  string      `json:"title"`
+	Body   string      `json:"body"`
+	Images []uuid.UUID `json:"images"`
+}
+
+// GetProductReviews lists a product's approved reviews with its rating
+// summary. ?rating=5 and ?verified=true filter; ?sort= is newest
+// (default), helpful, highest or lowest.
+func (h *ReviewHandler) GetProductReviews(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	var product models.Product
+	if err := h.db.Select("id", "rating_average", "rating_count", "rating_histogram").First(&product, productID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	page := max(c.QueryInt("page", 1), 1)
+	limit := min(max(c.QueryInt("limit", 10), 1), 50)
+
+	query := h.db.Model(&models.Review{}).Where("product_id = ? AND status = ?", productID, models.ReviewStatusApproved)
+	if rating := c.QueryInt("rating"); rating != 0 {
+		query = query.Where("rating = ?", rating)
+	}
+	if c.QueryBool("verified") {
+		query = query.Where("verified_purchase = ?", true)
+	}
+	order := "created_at DESC"
+	switch c.Query("sort", "newest") {
+	case "newest":
+	case "helpful":
+		order = "helpful_count DESC, created_at DESC"
+	case "highest":
+		order = "rating DESC, created_at DESC"
+	case "lowest":
+		order = "rating ASC, created_at DESC"
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Sort must be newest, helpful, highest or lowest"})
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+	reviews := []models.Review{}
+	if err := query.Preload("User", publicAuthor).Order(order).
+		Offset((page - 1) * limit).Limit(limit).
+		Find(&reviews).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+
+	return c.JSON(fiber.Map{
+		"reviews": reviews,
+		"summary": fiber.Map{
+			"average":   product.RatingAverage,
+			"count":     product.RatingCount,
+			"histogram": ratingHistogram(product.RatingHistogram),
+		},
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
+
+// CreateReview adds the user's review of a product, pending moderation.
+// It is marked as a verified purchase when the user has had the product
+// delivered.
+func (h *ReviewHandler) CreateReview(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Missing auth token"})
+	}
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	var input reviewInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	var product models.Product
+	if err := h.db.Select("id", "is_active").First(&product, productID).Error; err != nil || !product.IsActive {
+		if err == nil || err == gorm.ErrRecordNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+	var existing int64
+	h.db.Model(&models.Review{}).Where("product_id = ? AND user_id = ?", productID, userID).Count(&existing)
+	if existing > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "You have already reviewed this product; edit your review instead"})
+	}
+
+	review := models.Review{ID: uuid.New(), ProductID: productID, UserID: userID, Status: models.ReviewStatusPending}
+	if status, msg := h.applyInput(&review, &input); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := h.verify(&review); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	if err := h.db.Omit("User").Create(&review).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create review"})
+	}
+
+	h.db.Preload("User", publicAuthor).First(&review, review.ID)
+	return c.Status(201).JSON(review)
+}
+
+// UpdateReview edits the user's own review; edited reviews go back
+// through moderation
+func (h *ReviewHandler) UpdateReview(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Missing auth token"})
+	}
+	var review models.Review
+	if !h.find(c, &review) {
+		return nil
+	}
+	if review.UserID != userID {
+		return c.Status(403).JSON(fiber.Map{"error": "You can only edit your own reviews"})
+	}
+	input := reviewInput{Rating: review.Rating, Title: review.Title, Body: review.Body}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if status, msg := h.applyInput(&review, &input); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := h.verify(&review); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	wasApproved := review.Status == models.ReviewStatusApproved
+	review.Status = models.ReviewStatusPending
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("User").Save(&review).Error; err != nil {
+			return err
+		}
+		if wasApproved {
+			return recountRating(tx, review.ProductID)
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update review"})
+	}
+	if wasApproved {
+		h.refresh(review.ProductID)
+	}
+
+	h.db.Preload("User", publicAuthor).First(&review, review.ID)
+	return c.JSON(review)
+}
+
+// DeleteReview removes a review; reviewers can delete their own and staff
+// any
+func (h *ReviewHandler) DeleteReview(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Missing auth token"})
+	}
+	var review models.Review
+	if !h.find(c, &review) {
+		return nil
+	}
+	if review.UserID != userID && !isStaff(h.db, userID) {
+		return c.Status(403).JSON(fiber.Map{"error": "You can only delete your own reviews"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("review_id = ?", review.ID).Delete(&models.ReviewVote{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&review).Error; err != nil {
+			return err
+		}
+		if review.Status == models.ReviewStatusApproved {
+			return recountRating(tx, review.ProductID)
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete review"})
+	}
+	if review.Status == models.ReviewStatusApproved {
+		h.refresh(review.ProductID)
+	}
+	return c.JSON(fiber.Map{"message": "Review deleted successfully"})
+}
+
+// GetReviewModerationQueue lists reviews in a moderation state (pending by
+// default) oldest first, for editors
+func (h *ReviewHandler) GetReviewModerationQueue(c *fiber.Ctx) error {
+	status := c.Query("status", models.ReviewStatusPending)
+	switch status {
+	case models.ReviewStatusPending, models.ReviewStatusApproved, models.ReviewStatusRejected:
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid status"})
+	}
+
+	page := max(c.QueryInt("page", 1), 1)
+	limit := min(max(c.QueryInt("limit", 20), 1), 100)
+
+	query := h.db.Model(&models.Review{}).Where("status = ?", status)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+	reviews := []models.Review{}
+	if err := query.Preload("User", publicAuthor).
+		Order("created_at ASC").
+		Offset((page - 1) * limit).Limit(limit).
+		Find(&reviews).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+
+	return c.JSON(fiber.Map{
+		"reviews": reviews,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
+
+func (h *ReviewHandler) ApproveReview(c *fiber.Ctx) error {
+	return h.moderate(c, models.ReviewStatusApproved)
+}
+
+func (h *ReviewHandler) RejectReview(c *fiber.Ctx) error {
+	return h.moderate(c, models.ReviewStatusRejected)
+}
+
+func (h *ReviewHandler) moderate(c *fiber.Ctx, status string) error {
+	moderatorID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Missing auth token"})
+	}
+	var review models.Review
+	if !h.find(c, &review) {
+		return nil
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	c.BodyParser(&input)
+
+	// Approving or un-approving a review changes the product's rating
+	recount := (review.Status == models.ReviewStatusApproved) != (status == models.ReviewStatusApproved)
+	now := time.Now()
+	review.Status = status
+	review.ModerationReason = strings.TrimSpace(input.Reason)
+	review.ModeratedBy = &moderatorID
+	review.ModeratedAt = &now
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("User").Save(&review).Error; err != nil {
+			return err
+		}
+		if recount {
+			return recountRating(tx, review.ProductID)
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update review"})
+	}
+	if recount {
+		h.refresh(review.ProductID)
+	}
+
+	h.db.Preload("User", publicAuthor).First(&review, review.ID)
+	return c.JSON(review)
+}
+
+// VoteReview records whether the user found an approved review helpful,
+// replacing an earlier vote; {"helpful": false} votes it unhelpful
+func (h *ReviewHandler) VoteReview(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	var review models.Review
+	if !h.find(c, &review) {
+		return nil
+	}
+	if review.Status != models.ReviewStatusApproved {
+		return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+	}
+	if review.UserID == userID {
+		return c.Status(403).JSON(fiber.Map{"error": "You can't vote on your own review"})
+	}
+	var input struct {
+		Helpful *bool `json:"helpful"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Helpful == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "helpful must be true or false"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var vote models.ReviewVote
+		err := tx.Where("review_id = ? AND user_id = ?", review.ID, userID).First(&vote).Error
+		if err == nil {
+			if vote.Helpful == *input.Helpful {
+				return nil
+			}
+			if err := tx.Model(&vote).Update("helpful", *input.Helpful).Error; err != nil {
+				return err
+			}
+			if err := bumpReviewVotes(tx, review.ID, vote.Helpful, -1); err != nil {
+				return err
+			}
+			return bumpReviewVotes(tx, review.ID, *input.Helpful, 1)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		vote = models.ReviewVote{ReviewID: review.ID, UserID: userID, Helpful: *input.Helpful}
+		if err := tx.Create(&vote).Error; err != nil {
+			return err
+		}
+		return bumpReviewVotes(tx, review.ID, vote.Helpful, 1)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save vote"})
+	}
+
+	h.db.Select("id", "helpful_count", "unhelpful_count").First(&review, review.ID)
+	return c.JSON(fiber.Map{"helpful": *input.Helpful, "helpful_count": review.HelpfulCount, "unhelpful_count": review.UnhelpfulCount})
+}
+
+// UnvoteReview withdraws the user's vote on a review
+func (h *ReviewHandler) UnvoteReview(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	reviewID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid review ID"})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var vote models.ReviewVote
+		err := tx.Where("review_id = ? AND user_id = ?", reviewID, userID).First(&vote).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&vote).Error; err != nil {
+			return err
+		}
+		return bumpReviewVotes(tx, reviewID, vote.Helpful, -1)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove vote"})
+	}
+	return c.Status(204).Send(nil)
+}
+
+func (h *ReviewHandler) find(c *fiber.Ctx, review *models.Review) bool {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid review ID"})
+		return false
+	}
+	if err := h.db.First(review, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return false
+	}
+	return true
+}
+
+// applyInput validates the input and copies it onto the review, resolving
+// image asset IDs to their URLs. It returns the status and message of the
+// first problem, or "" when the input is valid.
+func (h *ReviewHandler) applyInput(review *models.Review, input *reviewInput) (int, string) {
+	input.Title = strings.TrimSpace(input.Title)
+	input.Body = strings.TrimSpace(input.Body)
+	switch {
+	case input.Rating < 1 || input.Rating > 5:
+		return 400, "Rating must be from 1 to 5"
+	case len(input.Title) > maxReviewTitle:
+		return 400, "Title is too long"
+	case len(input.Body) > maxReviewBody:
+		return 400, "Review is too long"
+	case len(input.Images) > maxReviewImages:
+		return 400, "Reviews can have at most 6 images"
+	}
+	review.Rating, review.Title, review.Body = input.Rating, input.Title, input.Body
+
+	// Images are only replaced when the input lists them
+	if input.Images == nil {
+		if review.Images == nil {
+			review.Images = []string{}
+		}
+		return 0, ""
+	}
+	var assets []models.MediaAsset
+	if len(input.Images) > 0 {
+		if err := h.db.Select("id", "storage_key").
+			Where("id IN ? AND uploader_id = ? AND content_type LIKE ?", input.Images, review.UserID, "image/%").
+			Find(&assets).Error; err != nil {
+			return 500, "Database error"
+		}
+	}
+	keys := make(map[uuid.UUID]string, len(assets))
+	for _, a := range assets {
+		keys[a.ID] = a.StorageKey
+	}
+	review.Images = make([]string, 0, len(input.Images))
+	for _, id := range input.Images {
+		key, ok := keys[id]
+		if !ok {
+			return 400, "Images must be images you uploaded to the media library"
+		}
+		review.Images = append(review.Images, h.media.URL(key))
+	}
+	return 0, ""
+}
+
+// verify marks the review as a verified purchase when the reviewer has a
+// delivered order with the product
+func (h *ReviewHandler) verify(review *models.Review) error {
+	var order models.Order
+	err := h.db.Select("orders.id").
+		Joins("JOIN order_items ON order_items.order_id = orders.id").
+		Where("orders.user_id = ? AND orders.status = ? AND order_items.product_id = ?",
+			review.UserID, models.OrderStatusDelivered, review.ProductID).
+		Order("orders.created_at DESC").
+		First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		review.VerifiedPurchase, review.OrderID = false, nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	review.VerifiedPurchase, review.OrderID = true, &order.ID
+	return nil
+}
+
+// refresh reindexes a product whose rating changed for the rating sort
+func (h *ReviewHandler) refresh(productID uuid.UUID) {
+	if err := h.catalog.Refresh(context.Background(), productID); err != nil {
+		log.Printf("ERROR: failed to refresh the search index - %v", err)
+	}
+}
+
+// recountRating recomputes a product's rating summary from its approved
+// reviews
+func recountRating(tx *gorm.DB, productID uuid.UUID) error {
+	// Serialize recounts of the same product
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&product, productID).Error; err != nil {
+		return err
+	}
+	var rows []struct {
+		Rating int
+		Count  int
+	}
+	if err := tx.Model(&models.Review{}).Select("rating, COUNT(*) AS count").
+		Where("product_id = ? AND status = ?", productID, models.ReviewStatusApproved).
+		Group("rating").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	histogram := ratingHistogram(nil)
+	count, sum := 0, 0
+	for _, r := range rows {
+		histogram[r.Rating] = r.Count
+		count += r.Count
+		sum += r.Rating * r.Count
+	}
+	average := 0.0
+	if count > 0 {
+		average = math.Round(float64(sum)/float64(count)*100) / 100
+	}
+	return tx.Model(&product).Select("rating_average", "rating_count", "rating_histogram").
+		UpdateColumns(models.Product{RatingAverage: average, RatingCount: count, RatingHistogram: histogram}).Error
+}
+
+// ratingHistogram fills in zero counts for ratings without reviews
+func ratingHistogram(counts map[int]int) map[int]int {
+	histogram := make(map[int]int, 5)
+	for rating := 1; rating <= 5; rating++ {
+		histogram[rating] = counts[rating]
+	}
+	return histogram
+}
+
+// bumpReviewVotes keeps a review's vote counters in step with its votes
+func bumpReviewVotes(tx *gorm.DB, reviewID uuid.UUID, helpful bool, delta int) error {
+	column := "unhelpful_count"
+	if helpful {
+		column = "helpful_count"
+	}
+	return tx.Model(&models.Review{}).Where("id = ?", reviewID).
+		UpdateColumn(column, gorm.Expr(column+" + ?", delta)).Error
+}
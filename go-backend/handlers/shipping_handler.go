@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"strings"
+
+	"go-backend/config"
+	"go-backend/models"
+	"go-backend/money"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ShippingHandler manages shipping zones, their methods and rate tables
+type ShippingHandler struct {
+	db       *gorm.DB
+	currency string
+}
+
+func NewShippingHandler(db *gorm.DB, cfg *config.Config) *ShippingHandler {
+	return &ShippingHandler{db: db, currency: cfg.Currency}
+}
+
+// GetShippingZones lists zones with their methods and rates
+func (h *ShippingHandler) GetShippingZones(c *fiber.Ctx) error {
+	zones := []models.ShippingZone{}
+	err := h.db.Preload("Methods", func(db *gorm.DB) *gorm.DB { return db.Order("name") }).
+		Preload("Methods.Rates", func(db *gorm.DB) *gorm.DB { return db.Order("range_from") }).
+		Order("name").Find(&zones).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch shipping zones"})
+	}
+	return c.JSON(zones)
+}
+
+func (h *ShippingHandler) CreateShippingZone(c *fiber.Ctx) error {
+	var zone models.ShippingZone
+	if err := c.BodyParser(&zone); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	zone.ID = uuid.New()
+	// Methods are added through /shipping/zones/:id/methods
+	zone.Methods = nil
+	if msg := validateShippingZone(&zone); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	if err := h.db.Create(&zone).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create shipping zone"})
+	}
+	return c.Status(201).JSON(zone)
+}
+
+func (h *ShippingHandler) UpdateShippingZone(c *fiber.Ctx) error {
+	var zone models.ShippingZone
+	if !h.find(c, &zone, "shipping zone") {
+		return nil
+	}
+	id := zone.ID
+	if err := c.BodyParser(&zone); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	zone.ID = id
+	zone.Methods = nil
+	if msg := validateShippingZone(&zone); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	if err := h.db.Save(&zone).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update shipping zone"})
+	}
+	return c.JSON(zone)
+}
+
+// DeleteShippingZone removes a zone with its methods and rates. Orders keep
+// the method name they were shipped with.
+func (h *ShippingHandler) DeleteShippingZone(c *fiber.Ctx) error {
+	var zone models.ShippingZone
+	if !h.find(c, &zone, "shipping zone") {
+		return nil
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		methods := tx.Model(&models.ShippingMethod{}).Select("id").Where("zone_id = ?", zone.ID)
+		if err := tx.Where("method_id IN (?)", methods).Delete(&models.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("zone_id = ?", zone.ID).Delete(&models.ShippingMethod{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&zone).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete shipping zone"})
+	}
+	return c.JSON(fiber.Map{"message": "Shipping zone deleted successfully"})
+}
+
+// CreateShippingMethod adds a method with its rate table to a zone
+func (h *ShippingHandler) CreateShippingMethod(c *fiber.Ctx) error {
+	var zone models.ShippingZone
+	if !h.find(c, &zone, "shipping zone") {
+		return nil
+	}
+
+	var method models.ShippingMethod
+	if err := c.BodyParser(&method); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	method.ID = uuid.New()
+	method.ZoneID = zone.ID
+	if msg := h.validateShippingMethod(&method); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	if err := h.db.Create(&method).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create shipping method"})
+	}
+	return c.Status(201).JSON(method)
+}
+
+// UpdateShippingMethod changes a method; the rates in the body replace its
+// whole rate table
+func (h *ShippingHandler) UpdateShippingMethod(c *fiber.Ctx) error {
+	var method models.ShippingMethod
+	if !h.find(c, &method, "shipping method") {
+		return nil
+	}
+	id, zoneID := method.ID, method.ZoneID
+	if err := c.BodyParser(&method); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	method.ID, method.ZoneID = id, zoneID
+	if msg := h.validateShippingMethod(&method); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("method_id = ?", method.ID).Delete(&models.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Omit("Rates").Save(&method).Error; err != nil {
+			return err
+		}
+		if len(method.Rates) == 0 {
+			return nil
+		}
+		return tx.Create(&method.Rates).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update shipping method"})
+	}
+	return c.JSON(method)
+}
+
+func (h *ShippingHandler) DeleteShippingMethod(c *fiber.Ctx) error {
+	var method models.ShippingMethod
+	if !h.find(c, &method, "shipping method") {
+		return nil
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("method_id = ?", method.ID).Delete(&models.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&method).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete shipping method"})
+	}
+	return c.JSON(fiber.Map{"message": "Shipping method deleted successfully"})
+}
+
+// find loads the zone or method named by :id into dest, writing the error
+// response when it can't
+func (h *ShippingHandler) find(c *fiber.Ctx, dest any, what string) bool {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid " + what + " ID"})
+		return false
+	}
+	if err := h.db.First(dest, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": strings.ToUpper(what[:1]) + what[1:] + " not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return false
+	}
+	return true
+}
+
+// validateShippingMethod fills defaults, ties the rates to the method and
+// returns a message describing the first problem, or "" when it is valid
+func (h *ShippingHandler) validateShippingMethod(m *models.ShippingMethod) string {
+	m.Name = strings.TrimSpace(m.Name)
+	m.Currency = money.NormalizeCurrency(m.Currency)
+	if m.Currency == "" {
+		m.Currency = h.currency
+	}
+	if m.RateBasis == "" {
+		m.RateBasis = models.ShippingByWeight
+	}
+	switch {
+	case m.Name == "":
+		return "Name is required"
+	case m.RateBasis != models.ShippingByWeight && m.RateBasis != models.ShippingByPrice:
+		return "rate_basis must be weight or price"
+	case !money.ValidCurrency(m.Currency):
+		return "Invalid currency"
+	case m.VolumetricDivisor < 0 || m.MinDays < 0 || m.MaxDays < m.MinDays:
+		return "volumetric_divisor and days can't be negative, and max_days can't be below min_days"
+	}
+	for i := range m.Rates {
+		r := &m.Rates[i]
+		r.ID = uuid.New()
+		r.MethodID = m.ID
+		r.Price = r.Price.OrCurrency(m.Currency)
+		switch {
+		case r.From < 0 || (r.To != 0 && r.To <= r.From):
+			return "Each rate needs 0 <= from < to, or to of 0 for no upper bound"
+		case r.Price.Currency != m.Currency:
+			return "Rate prices must be in the method currency"
+		case r.Price.IsNegative():
+			return "Rate prices can't be negative"
+		}
+	}
+	return ""
+}
+
+// validateShippingZone normalizes the destination codes and returns a
+// message describing the first problem, or "" when the zone is valid
+func validateShippingZone(zone *models.ShippingZone) string {
+	zone.Name = strings.TrimSpace(zone.Name)
+	if zone.Name == "" {
+		return "Name is required"
+	}
+	if len(zone.Countries) == 0 {
+		return "countries must list at least one country code or \"*\""
+	}
+	for i, code := range zone.Countries {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		country, _, _ := strings.Cut(code, "-")
+		if code != "*" && len(country) != 2 {
+			return "Invalid country code " + code
+		}
+		zone.Countries[i] = code
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"strings"
+
+	"go-backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TaxHandler struct {
+	db *gorm.DB
+}
+
+func NewTaxHandler(db *gorm.DB) *TaxHandler {
+	return &TaxHandler{db: db}
+}
+
+// GetTaxRates lists tax rates, optionally for one country
+func (h *TaxHandler) GetTaxRates(c *fiber.Ctx) error {
+	query := h.db.Model(&models.TaxRate{})
+	if country := strings.ToUpper(strings.TrimSpace(c.Query("country"))); country != "" {
+		query = query.Where("country = ?", country)
+	}
+
+	rates := []models.TaxRate{}
+	if err := query.Order("country, region, category, name").Find(&rates).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch tax rates"})
+	}
+	return c.JSON(rates)
+}
+
+func (h *TaxHandler) CreateTaxRate(c *fiber.Ctx) error {
+	var rate models.TaxRate
+	if err := c.BodyParser(&rate); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	rate.ID = uuid.New()
+	if msg := validateTaxRate(&rate); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	if h.taxRateExists(&rate) {
+		return c.Status(409).JSON(fiber.Map{"error": "A tax rate with this name already exists for this scope"})
+	}
+
+	if err := h.db.Create(&rate).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create tax rate"})
+	}
+	return c.Status(201).JSON(rate)
+}
+
+// UpdateTaxRate changes a rate; orders already placed keep the tax they
+// were charged
+func (h *TaxHandler) UpdateTaxRate(c *fiber.Ctx) error {
+	rate, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+	if err := c.BodyParser(rate); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if msg := validateTaxRate(rate); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	if h.taxRateExists(rate) {
+		return c.Status(409).JSON(fiber.Map{"error": "A tax rate with this name already exists for this scope"})
+	}
+
+	if err := h.db.Save(rate).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update tax rate"})
+	}
+	return c.JSON(rate)
+}
+
+func (h *TaxHandler) DeleteTaxRate(c *fiber.Ctx) error {
+	rate, ok := h.find(c)
+	if !ok {
+		return nil
+	}
+	if err := h.db.Delete(rate).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete tax rate"})
+	}
+	return c.JSON(fiber.Map{"message": "Tax rate deleted successfully"})
+}
+
+func (h *TaxHandler) find(c *fiber.Ctx) (*models.TaxRate, bool) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid tax rate ID"})
+		return nil, false
+	}
+	var rate models.TaxRate
+	if err := h.db.First(&rate, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.Status(404).JSON(fiber.Map{"error": "Tax rate not found"})
+		} else {
+			c.Status(500).JSON(fiber.Map{"error": "Database error"})
+		}
+		return nil, false
+	}
+	return &rate, true
+}
+
+// taxRateExists reports whether another rate has the same name and scope
+func (h *TaxHandler) taxRateExists(rate *models.TaxRate) bool {
+	var count int64
+	h.db.Model(&models.TaxRate{}).
+		Where("name = ? AND country = ? AND region = ? AND category = ? AND id <> ?", rate.Name, rate.Country, rate.Region, rate.Category, rate.ID).
+		Count(&count)
+	return count > 0
+}
+
+// validateTaxRate normalizes the codes and returns a message describing the
+// first problem, or "" when the rate is valid
+func validateTaxRate(rate *models.TaxRate) string {
+	rate.Name = strings.TrimSpace(rate.Name)
+	rate.Country = strings.ToUpper(strings.TrimSpace(rate.Country))
+	rate.Region = strings.ToUpper(strings.TrimSpace(rate.Region))
+	rate.Category = strings.ToLower(strings.TrimSpace(rate.Category))
+	if rate.Name == "" {
+		return "Name is required"
+	}
+	if len(rate.Country) != 2 {
+		return "Country must be a two-letter ISO code"
+	}
+	if rate.Rate < 0 || rate.Rate > 100 {
+		return "Rate must be a percentage between 0 and 100"
+	}
+	return ""
+}
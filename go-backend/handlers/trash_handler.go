@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"go-backend/models"
+	"go-backend/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TrashHandler lists, restores and purges deleted products, posts, orders
+// and users
+type TrashHandler struct {
+	db      *gorm.DB
+	trash   *services.Trash
+	catalog *services.Catalog
+}
+
+func NewTrashHandler(db *gorm.DB, trash *services.Trash, catalog *services.Catalog) *TrashHandler {
+	return &TrashHandler{db: db, trash: trash, catalog: catalog}
+}
+
+// GetTrash pages through the :type rows in the trash, most recently
+// deleted first
+func (h *TrashHandler) GetTrash(c *fiber.Ctx) error {
+	page := max(c.QueryInt("page", 1), 1)
+	limit := min(max(c.QueryInt("limit", 20), 1), 100)
+
+	rows, total, err := h.trash.List(c.UserContext(), c.Params("type"), page, limit)
+	if errors.Is(err, services.ErrUnknownTrashKind) {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown trash type", "types": services.TrashKinds()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch the trash"})
+	}
+
+	return c.JSON(fiber.Map{
+		"items": rows,
+		"pagination": models.PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+		},
+	})
+}
+
+// RestoreFromTrash takes a row out of the trash
+func (h *TrashHandler) RestoreFromTrash(c *fiber.Ctx) error {
+	kind, id, ok := h.target(c)
+	if !ok {
+		return nil
+	}
+	err := h.trash.Restore(c.UserContext(), kind, id)
+	if status, msg := trashError(err); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if kind == services.TrashProducts {
+		h.refresh(id)
+	}
+	return c.JSON(fiber.Map{"message": "Restored successfully"})
+}
+
+// PurgeFromTrash deletes a row in the trash for good. Rows that orders,
+// invoices or content still refer to can't be purged.
+func (h *TrashHandler) PurgeFromTrash(c *fiber.Ctx) error {
+	kind, id, ok := h.target(c)
+	if !ok {
+		return nil
+	}
+	err := h.trash.Purge(c.UserContext(), kind, id)
+	var refErr *services.ReferencedError
+	if errors.As(err, &refErr) {
+		return c.Status(409).JSON(fiber.Map{"error": "Still referenced; it can only stay in the trash", "references": refErr.References})
+	}
+	if status, msg := trashError(err); msg != "" {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	return c.JSON(fiber.Map{"message": "Purged successfully"})
+}
+
+func (h *TrashHandler) target(c *fiber.Ctx) (string, uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		c.Status(400).JSON(fiber.Map{"error": "Invalid ID"})
+		return "", uuid.Nil, false
+	}
+	return c.Params("type"), id, true
+}
+
+// refresh puts a restored product back into search
+func (h *TrashHandler) refresh(productID uuid.UUID) {
+	if err := h.catalog.Refresh(context.Background(), productID); err != nil {
+		log.Printf("ERROR: failed to refresh the search index - %v", err)
+	}
+}
+
+// trashError maps the trash service's errors to a status and message, or
+// "" for nil
+func trashError(err error) (int, string) {
+	switch {
+	case err == nil:
+		return 0, ""
+	case errors.Is(err, services.ErrUnknownTrashKind):
+		return 400, "Unknown trash type"
+	case errors.Is(err, services.ErrNotInTrash):
+		return 404, "Not found in the trash"
+	default:
+		log.Printf("ERROR: trash operation failed - %v", err)
+		return 500, "Database error"
+	}
+}
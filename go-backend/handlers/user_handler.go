@@ -12,13 +12,26 @@ import (
 )
 
 type UserHandler struct {
-	db *gorm.DB
+	db         *gorm.DB
+	loginHooks []func(*fiber.Ctx, models.User)
 }
 
 func NewUserHandler(db *gorm.DB) *UserHandler {
 	return &UserHandler{db: db}
 }
 
+// OnLogin registers fn to be called after a user logs in or registers,
+// e.g. to adopt state built up while anonymous
+func (h *UserHandler) OnLogin(fn func(*fiber.Ctx, models.User)) {
+	h.loginHooks = append(h.loginHooks, fn)
+}
+
+func (h *UserHandler) notifyLogin(c *fiber.Ctx, user models.User) {
+	for _, fn := range h.loginHooks {
+		fn(c, user)
+	}
+}
+
 // =========================
 // 🔐 Register
 // =========================
@@ -51,7 +64,7 @@ func (h *UserHandler) Register(c *fiber.Ctx) error {
 
 	// Kiểm tra trùng email hoặc username
 	var existing models.User
-	if err := h.db.Where("email = ? OR username = ?", input.Email, input.Username).First(&existing).Error; err == nil {
+	if err := h.db.Unscoped().Where("email = ? OR username = ?", input.Email, input.Username).First(&existing).Error; err == nil {
 		return c.Status(409).JSON(fiber.Map{"error": "User already exists"})
 	}
 
@@ -80,6 +93,7 @@ func (h *UserHandler) Register(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate token"})
 	}
+	h.notifyLogin(c, userData)
 
 	return c.Status(201).JSON(fiber.Map{
 		"token": token,
@@ -122,6 +136,7 @@ func (h *UserHandler) Login(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate token"})
 	}
+	h.notifyLogin(c, user)
 
 	return c.JSON(fiber.Map{
 		"token": token,
@@ -176,7 +191,7 @@ func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 
 	// Kiểm tra trùng email hoặc username
 	var existing models.User
-	if err := h.db.Where("email = ? OR username = ?", userData.Email, userData.Username).First(&existing).Error; err == nil {
+	if err := h.db.Unscoped().Where("email = ? OR username = ?", userData.Email, userData.Username).First(&existing).Error; err == nil {
 		return c.Status(409).JSON(fiber.Map{"error": "User already exists"})
 	}
 
@@ -279,7 +294,8 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 }
 
 // =========================
-// 🗑️ Xoá user theo ID
+// 🗑️ Xoá user theo ID (soft delete: user vào thùng rác, không đăng nhập
+// được nữa; đơn hàng và bài viết của user vẫn giữ nguyên)
 // =========================
 func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 	id := c.Params("id")
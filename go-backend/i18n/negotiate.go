@@ -0,0 +1,82 @@
+// Package i18n picks the locale to serve content in from a request's
+// explicit choice, its Accept-Language header and the site default.
+package i18n
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Normalize lowercases a language tag and uses "-" as the separator, so
+// "en_US" and "EN-us" both become "en-us"
+func Normalize(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// Base returns the primary language subtag: "en-us" -> "en"
+func Base(tag string) string {
+	base, _, _ := strings.Cut(tag, "-")
+	return base
+}
+
+// ParseAcceptLanguage returns the tags of an Accept-Language header ordered
+// by preference. Entries with q=0 and the "*" wildcard are dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var entries []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = Normalize(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.TrimSpace(key) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			entries = append(entries, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+
+	tags := make([]string, len(entries))
+	for i, e := range entries {
+		tags[i] = e.tag
+	}
+	return tags
+}
+
+// Negotiate builds the fallback chain of supported locales to try, best
+// first: the explicit ?lang= value, then Accept-Language preferences (each
+// falling back to its base language), then fallback. Locales outside
+// supported are skipped and each locale appears once.
+func Negotiate(explicit, acceptLanguage string, supported []string, fallback string) []string {
+	var chain []string
+	add := func(tag string) {
+		for _, candidate := range []string{tag, Base(tag)} {
+			if slices.Contains(supported, candidate) && !slices.Contains(chain, candidate) {
+				chain = append(chain, candidate)
+			}
+		}
+	}
+
+	if explicit != "" {
+		add(Normalize(explicit))
+	}
+	for _, tag := range ParseAcceptLanguage(acceptLanguage) {
+		add(tag)
+	}
+	add(Normalize(fallback))
+	return chain
+}
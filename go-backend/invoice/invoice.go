@@ -0,0 +1,128 @@
+// Package invoice renders issued invoices and credit notes to HTML and PDF
+// from the templates in templates/.
+package invoice
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"go-backend/models"
+	"go-backend/money"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// ErrUnsupportedText is returned by RenderPDF for documents with text its
+// Courier font can't show, such as Vietnamese names; their HTML version
+// stands in for the PDF
+var ErrUnsupportedText = errors.New("document text is outside Latin-1")
+
+// Seller is who issues the invoices
+type Seller struct {
+	Name    string
+	Address []string
+	TaxID   string
+	Email   string
+}
+
+// Document is what the templates see
+type Document struct {
+	Seller  Seller
+	Invoice models.Invoice
+}
+
+// Title is the document heading
+func (d Document) Title() string {
+	if d.Invoice.Kind == models.InvoiceKindCreditNote {
+		return "Credit note"
+	}
+	return "Invoice"
+}
+
+var funcs = template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+	"pct": func(rate float64) string {
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", rate), "0"), ".") + "%"
+	},
+	"amount": func(m money.Money) string {
+		return m.Decimal()
+	},
+	// left and right pad s to n columns, cutting it when it is longer
+	"left":  func(n int, s string) string { return pad(s, n, false) },
+	"right": func(n int, s string) string { return pad(s, n, true) },
+}
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("invoice.html.tmpl").Funcs(htmltemplate.FuncMap(funcs)).ParseFS(templateFS, "templates/invoice.html.tmpl"))
+	textTemplate = template.Must(template.New("invoice.txt.tmpl").Funcs(funcs).ParseFS(templateFS, "templates/invoice.txt.tmpl"))
+)
+
+// RenderHTML renders the document as a standalone HTML page
+func RenderHTML(doc Document) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderText renders the fixed-width layout the PDF is typeset from
+func RenderText(doc Document) (string, error) {
+	var buf bytes.Buffer
+	if err := textTemplate.Execute(&buf, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderPDF renders the document as a PDF, or fails with
+// ErrUnsupportedText when it has text outside Latin-1
+func RenderPDF(doc Document) ([]byte, error) {
+	text, err := RenderText(doc)
+	if err != nil {
+		return nil, err
+	}
+	title := doc.Title() + " " + doc.Invoice.Number
+	if !latin1(title) || !latin1(text) {
+		return nil, ErrUnsupportedText
+	}
+	return textPDF(title, strings.Split(strings.TrimRight(text, "\n"), "\n")), nil
+}
+
+// CanRenderPDF reports whether RenderPDF can typeset all of the document's
+// text
+func CanRenderPDF(doc Document) bool {
+	text, err := RenderText(doc)
+	return err == nil && latin1(doc.Title()+" "+doc.Invoice.Number) && latin1(text)
+}
+
+// latin1 reports whether every character of s is in Latin-1
+func latin1(s string) bool {
+	for _, r := range s {
+		if r > 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+func pad(s string, n int, alignRight bool) string {
+	if count := utf8.RuneCountInString(s); count > n {
+		return string([]rune(s)[:n])
+	} else if count < n {
+		fill := strings.Repeat(" ", n-count)
+		if alignRight {
+			return fill + s
+		}
+		return s + fill
+	}
+	return s
+}
@@ -33,6 +33,8 @@ func main() {
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
+		// Leave room for multipart overhead on top of the largest media upload
+		BodyLimit: int(cfg.MaxUploadBytes) + 1<<20,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -49,7 +51,7 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     cfg.CORSOrigins,
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,Idempotency-Key",
 		AllowCredentials: true,
 	}))
 
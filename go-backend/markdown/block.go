@@ -0,0 +1,471 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	atxHeadingRe    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+	thematicBreakRe = regexp.MustCompile(`^ {0,3}(?:(?:-[ ]*){3,}|(?:\*[ ]*){3,}|(?:_[ ]*){3,})$`)
+	setextRe        = regexp.MustCompile(`^ {0,3}(=+|-+)[ ]*$`)
+	fenceRe         = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ ]*([^`]*?)[ ]*$")
+	bulletRe        = regexp.MustCompile(`^( {0,3})([-+*])( +|$)`)
+	orderedRe       = regexp.MustCompile(`^( {0,3})(\d{1,9})([.)])( +|$)`)
+	tableDelimRe    = regexp.MustCompile(`^ {0,3}\|?[ ]*:?-+:?[ ]*(?:\|[ ]*:?-+:?[ ]*)*\|?[ ]*$`)
+	htmlBlockRe     = regexp.MustCompile(`(?i)^ {0,3}(?:<!--|</?(?:address|article|aside|blockquote|details|dialog|div|dl|dt|dd|fieldset|figcaption|figure|footer|form|h[1-6]|header|hr|iframe|li|main|nav|ol|p|pre|script|section|style|summary|table|tbody|td|tfoot|th|thead|tr|ul)(?:[ >/]|$))`)
+)
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentWidth(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func openFence(line string) (fence, info string, ok bool) {
+	m := fenceRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[2], m[3], true
+}
+
+func isClosingFence(line, fence string) bool {
+	trimmed := strings.TrimSpace(line)
+	if indentWidth(line) > 3 || len(trimmed) < len(fence) {
+		return false
+	}
+	return strings.Trim(trimmed, fence[:1]) == ""
+}
+
+type listMarker struct {
+	ordered bool
+	bullet  string
+	start   int
+	// content is the column where the item's content begins
+	content int
+	empty   bool
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	if thematicBreakRe.MatchString(line) {
+		return listMarker{}, false
+	}
+	if m := bulletRe.FindStringSubmatch(line); m != nil {
+		return newListMarker(line, m[1], m[2], m[3], false, 0), true
+	}
+	if m := orderedRe.FindStringSubmatch(line); m != nil {
+		start, _ := strconv.Atoi(m[2])
+		return newListMarker(line, m[1], m[2]+m[3], m[4], true, start), true
+	}
+	return listMarker{}, false
+}
+
+func newListMarker(line, indent, marker, spaces string, ordered bool, start int) listMarker {
+	lm := listMarker{ordered: ordered, bullet: marker[len(marker)-1:], start: start}
+	width := len(spaces)
+	lm.empty = isBlank(line[len(indent)+len(marker):])
+	if width == 0 || width > 4 || lm.empty {
+		width = 1
+	}
+	lm.content = len(indent) + len(marker) + width
+	return lm
+}
+
+func (lm listMarker) sameList(other listMarker) bool {
+	return lm.ordered == other.ordered && lm.bullet == other.bullet
+}
+
+// startsBlock reports whether line would interrupt a paragraph
+func startsBlock(line string) bool {
+	if _, _, ok := openFence(line); ok {
+		return true
+	}
+	if atxHeadingRe.MatchString(line) || thematicBreakRe.MatchString(line) || htmlBlockRe.MatchString(line) {
+		return true
+	}
+	if strings.HasPrefix(strings.TrimLeft(line, " "), ">") && indentWidth(line) < 4 {
+		return true
+	}
+	if lm, ok := parseListMarker(line); ok && !lm.empty && (!lm.ordered || lm.start == 1) {
+		return true
+	}
+	return false
+}
+
+// renderBlocks parses block structure out of lines and writes HTML. Tight
+// list items render their paragraphs without <p> wrappers.
+func (r *renderer) renderBlocks(lines []string, out *strings.Builder, tight bool) {
+	i := 0
+	for i < len(lines) {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			i++
+
+		case indentWidth(line) >= 4:
+			i = r.indentedCode(lines, i, out)
+
+		default:
+			if fence, info, ok := openFence(line); ok {
+				i = r.fencedCode(lines, i, fence, info, out)
+			} else if m := atxHeadingRe.FindStringSubmatch(line); m != nil {
+				r.heading(len(m[1]), m[2], out)
+				i++
+			} else if thematicBreakRe.MatchString(line) {
+				out.WriteString("<hr />\n")
+				i++
+			} else if strings.HasPrefix(strings.TrimLeft(line, " "), ">") {
+				i = r.blockquote(lines, i, out)
+			} else if lm, ok := parseListMarker(line); ok {
+				i = r.list(lines, i, lm, out)
+			} else if htmlBlockRe.MatchString(line) {
+				i = r.htmlBlock(lines, i, out)
+			} else if i+1 < len(lines) && strings.Contains(line, "|") && tableDelimRe.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-") {
+				if next, ok := r.table(lines, i, out); ok {
+					i = next
+				} else {
+					i = r.paragraph(lines, i, out, tight)
+				}
+			} else {
+				i = r.paragraph(lines, i, out, tight)
+			}
+		}
+	}
+}
+
+func (r *renderer) heading(level int, raw string, out *strings.Builder) {
+	content := r.inline(strings.TrimSpace(raw))
+	text := strings.Join(strings.Fields(plainText(content)), " ")
+	id := r.headingID(text)
+	r.toc = append(r.toc, Heading{Level: level, Text: text, ID: id})
+
+	tag := "h" + strconv.Itoa(level)
+	out.WriteString("<" + tag + ` id="` + html.EscapeString(id) + `">`)
+	out.WriteString(content)
+	out.WriteString(`<a class="anchor" href="#` + html.EscapeString(id) + `" aria-hidden="true"></a>`)
+	out.WriteString("</" + tag + ">\n")
+}
+
+func (r *renderer) fencedCode(lines []string, i int, fence, info string, out *strings.Builder) int {
+	indent := indentWidth(lines[i])
+	lang := ""
+	if fields := strings.Fields(info); len(fields) > 0 {
+		lang = strings.ToLower(fields[0])
+	}
+
+	var code []string
+	i++
+	for ; i < len(lines); i++ {
+		if isClosingFence(lines[i], fence) {
+			i++
+			break
+		}
+		line := lines[i]
+		// Remove up to the opening fence's indentation from content lines
+		strip := indentWidth(line)
+		if strip > indent {
+			strip = indent
+		}
+		code = append(code, line[strip:])
+	}
+
+	writeCode(out, strings.Join(code, "\n"), lang)
+	return i
+}
+
+func (r *renderer) indentedCode(lines []string, i int, out *strings.Builder) int {
+	var code []string
+	for i < len(lines) {
+		line := lines[i]
+		if isBlank(line) {
+			code = append(code, "")
+			i++
+			continue
+		}
+		if indentWidth(line) < 4 {
+			break
+		}
+		code = append(code, line[4:])
+		i++
+	}
+	for len(code) > 0 && code[len(code)-1] == "" {
+		code = code[:len(code)-1]
+	}
+
+	writeCode(out, strings.Join(code, "\n"), "")
+	return i
+}
+
+func writeCode(out *strings.Builder, code, lang string) {
+	if code != "" {
+		code += "\n"
+	}
+	if lang == "" {
+		out.WriteString("<pre><code>" + html.EscapeString(code) + "</code></pre>\n")
+		return
+	}
+	out.WriteString(`<pre><code class="language-` + html.EscapeString(lang) + `">`)
+	out.WriteString(Highlight(code, lang))
+	out.WriteString("</code></pre>\n")
+}
+
+func (r *renderer) blockquote(lines []string, i int, out *strings.Builder) int {
+	var inner []string
+	for i < len(lines) {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(trimmed, ">") && indentWidth(line) < 4 {
+			content := trimmed[1:]
+			content = strings.TrimPrefix(content, " ")
+			inner = append(inner, content)
+			i++
+			continue
+		}
+		// Lazy continuation of a paragraph inside the quote
+		if isBlank(line) || len(inner) == 0 || isBlank(inner[len(inner)-1]) || startsBlock(line) {
+			break
+		}
+		inner = append(inner, line)
+		i++
+	}
+
+	out.WriteString("<blockquote>\n")
+	r.renderBlocks(inner, out, false)
+	out.WriteString("</blockquote>\n")
+	return i
+}
+
+func (r *renderer) list(lines []string, i int, first listMarker, out *strings.Builder) int {
+	var items [][]string
+	loose := false
+	marker := first
+
+	for i < len(lines) {
+		line := lines[i]
+		lm, ok := parseListMarker(line)
+		if !ok || !lm.sameList(first) || indentWidth(line) >= marker.content {
+			break
+		}
+		marker = lm
+
+		item := []string{strings.TrimLeft(line[min(len(line), lm.content-1):], " ")}
+		if lm.empty {
+			item[0] = ""
+		}
+		i++
+
+		for i < len(lines) {
+			line = lines[i]
+			if isBlank(line) {
+				item = append(item, "")
+				i++
+				continue
+			}
+			if indentWidth(line) >= lm.content {
+				item = append(item, line[lm.content:])
+				i++
+				continue
+			}
+			// A marker left of the content column starts the next item, or
+			// ends the list, even where it couldn't interrupt a paragraph
+			// (e.g. "2." after "1.")
+			if _, ok := parseListMarker(line); ok {
+				break
+			}
+			prevBlank := isBlank(item[len(item)-1])
+			if !prevBlank && !startsBlock(line) {
+				// Lazy paragraph continuation
+				item = append(item, strings.TrimLeft(line, " "))
+				i++
+				continue
+			}
+			break
+		}
+
+		// Trailing blank lines separate items; interior ones make the list loose
+		trailing := 0
+		for len(item) > 1 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+			trailing++
+		}
+		for _, l := range item {
+			if l == "" {
+				loose = true
+			}
+		}
+		items = append(items, item)
+
+		if trailing > 0 {
+			if i < len(lines) {
+				if next, ok := parseListMarker(lines[i]); ok && next.sameList(first) && indentWidth(lines[i]) < marker.content {
+					loose = true
+					continue
+				}
+			}
+			break
+		}
+	}
+
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+		if first.start != 1 {
+			out.WriteString(`<ol start="` + strconv.Itoa(first.start) + `">` + "\n")
+		} else {
+			out.WriteString("<ol>\n")
+		}
+	} else {
+		out.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		out.WriteString("<li>")
+		var body strings.Builder
+		r.renderBlocks(item, &body, !loose)
+		out.WriteString(strings.TrimSuffix(body.String(), "\n"))
+		out.WriteString("</li>\n")
+	}
+	out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+func (r *renderer) htmlBlock(lines []string, i int, out *strings.Builder) int {
+	for i < len(lines) && !isBlank(lines[i]) {
+		out.WriteString(lines[i])
+		out.WriteString("\n")
+		i++
+	}
+	return i
+}
+
+func (r *renderer) paragraph(lines []string, i int, out *strings.Builder, tight bool) int {
+	var para []string
+	for i < len(lines) {
+		line := lines[i]
+		if isBlank(line) {
+			break
+		}
+		if len(para) > 0 {
+			if m := setextRe.FindStringSubmatch(line); m != nil {
+				level := 2
+				if m[1][0] == '=' {
+					level = 1
+				}
+				r.heading(level, strings.Join(para, "\n"), out)
+				return i + 1
+			}
+			if startsBlock(line) {
+				break
+			}
+		}
+		para = append(para, strings.TrimLeft(line, " "))
+		i++
+	}
+
+	content := r.inline(strings.TrimRight(strings.Join(para, "\n"), " "))
+	if tight {
+		out.WriteString(content)
+		out.WriteString("\n")
+	} else {
+		out.WriteString("<p>" + content + "</p>\n")
+	}
+	return i
+}
+
+// table renders a GFM pipe table. It reports false when the header and
+// delimiter rows disagree, in which case the lines are a plain paragraph.
+func (r *renderer) table(lines []string, i int, out *strings.Builder) (int, bool) {
+	header := splitTableRow(lines[i])
+	delims := splitTableRow(lines[i+1])
+	if len(header) != len(delims) {
+		return i, false
+	}
+
+	aligns := make([]string, len(delims))
+	for n, d := range delims {
+		d = strings.TrimSpace(d)
+		left, right := strings.HasPrefix(d, ":"), strings.HasSuffix(d, ":")
+		switch {
+		case left && right:
+			aligns[n] = "center"
+		case right:
+			aligns[n] = "right"
+		case left:
+			aligns[n] = "left"
+		}
+	}
+
+	writeRow := func(cells []string, tag string) {
+		out.WriteString("<tr>\n")
+		for n := range aligns {
+			cell := ""
+			if n < len(cells) {
+				cell = strings.TrimSpace(cells[n])
+			}
+			if aligns[n] != "" {
+				out.WriteString("<" + tag + ` align="` + aligns[n] + `">`)
+			} else {
+				out.WriteString("<" + tag + ">")
+			}
+			out.WriteString(r.inline(cell))
+			out.WriteString("</" + tag + ">\n")
+		}
+		out.WriteString("</tr>\n")
+	}
+
+	out.WriteString("<table>\n<thead>\n")
+	writeRow(header, "th")
+	out.WriteString("</thead>\n")
+
+	i += 2
+	bodyStarted := false
+	for i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]) {
+		if !bodyStarted {
+			out.WriteString("<tbody>\n")
+			bodyStarted = true
+		}
+		writeRow(splitTableRow(lines[i]), "td")
+		i++
+	}
+	if bodyStarted {
+		out.WriteString("</tbody>\n")
+	}
+	out.WriteString("</table>\n")
+	return i, true
+}
+
+// splitTableRow splits a pipe table row into cells, honouring escaped pipes
+// and pipes inside code spans
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	inCode := false
+	for n := 0; n < len(line); n++ {
+		ch := line[n]
+		switch {
+		case ch == '\\' && n+1 < len(line) && line[n+1] == '|':
+			cell.WriteByte('|')
+			n++
+		case ch == '`':
+			inCode = !inCode
+			cell.WriteByte(ch)
+		case ch == '|' && !inCode:
+			cells = append(cells, cell.String())
+			cell.Reset()
+		default:
+			cell.WriteByte(ch)
+		}
+	}
+	return append(cells, cell.String())
+}
@@ -0,0 +1,43 @@
+package markdown
+
+import "testing"
+
+func TestRenderBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// Lists
+		{"bullet list", "- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"ordered list", "1. x\n2. y", "<ol>\n<li>x</li>\n<li>y</li>\n</ol>\n"},
+		{"ordered list start", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"loose list", "- a\n\n- b", "<ul>\n<li><p>a</p></li>\n<li><p>b</p></li>\n</ul>\n"},
+		{"lazy continuation", "- a\nlazy", "<ul>\n<li>a\nlazy</li>\n</ul>\n"},
+		{"bullet change starts a new list", "- a\n* b", "<ul>\n<li>a</li>\n</ul>\n<ul>\n<li>b</li>\n</ul>\n"},
+		{"only 1. interrupts a paragraph", "para\n2. not a list", "<p>para\n2. not a list</p>\n"},
+		{"1. interrupts a paragraph", "para\n1. list", "<p>para</p>\n<ol>\n<li>list</li>\n</ol>\n"},
+
+		// Nesting
+		{"nested bullet list", "- a\n  - b\n  - c\n- d",
+			"<ul>\n<li>a\n<ul>\n<li>b</li>\n<li>c</li>\n</ul></li>\n<li>d</li>\n</ul>\n"},
+		{"nested ordered list", "1. a\n   1. b\n   2. c\n2. d",
+			"<ol>\n<li>a\n<ol>\n<li>b</li>\n<li>c</li>\n</ol></li>\n<li>d</li>\n</ol>\n"},
+		{"code block in list item", "- item\n\n  ```\n  code\n  ```",
+			"<ul>\n<li><p>item</p>\n<pre><code>code\n</code></pre></li>\n</ul>\n"},
+
+		// Code blocks
+		{"fenced code", "```go\nfunc main() {}\n```",
+			"<pre><code class=\"language-go\"><span class=\"hl-keyword\">func</span> <span class=\"hl-function\">main</span>() {}\n</code></pre>\n"},
+		{"indented code", "    code <b>\n    more", "<pre><code>code &lt;b&gt;\nmore\n</code></pre>\n"},
+		{"html in fenced code is escaped", "```\n<script>alert(1)</script>\n```",
+			"<pre><code>&lt;script&gt;alert(1)&lt;/script&gt;\n</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in).HTML; got != tt.want {
+				t.Errorf("Render(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
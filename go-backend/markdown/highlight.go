@@ -0,0 +1,221 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+)
+
+// language describes the lexical rules the highlighter needs for one language
+type language struct {
+	keywords     map[string]bool
+	literals     map[string]bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cStyle = [2]string{"/*", "*/"}
+
+	languages = map[string]*language{
+		"go": {
+			keywords:     words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"),
+			literals:     words("true false nil iota"),
+			lineComments: []string{"//"},
+			blockComment: cStyle,
+			quotes:       "\"'`",
+		},
+		"javascript": {
+			keywords:     words("async await break case catch class const continue debugger default delete do else export extends finally for from function if import in instanceof let new of return static super switch this throw try typeof var void while with yield"),
+			literals:     words("true false null undefined NaN Infinity"),
+			lineComments: []string{"//"},
+			blockComment: cStyle,
+			quotes:       "\"'`",
+		},
+		"typescript": {
+			keywords:     words("abstract as async await break case catch class const continue declare default delete do else enum export extends finally for from function if implements import in instanceof interface keyof let namespace new of private protected public readonly return static super switch this throw try type typeof var void while yield"),
+			literals:     words("true false null undefined"),
+			lineComments: []string{"//"},
+			blockComment: cStyle,
+			quotes:       "\"'`",
+		},
+		"python": {
+			keywords:     words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield"),
+			literals:     words("True False None"),
+			lineComments: []string{"#"},
+			quotes:       "\"'",
+		},
+		"bash": {
+			keywords:     words("if then else elif fi for while until do done case esac function in select return export local"),
+			literals:     words("true false"),
+			lineComments: []string{"#"},
+			quotes:       "\"'",
+		},
+		"sql": {
+			keywords:     words("select from where and or not insert into values update set delete create table alter drop index join left right inner outer on group by order having limit offset as distinct union all primary key foreign references default null is in like between case when then else end returning SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE ALTER DROP INDEX JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT OFFSET AS DISTINCT UNION ALL PRIMARY KEY FOREIGN REFERENCES DEFAULT NULL IS IN LIKE BETWEEN CASE WHEN THEN ELSE END RETURNING"),
+			literals:     words("true false TRUE FALSE"),
+			lineComments: []string{"--"},
+			blockComment: cStyle,
+			quotes:       "'\"",
+		},
+		"json": {
+			literals: words("true false null"),
+			quotes:   "\"",
+		},
+		"css": {
+			keywords:     words("@media @import @keyframes @font-face @supports"),
+			blockComment: cStyle,
+			quotes:       "\"'",
+		},
+		"rust": {
+			keywords:     words("as async await break const continue crate dyn else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while"),
+			literals:     words("true false None Some Ok Err"),
+			lineComments: []string{"//"},
+			blockComment: cStyle,
+			quotes:       "\"",
+		},
+	}
+
+	languageAliases = map[string]string{
+		"golang": "go",
+		"js":     "javascript",
+		"jsx":    "javascript",
+		"ts":     "typescript",
+		"tsx":    "typescript",
+		"py":     "python",
+		"sh":     "bash",
+		"shell":  "bash",
+		"zsh":    "bash",
+		"rs":     "rust",
+		"scss":   "css",
+	}
+)
+
+// Highlight wraps tokens of code in <span class="hl-*"> elements. Unknown
+// languages are returned escaped but otherwise untouched.
+func Highlight(code, lang string) string {
+	if alias, ok := languageAliases[lang]; ok {
+		lang = alias
+	}
+	l, ok := languages[lang]
+	if !ok {
+		return html.EscapeString(code)
+	}
+
+	var out strings.Builder
+	span := func(class, text string) {
+		out.WriteString(`<span class="hl-` + class + `">` + html.EscapeString(text) + "</span>")
+	}
+
+	i := 0
+	for i < len(code) {
+		rest := code[i:]
+
+		if prefix := matchPrefix(rest, l.lineComments); prefix != "" {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			span("comment", rest[:end])
+			i += end
+			continue
+		}
+
+		if l.blockComment[0] != "" && strings.HasPrefix(rest, l.blockComment[0]) {
+			end := strings.Index(rest[len(l.blockComment[0]):], l.blockComment[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += len(l.blockComment[0]) + len(l.blockComment[1])
+			}
+			span("comment", rest[:end])
+			i += end
+			continue
+		}
+
+		ch := code[i]
+		if strings.IndexByte(l.quotes, ch) >= 0 {
+			end := 1
+			for end < len(rest) && rest[end] != ch {
+				if rest[end] == '\n' && ch != '`' {
+					break
+				}
+				if rest[end] == '\\' && ch != '`' {
+					end++
+				}
+				end++
+			}
+			if end < len(rest) && rest[end] == ch {
+				end++
+			}
+			end = min(end, len(rest))
+			span("string", rest[:end])
+			i += end
+			continue
+		}
+
+		if isDigit(ch) {
+			end := 1
+			for end < len(rest) && (isIdentChar(rest[end]) || rest[end] == '.') {
+				end++
+			}
+			span("number", rest[:end])
+			i += end
+			continue
+		}
+
+		if isIdentStart(ch) {
+			end := 1
+			for end < len(rest) && isIdentChar(rest[end]) {
+				end++
+			}
+			word := rest[:end]
+			switch {
+			case l.keywords[word]:
+				span("keyword", word)
+			case l.literals[word]:
+				span("literal", word)
+			case end < len(rest) && rest[end] == '(':
+				span("function", word)
+			default:
+				out.WriteString(html.EscapeString(word))
+			}
+			i += end
+			continue
+		}
+
+		out.WriteString(html.EscapeString(code[i : i+1]))
+		i++
+	}
+
+	return out.String()
+}
+
+func matchPrefix(s string, prefixes []string) string {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || ch == '@' || (ch|0x20 >= 'a' && ch|0x20 <= 'z')
+}
+
+func isIdentChar(ch byte) bool {
+	return isIdentStart(ch) || isDigit(ch)
+}
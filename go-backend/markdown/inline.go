@@ -0,0 +1,441 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	autolinkRe   = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*)>`)
+	emailLinkRe  = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+	inlineHTMLRe = regexp.MustCompile(`^(?:<[a-zA-Z][a-zA-Z0-9-]*(?:\s+[a-zA-Z_:][a-zA-Z0-9_.:-]*(?:\s*=\s*(?:[^\s"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*\s*/?>|</[a-zA-Z][a-zA-Z0-9-]*\s*>|<!--[\s\S]*?-->)`)
+	entityRe     = regexp.MustCompile(`^&(?:[a-zA-Z][a-zA-Z0-9]{1,31}|#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6});`)
+)
+
+const asciiPunct = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// inline renders inline Markdown (emphasis, code spans, links, images,
+// autolinks, raw HTML and line breaks) to HTML
+func (r *renderer) inline(s string) string {
+	var out strings.Builder
+	r.inlineTo(s, &out)
+	return out.String()
+}
+
+func (r *renderer) inlineTo(s string, out *strings.Builder) {
+	i := 0
+	for i < len(s) {
+		ch := s[i]
+		switch ch {
+		case '\\':
+			if i+1 < len(s) && strings.IndexByte(asciiPunct, s[i+1]) >= 0 {
+				out.WriteString(html.EscapeString(s[i+1 : i+2]))
+				i += 2
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\n' {
+				out.WriteString("<br />\n")
+				i += 2
+				continue
+			}
+
+		case '`':
+			if n, ok := r.codeSpan(s, i, out); ok {
+				i = n
+				continue
+			}
+			// Unmatched backtick run is literal text
+			run := countRun(s, i, '`')
+			out.WriteString(s[i : i+run])
+			i += run
+			continue
+
+		case '<':
+			if m := autolinkRe.FindStringSubmatch(s[i:]); m != nil {
+				writeLink(out, m[1], "", html.EscapeString(m[1]))
+				i += len(m[0])
+				continue
+			}
+			if m := emailLinkRe.FindStringSubmatch(s[i:]); m != nil {
+				writeLink(out, "mailto:"+m[1], "", html.EscapeString(m[1]))
+				i += len(m[0])
+				continue
+			}
+			if m := inlineHTMLRe.FindString(s[i:]); m != "" {
+				// Raw HTML is passed through and cleaned up by the sanitizer
+				out.WriteString(m)
+				i += len(m)
+				continue
+			}
+
+		case '&':
+			if m := entityRe.FindString(s[i:]); m != "" {
+				out.WriteString(m)
+				i += len(m)
+				continue
+			}
+
+		case '!':
+			if i+1 < len(s) && s[i+1] == '[' {
+				if n, ok := r.link(s, i+1, true, out); ok {
+					i = n
+					continue
+				}
+			}
+
+		case '[':
+			if n, ok := r.link(s, i, false, out); ok {
+				i = n
+				continue
+			}
+
+		case '*', '_', '~':
+			if n, ok := r.emphasis(s, i, out); ok {
+				i = n
+				continue
+			}
+			run := countRun(s, i, ch)
+			out.WriteString(s[i : i+run])
+			i += run
+			continue
+
+		case '\n':
+			// Two trailing spaces make a hard break, otherwise a soft break
+			text := out.String()
+			if strings.HasSuffix(text, "  ") {
+				trimmed := strings.TrimRight(text, " ")
+				out.Reset()
+				out.WriteString(trimmed)
+				out.WriteString("<br />\n")
+			} else {
+				out.WriteString("\n")
+			}
+			i++
+			continue
+		}
+
+		out.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+}
+
+func countRun(s string, i int, ch byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == ch {
+		n++
+	}
+	return n
+}
+
+func (r *renderer) codeSpan(s string, i int, out *strings.Builder) (int, bool) {
+	run := countRun(s, i, '`')
+	start := i + run
+	for j := start; j < len(s); {
+		if s[j] != '`' {
+			j++
+			continue
+		}
+		closing := countRun(s, j, '`')
+		if closing == run {
+			code := strings.ReplaceAll(s[start:j], "\n", " ")
+			if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			out.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			return j + closing, true
+		}
+		j += closing
+	}
+	return i, false
+}
+
+// link parses [text](dest "title"), [text][label] and [label] starting at
+// the opening bracket, rendering an image when image is true
+func (r *renderer) link(s string, open int, image bool, out *strings.Builder) (int, bool) {
+	closeBracket := matchingBracket(s, open)
+	if closeBracket < 0 {
+		return open, false
+	}
+	text := s[open+1 : closeBracket]
+	next := closeBracket + 1
+
+	var dest, title string
+	found := false
+
+	if next < len(s) && s[next] == '(' {
+		if d, t, end, ok := parseInlineDest(s, next); ok {
+			dest, title, next, found = d, t, end, true
+		}
+	}
+	if !found {
+		label := text
+		if next+1 < len(s) && s[next] == '[' {
+			if end := strings.IndexByte(s[next+1:], ']'); end >= 0 {
+				if l := s[next+1 : next+1+end]; l != "" {
+					label = l
+				}
+				next = next + 1 + end + 1
+			}
+		}
+		ref, ok := r.refs[normalizeLabel(label)]
+		if !ok {
+			return open, false
+		}
+		dest, title, found = ref.dest, ref.title, true
+	}
+
+	var resolved Image
+	if r.resolve != nil {
+		if img, ok := r.resolve(dest); ok {
+			resolved, dest = img, img.Src
+		}
+	}
+
+	if image {
+		alt := strings.Join(strings.Fields(plainText(r.inline(text))), " ")
+		if alt == "" {
+			alt = resolved.Alt
+		}
+		out.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(alt) + `"`)
+		if title != "" {
+			out.WriteString(` title="` + html.EscapeString(title) + `"`)
+		}
+		writeImageAttrs(out, resolved)
+		out.WriteString(" />")
+		return next, true
+	}
+
+	writeLink(out, dest, title, r.inline(text))
+	return next, true
+}
+
+// writeImageAttrs adds the responsive attributes of a resolved image
+func writeImageAttrs(out *strings.Builder, img Image) {
+	if img.Srcset != "" {
+		out.WriteString(` srcset="` + html.EscapeString(img.Srcset) + `"`)
+		if img.Sizes != "" {
+			out.WriteString(` sizes="` + html.EscapeString(img.Sizes) + `"`)
+		}
+	}
+	if img.Width > 0 && img.Height > 0 {
+		out.WriteString(` width="` + strconv.Itoa(img.Width) + `" height="` + strconv.Itoa(img.Height) + `"`)
+	}
+	if img.Src != "" {
+		out.WriteString(` loading="lazy"`)
+	}
+}
+
+func writeLink(out *strings.Builder, dest, title, content string) {
+	out.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+	if title != "" {
+		out.WriteString(` title="` + html.EscapeString(title) + `"`)
+	}
+	out.WriteString(">" + content + "</a>")
+}
+
+func matchingBracket(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '`':
+			// Brackets inside code spans don't count
+			run := countRun(s, i, '`')
+			if end := strings.Index(s[i+run:], strings.Repeat("`", run)); end >= 0 {
+				i += run + end + run - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseInlineDest parses `(dest "title")` starting at the opening paren
+func parseInlineDest(s string, open int) (dest, title string, end int, ok bool) {
+	i := open + 1
+	skipSpace := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+			i++
+		}
+	}
+	skipSpace()
+
+	if i < len(s) && s[i] == '<' {
+		closeAngle := strings.IndexByte(s[i:], '>')
+		if closeAngle < 0 {
+			return "", "", 0, false
+		}
+		dest = s[i+1 : i+closeAngle]
+		i += closeAngle + 1
+	} else {
+		depth := 0
+		start := i
+		for i < len(s) {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i += 2
+				continue
+			}
+			if c == ' ' || c == '\n' {
+				break
+			}
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+			i++
+		}
+		dest = unescapePunct(s[start:i])
+	}
+
+	skipSpace()
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		closer := s[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		endTitle := strings.IndexByte(s[i+1:], closer)
+		if endTitle < 0 {
+			return "", "", 0, false
+		}
+		title = unescapePunct(s[i+1 : i+1+endTitle])
+		i += endTitle + 2
+		skipSpace()
+	}
+
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return dest, title, i + 1, true
+}
+
+func unescapePunct(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(asciiPunct, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// emphasis handles *em*, **strong**, _em_, __strong__ and ~~del~~ using a
+// simplified version of the CommonMark flanking rules
+func (r *renderer) emphasis(s string, i int, out *strings.Builder) (int, bool) {
+	ch := s[i]
+	run := countRun(s, i, ch)
+
+	var width int
+	var tag string
+	switch {
+	case ch == '~' && run == 2:
+		width, tag = 2, "del"
+	case ch == '~':
+		return i, false
+	case run >= 3:
+		width, tag = 3, ""
+	case run == 2:
+		width, tag = 2, "strong"
+	default:
+		width, tag = 1, "em"
+	}
+
+	after := runeAt(s, i+run)
+	before := runeBefore(s, i)
+	if after == 0 || unicode.IsSpace(after) {
+		return i, false
+	}
+	if ch == '_' && before != 0 && (unicode.IsLetter(before) || unicode.IsDigit(before)) {
+		return i, false
+	}
+
+	var discard strings.Builder
+	for j := i + run; j < len(s); {
+		c := s[j]
+		if c == '\\' {
+			j += 2
+			continue
+		}
+		if c == '`' {
+			// Code span contents can't close emphasis
+			if end, ok := r.codeSpan(s, j, &discard); ok {
+				j = end
+				continue
+			}
+			j += countRun(s, j, '`')
+			continue
+		}
+		if c != ch {
+			j++
+			continue
+		}
+
+		n := countRun(s, j, ch)
+		closeBefore := runeBefore(s, j)
+		openAfter := runeAt(s, j+n)
+		canClose := !unicode.IsSpace(closeBefore)
+		if ch == '_' && openAfter != 0 && (unicode.IsLetter(openAfter) || unicode.IsDigit(openAfter)) {
+			canClose = false
+		}
+		canOpen := openAfter != 0 && !unicode.IsSpace(openAfter)
+
+		if canClose && (n == width || (n > width && !canOpen)) {
+			inner := s[i+width : j]
+			if tag == "" {
+				out.WriteString("<em><strong>")
+				r.inlineTo(inner, out)
+				out.WriteString("</strong></em>")
+			} else {
+				out.WriteString("<" + tag + ">")
+				r.inlineTo(inner, out)
+				out.WriteString("</" + tag + ">")
+			}
+			return j + width, true
+		}
+
+		// Skip over nested emphasis so its delimiters don't close ours
+		if canOpen {
+			if end, ok := r.emphasis(s, j, &discard); ok {
+				j = end
+				continue
+			}
+		}
+		j += n
+	}
+	return i, false
+}
+
+func runeAt(s string, i int) rune {
+	if i >= len(s) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return r
+}
+
+func runeBefore(s string, i int) rune {
+	if i <= 0 {
+		return 0
+	}
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return r
+}
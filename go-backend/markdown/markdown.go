@@ -0,0 +1,206 @@
+package markdown
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// WordsPerMinute is the reading speed used for the reading time estimate
+const WordsPerMinute = 200
+
+// Heading is one entry of the generated table of contents
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id"`
+}
+
+// Result is the output of the render pipeline
+type Result struct {
+	HTML        string    `json:"content_html"`
+	TOC         []Heading `json:"toc"`
+	WordCount   int       `json:"word_count"`
+	ReadingTime int       `json:"reading_time"`
+}
+
+// Image is the rendition a Resolver substitutes for a link or image
+// destination. Only Src is used for links.
+type Image struct {
+	Src    string
+	Srcset string
+	Sizes  string
+	Width  int
+	Height int
+	// Alt is used when the Markdown gives no alt text
+	Alt string
+}
+
+// Resolver maps a link or image destination to what should be rendered in
+// its place; ok is false to keep the destination as written
+type Resolver func(dest string) (img Image, ok bool)
+
+// Options customises rendering
+type Options struct {
+	// Resolve rewrites destinations such as media library references
+	Resolve Resolver
+}
+
+// Render converts Markdown source (CommonMark + GFM tables) to sanitized HTML,
+// collecting heading anchors and a table of contents along the way
+func Render(source string) Result {
+	return RenderWith(source, Options{})
+}
+
+// RenderWith is Render with options
+func RenderWith(source string, opts Options) Result {
+	r := newRenderer(source)
+	r.resolve = opts.Resolve
+	var out strings.Builder
+	r.renderBlocks(r.lines, &out, false)
+
+	text := plainText(out.String())
+	words := len(strings.Fields(text))
+
+	return Result{
+		HTML:        Sanitize(out.String()),
+		TOC:         r.toc,
+		WordCount:   words,
+		ReadingTime: ReadingTime(words),
+	}
+}
+
+// ReadingTime returns the estimated reading time in minutes for a word count
+func ReadingTime(words int) int {
+	if words <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(words) / WordsPerMinute))
+}
+
+type linkRef struct {
+	dest  string
+	title string
+}
+
+type renderer struct {
+	lines []string
+	refs  map[string]linkRef
+	ids   map[string]int
+	toc   []Heading
+
+	resolve Resolver
+}
+
+var linkRefDefRe = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:\s*<?([^\s>]+)>?(?:\s+(?:"([^"]*)"|'([^']*)'|\(([^)]*)\)))?\s*$`)
+
+func newRenderer(source string) *renderer {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	source = strings.ReplaceAll(source, "\x00", "�")
+
+	r := &renderer{
+		refs: map[string]linkRef{},
+		ids:  map[string]int{},
+	}
+
+	// Pull out link reference definitions so [text][label] links can resolve
+	// regardless of where the definition appears
+	inFence := ""
+	for _, line := range strings.Split(source, "\n") {
+		line = expandTabs(line)
+		if inFence == "" {
+			if fence, _, ok := openFence(line); ok {
+				inFence = fence
+			} else if m := linkRefDefRe.FindStringSubmatch(line); m != nil {
+				label := normalizeLabel(m[1])
+				if _, exists := r.refs[label]; !exists {
+					r.refs[label] = linkRef{dest: m[2], title: m[3] + m[4] + m[5]}
+				}
+				continue
+			}
+		} else if isClosingFence(line, inFence) {
+			inFence = ""
+		}
+		r.lines = append(r.lines, line)
+	}
+
+	return r
+}
+
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for _, ch := range line {
+		if ch == '\t' {
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(ch)
+		col++
+	}
+	return b.String()
+}
+
+// headingID turns heading text into a unique anchor slug
+func (r *renderer) headingID(text string) string {
+	var b strings.Builder
+	lastDash := false
+	for _, ch := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(ch) || unicode.IsDigit(ch):
+			b.WriteRune(ch)
+			lastDash = false
+		case ch == ' ' || ch == '-' || ch == '_':
+			if !lastDash && b.Len() > 0 {
+				b.WriteByte('-')
+				lastDash = true
+			}
+		}
+	}
+	id := strings.TrimRight(b.String(), "-")
+	if id == "" {
+		id = "section"
+	}
+
+	if n, exists := r.ids[id]; exists {
+		r.ids[id] = n + 1
+		id = id + "-" + strconv.Itoa(n+1)
+	}
+	r.ids[id] = 0
+	return id
+}
+
+var tagRe = regexp.MustCompile(`<[^>]*>`)
+
+// plainText strips tags from rendered HTML and decodes entities
+func plainText(s string) string {
+	return html.UnescapeString(tagRe.ReplaceAllString(s, " "))
+}
+
+// Excerpt returns up to n runes of plain text from rendered HTML, cut at a
+// word boundary
+func Excerpt(s string, n int) string {
+	text := strings.Join(strings.Fields(plainText(s)), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	cut := string(runes[:n])
+	if space := strings.LastIndexByte(cut, ' '); space > 0 {
+		cut = cut[:space]
+	}
+	return cut + "…"
+}
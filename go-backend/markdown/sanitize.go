@@ -0,0 +1,313 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Policy is an allowlist of elements and the attributes permitted on each
+type Policy struct {
+	// Elements maps an allowed tag name to its allowed attributes
+	Elements map[string][]string
+	// DropContent lists elements removed together with everything inside them
+	DropContent []string
+	// URLSchemes lists schemes accepted in href/src/cite; relative URLs are always allowed
+	URLSchemes []string
+}
+
+// DefaultPolicy is the policy applied to rendered post content
+var DefaultPolicy = &Policy{
+	Elements: map[string][]string{
+		"a": {"href", "title", "class"}, "abbr": {"title"}, "b": nil, "blockquote": {"cite"}, "br": nil,
+		"code": {"class"}, "dd": nil, "del": nil, "details": {"open"}, "div": nil, "dl": nil, "dt": nil,
+		"em": nil, "figcaption": nil, "figure": nil, "h1": {"id"}, "h2": {"id"}, "h3": {"id"},
+		"h4": {"id"}, "h5": {"id"}, "h6": {"id"}, "hr": nil, "i": nil,
+		"img": {"src", "alt", "title", "width", "height", "srcset", "sizes", "loading"},
+		"kbd": nil, "li": nil, "mark": nil, "ol": {"start"}, "p": nil, "pre": nil, "q": {"cite"},
+		"s": nil, "samp": nil, "small": nil, "span": {"class"}, "strong": nil, "sub": nil,
+		"summary": nil, "sup": nil, "table": nil, "tbody": nil, "td": {"align", "colspan", "rowspan"},
+		"tfoot": nil, "th": {"align", "colspan", "rowspan", "scope"}, "thead": nil, "tr": nil,
+		"u": nil, "ul": nil,
+	},
+	DropContent: []string{"script", "style", "iframe", "object", "embed", "noscript", "template", "textarea", "title", "svg", "math", "select", "frameset"},
+	URLSchemes:  []string{"http", "https", "mailto"},
+}
+
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true}
+
+var (
+	numberAttrRe = regexp.MustCompile(`^[0-9]{1,5}$`)
+	classTokenRe = regexp.MustCompile(`^(?:language-[a-z0-9+#-]+|hl-[a-z]+|anchor)$`)
+	tagNameRe    = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*`)
+	attrRe       = regexp.MustCompile(`^\s*([^\s"'<>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	validEntity  = regexp.MustCompile(`^&(?:[a-zA-Z][a-zA-Z0-9]{1,31}|#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6});`)
+)
+
+// Sanitize cleans untrusted HTML using DefaultPolicy
+func Sanitize(s string) string {
+	return DefaultPolicy.Sanitize(s)
+}
+
+// Sanitize re-serializes s keeping only allowlisted elements and attributes.
+// Disallowed tags are dropped (keeping their text), unsafe URLs removed and
+// unbalanced tags closed so the result can be embedded safely.
+func (p *Policy) Sanitize(s string) string {
+	var out strings.Builder
+	var stack []string
+	dropping := ""
+
+	i := 0
+	for i < len(s) {
+		lt := strings.IndexByte(s[i:], '<')
+		if lt < 0 {
+			if dropping == "" {
+				writeText(&out, s[i:])
+			}
+			break
+		}
+		if dropping == "" {
+			writeText(&out, s[i:i+lt])
+		}
+		i += lt
+
+		rest := s[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += 4 + end + 3
+			}
+			continue
+
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += end + 1
+			}
+			continue
+
+		case strings.HasPrefix(rest, "</"):
+			name := strings.ToLower(tagNameRe.FindString(rest[2:]))
+			end := strings.IndexByte(rest, '>')
+			if name == "" || end < 0 {
+				if dropping == "" {
+					out.WriteString("&lt;")
+				}
+				i++
+				continue
+			}
+			i += end + 1
+
+			if dropping != "" {
+				if name == dropping {
+					dropping = ""
+				}
+				continue
+			}
+			// Close everything up to the matching open element, ignore strays
+			for n := len(stack) - 1; n >= 0; n-- {
+				if stack[n] == name {
+					for m := len(stack) - 1; m >= n; m-- {
+						out.WriteString("</" + stack[m] + ">")
+					}
+					stack = stack[:n]
+					break
+				}
+			}
+			continue
+		}
+
+		name, attrs, end, ok := parseTag(rest)
+		if !ok {
+			if dropping == "" {
+				out.WriteString("&lt;")
+			}
+			i++
+			continue
+		}
+		i += end
+
+		if dropping != "" {
+			continue
+		}
+		if contains(p.DropContent, name) {
+			if !voidElements[name] && !strings.HasSuffix(strings.TrimSpace(rest[:end-1]), "/") {
+				dropping = name
+			}
+			continue
+		}
+		allowed, ok := p.Elements[name]
+		if !ok {
+			continue
+		}
+
+		out.WriteString("<" + name)
+		for _, a := range attrs {
+			if !contains(allowed, a.name) {
+				continue
+			}
+			if value, ok := p.cleanAttr(a.name, a.value); ok {
+				out.WriteString(" " + a.name + `="` + html.EscapeString(value) + `"`)
+			}
+		}
+		if name == "a" {
+			// User content must not pass link equity or window.opener
+			out.WriteString(` rel="nofollow noopener ugc"`)
+		}
+		out.WriteString(">")
+
+		if !voidElements[name] {
+			stack = append(stack, name)
+		}
+	}
+
+	for n := len(stack) - 1; n >= 0; n-- {
+		out.WriteString("</" + stack[n] + ">")
+	}
+	return out.String()
+}
+
+type attribute struct {
+	name  string
+	value string
+}
+
+// parseTag parses an opening tag at the start of s, returning the lowercase
+// tag name, its attributes (values entity-decoded) and the tag length
+func parseTag(s string) (string, []attribute, int, bool) {
+	name := tagNameRe.FindString(s[1:])
+	if name == "" {
+		return "", nil, 0, false
+	}
+	i := 1 + len(name)
+
+	var attrs []attribute
+	for i < len(s) {
+		rest := s[i:]
+		trimmed := strings.TrimLeft(rest, " \t\n\r\f")
+		i += len(rest) - len(trimmed)
+		if strings.HasPrefix(trimmed, ">") {
+			return strings.ToLower(name), attrs, i + 1, true
+		}
+		if strings.HasPrefix(trimmed, "/>") {
+			return strings.ToLower(name), attrs, i + 2, true
+		}
+		m := attrRe.FindStringSubmatch(trimmed)
+		if m == nil {
+			return "", nil, 0, false
+		}
+		attrs = append(attrs, attribute{
+			name:  strings.ToLower(m[1]),
+			value: html.UnescapeString(m[2] + m[3] + m[4]),
+		})
+		i += len(m[0])
+	}
+	return "", nil, 0, false
+}
+
+func (p *Policy) cleanAttr(name, value string) (string, bool) {
+	switch name {
+	case "href", "src", "cite":
+		return value, p.safeURL(value)
+
+	case "srcset":
+		for _, candidate := range strings.Split(value, ",") {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 || !p.safeURL(fields[0]) {
+				return "", false
+			}
+		}
+		return value, true
+
+	case "width", "height", "colspan", "rowspan", "start":
+		return value, numberAttrRe.MatchString(value)
+
+	case "align":
+		return value, value == "left" || value == "right" || value == "center"
+
+	case "scope":
+		return value, value == "row" || value == "col"
+
+	case "loading":
+		return value, value == "lazy" || value == "eager"
+
+	case "open":
+		return "", true
+
+	case "class":
+		var kept []string
+		for _, token := range strings.Fields(value) {
+			if classTokenRe.MatchString(token) {
+				kept = append(kept, token)
+			}
+		}
+		return strings.Join(kept, " "), len(kept) > 0
+
+	case "id":
+		for _, ch := range value {
+			if !unicode.IsLetter(ch) && !unicode.IsDigit(ch) && ch != '-' && ch != '_' {
+				return "", false
+			}
+		}
+		return value, value != ""
+	}
+
+	return value, true
+}
+
+func (p *Policy) safeURL(raw string) bool {
+	// Browsers ignore control characters and whitespace inside schemes
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+
+	colon := strings.IndexByte(cleaned, ':')
+	if colon < 0 {
+		return true
+	}
+	// A colon after a path, query or fragment delimiter isn't a scheme
+	if delim := strings.IndexAny(cleaned, "/?#"); delim >= 0 && delim < colon {
+		return true
+	}
+	return contains(p.URLSchemes, strings.ToLower(cleaned[:colon]))
+}
+
+func writeText(out *strings.Builder, text string) {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '<':
+			out.WriteString("&lt;")
+		case '>':
+			out.WriteString("&gt;")
+		case '"':
+			out.WriteString("&#34;")
+		case '&':
+			if m := validEntity.FindString(text[i:]); m != "" {
+				out.WriteString(m)
+				i += len(m) - 1
+			} else {
+				out.WriteString("&amp;")
+			}
+		default:
+			out.WriteByte(text[i])
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
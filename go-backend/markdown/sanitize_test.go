@@ -0,0 +1,54 @@
+package markdown
+
+import "testing"
+
+func TestRenderSanitizesXSS(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"script tag", "<script>alert(1)</script>", "\n"},
+		{"script inside svg", "<svg><script>alert(1)</script></svg>", "<p></p>\n"},
+		{"iframe", "<iframe src=\"https://evil\"></iframe>after", "after\n"},
+		{"event handler", "<img src=x onerror=alert(1)>", "<p><img src=\"x\"></p>\n"},
+		{"style and event attributes", "<div style=\"x\" onclick=\"y\">t</div>", "<div>t</div>\n"},
+		{"javascript link", "[x](javascript:alert(1))", "<p><a rel=\"nofollow noopener ugc\">x</a></p>\n"},
+		{"javascript image", "![x](javascript:alert(1))", "<p><img alt=\"x\"></p>\n"},
+		{"mixed case scheme", "<a href=\"JaVaScRiPt:alert(1)\">x</a>", "<p><a rel=\"nofollow noopener ugc\">x</a></p>\n"},
+		{"entity encoded scheme", "<a href=\"&#106;avascript:alert(1)\">x</a>", "<p><a rel=\"nofollow noopener ugc\">x</a></p>\n"},
+		{"data url", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p><a rel=\"nofollow noopener ugc\">x</a></p>\n"},
+		{"unclosed tag", "<b>unclosed", "<p><b>unclosed</b></p>\n"},
+		{"stray closing tags", "<p>a</p></div></div>", "<p>a</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in).HTML; got != tt.want {
+				t.Errorf("Render(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeURLs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"https://example.com", true},
+		{"/relative/path", true},
+		{"#anchor", true},
+		{"mailto:a@example.com", true},
+		{"page?next=a:b", true},
+		{"javascript:alert(1)", false},
+		{"java\tscript:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"vbscript:x", false},
+		{"data:text/html,x", false},
+	}
+	for _, tt := range tests {
+		if got := DefaultPolicy.safeURL(tt.in); got != tt.want {
+			t.Errorf("safeURL(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
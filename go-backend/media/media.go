@@ -0,0 +1,192 @@
+// Package media inspects uploaded images, produces resized variants for
+// responsive srcset attributes and finds asset references in post content.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // register decoder
+	"image/jpeg"
+	"image/png"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Scheme prefixes asset references in Markdown, e.g. ![alt](asset:<uuid>)
+const Scheme = "asset:"
+
+// VariantWidths are the srcset widths generated for uploaded images; widths
+// at or above the original are skipped
+var VariantWidths = []int{320, 640, 1024, 1600}
+
+// DefaultSizes is the sizes attribute used with generated srcsets
+const DefaultSizes = "(max-width: 768px) 100vw, 768px"
+
+// ErrTooManyPixels is returned by Variants for images whose decoded size
+// would exceed the pixel limit
+var ErrTooManyPixels = errors.New("image has too many pixels")
+
+var referenceRe = regexp.MustCompile(`asset:([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})`)
+
+// ParseReference returns the asset ID of an asset: destination
+func ParseReference(dest string) (uuid.UUID, bool) {
+	if !strings.HasPrefix(dest, Scheme) {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(strings.TrimPrefix(dest, Scheme))
+	return id, err == nil
+}
+
+// References returns the distinct asset IDs referenced in content
+func References(content string) []uuid.UUID {
+	var ids []uuid.UUID
+	seen := map[uuid.UUID]bool{}
+	for _, m := range referenceRe.FindAllStringSubmatch(content, -1) {
+		id, err := uuid.Parse(m[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Info describes a decodable image
+type Info struct {
+	Width  int
+	Height int
+	Format string
+}
+
+// Inspect reads image dimensions without decoding the pixels
+func Inspect(data []byte) (Info, bool) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, false
+	}
+	return Info{Width: cfg.Width, Height: cfg.Height, Format: format}, true
+}
+
+// Pixels is the number of pixels the image decodes to
+func (i Info) Pixels() int64 {
+	return int64(i.Width) * int64(i.Height)
+}
+
+// Variant is one resized rendition of an image
+type Variant struct {
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// Suffix is appended to the original storage key to name this variant
+func (v Variant) Suffix() string {
+	if v.ContentType == "image/png" {
+		return "-" + strconv.Itoa(v.Width) + "w.png"
+	}
+	return "-" + strconv.Itoa(v.Width) + "w.jpg"
+}
+
+// Variants decodes an image and produces a downscaled copy for each of
+// widths narrower than the original. PNGs stay PNG to keep transparency;
+// everything else is re-encoded as JPEG. The dimensions are read from the
+// header first so images over maxPixels (when positive) are rejected with
+// ErrTooManyPixels before any pixels are decoded.
+func Variants(data []byte, widths []int, maxPixels int64) ([]Variant, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	info := Info{Width: cfg.Width, Height: cfg.Height}
+	if maxPixels > 0 && info.Pixels() > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+	if !slices.ContainsFunc(widths, func(w int) bool { return w < info.Width }) {
+		return nil, nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	src := toNRGBA(img)
+	bounds := src.Bounds()
+
+	var variants []Variant
+	for _, w := range widths {
+		if w >= bounds.Dx() {
+			continue
+		}
+		h := max(1, bounds.Dy()*w/bounds.Dx())
+		dst := resize(src, w, h)
+
+		var buf bytes.Buffer
+		v := Variant{Width: w, Height: h}
+		if format == "png" {
+			v.ContentType = "image/png"
+			err = png.Encode(&buf, dst)
+		} else {
+			v.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 82})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encode %dw variant: %w", w, err)
+		}
+		v.Data = buf.Bytes()
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// toNRGBA returns img as an *image.NRGBA, converting it only when it isn't
+// one already
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		return nrgba
+	}
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(b)
+	draw.Draw(nrgba, b, img, b.Min, draw.Src)
+	return nrgba
+}
+
+// resize downscales src to w x h by averaging the source pixels covered by
+// each destination pixel (a box filter), which avoids the aliasing of
+// nearest-neighbour sampling when shrinking photos
+func resize(rgba *image.NRGBA, w, h int) *image.NRGBA {
+	b := rgba.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	sw, sh := b.Dx(), b.Dy()
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				off := rgba.PixOffset(b.Min.X+x0, b.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					p := rgba.Pix[off : off+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					bl += uint64(p[2])
+					a += uint64(p[3])
+					n++
+					off += 4
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
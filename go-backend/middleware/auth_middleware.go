@@ -1,18 +1,22 @@
 package middleware
 
 import (
+	"strings"
+
+	"go-backend/models"
 	"go-backend/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
-// AuthRequired middleware: kiểm tra JWT từ HttpOnly cookie
+// AuthRequired middleware: kiểm tra JWT từ HttpOnly cookie hoặc header Authorization
 func AuthRequired(c *fiber.Ctx) error {
-	cookie := c.Cookies("jwt")
-	if cookie == "" {
+	token := tokenFromRequest(c)
+	if token == "" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing auth token"})
 	}
-	userId, err := utils.ValidateToken(cookie)
+	userId, err := utils.ValidateToken(token)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
 	}
@@ -20,3 +24,54 @@ func AuthRequired(c *fiber.Ctx) error {
 	c.Locals("userId", userId)
 	return c.Next()
 }
+
+// OptionalAuth middleware: giống AuthRequired nhưng cho phép request ẩn danh
+func OptionalAuth(c *fiber.Ctx) error {
+	if token := tokenFromRequest(c); token != "" {
+		if userId, err := utils.ValidateToken(token); err == nil {
+			c.Locals("userId", userId)
+		}
+	}
+	return c.Next()
+}
+
+// RequireRole middleware: chỉ cho phép user có một trong các role được liệt kê.
+// Phải đặt sau AuthRequired.
+func RequireRole(db *gorm.DB, roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, _ := c.Locals("userId").(string)
+		if userId == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing auth token"})
+		}
+
+		var user models.User
+		if err := db.Select("id", "role", "is_active").First(&user, "id = ?", userId).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
+		}
+		if !user.IsActive || !hasRole(user.Role, roles) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Insufficient permissions"})
+		}
+
+		c.Locals("userRole", user.Role)
+		return c.Next()
+	}
+}
+
+func tokenFromRequest(c *fiber.Ctx) string {
+	if cookie := c.Cookies("jwt"); cookie != "" {
+		return cookie
+	}
+	if header := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return ""
+}
+
+func hasRole(role string, roles []string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+
+	"go-backend/services"
+	"go-backend/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IdempotencyHeader là header client gửi kèm để retry an toàn
+const IdempotencyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotency middleware: với POST/PUT/PATCH/DELETE có Idempotency-Key, chỉ
+// chạy handler một lần và trả lại response đã lưu cho các lần retry.
+// Cùng key nhưng khác method, path hoặc body thì trả 409; request trùng
+// đang chạy song song cũng nhận 409 kèm Retry-After.
+func Idempotency(keys *services.IdempotencyKeys) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := strings.TrimSpace(c.Get(IdempotencyHeader))
+		if key == "" || !isMutating(c.Method()) {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Idempotency-Key must be at most 255 characters"})
+		}
+
+		scope := idempotencyScope(c)
+		method, path := c.Method(), c.OriginalURL()
+		sum := sha256.New()
+		sum.Write([]byte(method + " " + path + "\n"))
+		sum.Write(c.Body())
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		stored, err := keys.Claim(c.UserContext(), scope, key, method, path, fingerprint)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyMismatch):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Idempotency-Key was already used for a different request"})
+		case errors.Is(err, services.ErrIdempotencyInProgress):
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A request with this Idempotency-Key is still being processed"})
+		case err != nil:
+			log.Printf("ERROR: failed to claim idempotency key - %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to process Idempotency-Key"})
+		case stored != nil:
+			// Trả lại đúng response của lần đầu
+			c.Set("Idempotent-Replayed", "true")
+			if stored.ResponseContentType != "" {
+				c.Set(fiber.HeaderContentType, stored.ResponseContentType)
+			}
+			return c.Status(stored.ResponseStatus).Send(stored.ResponseBody)
+		}
+
+		// Lỗi 5xx có thể hết khi retry nên không lưu lại
+		if err := c.Next(); err != nil {
+			release(c, keys, scope, key)
+			return err
+		}
+		res := c.Response()
+		if res.StatusCode() >= fiber.StatusInternalServerError || res.IsBodyStream() {
+			release(c, keys, scope, key)
+			return nil
+		}
+		body := append([]byte(nil), res.Body()...)
+		if err := keys.Complete(c.UserContext(), scope, key, res.StatusCode(), string(res.Header.ContentType()), body); err != nil {
+			log.Printf("ERROR: failed to store idempotent response - %v", err)
+		}
+		return nil
+	}
+}
+
+func release(c *fiber.Ctx, keys *services.IdempotencyKeys, scope, key string) {
+	if err := keys.Release(c.UserContext(), scope, key); err != nil {
+		log.Printf("ERROR: failed to release idempotency key - %v", err)
+	}
+}
+
+// idempotencyScope tách key theo người gửi: user đã đăng nhập, giỏ hàng
+// ẩn danh, hoặc IP
+func idempotencyScope(c *fiber.Ctx) string {
+	if token := tokenFromRequest(c); token != "" {
+		if userId, err := utils.ValidateToken(token); err == nil {
+			return "user:" + userId
+		}
+	}
+	cart := c.Get("X-Cart-Token")
+	if cart == "" {
+		cart = c.Cookies("cart_token")
+	}
+	if cart != "" {
+		sum := sha256.Sum256([]byte(cart))
+		return "cart:" + hex.EncodeToString(sum[:])
+	}
+	return "ip:" + c.IP()
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -1,12 +1,23 @@
 package models
 
 import (
+	"strings"
 	"time"
 
+	"go-backend/money"
+	"go-backend/tax"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// User roles
+const (
+	RoleUser   = "user"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
 type User struct {
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Email     string    `json:"email" gorm:"unique;not null"`
@@ -19,66 +30,1036 @@ type User struct {
 	IsActive  bool      `json:"is_active" gorm:"default:true"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt puts the user in the trash; the email and username stay
+	// taken until it is purged
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
 }
 
 type Post struct {
+	ID           uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Title        string            `json:"title" gorm:"not null"`
+	Content      string            `json:"content" gorm:"type:text"` // Markdown source
+	ContentHTML  string            `json:"content_html" gorm:"type:text"`
+	TOC          []TOCEntry        `json:"toc" gorm:"serializer:json;type:jsonb"`
+	ReadingTime  int               `json:"reading_time" gorm:"default:0"` // minutes
+	Slug         string            `json:"slug" gorm:"unique;not null"`
+	Locale       string            `json:"locale" gorm:"not null;default:vi"` // language of the canonical content
+	Translations []PostTranslation `json:"translations,omitempty"`
+	// AvailableLocales lists the canonical locale and every translation
+	AvailableLocales []string       `json:"available_locales,omitempty" gorm:"-"`
+	AuthorID         uuid.UUID      `json:"author_id" gorm:"type:uuid;not null"`
+	Author           User           `json:"author" gorm:"foreignKey:AuthorID"`
+	Tags             []Tag          `json:"tags" gorm:"many2many:post_tags"`
+	ViewCount        int64          `json:"view_count" gorm:"default:0"`
+	ReactionCount    int64          `json:"reaction_count" gorm:"default:0"`
+	Status           string         `json:"status" gorm:"default:draft"`
+	PublishedAt      *time.Time     `json:"published_at"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// PostTranslation is a post's title and content in another locale. The
+// canonical post keeps everything else (author, tags, status, counters).
+type PostTranslation struct {
 	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PostID      uuid.UUID  `json:"post_id" gorm:"type:uuid;not null;uniqueIndex:idx_post_translations_post_locale"`
+	Locale      string     `json:"locale" gorm:"not null;uniqueIndex:idx_post_translations_post_locale;uniqueIndex:idx_post_translations_locale_slug"`
 	Title       string     `json:"title" gorm:"not null"`
+	Slug        string     `json:"slug" gorm:"not null;uniqueIndex:idx_post_translations_locale_slug"`
 	Content     string     `json:"content" gorm:"type:text"`
-	Slug        string     `json:"slug" gorm:"unique;not null"`
-	AuthorID    uuid.UUID  `json:"author_id" gorm:"type:uuid;not null"`
-	Author      User       `json:"author" gorm:"foreignKey:AuthorID"`
-	Status      string     `json:"status" gorm:"default:draft"`
-	PublishedAt *time.Time `json:"published_at"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	ContentHTML string     `json:"content_html" gorm:"type:text"`
+	TOC         []TOCEntry `json:"toc" gorm:"serializer:json;type:jsonb"`
+	ReadingTime int        `json:"reading_time" gorm:"default:0"`
+	// SourceUpdatedAt is the canonical post's UpdatedAt when this translation
+	// was last saved; an older value means the translation may be outdated
+	SourceUpdatedAt time.Time `json:"source_updated_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type Tag struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name      string    `json:"name" gorm:"not null"`
+	Slug      string    `json:"slug" gorm:"unique;not null"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
+// TOCEntry is one heading of a post's generated table of contents
+type TOCEntry struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id"`
+}
+
+// Comment moderation states
+const (
+	CommentStatusPending  = "pending"
+	CommentStatusApproved = "approved"
+	CommentStatusRejected = "rejected"
+	CommentStatusSpam     = "spam"
+)
+
 type Comment struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Content    string     `json:"content" gorm:"type:text;not null"`
+	PostID     uuid.UUID  `json:"post_id" gorm:"type:uuid;not null;index"`
+	Post       *Post      `json:"post,omitempty" gorm:"foreignKey:PostID"`
+	AuthorID   uuid.UUID  `json:"author_id" gorm:"type:uuid;not null"`
+	Author     User       `json:"author" gorm:"foreignKey:AuthorID"`
+	ParentID   *uuid.UUID `json:"parent_id" gorm:"type:uuid;index"`
+	Depth      int        `json:"depth" gorm:"default:0"`
+	ThreadPath string     `json:"-" gorm:"index"` // materialized path, sorts replies under their parent
+	Status     string     `json:"status" gorm:"default:pending;index"`
+	// ModerationReason records why the spam checks or a moderator chose Status
+	ModerationReason   string     `json:"moderation_reason,omitempty"`
+	ContentFingerprint string     `json:"-" gorm:"index"`
+	IPAddress          string     `json:"-"`
+	UserAgent          string     `json:"-"`
+	ModeratedBy        *uuid.UUID `json:"moderated_by,omitempty" gorm:"type:uuid"`
+	ModeratedAt        *time.Time `json:"moderated_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// Reaction kinds and the entities they can target
+const (
+	ReactionTargetPost    = "post"
+	ReactionTargetComment = "comment"
+)
+
+var ReactionKinds = []string{"like", "love", "haha", "wow", "sad", "angry"}
+
+// Reaction is one user's reaction to a post or comment; a user has at most
+// one reaction per target and changing it replaces the kind
+type Reaction struct {
 	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Content    string    `json:"content" gorm:"type:text;not null"`
-	PostID     uuid.UUID `json:"post_id" gorm:"type:uuid;not null"`
-	Post       Post      `json:"post" gorm:"foreignKey:PostID"`
-	AuthorID   uuid.UUID `json:"author_id" gorm:"type:uuid;not null"`
-	Author     User      `json:"author" gorm:"foreignKey:AuthorID"`
-	IsApproved bool      `json:"is_approved" gorm:"default:false"`
+	UserID     uuid.UUID `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_reactions_user_target"`
+	TargetType string    `json:"target_type" gorm:"not null;uniqueIndex:idx_reactions_user_target;index:idx_reactions_target"`
+	TargetID   uuid.UUID `json:"target_id" gorm:"type:uuid;not null;uniqueIndex:idx_reactions_user_target;index:idx_reactions_target"`
+	Kind       string    `json:"kind" gorm:"not null"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+type Bookmark struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_bookmarks_user_post"`
+	PostID    uuid.UUID `json:"post_id" gorm:"type:uuid;not null;uniqueIndex:idx_bookmarks_user_post"`
+	Post      *Post     `json:"post,omitempty" gorm:"foreignKey:PostID"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MediaAsset is an uploaded file in the media library. Images also carry
+// their dimensions and the resized variants used for srcset.
+type MediaAsset struct {
+	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Filename    string         `json:"filename" gorm:"not null"`
+	ContentType string         `json:"content_type" gorm:"not null"`
+	Size        int64          `json:"size"`
+	Checksum    string         `json:"checksum" gorm:"index"` // sha256 of the original
+	StorageKey  string         `json:"-" gorm:"not null"`
+	URL         string         `json:"url" gorm:"-"`
+	Width       int            `json:"width,omitempty"`
+	Height      int            `json:"height,omitempty"`
+	AltText     string         `json:"alt_text"`
+	Caption     string         `json:"caption"`
+	Variants    []MediaVariant `json:"variants" gorm:"serializer:json;type:jsonb"`
+	UploaderID  uuid.UUID      `json:"uploader_id" gorm:"type:uuid;not null;index"`
+	Uploader    *User          `json:"uploader,omitempty" gorm:"foreignKey:UploaderID"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// MediaVariant is one resized copy of an image asset
+type MediaVariant struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	StorageKey  string `json:"-"`
+	URL         string `json:"url,omitempty"`
+}
+
+// IsImage reports whether the asset has dimensions and can be rendered inline
+func (m *MediaAsset) IsImage() bool {
+	return m.Width > 0 && m.Height > 0
+}
+
+// MediaReference records that a post (in any of its translations) embeds or
+// links to an asset; assets with references can't be deleted
+type MediaReference struct {
+	ID        uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	AssetID   uuid.UUID   `json:"asset_id" gorm:"type:uuid;not null;uniqueIndex:idx_media_references_asset_post"`
+	Asset     *MediaAsset `json:"asset,omitempty" gorm:"foreignKey:AssetID"`
+	PostID    uuid.UUID   `json:"post_id" gorm:"type:uuid;not null;uniqueIndex:idx_media_references_asset_post;index"`
+	Post      *Post       `json:"post,omitempty" gorm:"foreignKey:PostID"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Product.Price is the base price; Prices overrides it per currency, and
+// currencies without an entry are converted from the base price
 type Product struct {
-	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Name        string    `json:"name" gorm:"not null"`
-	Description string    `json:"description" gorm:"type:text"`
-	Price       float64   `json:"price" gorm:"type:decimal(10,2);not null"`
-	Stock       int       `json:"stock" gorm:"default:0"`
-	Category    string    `json:"category"`
-	ImageURL    string    `json:"image_url"`
+	ID   uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name string    `json:"name" gorm:"not null"`
+	// SKU is optional and shares one namespace with variant SKUs; imports
+	// match products by it
+	SKU         string         `json:"sku,omitempty" gorm:"size:64"`
+	Description string         `json:"description" gorm:"type:text"`
+	Price       money.Money    `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	Prices      []ProductPrice `json:"prices,omitempty" gorm:"foreignKey:ProductID"`
+	LocalPrice  *money.Money   `json:"local_price,omitempty" gorm:"-"` // price in the requested currency
+	// LowestPrice30d is the lowest price of the 30 days before Price took
+	// effect, from the price history and in the currency of Price, shown
+	// next to price reductions
+	LowestPrice30d *money.Money `json:"lowest_price_30d,omitempty" gorm:"-"`
+	// Stock is what can be sold across all active warehouses: on hand less
+	// reserved. It is kept in step with InventoryLevel and is read-only;
+	// products with variants count stock per variant.
+	Stock int `json:"stock" gorm:"default:0"`
+	// Options (e.g. Size, Color) span the Variants matrix
+	Options  []ProductOption  `json:"options,omitempty" gorm:"foreignKey:ProductID"`
+	Variants []ProductVariant `json:"variants,omitempty" gorm:"foreignKey:ProductID"`
+	// Category is the name of the CategoryID category, kept for promotions
+	// that target categories by name
+	CategoryID  *uuid.UUID `json:"category_id,omitempty" gorm:"type:uuid;index"`
+	Category    string     `json:"category"`
+	TaxCategory string     `json:"tax_category" gorm:"size:50"` // empty is the standard rate
+	// Shipping weight and package dimensions
+	WeightGrams int     `json:"weight_grams" gorm:"default:0"`
+	LengthCm    float64 `json:"length_cm"`
+	WidthCm     float64 `json:"width_cm"`
+	HeightCm    float64 `json:"height_cm"`
+	ImageURL    string  `json:"image_url"`
+	IsActive    bool    `json:"is_active" gorm:"default:true"`
+	// The rating summary of approved reviews is kept in step by review
+	// moderation and is read-only; RatingHistogram counts reviews per star
+	RatingAverage   float64     `json:"rating_average"`
+	RatingCount     int         `json:"rating_count" gorm:"default:0"`
+	RatingHistogram map[int]int `json:"rating_histogram,omitempty" gorm:"serializer:json"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+	// DeletedAt puts the product in the trash. Its prices, variants, stock
+	// and reviews are kept for a restore and order history still shows it;
+	// its SKU stays taken until it is purged.
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// Category is a node of the product category tree. Path joins the slugs
+// from the root down to it ("clothing/t-shirts"), so a subtree is every
+// category whose path starts with its own.
+type Category struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ParentID    *uuid.UUID `json:"parent_id" gorm:"type:uuid;index"`
+	Name        string     `json:"name" gorm:"not null"`
+	Slug        string     `json:"slug" gorm:"size:100;not null;uniqueIndex"`
+	Path        string     `json:"path" gorm:"not null;index"`
+	Description string     `json:"description" gorm:"type:text"`
+	Position    int        `json:"position" gorm:"default:0"`
+	Children    []Category `json:"children,omitempty" gorm:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ProductOption is an option type of a product, e.g. Size with the values
+// S, M and L, in display order
+type ProductOption struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID uuid.UUID `json:"product_id" gorm:"type:uuid;not null;uniqueIndex:idx_product_options_product_name"`
+	Name      string    `json:"name" gorm:"not null;uniqueIndex:idx_product_options_product_name"`
+	Values    []string  `json:"values" gorm:"serializer:json"`
+	Position  int       `json:"position" gorm:"default:0"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProductVariant is one sellable combination of a product's option values
+// with its own SKU and stock. Price overrides the product's price when it
+// has a currency; other currencies are converted from it.
+type ProductVariant struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID uuid.UUID `json:"product_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_product_variants_product_options"`
+	SKU       string    `json:"sku" gorm:"size:64;not null;uniqueIndex"`
+	Barcode   string    `json:"barcode,omitempty" gorm:"size:64;index"`
+	// Options maps each of the product's option names to this variant's
+	// value; OptionKey is its canonical form, unique per product
+	Options    map[string]string `json:"options" gorm:"serializer:json"`
+	OptionKey  string            `json:"-" gorm:"not null;uniqueIndex:idx_product_variants_product_options"`
+	Price      money.Money       `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	LocalPrice *money.Money      `json:"local_price,omitempty" gorm:"-"`
+	// LowestPrice30d is like Product.LowestPrice30d, for the price the
+	// variant sells at
+	LowestPrice30d *money.Money `json:"lowest_price_30d,omitempty" gorm:"-"`
+	Stock          int          `json:"stock" gorm:"default:0"` // like Product.Stock
+
+	// WeightGrams of zero ships at the product's weight
+	WeightGrams int       `json:"weight_grams" gorm:"default:0"`
+	Images      []string  `json:"images" gorm:"serializer:json"`
 	IsActive    bool      `json:"is_active" gorm:"default:true"`
+	Position    int       `json:"position" gorm:"default:0"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
-type Order struct {
+// Variant returns the product's variant with id, if it was loaded
+func (p *Product) Variant(id uuid.UUID) (*ProductVariant, bool) {
+	for i := range p.Variants {
+		if p.Variants[i].ID == id {
+			return &p.Variants[i], true
+		}
+	}
+	return nil, false
+}
+
+// Title joins the variant's values in the product's option order, e.g.
+// "M / Red"
+func (v *ProductVariant) Title(options []ProductOption) string {
+	var parts []string
+	for _, o := range options {
+		if value := v.Options[o.Name]; value != "" {
+			parts = append(parts, value)
+		}
+	}
+	if len(parts) == 0 {
+		return v.SKU
+	}
+	return strings.Join(parts, " / ")
+}
+
+// ProductPrice is a product's price list entry for one currency; unique
+// per product and currency
+type ProductPrice struct {
+	ID        uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID uuid.UUID   `json:"product_id" gorm:"type:uuid;not null;index"`
+	Price     money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// Price history sources
+const (
+	PriceSourceInitial  = "initial" // the price when history began
+	PriceSourceManual   = "manual"
+	PriceSourceImport   = "import"
+	PriceSourceSchedule = "schedule"
+	PriceSourceSaleEnd  = "sale_end"
+)
+
+// PriceHistory is the price a product, or one of its variants, sold at
+// from EffectiveAt until the next entry. Variant entries hold the price the
+// variant sells at, which is the product's price unless it has its own.
+type PriceHistory struct {
 	ID          uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	UserID      uuid.UUID   `json:"user_id" gorm:"type:uuid;not null"`
-	User        User        `json:"user" gorm:"foreignKey:UserID"`
-	TotalAmount float64     `json:"total_amount" gorm:"type:decimal(10,2);not null"`
-	Status      string      `json:"status" gorm:"default:pending"`
-	Items       []OrderItem `json:"items" gorm:"foreignKey:OrderID"`
+	ProductID   uuid.UUID   `json:"product_id" gorm:"type:uuid;not null;index:idx_price_histories_target"`
+	VariantID   *uuid.UUID  `json:"variant_id,omitempty" gorm:"type:uuid;index:idx_price_histories_target"`
+	Price       money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	Source      string      `json:"source" gorm:"not null"`
+	ScheduleID  *uuid.UUID  `json:"schedule_id,omitempty" gorm:"type:uuid"`
+	ChangedByID *uuid.UUID  `json:"changed_by_id,omitempty" gorm:"type:uuid"`
+	EffectiveAt time.Time   `json:"effective_at" gorm:"not null;index:idx_price_histories_target"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// Price schedule statuses. A sale is active between its start and end.
+const (
+	PriceScheduleScheduled = "scheduled"
+	PriceScheduleActive    = "active"
+	PriceScheduleCompleted = "completed"
+	PriceScheduleCancelled = "cancelled"
+)
+
+// PriceSchedule changes a product's or variant's own price at StartsAt.
+// With EndsAt it is a sale window: the price it replaced, kept in
+// RevertPrice, comes back at EndsAt unless the price was changed again in
+// the meantime.
+type PriceSchedule struct {
+	ID        uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID uuid.UUID   `json:"product_id" gorm:"type:uuid;not null;index"`
+	VariantID *uuid.UUID  `json:"variant_id,omitempty" gorm:"type:uuid"`
+	Price     money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	StartsAt  time.Time   `json:"starts_at" gorm:"not null"`
+	EndsAt    *time.Time  `json:"ends_at,omitempty"`
+	// RevertPrice has no currency for a variant that had the product's price
+	RevertPrice money.Money `json:"revert_price" gorm:"embedded;embeddedPrefix:revert_price_"`
+	Status      string      `json:"status" gorm:"not null;default:scheduled;index"`
+	Note        string      `json:"note,omitempty"`
+	CreatedByID *uuid.UUID  `json:"created_by_id,omitempty" gorm:"type:uuid"`
+	StartedAt   *time.Time  `json:"started_at,omitempty"`
+	EndedAt     *time.Time  `json:"ended_at,omitempty"`
 	CreatedAt   time.Time   `json:"created_at"`
 	UpdatedAt   time.Time   `json:"updated_at"`
 }
 
+// Warehouse is a stock location. Orders are filled from active warehouses
+// in Priority order, lowest first.
+type Warehouse struct {
+	ID        uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Code      string        `json:"code" gorm:"size:32;not null;uniqueIndex"`
+	Name      string        `json:"name" gorm:"not null"`
+	Address   PostalAddress `json:"address" gorm:"embedded;embeddedPrefix:address_"`
+	Priority  int           `json:"priority" gorm:"default:0"`
+	IsActive  bool          `json:"is_active" gorm:"default:true"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// InventoryLevel is the stock of a product, or one of its variants, in a
+// warehouse. VariantID is uuid.Nil for products without variants so the
+// unique index covers them too. Available is OnHand less Reserved; an
+// alert is raised when it drops to LowStockThreshold.
+type InventoryLevel struct {
+	ID                uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	WarehouseID       uuid.UUID  `json:"warehouse_id" gorm:"type:uuid;not null;uniqueIndex:idx_inventory_levels_item"`
+	Warehouse         *Warehouse `json:"warehouse,omitempty" gorm:"foreignKey:WarehouseID"`
+	ProductID         uuid.UUID  `json:"product_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_inventory_levels_item"`
+	VariantID         uuid.UUID  `json:"variant_id" gorm:"type:uuid;not null;default:'00000000-0000-0000-0000-000000000000';uniqueIndex:idx_inventory_levels_item"`
+	OnHand            int        `json:"on_hand" gorm:"not null;default:0"`
+	Reserved          int        `json:"reserved" gorm:"not null;default:0"`
+	Available         int        `json:"available" gorm:"-"` // filled when listed
+	LowStockThreshold int        `json:"low_stock_threshold" gorm:"not null;default:0"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// Stock movement types
+const (
+	StockReceipt      = "receipt"
+	StockSale         = "sale"
+	StockAdjustment   = "adjustment"
+	StockTransfer     = "transfer"
+	StockReturn       = "return"
+	StockCancellation = "cancellation"
+)
+
+// StockMovement is one entry of the append-only stock ledger: Quantity
+// units (negative when leaving) of an item in a warehouse, and the on hand
+// count after it. Both legs of a transfer share a TransferID.
+type StockMovement struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	WarehouseID uuid.UUID  `json:"warehouse_id" gorm:"type:uuid;not null;index"`
+	ProductID   uuid.UUID  `json:"product_id" gorm:"type:uuid;not null;index"`
+	VariantID   *uuid.UUID `json:"variant_id,omitempty" gorm:"type:uuid;index"`
+	Type        string     `json:"type" gorm:"not null;index"`
+	Quantity    int        `json:"quantity" gorm:"not null"`
+	OnHandAfter int        `json:"on_hand_after"`
+	OrderID     *uuid.UUID `json:"order_id,omitempty" gorm:"type:uuid;index"`
+	ReturnID    *uuid.UUID `json:"return_id,omitempty" gorm:"type:uuid"`
+	TransferID  *uuid.UUID `json:"transfer_id,omitempty" gorm:"type:uuid"`
+	ActorID     *uuid.UUID `json:"actor_id,omitempty" gorm:"type:uuid"`
+	Note        string     `json:"note,omitempty" gorm:"type:text"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"index"`
+}
+
+// Stock reservation statuses. Active reservations hold stock for an unpaid
+// order until ExpiresAt; payment commits them as a sale, and cancellation
+// or expiry releases them.
+const (
+	ReservationActive    = "active"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// StockReservation holds Quantity units of an order item in a warehouse
+type StockReservation struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderID     uuid.UUID  `json:"order_id" gorm:"type:uuid;not null;index"`
+	OrderItemID uuid.UUID  `json:"order_item_id" gorm:"type:uuid;not null"`
+	WarehouseID uuid.UUID  `json:"warehouse_id" gorm:"type:uuid;not null"`
+	ProductID   uuid.UUID  `json:"product_id" gorm:"type:uuid;not null"`
+	VariantID   *uuid.UUID `json:"variant_id,omitempty" gorm:"type:uuid"`
+	Quantity    int        `json:"quantity" gorm:"not null"`
+	Status      string     `json:"status" gorm:"not null;default:active;index:idx_stock_reservations_status_expires"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"index:idx_stock_reservations_status_expires"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// StockAlert is raised when an item's available stock in a warehouse drops
+// to its low stock threshold, and resolved once it is back above it.
+// NotifiedAt is set once the OnLowStock hooks have seen it.
+type StockAlert struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	WarehouseID uuid.UUID  `json:"warehouse_id" gorm:"type:uuid;not null;index"`
+	ProductID   uuid.UUID  `json:"product_id" gorm:"type:uuid;not null;index"`
+	VariantID   *uuid.UUID `json:"variant_id,omitempty" gorm:"type:uuid"`
+	Available   int        `json:"available"`
+	Threshold   int        `json:"threshold"`
+	NotifiedAt  *time.Time `json:"notified_at,omitempty" gorm:"index"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty" gorm:"index"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Review moderation states
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+)
+
+// Review is a customer's rating of a product, one per customer and
+// product. VerifiedPurchase is set when OrderID, an order of the customer
+// with the product, was delivered. Only approved reviews are shown and
+// count towards the product's rating.
+type Review struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ProductID        uuid.UUID  `json:"product_id" gorm:"type:uuid;not null;uniqueIndex:idx_reviews_product_user;index:idx_reviews_product_status"`
+	UserID           uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_reviews_product_user;index"`
+	User             User       `json:"user" gorm:"foreignKey:UserID"`
+	Rating           int        `json:"rating" gorm:"not null"`
+	Title            string     `json:"title" gorm:"size:200"`
+	Body             string     `json:"body" gorm:"type:text"`
+	Images           []string   `json:"images" gorm:"serializer:json"` // URLs of the reviewer's media uploads
+	VerifiedPurchase bool       `json:"verified_purchase"`
+	OrderID          *uuid.UUID `json:"-" gorm:"type:uuid"`
+	Status           string     `json:"status" gorm:"size:20;default:pending;index:idx_reviews_product_status"`
+	ModerationReason string     `json:"moderation_reason,omitempty"`
+	ModeratedBy      *uuid.UUID `json:"moderated_by,omitempty" gorm:"type:uuid"`
+	ModeratedAt      *time.Time `json:"moderated_at,omitempty"`
+	// Helpfulness votes, kept in step with ReviewVote
+	HelpfulCount   int       `json:"helpful_count" gorm:"default:0"`
+	UnhelpfulCount int       `json:"unhelpful_count" gorm:"default:0"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ReviewVote is one user's helpful or not helpful vote on a review
+type ReviewVote struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ReviewID  uuid.UUID `json:"review_id" gorm:"type:uuid;not null;uniqueIndex:idx_review_votes_review_user"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_review_votes_review_user"`
+	Helpful   bool      `json:"helpful"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Product job kinds, file formats and statuses
+const (
+	ProductJobImport = "import"
+	ProductJobExport = "export"
+
+	ProductJobCSV   = "csv"
+	ProductJobJSONL = "jsonl"
+
+	ProductJobQueued    = "queued"
+	ProductJobRunning   = "running"
+	ProductJobCompleted = "completed"
+	ProductJobFailed    = "failed"
+)
+
+// ProductJob is a background product import or export. An import reads
+// the uploaded file at FileKey and upserts one product or variant per row
+// by SKU; an export writes the products Filter selects there.
+type ProductJob struct {
+	ID     uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Kind   string    `json:"kind" gorm:"size:10;not null"`
+	Format string    `json:"format" gorm:"size:10;not null"`
+	Status string    `json:"status" gorm:"size:20;not null;index"`
+	// DryRun imports validate and apply every row, then roll everything
+	// back
+	DryRun   bool              `json:"dry_run"`
+	Filter   map[string]string `json:"filter,omitempty" gorm:"serializer:json"`
+	FileName string            `json:"file_name"`
+	FileKey  string            `json:"-"`
+	// Progress: TotalRows is known once the file has been counted
+	TotalRows     int        `json:"total_rows"`
+	ProcessedRows int        `json:"processed_rows"`
+	CreatedRows   int        `json:"created_rows"`
+	UpdatedRows   int        `json:"updated_rows"`
+	FailedRows    int        `json:"failed_rows"`
+	Error         string     `json:"error,omitempty" gorm:"type:text"` // why the whole job failed
+	CreatedByID   *uuid.UUID `json:"created_by_id,omitempty" gorm:"type:uuid"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// ProductJobError is an import row that was rejected; Row counts data rows
+// from 1, not including a CSV header
+type ProductJobError struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	JobID     uuid.UUID `json:"job_id" gorm:"type:uuid;not null;index"`
+	Row       int       `json:"row"`
+	SKU       string    `json:"sku"`
+	Field     string    `json:"field,omitempty"`
+	Message   string    `json:"message" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PostalAddress is a delivery address; it is embedded in address book
+// entries and snapshotted onto orders
+type PostalAddress struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	Region     string `json:"region,omitempty" gorm:"size:10"` // state or province code
+	PostalCode string `json:"postal_code" gorm:"size:20"`
+	Country    string `json:"country" gorm:"size:2"` // ISO 3166-1 alpha-2
+	Phone      string `json:"phone,omitempty" gorm:"size:30"`
+}
+
+// Address is an entry in a user's address book
+type Address struct {
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID        uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	Label         string    `json:"label"` // e.g. Home, Work
+	PostalAddress `gorm:"embedded"`
+	IsDefault     bool      `json:"is_default" gorm:"default:false"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Shipping rate bases
+const (
+	ShippingByWeight = "weight" // brackets in grams
+	ShippingByPrice  = "price"  // brackets in minor units of the method currency
+)
+
+// ShippingZone groups destinations that share shipping methods. Countries
+// holds ISO codes ("US"), country-region codes ("US-AK") or "*" for the
+// rest of the world.
+type ShippingZone struct {
+	ID        uuid.UUID        `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name      string           `json:"name" gorm:"not null"`
+	Countries []string         `json:"countries" gorm:"serializer:json"`
+	Methods   []ShippingMethod `json:"methods,omitempty" gorm:"foreignKey:ZoneID"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// ShippingMethod is a delivery option in a zone, priced by a rate table
+// over the parcel weight or the order value
+type ShippingMethod struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ZoneID    uuid.UUID `json:"zone_id" gorm:"type:uuid;not null;index"`
+	Name      string    `json:"name" gorm:"not null"`
+	Carrier   string    `json:"carrier"`
+	RateBasis string    `json:"rate_basis" gorm:"not null;default:weight"`
+	Currency  string    `json:"currency" gorm:"size:3"` // of price brackets and rate prices
+	// VolumetricDivisor turns package volume into a billable weight:
+	// cm³ per kg, e.g. 5000. Zero bills the actual weight.
+	VolumetricDivisor int            `json:"volumetric_divisor"`
+	MinDays           int            `json:"min_days"`
+	MaxDays           int            `json:"max_days"`
+	IsActive          bool           `json:"is_active" gorm:"default:true"`
+	Rates             []ShippingRate `json:"rates" gorm:"foreignKey:MethodID"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+}
+
+// ShippingRate is one bracket of a method's rate table: parcels from From
+// up to, but not including, To cost Price. To of zero has no upper bound.
+type ShippingRate struct {
+	ID       uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	MethodID uuid.UUID   `json:"method_id" gorm:"type:uuid;not null;index"`
+	From     int64       `json:"from" gorm:"column:range_from"`
+	To       int64       `json:"to" gorm:"column:range_to"`
+	Price    money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+}
+
+// Invoice document kinds
+const (
+	InvoiceKindInvoice    = "invoice"
+	InvoiceKindCreditNote = "credit_note"
+)
+
+// Invoice is an issued invoice or credit note. It is a snapshot: later
+// changes to the order, products or customer don't alter it. Numbers are
+// gap-free per kind and year (INV-2026-000001, CN-2026-000001).
+type Invoice struct {
+	ID       uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Kind     string    `json:"kind" gorm:"not null;index"`
+	Number   string    `json:"number" gorm:"not null;uniqueIndex"`
+	Year     int       `json:"year" gorm:"not null"`
+	Sequence int       `json:"sequence" gorm:"not null"`
+	OrderID  uuid.UUID `json:"order_id" gorm:"type:uuid;not null;index"`
+	// InvoiceID is the invoice a credit note corrects
+	InvoiceID     *uuid.UUID    `json:"invoice_id,omitempty" gorm:"type:uuid"`
+	PaymentID     *uuid.UUID    `json:"payment_id,omitempty" gorm:"type:uuid"`
+	IssuedAt      time.Time     `json:"issued_at"`
+	CustomerName  string        `json:"customer_name"`
+	CustomerEmail string        `json:"customer_email"`
+	BillTo        PostalAddress `json:"bill_to" gorm:"embedded;embeddedPrefix:bill_"`
+	Currency      string        `json:"currency" gorm:"size:3"`
+	Lines         []InvoiceLine `json:"lines" gorm:"serializer:json"`
+	Subtotal      money.Money   `json:"subtotal" gorm:"embedded;embeddedPrefix:subtotal_"`
+	DiscountTotal money.Money   `json:"discount_total" gorm:"embedded;embeddedPrefix:discount_total_"`
+	ShippingTotal money.Money   `json:"shipping_total" gorm:"embedded;embeddedPrefix:shipping_"`
+	TaxTotal      money.Money   `json:"tax_total" gorm:"embedded;embeddedPrefix:tax_total_"`
+	Total         money.Money   `json:"total" gorm:"embedded;embeddedPrefix:total_"`
+	// PricesIncludeTax says whether line amounts contain TaxTotal
+	PricesIncludeTax bool   `json:"prices_include_tax"`
+	Reason           string `json:"reason,omitempty"`
+	// Rendered documents in the invoice blob store; empty until rendered
+	PDFKey    string    `json:"-"`
+	HTMLKey   string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// InvoiceLine is one line of an invoice snapshot
+type InvoiceLine struct {
+	Description string      `json:"description"`
+	Quantity    int         `json:"quantity"`
+	UnitPrice   money.Money `json:"unit_price"`
+	Discount    money.Money `json:"discount"`
+	TaxRate     float64     `json:"tax_rate"`
+	Tax         money.Money `json:"tax"`
+	Amount      money.Money `json:"amount"` // quantity * unit price - discount
+}
+
+// DocumentSequence hands out gap-free invoice numbers: it is read and
+// bumped under a row lock in the transaction that issues the document
+type DocumentSequence struct {
+	Kind string `gorm:"primaryKey;size:20"`
+	Year int    `gorm:"primaryKey;autoIncrement:false"`
+	Last int    `gorm:"not null;default:0"`
+}
+
+// TaxRate is a percentage charged in a country, or one of its regions, on
+// a product tax category. Empty Region and Category are wildcards; see
+// tax.Table for how the most specific match is chosen.
+type TaxRate struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name      string    `json:"name" gorm:"not null;uniqueIndex:idx_tax_rates_scope"`
+	Country   string    `json:"country" gorm:"size:2;not null;uniqueIndex:idx_tax_rates_scope"`
+	Region    string    `json:"region" gorm:"size:10;uniqueIndex:idx_tax_rates_scope"`
+	Category  string    `json:"category" gorm:"size:50;uniqueIndex:idx_tax_rates_scope"`
+	Rate      float64   `json:"rate" gorm:"type:decimal(7,4);not null"` // percent
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExchangeRate says one unit of Base buys Rate units of Quote
+type ExchangeRate struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Base        string    `json:"base" gorm:"size:3;not null;uniqueIndex:idx_exchange_rates_pair"`
+	Quote       string    `json:"quote" gorm:"size:3;not null;uniqueIndex:idx_exchange_rates_pair"`
+	Rate        float64   `json:"rate" gorm:"type:decimal(20,10);not null"`
+	Source      string    `json:"source"`
+	EffectiveAt time.Time `json:"effective_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Cart holds products a shopper intends to buy. Signed-in shoppers have one
+// cart by UserID; anonymous carts are found by their random Token.
+type Cart struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    *uuid.UUID `json:"user_id,omitempty" gorm:"type:uuid;uniqueIndex"`
+	Token     string     `json:"-" gorm:"uniqueIndex"`
+	Currency  string     `json:"currency" gorm:"size:3"`
+	Items     []CartItem `json:"items" gorm:"foreignKey:CartID"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type CartItem struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CartID    uuid.UUID `json:"cart_id" gorm:"type:uuid;not null;uniqueIndex:idx_cart_items_cart_product_variant"`
+	ProductID uuid.UUID `json:"product_id" gorm:"type:uuid;not null;uniqueIndex:idx_cart_items_cart_product_variant"`
+	Product   *Product  `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	// VariantID is uuid.Nil for products without variants, so the unique
+	// index also covers those lines
+	VariantID uuid.UUID `json:"variant_id" gorm:"type:uuid;not null;default:'00000000-0000-0000-0000-000000000000';uniqueIndex:idx_cart_items_cart_product_variant"`
+	Quantity  int       `json:"quantity" gorm:"not null"`
+	// AddedPrice is the unit price when the shopper last changed this line,
+	// used to flag price changes since
+	AddedPrice money.Money `json:"added_price" gorm:"embedded;embeddedPrefix:added_price_"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// Order statuses; see services.OrderTransitions for the allowed moves
+const (
+	OrderStatusPending       = "pending"
+	OrderStatusPaymentFailed = "payment_failed"
+	OrderStatusPaid          = "paid"
+	OrderStatusFulfilling    = "fulfilling"
+	OrderStatusShipped       = "shipped"
+	OrderStatusDelivered     = "delivered"
+	OrderStatusCancelled     = "cancelled"
+	OrderStatusRefunded      = "refunded"
+)
+
+type Order struct {
+	ID     uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID uuid.UUID `json:"user_id" gorm:"type:uuid;not null"`
+	User   User      `json:"user" gorm:"foreignKey:UserID"`
+	// Currency is locked at checkout; every amount on the order and its
+	// items is in it
+	Currency      string      `json:"currency" gorm:"size:3"`
+	Subtotal      money.Money `json:"subtotal" gorm:"embedded;embeddedPrefix:subtotal_"`
+	DiscountTotal money.Money `json:"discount_total" gorm:"embedded;embeddedPrefix:discount_total_"`
+	TotalAmount   money.Money `json:"total_amount" gorm:"embedded;embeddedPrefix:total_"`
+	FreeShipping  bool        `json:"free_shipping" gorm:"default:false"`
+	// TaxTotal is part of the item prices when PricesIncludeTax, and added
+	// to the total otherwise. TaxCountry and TaxRegion picked the rates.
+	TaxTotal         money.Money `json:"tax_total" gorm:"embedded;embeddedPrefix:tax_total_"`
+	PricesIncludeTax bool        `json:"prices_include_tax" gorm:"default:false"`
+	TaxCountry       string      `json:"tax_country,omitempty" gorm:"size:2"`
+	TaxRegion        string      `json:"tax_region,omitempty" gorm:"size:10"`
+	// The shipping address and method are snapshots taken at checkout;
+	// ShippingTotal is zero for free shipping, ShippingTax is in TaxTotal
+	ShippingAddress    PostalAddress `json:"shipping_address" gorm:"embedded;embeddedPrefix:ship_"`
+	ShippingMethodID   *uuid.UUID    `json:"shipping_method_id,omitempty" gorm:"type:uuid"`
+	ShippingMethodName string        `json:"shipping_method_name,omitempty"`
+	ShippingTotal      money.Money   `json:"shipping_total" gorm:"embedded;embeddedPrefix:shipping_"`
+	ShippingTax        money.Money   `json:"shipping_tax" gorm:"embedded;embeddedPrefix:shipping_tax_"`
+	// RefundedTotal is what the payment provider has confirmed refunding
+	RefundedTotal  money.Money `json:"refunded_total" gorm:"embedded;embeddedPrefix:refunded_total_"`
+	Status         string      `json:"status" gorm:"default:pending;index"`
+	TrackingNumber string      `json:"tracking_number,omitempty"`
+	Carrier        string      `json:"carrier,omitempty"`
+	ShippedAt      *time.Time  `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time  `json:"delivered_at,omitempty"`
+	CancelledAt    *time.Time  `json:"cancelled_at,omitempty"`
+	Items          []OrderItem `json:"items" gorm:"foreignKey:OrderID"`
+	// Discounts is the applied promotion breakdown; each line's share is
+	// in OrderItem.Discount
+	Discounts []OrderDiscount `json:"discounts,omitempty" gorm:"foreignKey:OrderID"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	DeletedAt gorm.DeletedAt  `json:"deleted_at,omitempty" gorm:"index"`
+}
+
 type OrderItem struct {
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	OrderID   uuid.UUID `json:"order_id" gorm:"type:uuid;not null"`
 	ProductID uuid.UUID `json:"product_id" gorm:"type:uuid;not null"`
 	Product   Product   `json:"product" gorm:"foreignKey:ProductID"`
-	Quantity  int       `json:"quantity" gorm:"not null"`
-	Price     float64   `json:"price" gorm:"type:decimal(10,2);not null"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	// VariantID is set for products with variants. ProductName, SKU,
+	// VariantName and Price are snapshots taken at checkout.
+	VariantID   *uuid.UUID  `json:"variant_id,omitempty" gorm:"type:uuid;index"`
+	SKU         string      `json:"sku,omitempty"`
+	VariantName string      `json:"variant_name,omitempty"`
+	ProductName string      `json:"product_name"`
+	Quantity    int         `json:"quantity" gorm:"not null"`
+	Price       money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	LineTotal   money.Money `json:"line_total" gorm:"embedded;embeddedPrefix:line_total_"`
+	// Discount is this line's share of the order's discounts
+	Discount money.Money `json:"discount" gorm:"embedded;embeddedPrefix:discount_"`
+	// Tax is charged on the line after its discount; TaxRate is the
+	// combined percentage and TaxBreakdown the individual taxes
+	TaxCategory  string          `json:"tax_category,omitempty"`
+	TaxRate      float64         `json:"tax_rate"`
+	Tax          money.Money     `json:"tax" gorm:"embedded;embeddedPrefix:tax_"`
+	TaxBreakdown []tax.Component `json:"tax_breakdown" gorm:"serializer:json"`
+	// ReturnedQuantity counts the units received back through returns
+	ReturnedQuantity int       `json:"returned_quantity" gorm:"not null;default:0"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Return request statuses; see services.ReturnTransitions for the allowed
+// moves
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusCancelled = "cancelled"
+	ReturnStatusReceived  = "received"
+	ReturnStatusRefunded  = "refunded"
+)
+
+// Return reasons
+const (
+	ReturnReasonDamaged        = "damaged"
+	ReturnReasonDefective      = "defective"
+	ReturnReasonWrongItem      = "wrong_item"
+	ReturnReasonNotAsDescribed = "not_as_described"
+	ReturnReasonNoLongerNeeded = "no_longer_needed"
+	ReturnReasonOther          = "other"
+)
+
+// ReturnRequest asks to send back some units of a delivered order's items.
+// Staff approve or reject it, mark the parcel received (restocking what can
+// be resold) and refund it through the order's payments.
+type ReturnRequest struct {
+	ID      uuid.UUID    `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderID uuid.UUID    `json:"order_id" gorm:"type:uuid;not null;index"`
+	UserID  uuid.UUID    `json:"user_id" gorm:"type:uuid;not null;index"`
+	Status  string       `json:"status" gorm:"not null;default:requested;index"`
+	Note    string       `json:"note,omitempty" gorm:"type:text"`
+	Items   []ReturnItem `json:"items" gorm:"foreignKey:ReturnID"`
+	// StaffNote explains the decision to the customer
+	StaffNote string     `json:"staff_note,omitempty" gorm:"type:text"`
+	DecidedBy *uuid.UUID `json:"decided_by,omitempty" gorm:"type:uuid"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	// RefundAmount is what was sent to the payment provider, including a
+	// refund in progress; the order's RefundedTotal moves once the provider
+	// confirms it
+	RefundAmount money.Money `json:"refund_amount" gorm:"embedded;embeddedPrefix:refund_"`
+	// RefundAttempts numbers refund calls, keying them at the provider
+	RefundAttempts int        `json:"-" gorm:"not null;default:0"`
+	ReceivedAt     *time.Time `json:"received_at,omitempty"`
+	RefundedAt     *time.Time `json:"refunded_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ReturnItem is the quantity of one order item being returned
+type ReturnItem struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ReturnID    uuid.UUID  `json:"return_id" gorm:"type:uuid;not null;index"`
+	OrderItemID uuid.UUID  `json:"order_item_id" gorm:"type:uuid;not null;index"`
+	ProductID   uuid.UUID  `json:"product_id" gorm:"type:uuid;not null"`
+	VariantID   *uuid.UUID `json:"variant_id,omitempty" gorm:"type:uuid"`
+	ProductName string     `json:"product_name"`
+	Quantity    int        `json:"quantity" gorm:"not null"`
+	Reason      string     `json:"reason" gorm:"not null"`
+	Comment     string     `json:"comment,omitempty" gorm:"type:text"`
+	// RestockedQuantity is how many of the received units went back to stock
+	RestockedQuantity int       `json:"restocked_quantity" gorm:"not null;default:0"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Promotion types
+const (
+	PromotionPercentage   = "percentage"
+	PromotionFixedAmount  = "fixed_amount"
+	PromotionFreeShipping = "free_shipping"
+	PromotionBuyXGetY     = "buy_x_get_y"
+)
+
+// Promotion is a discount code. Value is the percentage off for
+// percentage and buy_x_get_y (100 = free); AmountOff is the amount off for
+// fixed_amount. Amounts are converted to the order's currency. ProductIDs
+// and Categories (names or slugs, covering their subcategories) limit which
+// lines it applies to; both empty means the whole order.
+type Promotion struct {
+	ID            uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Code          string      `json:"code" gorm:"uniqueIndex;not null"`
+	Name          string      `json:"name"`
+	Type          string      `json:"type" gorm:"not null"`
+	Value         float64     `json:"value" gorm:"type:decimal(10,2);not null;default:0"`
+	AmountOff     money.Money `json:"amount_off" gorm:"embedded;embeddedPrefix:off_"`
+	BuyQuantity   int         `json:"buy_quantity"`
+	GetQuantity   int         `json:"get_quantity"`
+	MinOrderValue money.Money `json:"min_order_value" gorm:"embedded;embeddedPrefix:min_order_"`
+	ProductIDs    []uuid.UUID `json:"product_ids" gorm:"serializer:json"`
+	Categories    []string    `json:"categories" gorm:"serializer:json"`
+	// UsageLimit and PerUserLimit of 0 mean unlimited
+	UsageLimit   int        `json:"usage_limit"`
+	PerUserLimit int        `json:"per_user_limit"`
+	UsedCount    int        `json:"used_count" gorm:"not null;default:0"`
+	Stackable    bool       `json:"stackable" gorm:"default:false"`
+	StartsAt     *time.Time `json:"starts_at,omitempty"`
+	EndsAt       *time.Time `json:"ends_at,omitempty"`
+	IsActive     bool       `json:"is_active" gorm:"default:true"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// OrderDiscount is one promotion applied to an order
+type OrderDiscount struct {
+	ID          uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderID     uuid.UUID   `json:"order_id" gorm:"type:uuid;not null;index"`
+	PromotionID uuid.UUID   `json:"promotion_id" gorm:"type:uuid;not null;index"`
+	Code        string      `json:"code"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Amount      money.Money `json:"amount" gorm:"embedded;embeddedPrefix:discount_"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// PromotionRedemption counts a promotion use against its limits
+type PromotionRedemption struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PromotionID uuid.UUID `json:"promotion_id" gorm:"type:uuid;not null;uniqueIndex:idx_redemptions_promotion_order;index:idx_redemptions_promotion_user"`
+	OrderID     uuid.UUID `json:"order_id" gorm:"type:uuid;not null;uniqueIndex:idx_redemptions_promotion_order"`
+	UserID      uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index:idx_redemptions_promotion_user"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Payment statuses
+const (
+	PaymentStatusPending           = "pending"
+	PaymentStatusAuthorized        = "authorized"
+	PaymentStatusSucceeded         = "succeeded"
+	PaymentStatusFailed            = "failed"
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+	PaymentStatusRefunded          = "refunded"
+	// PaymentStatusNeedsReview is a capture that doesn't match the order
+	// total; the order isn't marked paid until staff sort it out
+	PaymentStatusNeedsReview = "needs_review"
+)
+
+// Payment is one attempt to pay for an order through a payment provider
+type Payment struct {
+	ID             uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderID        uuid.UUID   `json:"order_id" gorm:"type:uuid;not null;index"`
+	Provider       string      `json:"provider" gorm:"not null;uniqueIndex:idx_payments_provider_ref"`
+	ProviderRef    string      `json:"provider_ref" gorm:"not null;uniqueIndex:idx_payments_provider_ref"`
+	Status         string      `json:"status" gorm:"not null;default:pending"`
+	Amount         money.Money `json:"amount" gorm:"embedded;embeddedPrefix:intent_"`
+	AmountCaptured money.Money `json:"amount_captured" gorm:"embedded;embeddedPrefix:captured_"`
+	AmountRefunded money.Money `json:"amount_refunded" gorm:"embedded;embeddedPrefix:refunded_"`
+	// AmountRefundRequested is what refunds asked of the provider add up
+	// to, counted before the webhooks that confirm them move AmountRefunded
+	AmountRefundRequested money.Money `json:"amount_refund_requested" gorm:"embedded;embeddedPrefix:refund_requested_"`
+	Currency              string      `json:"currency" gorm:"not null"`
+	FailureReason         string      `json:"failure_reason,omitempty"`
+	// ClientSecret is only returned when the payment is started
+	ClientSecret string    `json:"client_secret,omitempty" gorm:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// WebhookEvent records each provider event once so redelivered webhooks are
+// acknowledged without being applied twice
+type WebhookEvent struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Provider    string    `json:"provider" gorm:"not null;uniqueIndex:idx_webhook_events_provider_event"`
+	EventID     string    `json:"event_id" gorm:"not null;uniqueIndex:idx_webhook_events_provider_event"`
+	Type        string    `json:"type"`
+	Payload     string    `json:"payload" gorm:"type:text"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// IdempotencyKey is a client's Idempotency-Key with the response to replay
+// for retries of the same request. ResponseStatus is zero while the first
+// request is still running.
+type IdempotencyKey struct {
+	ID     uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Scope  string    `json:"scope" gorm:"not null;uniqueIndex:idx_idempotency_keys_scope_key"`
+	Key    string    `json:"key" gorm:"size:255;not null;uniqueIndex:idx_idempotency_keys_scope_key"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	// Fingerprint hashes the method, path and body the key was first used with
+	Fingerprint         string    `json:"fingerprint" gorm:"not null"`
+	ResponseStatus      int       `json:"response_status" gorm:"not null;default:0"`
+	ResponseContentType string    `json:"response_content_type"`
+	ResponseBody        []byte    `json:"-"`
+	ExpiresAt           time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+const (
+	OrderEventCreated    = "created"
+	OrderEventTransition = "transition"
+	OrderEventPayment    = "payment"
+	OrderEventReturn     = "return"
+	OrderEventStock      = "stock"
+)
+
+// OrderEvent is one entry of an order's append-only timeline
+type OrderEvent struct {
+	ID         uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	OrderID    uuid.UUID      `json:"order_id" gorm:"type:uuid;not null;index"`
+	Type       string         `json:"type" gorm:"not null"`
+	FromStatus string         `json:"from_status,omitempty"`
+	ToStatus   string         `json:"to_status,omitempty"`
+	ActorID    *uuid.UUID     `json:"actor_id,omitempty" gorm:"type:uuid"`
+	Actor      *User          `json:"actor,omitempty" gorm:"foreignKey:ActorID"`
+	Note       string         `json:"note,omitempty" gorm:"type:text"`
+	Data       map[string]any `json:"data,omitempty" gorm:"serializer:json;type:jsonb"`
+	CreatedAt  time.Time      `json:"created_at"`
 }
 
 type AnalyticsEvent struct {
@@ -109,6 +1090,14 @@ func (p *Post) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// BeforeCreate hook for Tag model
+func (t *Tag) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
 // BeforeCreate hook for Comment model
 func (c *Comment) BeforeCreate(tx *gorm.DB) error {
 	if c.ID == uuid.Nil {
@@ -117,6 +1106,46 @@ func (c *Comment) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// BeforeCreate hook for PostTranslation model
+func (t *PostTranslation) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for MediaAsset model
+func (m *MediaAsset) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for MediaReference model
+func (m *MediaReference) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Reaction model
+func (r *Reaction) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Bookmark model
+func (b *Bookmark) BeforeCreate(tx *gorm.DB) error {
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	return nil
+}
+
 // BeforeCreate hook for Product model
 func (p *Product) BeforeCreate(tx *gorm.DB) error {
 	if p.ID == uuid.Nil {
@@ -141,6 +1170,270 @@ func (oi *OrderItem) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// BeforeCreate hook for Category model
+func (c *Category) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ProductOption model
+func (o *ProductOption) BeforeCreate(tx *gorm.DB) error {
+	if o.ID == uuid.Nil {
+		o.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ProductVariant model
+func (v *ProductVariant) BeforeCreate(tx *gorm.DB) error {
+	if v.ID == uuid.Nil {
+		v.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ProductPrice model
+func (p *ProductPrice) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Warehouse model
+func (w *Warehouse) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for InventoryLevel model
+func (l *InventoryLevel) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for StockMovement model
+func (m *StockMovement) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for StockReservation model
+func (r *StockReservation) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for StockAlert model
+func (a *StockAlert) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Review model
+func (r *Review) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ReviewVote model
+func (v *ReviewVote) BeforeCreate(tx *gorm.DB) error {
+	if v.ID == uuid.Nil {
+		v.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ProductJob model
+func (j *ProductJob) BeforeCreate(tx *gorm.DB) error {
+	if j.ID == uuid.Nil {
+		j.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ProductJobError model
+func (e *ProductJobError) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for PriceHistory model
+func (h *PriceHistory) BeforeCreate(tx *gorm.DB) error {
+	if h.ID == uuid.Nil {
+		h.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for PriceSchedule model
+func (s *PriceSchedule) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ExchangeRate model
+func (r *ExchangeRate) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for TaxRate model
+func (r *TaxRate) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Address model
+func (a *Address) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ShippingZone model
+func (z *ShippingZone) BeforeCreate(tx *gorm.DB) error {
+	if z.ID == uuid.Nil {
+		z.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ShippingMethod model
+func (m *ShippingMethod) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ShippingRate model
+func (r *ShippingRate) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Invoice model
+func (inv *Invoice) BeforeCreate(tx *gorm.DB) error {
+	if inv.ID == uuid.Nil {
+		inv.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ReturnRequest model
+func (r *ReturnRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for ReturnItem model
+func (i *ReturnItem) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for IdempotencyKey model
+func (k *IdempotencyKey) BeforeCreate(tx *gorm.DB) error {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Cart model
+func (c *Cart) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for CartItem model
+func (i *CartItem) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Promotion model
+func (p *Promotion) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for OrderDiscount model
+func (d *OrderDiscount) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for PromotionRedemption model
+func (r *PromotionRedemption) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for Payment model
+func (p *Payment) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for WebhookEvent model
+func (e *WebhookEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook for OrderEvent model
+func (e *OrderEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
 // BeforeCreate hook for AnalyticsEvent model
 func (ae *AnalyticsEvent) BeforeCreate(tx *gorm.DB) error {
 	if ae.ID == uuid.Nil {
@@ -156,25 +1449,25 @@ type CWE struct {
 }
 
 type CVE struct {
-	ID                 uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	VulnID             string     `json:"vuln_id" gorm:"not null"`
-	Source             string     `json:"source"`
-	Aliases            string     `json:"aliases"`
-	Published          time.Time  `json:"published"`
-	Description        string     `json:"description" gorm:"type:text"`
-	CWEs               []CWE      `json:"cwes" gorm:"type:json"`
-	References         string     `json:"references" gorm:"type:text"`
-	Severity           string     `json:"severity"`
-	Analysis           string     `json:"analysis" gorm:"type:text"`
-	Suppressed         string     `json:"suppressed"`
-	CvssV3ImpactScore  float64    `json:"cvss_v3_impact_score"`
-	CvssV3BaseScore    float64    `json:"cvss_v3_base_score"`
-	CvssV3ExploitScore float64    `json:"cvss_v3_exploit_score"`
-	EpssScore          float64    `json:"epssscore"`
-	EpssPercentile     float64    `json:"epsspercentile"`
-	CreatedAt          time.Time  `json:"created_at"`
-	UpdatedAt          time.Time  `json:"updated_at"`
-	DeletedAt          *time.Time `json:"deleted_at" gorm:"index"`
+	ID                 uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	VulnID             string         `json:"vuln_id" gorm:"not null"`
+	Source             string         `json:"source"`
+	Aliases            string         `json:"aliases"`
+	Published          time.Time      `json:"published"`
+	Description        string         `json:"description" gorm:"type:text"`
+	CWEs               []CWE          `json:"cwes" gorm:"type:json"`
+	References         string         `json:"references" gorm:"type:text"`
+	Severity           string         `json:"severity"`
+	Analysis           string         `json:"analysis" gorm:"type:text"`
+	Suppressed         string         `json:"suppressed"`
+	CvssV3ImpactScore  float64        `json:"cvss_v3_impact_score"`
+	CvssV3BaseScore    float64        `json:"cvss_v3_base_score"`
+	CvssV3ExploitScore float64        `json:"cvss_v3_exploit_score"`
+	EpssScore          float64        `json:"epssscore"`
+	EpssPercentile     float64        `json:"epsspercentile"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `json:"deleted_at" gorm:"index"`
 }
 
 // BeforeCreate hook for CVE model
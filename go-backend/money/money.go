@@ -0,0 +1,295 @@
+// Package money represents amounts as integer minor units (cents) with an
+// ISO 4217 currency, so sums and splits never pick up float rounding errors.
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ErrCurrencyMismatch is returned when combining amounts in different currencies
+var ErrCurrencyMismatch = errors.New("money: currency mismatch")
+
+// Money is an amount in the currency's minor units. Embedded in models with
+// a prefix it maps to <prefix>amount and <prefix>currency columns.
+type Money struct {
+	Amount   int64  `json:"amount" gorm:"not null;default:0"`
+	Currency string `json:"currency" gorm:"size:3"`
+}
+
+// exponents lists currencies whose minor unit isn't 1/100
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Exponent is the number of minor unit digits of currency
+func Exponent(currency string) int {
+	if e, ok := exponents[currency]; ok {
+		return e
+	}
+	return 2
+}
+
+// ValidCurrency reports whether code looks like an ISO 4217 code
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeCurrency upper-cases and trims a currency code
+func NormalizeCurrency(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// New returns amount minor units of currency
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Zero returns no money in currency
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// FromMajor converts a decimal amount such as 19.99 to minor units,
+// rounding half away from zero
+func FromMajor(v float64, currency string) Money {
+	return Money{Amount: int64(math.Round(v * math.Pow10(Exponent(currency)))), Currency: currency}
+}
+
+// Parse reads a decimal string such as "19.99" exactly. More fraction
+// digits than the currency has are rejected.
+func Parse(s, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Money{}, fmt.Errorf("money: invalid amount %q", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(Exponent(currency))), nil)))
+	if !r.IsInt() {
+		return Money{}, fmt.Errorf("money: %q has more decimals than %s allows", s, currency)
+	}
+	if !r.Num().IsInt64() {
+		return Money{}, fmt.Errorf("money: amount %q is out of range", s)
+	}
+	return Money{Amount: r.Num().Int64(), Currency: currency}, nil
+}
+
+// Major returns the amount in major units; for display and provider APIs
+// that want decimals, never for arithmetic
+func (m Money) Major() float64 {
+	return float64(m.Amount) / math.Pow10(Exponent(m.Currency))
+}
+
+// Decimal formats the amount with the currency's number of decimals
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	if exp == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+	sign, abs := "", m.Amount
+	if abs < 0 {
+		sign, abs = "-", -abs
+	}
+	unit := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/unit, exp, abs%unit)
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+func (m Money) IsZero() bool     { return m.Amount == 0 }
+func (m Money) IsPositive() bool { return m.Amount > 0 }
+func (m Money) IsNegative() bool { return m.Amount < 0 }
+
+// SameCurrency reports whether m and o can be combined
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == o.Currency
+}
+
+// Add returns m + o. Mixing currencies is a programming error and panics;
+// convert first.
+func (m Money) Add(o Money) Money {
+	m.mustMatch(o)
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}
+}
+
+// Sub returns m - o; it panics on mixed currencies like Add
+func (m Money) Sub(o Money) Money {
+	m.mustMatch(o)
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}
+}
+
+// Cmp compares m and o: -1, 0 or +1. It panics on mixed currencies.
+func (m Money) Cmp(o Money) int {
+	m.mustMatch(o)
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	}
+	return 0
+}
+
+// Min returns the smaller of m and o
+func (m Money) Min(o Money) Money {
+	if m.Cmp(o) <= 0 {
+		return m
+	}
+	return o
+}
+
+// Mul returns m times n
+func (m Money) Mul(n int) Money {
+	return Money{Amount: m.Amount * int64(n), Currency: m.Currency}
+}
+
+// Percent returns pct percent of m, rounded half away from zero
+func (m Money) Percent(pct float64) Money {
+	return Money{Amount: int64(math.Round(float64(m.Amount) * pct / 100)), Currency: m.Currency}
+}
+
+// Allocate splits m in proportion to weights without losing or creating a
+// minor unit: the remainder goes one unit at a time to the largest
+// fractional shares. All-zero weights give all-zero shares.
+func (m Money) Allocate(weights []int64) []Money {
+	shares := make([]Money, len(weights))
+	var total int64
+	for i, w := range weights {
+		shares[i] = Zero(m.Currency)
+		if w > 0 {
+			total += w
+		}
+	}
+	if total == 0 {
+		return shares
+	}
+
+	type rem struct {
+		i    int
+		frac int64
+	}
+	var left = m.Amount
+	rems := make([]rem, 0, len(weights))
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		// m.Amount * w / total without overflowing on large amounts
+		q, r := new(big.Int).QuoRem(new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(w)), big.NewInt(total), new(big.Int))
+		shares[i].Amount = q.Int64()
+		left -= q.Int64()
+		rems = append(rems, rem{i: i, frac: r.Int64()})
+	}
+	for n := 0; left != 0 && n < len(rems); n++ {
+		best := n
+		for k := n + 1; k < len(rems); k++ {
+			if rems[k].frac > rems[best].frac {
+				best = k
+			}
+		}
+		rems[n], rems[best] = rems[best], rems[n]
+		if left > 0 {
+			shares[rems[n].i].Amount++
+			left--
+		} else {
+			shares[rems[n].i].Amount--
+			left++
+		}
+	}
+	return shares
+}
+
+// Sum adds amounts in currency
+func Sum(currency string, amounts ...Money) Money {
+	total := Zero(currency)
+	for _, a := range amounts {
+		total = total.Add(a)
+	}
+	return total
+}
+
+func (m Money) mustMatch(o Money) {
+	if m.Currency != o.Currency {
+		panic(fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency))
+	}
+}
+
+// MarshalJSON adds the formatted decimal next to the minor units so clients
+// don't need the currency exponent table to display amounts
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+		Display  string `json:"display"`
+	}{m.Amount, m.Currency, m.Decimal()})
+}
+
+// UnmarshalJSON accepts {"amount": 1999, "currency": "USD"} in minor units,
+// or a bare decimal number or string such as 19.99 for clients written
+// against the old float fields. Bare decimals are read as hundredths and
+// leave Currency empty; the caller fills it in with OrCurrency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '{':
+		var v struct {
+			Amount   int64  `json:"amount"`
+			Currency string `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*m = Money{Amount: v.Amount, Currency: NormalizeCurrency(v.Currency)}
+		return nil
+	}
+
+	var s string
+	if data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	} else {
+		s = string(data)
+	}
+	parsed, err := Parse(s, "")
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// OrCurrency returns m in currency when m has none yet, rescaling the
+// hundredths a bare decimal was read as to the currency's minor units
+func (m Money) OrCurrency(currency string) Money {
+	if m.Currency != "" {
+		return m
+	}
+	exp := Exponent(currency)
+	if exp != 2 {
+		m.Amount = int64(math.Round(float64(m.Amount) * math.Pow10(exp-2)))
+	}
+	m.Currency = currency
+	return m
+}
@@ -0,0 +1,92 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// ErrNoRate is returned when no rate connects two currencies
+var ErrNoRate = errors.New("money: no exchange rate")
+
+// Rates is an exchange rate table: one unit of a base currency buys Rate
+// units of a quote currency. Conversions use a direct rate, the inverse of
+// the opposite rate, or a cross rate through a currency both are quoted
+// against.
+type Rates struct {
+	rates map[string]map[string]float64
+}
+
+func NewRates() *Rates {
+	return &Rates{rates: map[string]map[string]float64{}}
+}
+
+// Set records that 1 base = rate quote
+func (r *Rates) Set(base, quote string, rate float64) {
+	if r.rates[base] == nil {
+		r.rates[base] = map[string]float64{}
+	}
+	r.rates[base][quote] = rate
+}
+
+// Rate returns how many units of to one unit of from buys
+func (r *Rates) Rate(from, to string) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+	if rate, ok := r.direct(from, to); ok {
+		return rate, true
+	}
+	// Pivots are tried in a fixed order so the same pair always converts
+	// at the same cross rate
+	for _, pivot := range r.pivots(from) {
+		a, ok := r.direct(from, pivot)
+		if !ok {
+			continue
+		}
+		if b, ok := r.direct(pivot, to); ok {
+			return a * b, true
+		}
+	}
+	return 0, false
+}
+
+// Convert converts m to currency to, rounding half away from zero in the
+// target currency's minor units
+func (r *Rates) Convert(m Money, to string) (Money, error) {
+	if m.Currency == to {
+		return m, nil
+	}
+	rate, ok := r.Rate(m.Currency, to)
+	if !ok {
+		return Money{}, fmt.Errorf("%w from %s to %s", ErrNoRate, m.Currency, to)
+	}
+	major := m.Major() * rate
+	return Money{Amount: int64(math.Round(major * math.Pow10(Exponent(to)))), Currency: to}, nil
+}
+
+func (r *Rates) direct(from, to string) (float64, bool) {
+	if rate, ok := r.rates[from][to]; ok && rate > 0 {
+		return rate, true
+	}
+	if rate, ok := r.rates[to][from]; ok && rate > 0 {
+		return 1 / rate, true
+	}
+	return 0, false
+}
+
+// pivots lists the currencies from can be converted to directly, sorted
+func (r *Rates) pivots(from string) []string {
+	var out []string
+	for quote := range r.rates[from] {
+		out = append(out, quote)
+	}
+	for base, quotes := range r.rates {
+		if _, ok := quotes[from]; ok {
+			out = append(out, base)
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
@@ -0,0 +1,231 @@
+package payments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeDeclineCents makes the fake decline any amount ending in these cents,
+// e.g. 10.02, mirroring the decline test cards of real gateways
+const FakeDeclineCents = 2
+
+// FakeProvider is an in-memory, deterministic gateway for development and
+// tests. IDs derive from the idempotency key (or order and amount), so the
+// same request always yields the same intent. Payments are confirmed with
+// Confirm, which emits Stripe-format webhook events signed with
+// WebhookSecret.
+type FakeProvider struct {
+	WebhookSecret string
+	// OnEvent receives signed webhook payloads; it is called from a new
+	// goroutine, like a real gateway calling back later
+	OnEvent func(payload []byte, signature string)
+	// Now is the clock used for signatures
+	Now func() time.Time
+
+	mu       sync.Mutex
+	intents  map[string]*Intent
+	manual   map[string]bool
+	refunded map[string]int64
+	refunds  map[string]*Refund
+	seq      int
+}
+
+func NewFakeProvider(webhookSecret string) *FakeProvider {
+	return &FakeProvider{
+		WebhookSecret: webhookSecret,
+		Now:           time.Now,
+		intents:       map[string]*Intent{},
+		manual:        map[string]bool{},
+		refunded:      map[string]int64{},
+		refunds:       map[string]*Refund{},
+	}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) CreateIntent(_ context.Context, params IntentParams) (*Intent, error) {
+	if params.Amount <= 0 {
+		return nil, fmt.Errorf("payments: amount must be positive")
+	}
+	seed := params.IdempotencyKey
+	if seed == "" {
+		seed = params.OrderID + ":" + strconv.FormatInt(params.Amount, 10)
+	}
+	id := "pi_fake_" + fakeID(seed)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if intent, ok := p.intents[id]; ok {
+		copy := *intent
+		return &copy, nil
+	}
+	intent := &Intent{
+		ID:           id,
+		Status:       StatusRequiresPayment,
+		Amount:       params.Amount,
+		Currency:     params.Currency,
+		ClientSecret: id + "_secret_" + fakeID(id)[:12],
+	}
+	p.intents[id] = intent
+	// Remembered so Confirm authorises instead of capturing
+	p.manual[id] = params.CaptureLater
+
+	copy := *intent
+	return &copy, nil
+}
+
+// Confirm completes a payment as the customer's browser would. Amounts
+// ending in FakeDeclineCents fail; everything else succeeds (or becomes
+// capturable for manual capture intents). A webhook event is emitted.
+func (p *FakeProvider) Confirm(intentID string) (*Intent, error) {
+	p.mu.Lock()
+	intent, ok := p.intents[intentID]
+	if !ok {
+		p.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	manual := p.manual[intentID]
+	if intent.Status != StatusRequiresPayment {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("payments: intent %s is %s", intentID, intent.Status)
+	}
+
+	var eventType string
+	switch {
+	case intent.Amount%100 == FakeDeclineCents:
+		intent.Status = StatusFailed
+		intent.FailureReason = "Your card was declined."
+		eventType = "payment_intent.payment_failed"
+	case manual:
+		intent.Status = StatusRequiresCapture
+		eventType = "payment_intent.amount_capturable_updated"
+	default:
+		intent.Status = StatusSucceeded
+		intent.AmountCaptured = intent.Amount
+		eventType = "payment_intent.succeeded"
+	}
+	copy := *intent
+	p.mu.Unlock()
+
+	p.emit(eventType, intentObject(&copy))
+	return &copy, nil
+}
+
+func (p *FakeProvider) Capture(_ context.Context, intentID string, amount int64) (*Intent, error) {
+	p.mu.Lock()
+	intent, ok := p.intents[intentID]
+	if !ok {
+		p.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	if intent.Status != StatusRequiresCapture {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("payments: intent %s is %s, not capturable", intentID, intent.Status)
+	}
+	if amount <= 0 || amount > intent.Amount {
+		amount = intent.Amount
+	}
+	intent.Status = StatusSucceeded
+	intent.AmountCaptured = amount
+	copy := *intent
+	p.mu.Unlock()
+
+	p.emit("payment_intent.succeeded", intentObject(&copy))
+	return &copy, nil
+}
+
+func (p *FakeProvider) Refund(_ context.Context, intentID string, amount int64, _, idempotencyKey string) (*Refund, error) {
+	p.mu.Lock()
+	if refund, ok := p.refunds[idempotencyKey]; ok && idempotencyKey != "" {
+		copy := *refund
+		p.mu.Unlock()
+		return &copy, nil
+	}
+	intent, ok := p.intents[intentID]
+	if !ok {
+		p.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	remaining := intent.AmountCaptured - p.refunded[intentID]
+	if intent.Status != StatusSucceeded || remaining <= 0 {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("payments: intent %s has nothing to refund", intentID)
+	}
+	if amount <= 0 {
+		amount = remaining
+	}
+	if amount > remaining {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("payments: refund of %d exceeds refundable %d", amount, remaining)
+	}
+	p.refunded[intentID] += amount
+	p.seq++
+	refund := &Refund{ID: "re_fake_" + fakeID(intentID+":"+strconv.Itoa(p.seq)), IntentID: intentID, Amount: amount, Status: "succeeded"}
+	if idempotencyKey != "" {
+		p.refunds[idempotencyKey] = refund
+	}
+	total := p.refunded[intentID]
+	p.mu.Unlock()
+
+	p.emit("charge.refunded", map[string]any{
+		"id":              "ch_fake_" + fakeID(intentID),
+		"object":          "charge",
+		"payment_intent":  intentID,
+		"amount":          intent.AmountCaptured,
+		"amount_refunded": total,
+	})
+	return refund, nil
+}
+
+func (p *FakeProvider) VerifyWebhook(payload []byte, signature string) (*Event, error) {
+	if err := VerifySignature(payload, signature, p.WebhookSecret, p.Now()); err != nil {
+		return nil, err
+	}
+	return parseStripeEvent(payload)
+}
+
+// emit builds a Stripe-format event, signs it and hands it to OnEvent
+func (p *FakeProvider) emit(eventType string, object map[string]any) {
+	p.mu.Lock()
+	p.seq++
+	id := "evt_fake_" + fakeID(eventType+":"+fmt.Sprint(object["id"])+":"+strconv.Itoa(p.seq))
+	p.mu.Unlock()
+
+	payload, _ := json.Marshal(map[string]any{
+		"id":   id,
+		"type": eventType,
+		"data": map[string]any{"object": object},
+	})
+	if p.OnEvent != nil {
+		signature := Sign(payload, p.WebhookSecret, p.Now())
+		go p.OnEvent(payload, signature)
+	}
+}
+
+func intentObject(i *Intent) map[string]any {
+	obj := map[string]any{
+		"id":              i.ID,
+		"object":          "payment_intent",
+		"amount":          i.Amount,
+		"amount_received": i.AmountCaptured,
+		"currency":        i.Currency,
+		"status":          i.Status,
+	}
+	if i.FailureReason != "" {
+		obj["last_payment_error"] = map[string]any{"message": i.FailureReason}
+	}
+	return obj
+}
+
+func fakeID(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:12])
+}
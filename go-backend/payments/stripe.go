@@ -0,0 +1,232 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStripeURL is the Stripe API base URL
+const DefaultStripeURL = "https://api.stripe.com"
+
+// StripeProvider talks to the Stripe PaymentIntents API, or any service
+// implementing the same endpoints
+type StripeProvider struct {
+	BaseURL       string
+	SecretKey     string
+	WebhookSecret string
+	Client        *http.Client
+}
+
+func NewStripeProvider(baseURL, secretKey, webhookSecret string) *StripeProvider {
+	if baseURL == "" {
+		baseURL = DefaultStripeURL
+	}
+	return &StripeProvider{
+		BaseURL:       strings.TrimRight(baseURL, "/"),
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		Client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+type stripeIntent struct {
+	ID               string `json:"id"`
+	Status           string `json:"status"`
+	Amount           int64  `json:"amount"`
+	AmountReceived   int64  `json:"amount_received"`
+	Currency         string `json:"currency"`
+	ClientSecret     string `json:"client_secret"`
+	LastPaymentError *struct {
+		Message string `json:"message"`
+	} `json:"last_payment_error"`
+}
+
+func (i *stripeIntent) toIntent() *Intent {
+	intent := &Intent{
+		ID:             i.ID,
+		Status:         stripeStatus(i.Status),
+		Amount:         i.Amount,
+		AmountCaptured: i.AmountReceived,
+		Currency:       i.Currency,
+		ClientSecret:   i.ClientSecret,
+	}
+	if i.LastPaymentError != nil {
+		intent.FailureReason = i.LastPaymentError.Message
+	}
+	return intent
+}
+
+// stripeStatus maps PaymentIntent statuses onto ours
+func stripeStatus(s string) string {
+	switch s {
+	case "succeeded":
+		return StatusSucceeded
+	case "requires_capture":
+		return StatusRequiresCapture
+	case "canceled":
+		return StatusCanceled
+	}
+	return StatusRequiresPayment
+}
+
+func (p *StripeProvider) CreateIntent(ctx context.Context, params IntentParams) (*Intent, error) {
+	form := url.Values{
+		"amount":                             {strconv.FormatInt(params.Amount, 10)},
+		"currency":                           {strings.ToLower(params.Currency)},
+		"automatic_payment_methods[enabled]": {"true"},
+		"metadata[order_id]":                 {params.OrderID},
+	}
+	if params.CaptureLater {
+		form.Set("capture_method", "manual")
+	}
+	if params.CustomerEmail != "" {
+		form.Set("receipt_email", params.CustomerEmail)
+	}
+	for k, v := range params.Metadata {
+		form.Set("metadata["+k+"]", v)
+	}
+
+	var out stripeIntent
+	if err := p.post(ctx, "/v1/payment_intents", form, params.IdempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return out.toIntent(), nil
+}
+
+func (p *StripeProvider) Capture(ctx context.Context, intentID string, amount int64) (*Intent, error) {
+	form := url.Values{}
+	if amount > 0 {
+		form.Set("amount_to_capture", strconv.FormatInt(amount, 10))
+	}
+
+	var out stripeIntent
+	if err := p.post(ctx, "/v1/payment_intents/"+url.PathEscape(intentID)+"/capture", form, "", &out); err != nil {
+		return nil, err
+	}
+	return out.toIntent(), nil
+}
+
+func (p *StripeProvider) Refund(ctx context.Context, intentID string, amount int64, reason, idempotencyKey string) (*Refund, error) {
+	form := url.Values{"payment_intent": {intentID}}
+	if amount > 0 {
+		form.Set("amount", strconv.FormatInt(amount, 10))
+	}
+	if reason != "" {
+		form.Set("metadata[reason]", reason)
+	}
+
+	var out struct {
+		ID     string `json:"id"`
+		Amount int64  `json:"amount"`
+		Status string `json:"status"`
+	}
+	if err := p.post(ctx, "/v1/refunds", form, idempotencyKey, &out); err != nil {
+		return nil, err
+	}
+	return &Refund{ID: out.ID, IntentID: intentID, Amount: out.Amount, Status: out.Status}, nil
+}
+
+func (p *StripeProvider) VerifyWebhook(payload []byte, signature string) (*Event, error) {
+	if err := VerifySignature(payload, signature, p.WebhookSecret, time.Now()); err != nil {
+		return nil, err
+	}
+	return parseStripeEvent(payload)
+}
+
+// parseStripeEvent maps the Stripe events we act on; everything else is
+// returned as EventIgnored so it is still recorded as seen
+func parseStripeEvent(payload []byte) (*Event, error) {
+	var raw struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID               string `json:"id"`
+				Object           string `json:"object"`
+				Amount           int64  `json:"amount"`
+				AmountReceived   int64  `json:"amount_received"`
+				AmountRefunded   int64  `json:"amount_refunded"`
+				Currency         string `json:"currency"`
+				PaymentIntent    string `json:"payment_intent"`
+				LastPaymentError *struct {
+					Message string `json:"message"`
+				} `json:"last_payment_error"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("payments: malformed event: %w", err)
+	}
+	if raw.ID == "" {
+		return nil, fmt.Errorf("payments: event has no id")
+	}
+
+	obj := raw.Data.Object
+	event := &Event{ID: raw.ID, ProviderType: raw.Type, Type: EventIgnored, Currency: strings.ToUpper(obj.Currency), Payload: payload}
+	switch raw.Type {
+	case "payment_intent.amount_capturable_updated":
+		event.Type, event.IntentID, event.Amount = EventPaymentAuthorized, obj.ID, obj.Amount
+	case "payment_intent.succeeded":
+		event.Type, event.IntentID, event.Amount = EventPaymentSucceeded, obj.ID, obj.AmountReceived
+	case "payment_intent.payment_failed":
+		event.Type, event.IntentID, event.Amount = EventPaymentFailed, obj.ID, obj.Amount
+		if obj.LastPaymentError != nil {
+			event.FailureReason = obj.LastPaymentError.Message
+		}
+	case "charge.refunded":
+		event.Type, event.IntentID, event.Amount = EventPaymentRefunded, obj.PaymentIntent, obj.AmountRefunded
+	}
+	return event, nil
+}
+
+func (p *StripeProvider) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.SecretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &apiErr)
+		switch {
+		case apiErr.Error.Type == "card_error":
+			return fmt.Errorf("%w: %s", ErrDeclined, apiErr.Error.Message)
+		case resp.StatusCode == http.StatusNotFound:
+			return ErrNotFound
+		}
+		return fmt.Errorf("payments: stripe %s returned %d: %s", path, resp.StatusCode, apiErr.Error.Message)
+	}
+	return json.Unmarshal(body, out)
+}
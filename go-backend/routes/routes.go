@@ -1,29 +1,166 @@
 package routes
 
 import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/config"
 	"go-backend/handlers"
+	"go-backend/invoice"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/search"
+	"go-backend/services"
+	"go-backend/spam"
+	"go-backend/storage"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"gorm.io/gorm"
 )
 
 func SetupRoutes(app *fiber.App, db *gorm.DB) {
+	cfg := config.Load()
+
+	// Post views are buffered in memory and flushed in batches
+	viewCounter := services.NewViewCounter(db)
+	if db != nil {
+		viewCounter.Start(10 * time.Second)
+	}
+
+	// Orders are taxed from the tax_rates table and hold their stock for
+	// STOCK_RESERVATION_MINUTES until paid
+	checkout := services.NewCheckout(db, cfg.Currency, services.TaxPolicy{
+		Calculator:       services.NewTaxRates(db),
+		PricesIncludeTax: cfg.PricesIncludeTax,
+		DefaultCountry:   cfg.TaxDefaultCountry,
+	}, time.Duration(cfg.StockReservationMinutes)*time.Minute)
+
+	// Expired reservations go back to stock and low stock alerts are sent
+	// every minute
+	inventory := services.NewInventory(db)
+	inventory.OnLowStock(func(alert models.StockAlert) {
+		item := "product " + alert.ProductID.String()
+		if alert.VariantID != nil {
+			item += " variant " + alert.VariantID.String()
+		}
+		log.Printf("WARN: low stock - %s has %d available in warehouse %s (threshold %d)",
+			item, alert.Available, alert.WarehouseID, alert.Threshold)
+	})
+	if db != nil {
+		inventory.StartSweeper(time.Minute)
+	}
+
+	// Product search runs on an in-memory index that product edits keep
+	// current; a full reindex every five minutes picks up stock and
+	// popularity changes
+	catalog := services.NewCatalog(db, search.NewMemoryIndex(), cfg.Currency)
+	if db != nil {
+		catalog.StartIndexer(5 * time.Minute)
+	}
+
+	// Scheduled price changes start, and sales end, within a minute of
+	// their time
+	prices := services.NewPrices(db, catalog)
+	if db != nil {
+		prices.StartScheduler(time.Minute)
+	}
+
+	// Product imports and exports run one at a time in the background
+	productJobs := services.NewProductJobs(db, storage.NewLocalStore(cfg.ProductJobDir, ""), catalog, cfg.Currency)
+	if db != nil {
+		productJobs.Start(30 * time.Second)
+	}
+
+	// Carts that haven't been touched for their TTL are swept hourly
+	carts := services.NewCarts(db, checkout)
+	if db != nil {
+		carts.StartSweeper(time.Hour)
+	}
+
+	// Retried POSTs with an Idempotency-Key get the first response back;
+	// keys expire after IDEMPOTENCY_TTL_HOURS
+	idempotencyKeys := services.NewIdempotencyKeys(db, time.Duration(cfg.IdempotencyTTLHours)*time.Hour)
+	if db != nil {
+		idempotencyKeys.StartSweeper(time.Hour)
+	}
+
+	// Deleted rows stay in the trash for TRASH_RETENTION_DAYS; a daily sweep
+	// purges those nothing refers to any more
+	trash := services.NewTrash(db, time.Duration(cfg.TrashRetentionDays)*24*time.Hour)
+	if db != nil {
+		trash.StartSweeper(24 * time.Hour)
+	}
+
+	// Exchange rates can be seeded from a local CSV or JSON file at startup
+	currencies := services.NewCurrencies(db, cfg.Currency)
+	if db != nil && cfg.ExchangeRatesFile != "" {
+		if n, err := currencies.ImportFile(context.Background(), cfg.ExchangeRatesFile); err != nil {
+			log.Printf("ERROR: failed to import exchange rates from %s - %v", cfg.ExchangeRatesFile, err)
+		} else {
+			log.Printf("Imported %d exchange rates from %s", n, cfg.ExchangeRatesFile)
+		}
+	}
+
+	// Uploaded media lives on local disk and is served below
+	mediaStore := storage.NewLocalStore(cfg.MediaDir, cfg.MediaURL)
+
+	// Invoices are rendered on first download into a store that is only
+	// reachable through the order endpoints
+	invoices := services.NewInvoices(db, storage.NewLocalStore(cfg.InvoiceDir, ""), invoice.Seller{
+		Name:    cfg.InvoiceSellerName,
+		Address: cfg.InvoiceSellerAddress,
+		TaxID:   cfg.InvoiceSellerTaxID,
+		Email:   cfg.InvoiceSellerEmail,
+	})
+
 	// Initialize handlers
 	userHandler := handlers.NewUserHandler(db)
-	postHandler := handlers.NewPostHandler(db)
-	analyticsHandler := handlers.NewAnalyticsHandler(db)
-	productHandler := handlers.NewProductHandler(db)
-	orderHandler := handlers.NewOrderHandler(db)
-	// commentHandler := handlers.NewCommentHandler(db) // Replaced with music API
+	postHandler := handlers.NewPostHandler(db, cfg, viewCounter, mediaStore)
+	feedHandler := handlers.NewFeedHandler(db, cfg)
+	analyticsHandler := handlers.NewAnalyticsHandler(db, viewCounter)
+	engagementHandler := handlers.NewEngagementHandler(db)
+	mediaHandler := handlers.NewMediaHandler(db, cfg, mediaStore)
+	paymentHandler := handlers.NewPaymentHandler(db, cfg)
+	productHandler := handlers.NewProductHandler(db, cfg, catalog, prices)
+	categoryHandler := handlers.NewCategoryHandler(db, catalog)
+	productJobHandler := handlers.NewProductJobHandler(db, productJobs)
+	reviewHandler := handlers.NewReviewHandler(db, catalog, mediaStore)
+	trashHandler := handlers.NewTrashHandler(db, trash, catalog)
+	orderHandler := handlers.NewOrderHandler(db, checkout)
+	cartHandler := handlers.NewCartHandler(db, carts)
+	promotionHandler := handlers.NewPromotionHandler(db, cfg)
+	currencyHandler := handlers.NewCurrencyHandler(db, currencies)
+	taxHandler := handlers.NewTaxHandler(db)
+	addressHandler := handlers.NewAddressHandler(db)
+	shippingHandler := handlers.NewShippingHandler(db, cfg)
+	invoiceHandler := handlers.NewInvoiceHandler(db, invoices)
+	inventoryHandler := handlers.NewInventoryHandler(db, inventory)
+	returnHandler := handlers.NewReturnHandler(db, services.NewReturns(db, paymentHandler.Payments(), time.Duration(cfg.ReturnWindowDays)*24*time.Hour))
+	commentHandler := handlers.NewCommentHandler(db, cfg)
 	weatherHandler := handlers.NewWeatherHandler()
 
+	// Published post changes make cached feeds and sitemaps stale
+	postHandler.OnPublish(feedHandler.Invalidate)
+
+	// Signing in adopts the anonymous cart built up before
+	userHandler.OnLogin(cartHandler.MergeOnLogin)
+
 	// API v1 routes
-	api := app.Group("/api/v1")
+	api := app.Group("/api/v1", middleware.Idempotency(idempotencyKeys))
 
 	// User Management (API 1)
 	users := api.Group("/users")
 	users.Get("/", userHandler.GetUsers)
 	users.Post("/", userHandler.CreateUser)
+	users.Get("/me/bookmarks", middleware.AuthRequired, engagementHandler.GetMyBookmarks)
+	users.Get("/me/addresses", middleware.AuthRequired, addressHandler.GetMyAddresses)
+	users.Post("/me/addresses", middleware.AuthRequired, addressHandler.CreateAddress)
+	users.Put("/me/addresses/:id", middleware.AuthRequired, addressHandler.UpdateAddress)
+	users.Delete("/me/addresses/:id", middleware.AuthRequired, addressHandler.DeleteAddress)
 	users.Get("/:id", userHandler.GetUser)
 	users.Put("/:id", userHandler.UpdateUser)
 	users.Delete("/:id", userHandler.DeleteUser)
@@ -34,12 +171,45 @@ func SetupRoutes(app *fiber.App, db *gorm.DB) {
 	auth.Post("/register", userHandler.Register)
 
 	// Content Management (API 2)
+	editorOnly := middleware.RequireRole(db, models.RoleEditor, models.RoleAdmin)
 	posts := api.Group("/posts")
 	posts.Get("/", postHandler.GetPosts)
 	posts.Post("/", postHandler.CreatePost)
-	posts.Get("/:id", postHandler.GetPost)
+	posts.Get("/translations/missing", middleware.AuthRequired, editorOnly, postHandler.GetMissingTranslations) // GET /api/v1/posts/translations/missing?locale=en
+	posts.Get("/:id", middleware.OptionalAuth, postHandler.GetPost)
 	posts.Put("/:id", postHandler.UpdatePost)
 	posts.Delete("/:id", postHandler.DeletePost)
+	posts.Get("/:id/translations", postHandler.GetPostTranslations)
+	posts.Put("/:id/translations/:locale", middleware.AuthRequired, editorOnly, postHandler.UpsertPostTranslation)
+	posts.Delete("/:id/translations/:locale", middleware.AuthRequired, editorOnly, postHandler.DeletePostTranslation)
+	posts.Get("/:id/comments", commentHandler.GetPostComments)
+	posts.Post("/:id/comments", middleware.AuthRequired, commentHandler.CreateComment)
+	posts.Get("/:id/reactions", middleware.OptionalAuth, engagementHandler.GetPostReactions)
+	posts.Put("/:id/reactions", middleware.AuthRequired, engagementHandler.ReactToPost)
+	posts.Delete("/:id/reactions", middleware.AuthRequired, engagementHandler.UnreactToPost)
+	posts.Put("/:id/bookmark", middleware.AuthRequired, engagementHandler.BookmarkPost)
+	posts.Delete("/:id/bookmark", middleware.AuthRequired, engagementHandler.UnbookmarkPost)
+
+	// Comments and moderation queue
+	comments := api.Group("/comments")
+	comments.Get("/moderation", middleware.AuthRequired, editorOnly, commentHandler.GetModerationQueue) // GET /api/v1/comments/moderation?status=pending
+	comments.Get("/:id", middleware.OptionalAuth, commentHandler.GetComment)
+	comments.Put("/:id", middleware.AuthRequired, commentHandler.UpdateComment)
+	comments.Delete("/:id", middleware.AuthRequired, commentHandler.DeleteComment)
+	comments.Get("/:id/reactions", middleware.OptionalAuth, engagementHandler.GetCommentReactions)
+	comments.Put("/:id/reactions", middleware.AuthRequired, engagementHandler.ReactToComment)
+	comments.Delete("/:id/reactions", middleware.AuthRequired, engagementHandler.UnreactToComment)
+	comments.Post("/:id/approve", middleware.AuthRequired, editorOnly, commentHandler.ApproveComment)
+	comments.Post("/:id/reject", middleware.AuthRequired, editorOnly, commentHandler.RejectComment)
+	comments.Post("/:id/spam", middleware.AuthRequired, editorOnly, commentHandler.MarkCommentSpam)
+
+	// Media library
+	mediaLib := api.Group("/media", middleware.AuthRequired)
+	mediaLib.Get("/", mediaHandler.GetMedia)     // GET /api/v1/media?type=image&q=&mine=true
+	mediaLib.Post("/", mediaHandler.UploadMedia) // multipart: file, alt_text, caption
+	mediaLib.Get("/:id", mediaHandler.GetMediaAsset)
+	mediaLib.Put("/:id", mediaHandler.UpdateMediaAsset)
+	mediaLib.Delete("/:id", mediaHandler.DeleteMediaAsset)
 
 	// Analytics (API 3)
 	analytics := api.Group("/analytics")
@@ -50,16 +220,150 @@ func SetupRoutes(app *fiber.App, db *gorm.DB) {
 	// E-commerce (API 4)
 	products := api.Group("/products")
 	products.Get("/", productHandler.GetProducts)
-	products.Post("/", productHandler.CreateProduct)
+	products.Post("/", productHandler.CreateProduct)                                                 // {"name": "...", "price": 19.99, "category_id": "..."}
+	products.Post("/imports", middleware.AuthRequired, editorOnly, productJobHandler.ImportProducts) // multipart "file" or raw body; ?format=csv|jsonl&dry_run=true
+	products.Post("/exports", middleware.AuthRequired, editorOnly, productJobHandler.ExportProducts) // {"format": "jsonl", "category": "clothing", "is_active": true}
+	products.Get("/search", productHandler.SearchProducts)                                           // ?q=shirt&category=clothing&min_price=10&max_price=50&in_stock=true&attr.Color=Red,Blue&sort=price_asc&page=1
 	products.Get("/:id", productHandler.GetProduct)
 	products.Put("/:id", productHandler.UpdateProduct)
 	products.Delete("/:id", productHandler.DeleteProduct)
+	products.Put("/:id/prices/:currency", middleware.AuthRequired, editorOnly, productHandler.SetProductPrice) // {"price": {"amount": 1799, "currency": "EUR"}}
+	products.Delete("/:id/prices/:currency", middleware.AuthRequired, editorOnly, productHandler.DeleteProductPrice)
+	products.Put("/:id/options", middleware.AuthRequired, editorOnly, productHandler.SetProductOptions)      // [{"name": "Size", "values": ["S", "M", "L"]}]
+	products.Get("/:id/variants", productHandler.GetProductVariants)                                         // ?currency=EUR
+	products.Post("/:id/variants", middleware.AuthRequired, editorOnly, productHandler.CreateProductVariant) // {"sku": "TEE-M-RED", "options": {"Size": "M", "Color": "Red"}, "stock": 10}
+	products.Put("/:id/variants/:variantId", middleware.AuthRequired, editorOnly, productHandler.UpdateProductVariant)
+	products.Delete("/:id/variants/:variantId", middleware.AuthRequired, editorOnly, productHandler.DeleteProductVariant)
+	products.Get("/:id/price-history", productHandler.GetPriceHistory)                                             // ?variant_id=&days=90
+	products.Get("/:id/price-schedules", middleware.AuthRequired, editorOnly, productHandler.GetPriceSchedules)    // ?status=scheduled
+	products.Post("/:id/price-schedules", middleware.AuthRequired, editorOnly, productHandler.CreatePriceSchedule) // {"price": 14.99, "starts_at": "2026-11-27T00:00:00Z", "ends_at": "2026-11-30T23:59:59Z"}
+	products.Delete("/:id/price-schedules/:scheduleId", middleware.AuthRequired, editorOnly, productHandler.CancelPriceSchedule)
+	products.Get("/:id/reviews", reviewHandler.GetProductReviews)                      // ?rating=5&verified=true&sort=helpful&page=1
+	products.Post("/:id/reviews", middleware.AuthRequired, reviewHandler.CreateReview) // {"rating": 5, "title": "...", "body": "...", "images": ["<media asset id>"]}
+
+	// Reviews, their moderation queue and helpfulness votes
+	reviews := api.Group("/reviews")
+	reviews.Get("/moderation", middleware.AuthRequired, editorOnly, reviewHandler.GetReviewModerationQueue) // ?status=pending
+	reviews.Put("/:id", middleware.AuthRequired, reviewHandler.UpdateReview)
+	reviews.Delete("/:id", middleware.AuthRequired, reviewHandler.DeleteReview)
+	reviews.Post("/:id/approve", middleware.AuthRequired, editorOnly, reviewHandler.ApproveReview)
+	reviews.Post("/:id/reject", middleware.AuthRequired, editorOnly, reviewHandler.RejectReview) // {"reason": "..."}
+	reviews.Put("/:id/vote", middleware.AuthRequired, reviewHandler.VoteReview)                  // {"helpful": true}
+	reviews.Delete("/:id/vote", middleware.AuthRequired, reviewHandler.UnvoteReview)
+
+	// Import and export jobs: progress, rejected rows and export downloads
+	productJobRoutes := api.Group("/product-jobs", middleware.AuthRequired, editorOnly)
+	productJobRoutes.Get("/", productJobHandler.GetProductJobs) // ?kind=import
+	productJobRoutes.Get("/:id", productJobHandler.GetProductJob)
+	productJobRoutes.Get("/:id/errors", productJobHandler.GetProductJobErrors) // ?page=1 or ?format=csv
+	productJobRoutes.Get("/:id/download", productJobHandler.DownloadProductExport)
+
+	// Deleted products, posts, orders and users; :type is one of those
+	trashRoutes := api.Group("/trash", middleware.AuthRequired, middleware.RequireRole(db, models.RoleAdmin))
+	trashRoutes.Get("/:type", trashHandler.GetTrash)                      // GET /api/v1/trash/products?page=1
+	trashRoutes.Post("/:type/:id/restore", trashHandler.RestoreFromTrash) // POST /api/v1/trash/posts/{id}/restore
+	trashRoutes.Delete("/:type/:id", trashHandler.PurgeFromTrash)         // 409 while orders or content still refer to it
+
+	// Category tree; a category's products include its subcategories'
+	categories := api.Group("/categories")
+	categories.Get("/", categoryHandler.GetCategories)
+	categories.Get("/:slug", categoryHandler.GetCategory)
+	categories.Post("/", middleware.AuthRequired, editorOnly, categoryHandler.CreateCategory) // {"name": "T-Shirts", "parent_id": "..."}
+	categories.Put("/:id", middleware.AuthRequired, editorOnly, categoryHandler.UpdateCategory)
+	categories.Delete("/:id", middleware.AuthRequired, editorOnly, categoryHandler.DeleteCategory)
+
+	// Warehouses and stock; product and variant stock is what they have
+	// available
+	inventoryRoutes := api.Group("/inventory", middleware.AuthRequired, editorOnly)
+	inventoryRoutes.Get("/", inventoryHandler.GetInventory) // ?warehouse_id=&product_id=&variant_id=&low=true
+	inventoryRoutes.Get("/warehouses", inventoryHandler.GetWarehouses)
+	inventoryRoutes.Post("/warehouses", inventoryHandler.CreateWarehouse) // {"code": "HCM", "name": "Ho Chi Minh City", "priority": 1}
+	inventoryRoutes.Put("/warehouses/:id", inventoryHandler.UpdateWarehouse)
+	inventoryRoutes.Delete("/warehouses/:id", inventoryHandler.DeleteWarehouse)
+	inventoryRoutes.Get("/movements", inventoryHandler.GetStockMovements)       // ?product_id=&warehouse_id=&type=sale&page=1
+	inventoryRoutes.Post("/movements", inventoryHandler.CreateStockMovement)    // {"type": "transfer", "warehouse_id": "...", "to_warehouse_id": "...", "product_id": "...", "quantity": 5}
+	inventoryRoutes.Put("/thresholds", inventoryHandler.SetLowStockThreshold)   // {"warehouse_id": "...", "product_id": "...", "threshold": 5}
+	inventoryRoutes.Get("/reservations", inventoryHandler.GetStockReservations) // ?status=active or ?order_id=
+	inventoryRoutes.Get("/alerts", inventoryHandler.GetStockAlerts)             // ?all=true
+
+	// Exchange rates used to price products without a price list entry
+	exchangeRates := api.Group("/exchange-rates")
+	exchangeRates.Get("/", currencyHandler.GetExchangeRates)
+	exchangeRates.Get("/convert", currencyHandler.ConvertAmount)                                            // GET /api/v1/exchange-rates/convert?amount=10&from=USD&to=EUR
+	exchangeRates.Post("/import", middleware.AuthRequired, editorOnly, currencyHandler.ImportExchangeRates) // CSV base,quote,rate rows or {"base": "USD", "rates": {...}}
+
+	// Carts belong to the signed-in user, or to the X-Cart-Token / cart_token
+	// cookie for anonymous shoppers
+	cart := api.Group("/cart", middleware.OptionalAuth)
+	cart.Get("/", cartHandler.GetCart)
+	cart.Put("/currency", cartHandler.SetCartCurrency) // {"currency": "EUR"}
+	cart.Post("/items", cartHandler.AddCartItem)       // {"product_id": "...", "quantity": 1}
+	cart.Put("/items/:productId", cartHandler.UpdateCartItem)
+	cart.Delete("/items/:productId", cartHandler.RemoveCartItem)
+	cart.Post("/checkout", middleware.AuthRequired, cartHandler.CheckoutCart)
+
+	// Promotions and discount codes
+	promotions := api.Group("/promotions", middleware.AuthRequired, editorOnly)
+	promotions.Get("/", promotionHandler.GetPromotions)
+	promotions.Post("/", promotionHandler.CreatePromotion)
+	promotions.Get("/:id", promotionHandler.GetPromotion)
+	promotions.Put("/:id", promotionHandler.UpdatePromotion)
+	promotions.Delete("/:id", promotionHandler.DeletePromotion)
+
+	// Tax rates by country, region and product tax category
+	taxRates := api.Group("/tax-rates")
+	taxRates.Get("/", taxHandler.GetTaxRates) // GET /api/v1/tax-rates?country=US
+	taxRates.Post("/", middleware.AuthRequired, editorOnly, taxHandler.CreateTaxRate)
+	taxRates.Put("/:id", middleware.AuthRequired, editorOnly, taxHandler.UpdateTaxRate)
+	taxRates.Delete("/:id", middleware.AuthRequired, editorOnly, taxHandler.DeleteTaxRate)
+
+	// Shipping zones, methods and rate tables; shoppers see the options for
+	// an address through /orders/quote
+	shipping := api.Group("/shipping", middleware.AuthRequired, editorOnly)
+	shipping.Get("/zones", shippingHandler.GetShippingZones)
+	shipping.Post("/zones", shippingHandler.CreateShippingZone) // {"name": "EU", "countries": ["DE", "FR"]}
+	shipping.Put("/zones/:id", shippingHandler.UpdateShippingZone)
+	shipping.Delete("/zones/:id", shippingHandler.DeleteShippingZone)
+	shipping.Post("/zones/:id/methods", shippingHandler.CreateShippingMethod) // {"name": "Standard", "rate_basis": "weight", "rates": [{"from": 0, "to": 2000, "price": 4.90}]}
+	shipping.Put("/methods/:id", shippingHandler.UpdateShippingMethod)
+	shipping.Delete("/methods/:id", shippingHandler.DeleteShippingMethod)
 
 	orders := api.Group("/orders")
-	orders.Get("/", orderHandler.GetOrders)
-	orders.Post("/", orderHandler.CreateOrder)
-	orders.Get("/:id", orderHandler.GetOrder)
-	orders.Put("/:id", orderHandler.UpdateOrder)
+	orders.Get("/", middleware.AuthRequired, orderHandler.GetOrders) // staff see every order, customers their own
+	orders.Post("/", middleware.AuthRequired, orderHandler.CreateOrder)
+	orders.Post("/checkout", middleware.AuthRequired, orderHandler.Checkout) // {"items": [...], "promo_codes": ["SUMMER10"]}
+	orders.Post("/quote", middleware.OptionalAuth, orderHandler.QuoteOrder)  // add "address_id" or "shipping_address" for shipping options
+	orders.Get("/:id", middleware.AuthRequired, orderHandler.GetOrder)
+	orders.Put("/:id", middleware.AuthRequired, orderHandler.UpdateOrder)
+	orders.Post("/:id/transitions", middleware.AuthRequired, orderHandler.TransitionOrder) // {"to": "shipped", "tracking_number": "..."}
+	orders.Get("/:id/events", middleware.AuthRequired, orderHandler.GetOrderEvents)
+	orders.Post("/:id/payments", middleware.AuthRequired, paymentHandler.StartPayment)
+	orders.Get("/:id/payments", middleware.AuthRequired, paymentHandler.GetOrderPayments)
+	orders.Get("/:id/invoice", middleware.AuthRequired, invoiceHandler.GetOrderInvoice) // GET /api/v1/orders/{id}/invoice?format=pdf|html
+	orders.Get("/:id/invoices", middleware.AuthRequired, invoiceHandler.GetOrderInvoices)
+	orders.Get("/:id/credit-notes/:number", middleware.AuthRequired, invoiceHandler.GetCreditNote)
+	orders.Post("/:id/returns", middleware.AuthRequired, returnHandler.CreateReturn) // {"items": [{"order_item_id": "...", "quantity": 1, "reason": "damaged"}]}
+	orders.Get("/:id/returns", middleware.AuthRequired, returnHandler.GetOrderReturns)
+
+	// Issued invoices and credit notes for accounting
+	api.Get("/invoices", middleware.AuthRequired, editorOnly, invoiceHandler.GetInvoices) // GET /api/v1/invoices?year=2026&kind=credit_note
+
+	// Returns: requested by the customer, then approved or rejected,
+	// received and refunded by staff
+	returnRoutes := api.Group("/returns")
+	returnRoutes.Get("/", middleware.AuthRequired, editorOnly, returnHandler.GetReturns) // GET /api/v1/returns?status=requested
+	returnRoutes.Get("/:id", middleware.AuthRequired, returnHandler.GetReturn)
+	returnRoutes.Post("/:id/cancel", middleware.AuthRequired, returnHandler.CancelReturn)
+	returnRoutes.Post("/:id/approve", middleware.AuthRequired, editorOnly, returnHandler.ApproveReturn) // {"note": "Use the prepaid label"}
+	returnRoutes.Post("/:id/reject", middleware.AuthRequired, editorOnly, returnHandler.RejectReturn)
+	returnRoutes.Post("/:id/receive", middleware.AuthRequired, editorOnly, returnHandler.ReceiveReturn) // {"warehouse_id": "...", "restock": {"<return item id>": 0}}
+	returnRoutes.Post("/:id/refund", middleware.AuthRequired, editorOnly, returnHandler.RefundReturn)   // {"amount": "12.50"}; empty refunds the items' value
+
+	// Payments
+	paymentRoutes := api.Group("/payments")
+	paymentRoutes.Post("/webhook", paymentHandler.Webhook) // signed by the provider, no auth
+	paymentRoutes.Post("/:id/capture", middleware.AuthRequired, editorOnly, paymentHandler.CapturePayment)
+	paymentRoutes.Post("/:id/refund", middleware.AuthRequired, editorOnly, paymentHandler.RefundPayment)
 	orders.Delete("/:id", orderHandler.DeleteOrder)
 
 	// Communication (API 5) - Replaced with Music API
@@ -101,6 +405,26 @@ func SetupRoutes(app *fiber.App, db *gorm.DB) {
 	cve.Put("/:id", handlers.UpdateCVE)       // PUT /api/v1/cve/{id}
 	cve.Delete("/:id", handlers.DeleteCVE)    // DELETE /api/v1/cve/{id}
 
+	// Syndication feeds and sitemaps
+	app.Get("/feeds/tags/:slug/:format", feedHandler.GetTagFeed)           // GET /feeds/tags/{slug}/{rss|atom|json}
+	app.Get("/feeds/authors/:username/:format", feedHandler.GetAuthorFeed) // GET /feeds/authors/{username}/{rss|atom|json}
+	app.Get("/feeds/:format", feedHandler.GetFeed)                         // GET /feeds/{rss|atom|json}
+	app.Get("/sitemap.xml", feedHandler.GetSitemapIndex)
+	app.Get("/sitemaps/tags.xml", feedHandler.GetTagsSitemap)
+	app.Get("/sitemaps/posts-:page.xml", feedHandler.GetPostsSitemap)
+
+	// Uploaded files
+	if strings.HasPrefix(cfg.MediaURL, "/") {
+		app.Static(cfg.MediaURL, cfg.MediaDir, fiber.Static{MaxAge: 31536000})
+	}
+
+	// Local stand-ins for the Akismet API (point AKISMET_URL at it) and for
+	// confirming fake provider payments
+	if cfg.AppEnv == "development" {
+		app.Post("/dev/payments/:ref/confirm", paymentHandler.DevConfirmPayment)
+		app.Use("/dev/akismet", adaptor.HTTPHandler(http.StripPrefix("/dev/akismet", spam.FakeAkismet())))
+	}
+
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
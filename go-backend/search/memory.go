@@ -0,0 +1,259 @@
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryIndex keeps every document in memory and scans them per query,
+// which is plenty for catalogs of a few ten thousand products
+type MemoryIndex struct {
+	mu   sync.RWMutex
+	docs map[string]*entry
+}
+
+// entry is a document with its pre-tokenized text
+type entry struct {
+	Document
+	title []string
+	text  []string
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{docs: map[string]*entry{}}
+}
+
+func (ix *MemoryIndex) Upsert(_ context.Context, docs ...Document) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, d := range docs {
+		ix.docs[d.ID] = newEntry(d)
+	}
+	return nil
+}
+
+func (ix *MemoryIndex) Delete(_ context.Context, ids ...string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, id := range ids {
+		delete(ix.docs, id)
+	}
+	return nil
+}
+
+func (ix *MemoryIndex) Replace(_ context.Context, docs []Document) error {
+	entries := make(map[string]*entry, len(docs))
+	for _, d := range docs {
+		entries[d.ID] = newEntry(d)
+	}
+	ix.mu.Lock()
+	ix.docs = entries
+	ix.mu.Unlock()
+	return nil
+}
+
+func (ix *MemoryIndex) Search(_ context.Context, q Query) (*Result, error) {
+	terms := Tokens(q.Text)
+	if q.Sort == "" {
+		q.Sort = SortNewest
+		if len(terms) > 0 {
+			q.Sort = SortRelevance
+		}
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	type hit struct {
+		*entry
+		score float64
+	}
+	var hits []hit
+	// Documents failing exactly one attribute filter still count towards
+	// that attribute's facet
+	attrCounts := map[string]map[string]int{}
+	for _, e := range ix.docs {
+		if !e.matchesFilters(q) {
+			continue
+		}
+		score, ok := e.score(terms)
+		if !ok {
+			continue
+		}
+		failed := e.failedAttributes(q.Attributes)
+		if len(failed) > 1 {
+			continue
+		}
+		for name, values := range e.Attributes {
+			if len(failed) == 1 && failed[0] != name {
+				continue
+			}
+			counts := attrCounts[name]
+			if counts == nil {
+				counts = map[string]int{}
+				attrCounts[name] = counts
+			}
+			for _, v := range distinct(values) {
+				counts[v]++
+			}
+		}
+		if len(failed) == 0 {
+			hits = append(hits, hit{entry: e, score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		switch q.Sort {
+		case SortRelevance:
+			if a.score != b.score {
+				return a.score > b.score
+			}
+		case SortPriceAsc:
+			if a.Price != b.Price {
+				return a.Price < b.Price
+			}
+		case SortPriceDesc:
+			if a.Price != b.Price {
+				return a.Price > b.Price
+			}
+		case SortPopularity:
+			if a.Popularity != b.Popularity {
+				return a.Popularity > b.Popularity
+			}
+		case SortRating:
+			if a.Rating != b.Rating {
+				return a.Rating > b.Rating
+			}
+			if a.RatingCount != b.RatingCount {
+				return a.RatingCount > b.RatingCount
+			}
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+
+	res := &Result{Total: len(hits), Attributes: map[string][]FacetCount{}}
+	categories := map[string]int{}
+	for _, h := range hits {
+		for _, path := range h.Categories {
+			categories[path]++
+		}
+	}
+	res.Categories = facetCounts(categories)
+	for name, counts := range attrCounts {
+		res.Attributes[name] = facetCounts(counts)
+	}
+
+	start := min(max(q.Offset, 0), len(hits))
+	end := len(hits)
+	if q.Limit > 0 {
+		end = min(start+q.Limit, len(hits))
+	}
+	res.IDs = make([]string, 0, end-start)
+	for _, h := range hits[start:end] {
+		res.IDs = append(res.IDs, h.ID)
+	}
+	return res, nil
+}
+
+func newEntry(d Document) *entry {
+	return &entry{Document: d, title: Tokens(d.Title), text: Tokens(d.Text)}
+}
+
+// matchesFilters checks the category, price and stock filters
+func (e *entry) matchesFilters(q Query) bool {
+	if q.Category != "" && !containsFold(e.Categories, q.Category) {
+		return false
+	}
+	if q.MinPrice != nil && e.Price < *q.MinPrice {
+		return false
+	}
+	if q.MaxPrice != nil && e.Price > *q.MaxPrice {
+		return false
+	}
+	return !q.InStock || e.InStock
+}
+
+// score reports whether every term starts a word of the document, scoring
+// title words above the rest and whole words above prefixes
+func (e *entry) score(terms []string) (float64, bool) {
+	var score float64
+	for _, term := range terms {
+		best := 0.0
+		for _, words := range []struct {
+			tokens []string
+			weight float64
+		}{{e.title, 3}, {e.text, 1}} {
+			for _, w := range words.tokens {
+				switch {
+				case w == term:
+					best = max(best, words.weight*2)
+				case strings.HasPrefix(w, term):
+					best = max(best, words.weight)
+				}
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		score += best
+	}
+	return score, true
+}
+
+// failedAttributes lists the filtered attributes the document has none of
+// the wanted values for
+func (e *entry) failedAttributes(filters map[string][]string) []string {
+	var failed []string
+	for name, wanted := range filters {
+		ok := false
+		for _, v := range e.Attributes[name] {
+			ok = ok || containsFold(wanted, v)
+		}
+		if !ok {
+			failed = append(failed, name)
+		}
+	}
+	return failed
+}
+
+// facetCounts orders counts by count, then value
+func facetCounts(counts map[string]int) []FacetCount {
+	facets := make([]FacetCount, 0, len(counts))
+	for v, n := range counts {
+		facets = append(facets, FacetCount{Value: v, Count: n})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	return facets
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func distinct(values []string) []string {
+	seen := map[string]bool{}
+	out := values[:0:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
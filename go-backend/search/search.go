@@ -0,0 +1,114 @@
+// Package search keeps the catalog search index behind a small interface
+// so the in-memory index can later be swapped for a search server.
+package search
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Sort orders
+const (
+	SortRelevance  = "relevance"
+	SortPriceAsc   = "price_asc"
+	SortPriceDesc  = "price_desc"
+	SortNewest     = "newest"
+	SortPopularity = "popularity"
+	SortRating     = "rating"
+)
+
+// Document is what the index knows about one product
+type Document struct {
+	ID    string
+	Title string
+	// Text is searched along with the title, e.g. description and SKUs
+	Text string
+	// Categories are the category paths the document is filed under,
+	// including every ancestor: "clothing", "clothing/t-shirts"
+	Categories []string
+	// Price is the lowest price in minor units of the index currency
+	Price   int64
+	InStock bool
+	// Attributes are the facet values, e.g. Color: Red, Blue
+	Attributes map[string][]string
+	Popularity float64
+	// Rating is the average review rating out of RatingCount reviews
+	Rating      float64
+	RatingCount int
+	CreatedAt   time.Time
+}
+
+// Query selects documents. Category matches a path and its subtree;
+// Attributes need one of the listed values for each attribute.
+type Query struct {
+	Text       string
+	Category   string
+	MinPrice   *int64
+	MaxPrice   *int64
+	InStock    bool
+	Attributes map[string][]string
+	// Sort defaults to relevance with Text and newest without
+	Sort   string
+	Offset int
+	Limit  int
+}
+
+// FacetCount is how many matches have a value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Result is one page of matching document IDs with the facets of all
+// matches
+type Result struct {
+	IDs   []string
+	Total int
+	// Attributes counts each attribute's values among the matches,
+	// ignoring the query's own filter on that attribute so the other
+	// values stay selectable
+	Attributes map[string][]FacetCount
+	// Categories counts matches per category path
+	Categories []FacetCount
+}
+
+// Index stores documents and answers queries
+type Index interface {
+	Upsert(ctx context.Context, docs ...Document) error
+	Delete(ctx context.Context, ids ...string) error
+	// Replace swaps the whole index for docs
+	Replace(ctx context.Context, docs []Document) error
+	Search(ctx context.Context, q Query) (*Result, error)
+}
+
+// Fold lowercases s and strips diacritics, so "Áo Đỏ" matches "ao do"
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == 'đ':
+			r = 'd'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Tokens splits folded text into words
+func Tokens(s string) []string {
+	return strings.FieldsFunc(Fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Slug turns a name into a URL slug with the same folding as the index,
+// e.g. "Áo thun nam" -> "ao-thun-nam"
+func Slug(s string) string {
+	return strings.Join(Tokens(s), "-")
+}
@@ -0,0 +1,518 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Cart lifetimes, extended on every change
+const (
+	AnonymousCartTTL = 7 * 24 * time.Hour
+	UserCartTTL      = 30 * 24 * time.Hour
+)
+
+// ErrCartItemNotFound is returned when changing a product that isn't in the cart
+var ErrCartItemNotFound = errors.New("cart: product is not in the cart")
+
+// CartLine is a cart item priced and checked against the current catalog
+type CartLine struct {
+	ProductID    uuid.UUID   `json:"product_id"`
+	VariantID    *uuid.UUID  `json:"variant_id,omitempty"`
+	SKU          string      `json:"sku,omitempty"`
+	Name         string      `json:"name"`
+	VariantName  string      `json:"variant_name,omitempty"`
+	ImageURL     string      `json:"image_url,omitempty"`
+	Quantity     int         `json:"quantity"`
+	UnitPrice    money.Money `json:"unit_price"`
+	LineTotal    money.Money `json:"line_total"`
+	Available    int         `json:"available"`
+	PriceChanged bool        `json:"price_changed"`
+	AddedPrice   money.Money `json:"added_price"`
+	Issue        *LineError  `json:"issue,omitempty"`
+}
+
+// CartView is what the shopper sees: live prices and stock for every line.
+// Valid is false when any line has an issue that would fail checkout.
+type CartView struct {
+	ID        uuid.UUID   `json:"id"`
+	Token     string      `json:"token,omitempty"`
+	Currency  string      `json:"currency"`
+	Lines     []CartLine  `json:"lines"`
+	ItemCount int         `json:"item_count"`
+	Subtotal  money.Money `json:"subtotal"`
+	Valid     bool        `json:"valid"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// Carts stores server-side shopping carts for signed-in shoppers (by user)
+// and anonymous ones (by cart token)
+type Carts struct {
+	db       *gorm.DB
+	checkout *Checkout
+	currency string
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewCarts places cart orders through checkout and prices new carts in its
+// currency unless the shopper picks another
+func NewCarts(db *gorm.DB, checkout *Checkout) *Carts {
+	return &Carts{db: db, checkout: checkout, currency: checkout.currency}
+}
+
+// Find returns the user's cart, or the anonymous cart for token when userID
+// is nil. It returns nil without an error when there is no live cart.
+func (s *Carts) Find(ctx context.Context, userID *uuid.UUID, token string) (*models.Cart, error) {
+	q := s.db.WithContext(ctx).Where("expires_at > ?", time.Now())
+	switch {
+	case userID != nil:
+		q = q.Where("user_id = ?", *userID)
+	case token != "":
+		q = q.Where("token = ? AND user_id IS NULL", token)
+	default:
+		return nil, nil
+	}
+
+	var cart models.Cart
+	if err := q.First(&cart).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// FindOrCreate is Find, creating an empty cart when there is none
+func (s *Carts) FindOrCreate(ctx context.Context, userID *uuid.UUID, token string) (*models.Cart, error) {
+	cart, err := s.Find(ctx, userID, token)
+	if err != nil || cart != nil {
+		return cart, err
+	}
+	return createCart(s.db.WithContext(ctx), userID, s.currency)
+}
+
+// SetCurrency switches the currency the cart is priced and checked out in
+func (s *Carts) SetCurrency(ctx context.Context, cart *models.Cart, currency string) error {
+	currency, err := CheckCurrency(currency, s.currency)
+	if err != nil {
+		return err
+	}
+	if cart.Currency == currency {
+		return nil
+	}
+	cart.Currency = currency
+	return s.db.WithContext(ctx).Model(cart).Update("currency", currency).Error
+}
+
+func createCart(tx *gorm.DB, userID *uuid.UUID, currency string) (*models.Cart, error) {
+	token, err := newCartToken()
+	if err != nil {
+		return nil, err
+	}
+	cart := &models.Cart{UserID: userID, Token: token, Currency: currency, ExpiresAt: cartExpiry(userID)}
+
+	// An expired cart that hasn't been swept yet would violate the unique
+	// user index, so it is cleared first
+	if userID != nil {
+		if err := deleteUserCarts(tx, *userID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Create(cart).Error; err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// AddItem adds quantity of a product, on top of any already in the cart.
+// variantID picks the variant of products that have them and is nil
+// otherwise; the same goes for the other item methods.
+func (s *Carts) AddItem(ctx context.Context, cart *models.Cart, productID uuid.UUID, variantID *uuid.UUID, quantity int) error {
+	if quantity <= 0 {
+		return invalidQuantity(productID, quantity)
+	}
+	return s.changeItem(ctx, cart, productID, variantID, func(current int) int { return current + quantity })
+}
+
+// SetQuantity replaces a line's quantity; zero removes the line
+func (s *Carts) SetQuantity(ctx context.Context, cart *models.Cart, productID uuid.UUID, variantID *uuid.UUID, quantity int) error {
+	if quantity == 0 {
+		return s.RemoveItem(ctx, cart, productID, variantID)
+	}
+	return s.changeItem(ctx, cart, productID, variantID, func(current int) int {
+		if current == 0 {
+			return 0
+		}
+		return quantity
+	})
+}
+
+// RemoveItem drops a product from the cart
+func (s *Carts) RemoveItem(ctx context.Context, cart *models.Cart, productID uuid.UUID, variantID *uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		key := CheckoutLine{ProductID: productID, VariantID: variantID}.key()
+		res := tx.Where("cart_id = ? AND product_id = ? AND variant_id = ?", cart.ID, key.ProductID, key.VariantID).Delete(&models.CartItem{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrCartItemNotFound
+		}
+		return touchCart(tx, cart)
+	})
+}
+
+// changeItem sets a line to next(current quantity) after checking the
+// product can be bought in that quantity. next returning 0 means the line
+// must already exist.
+func (s *Carts) changeItem(ctx context.Context, cart *models.Cart, productID uuid.UUID, variantID *uuid.UUID, next func(current int) int) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		line := CheckoutLine{ProductID: productID, VariantID: variantID}
+		key := line.key()
+		var item models.CartItem
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("cart_id = ? AND product_id = ? AND variant_id = ?", cart.ID, key.ProductID, key.VariantID).
+			First(&item).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		quantity := next(item.Quantity)
+		if quantity == 0 {
+			return ErrCartItemNotFound
+		}
+		if quantity < 0 {
+			return invalidQuantity(productID, quantity)
+		}
+		line.Quantity = quantity
+
+		var product models.Product
+		products := map[uuid.UUID]models.Product{}
+		if err := tx.Preload("Prices").Scopes(WithVariants).First(&product, productID).Error; err == nil {
+			products[product.ID] = product
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if lineErrs := checkAvailability([]CheckoutLine{line}, products); len(lineErrs) > 0 {
+			return &CheckoutError{Lines: lineErrs}
+		}
+		rates, err := LoadRates(tx)
+		if err != nil {
+			return err
+		}
+		priced, err := priceLines([]CheckoutLine{line}, products, s.cartCurrency(cart), rates)
+		if err != nil {
+			return err
+		}
+
+		item.CartID = cart.ID
+		item.ProductID = productID
+		item.VariantID = key.VariantID
+		item.Quantity = quantity
+		item.AddedPrice = priced[0].UnitPrice
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		return touchCart(tx, cart)
+	})
+}
+
+// View prices the cart with current product data. Lines whose product was
+// removed, deactivated or no longer has enough stock carry an Issue.
+func (s *Carts) View(ctx context.Context, cart *models.Cart) (*CartView, error) {
+	db := s.db.WithContext(ctx)
+	var items []models.CartItem
+	if err := db.Preload("Product").Preload("Product.Prices").
+		Preload("Product.Options", func(db *gorm.DB) *gorm.DB { return db.Order("position, name") }).
+		Preload("Product.Variants").
+		Where("cart_id = ?", cart.ID).
+		Order("created_at ASC").
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	lines := make([]CheckoutLine, len(items))
+	products := map[uuid.UUID]models.Product{}
+	for i, item := range items {
+		lines[i] = cartLine(item)
+		if item.Product != nil {
+			products[item.ProductID] = *item.Product
+		}
+	}
+	issues := map[int]LineError{}
+	for _, e := range checkAvailability(lines, products) {
+		issues[e.Line] = e
+	}
+	rates, err := LoadRates(db)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := s.cartCurrency(cart)
+	view := &CartView{ID: cart.ID, Currency: currency, Lines: make([]CartLine, 0, len(items)), Subtotal: money.Zero(currency), Valid: len(items) > 0, ExpiresAt: cart.ExpiresAt}
+	if cart.UserID == nil {
+		view.Token = cart.Token
+	}
+	for i, item := range items {
+		line := CartLine{ProductID: item.ProductID, VariantID: lines[i].VariantID, Quantity: item.Quantity, AddedPrice: item.AddedPrice, UnitPrice: money.Zero(currency), LineTotal: money.Zero(currency)}
+		if p, ok := products[item.ProductID]; ok {
+			variant := lineVariant(p, lines[i])
+			line.Name = p.Name
+			line.ImageURL = p.ImageURL
+			line.Available = p.Stock
+			if variant != nil {
+				line.SKU = variant.SKU
+				line.VariantName = variant.Title(p.Options)
+				line.Available = variant.Stock
+				if len(variant.Images) > 0 {
+					line.ImageURL = variant.Images[0]
+				}
+			}
+			if unit, err := VariantPriceFor(p, variant, currency, rates); err == nil {
+				line.UnitPrice = unit
+				line.LineTotal = unit.Mul(item.Quantity)
+				line.PriceChanged = item.AddedPrice.SameCurrency(unit) && item.AddedPrice != unit
+			} else if _, flagged := issues[i]; !flagged {
+				issues[i] = LineError{Line: i, ProductID: p.ID, Code: LineNoPrice, Message: p.Name + " can't be bought in " + currency}
+			}
+		}
+		if issue, ok := issues[i]; ok {
+			line.Issue = &issue
+			view.Valid = false
+		} else {
+			view.Subtotal = view.Subtotal.Add(line.LineTotal)
+		}
+		view.ItemCount += item.Quantity
+		view.Lines = append(view.Lines, line)
+	}
+	return view, nil
+}
+
+// Merge moves the anonymous cart for token into the user's cart, adding up
+// quantities of products in both, and deletes the anonymous cart. When the
+// user has no cart yet the anonymous one is simply adopted.
+func (s *Carts) Merge(ctx context.Context, userID uuid.UUID, token string) error {
+	if token == "" {
+		return nil
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var anon models.Cart
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token = ? AND user_id IS NULL AND expires_at > ?", token, time.Now()).
+			First(&anon).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var cart models.Cart
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+			First(&cart).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := deleteUserCarts(tx, userID); err != nil {
+				return err
+			}
+			anon.UserID = &userID
+			return touchCart(tx, &anon)
+		}
+		if err != nil {
+			return err
+		}
+
+		var items []models.CartItem
+		if err := tx.Where("cart_id = ?", anon.ID).Find(&items).Error; err != nil {
+			return err
+		}
+		for _, item := range items {
+			merged := models.CartItem{CartID: cart.ID, ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity, AddedPrice: item.AddedPrice}
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "cart_id"}, {Name: "product_id"}, {Name: "variant_id"}},
+				DoUpdates: clause.Assignments(map[string]any{
+					"quantity":   gorm.Expr("cart_items.quantity + excluded.quantity"),
+					"updated_at": time.Now(),
+				}),
+			}).Create(&merged).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := deleteCarts(tx, []uuid.UUID{anon.ID}); err != nil {
+			return err
+		}
+		return touchCart(tx, &cart)
+	})
+}
+
+// Checkout places an order for the user's cart and empties it, in one
+// transaction. The order is in the cart's currency unless opts names one.
+// Line problems abort it with a *CheckoutError whose Line indexes follow
+// the cart's line order.
+func (s *Carts) Checkout(ctx context.Context, userID uuid.UUID, opts CheckoutOptions) (*models.Order, error) {
+	var order *models.Order
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Locking the cart makes a double-submitted checkout wait and then
+		// find the cart empty instead of ordering twice
+		var cart models.Cart
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+			First(&cart).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrEmptyOrder
+		}
+		if err != nil {
+			return err
+		}
+
+		var items []models.CartItem
+		if err := tx.Where("cart_id = ?", cart.ID).Order("created_at ASC").Find(&items).Error; err != nil {
+			return err
+		}
+		lines := make([]CheckoutLine, len(items))
+		for i, item := range items {
+			lines[i] = cartLine(item)
+		}
+
+		if opts.Currency == "" {
+			opts.Currency = s.cartCurrency(&cart)
+		}
+		order, err = s.checkout.PlaceOrderTx(tx, userID, lines, opts)
+		if err != nil {
+			return err
+		}
+		return tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// Sweep deletes expired carts and their items, returning how many carts
+// were removed
+func (s *Carts) Sweep(ctx context.Context) (int64, error) {
+	var removed int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&models.Cart{}).Select("id").Where("expires_at <= ?", time.Now())
+		if err := tx.Where("cart_id IN (?)", expired).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("expires_at <= ?", time.Now()).Delete(&models.Cart{})
+		removed = res.RowsAffected
+		return res.Error
+	})
+	return removed, err
+}
+
+// StartSweeper sweeps expired carts every interval until StopSweeper
+func (s *Carts) StartSweeper(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := s.Sweep(context.Background()); err != nil {
+					log.Printf("ERROR: failed to sweep expired carts - %v", err)
+				} else if n > 0 {
+					log.Printf("INFO: swept %d expired carts", n)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopSweeper ends the sweep loop
+func (s *Carts) StopSweeper() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.stopped
+	s.stop = nil
+}
+
+// cartCurrency is the cart's currency, or the default for carts created
+// before carts had one
+func (s *Carts) cartCurrency(cart *models.Cart) string {
+	if cart.Currency != "" {
+		return cart.Currency
+	}
+	return s.currency
+}
+
+// touchCart pushes the cart's expiry out after activity
+func touchCart(tx *gorm.DB, cart *models.Cart) error {
+	cart.ExpiresAt = cartExpiry(cart.UserID)
+	return tx.Model(cart).Select("user_id", "expires_at", "updated_at").Updates(cart).Error
+}
+
+// deleteUserCarts removes the user's cart, live or expired
+func deleteUserCarts(tx *gorm.DB, userID uuid.UUID) error {
+	var cartIDs []uuid.UUID
+	if err := tx.Model(&models.Cart{}).Where("user_id = ?", userID).Pluck("id", &cartIDs).Error; err != nil {
+		return err
+	}
+	return deleteCarts(tx, cartIDs)
+}
+
+// deleteCarts removes carts together with their items
+func deleteCarts(tx *gorm.DB, cartIDs []uuid.UUID) error {
+	if len(cartIDs) == 0 {
+		return nil
+	}
+	if err := tx.Where("cart_id IN ?", cartIDs).Delete(&models.CartItem{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("id IN ?", cartIDs).Delete(&models.Cart{}).Error
+}
+
+// cartLine is the checkout line for a cart item
+func cartLine(item models.CartItem) CheckoutLine {
+	line := CheckoutLine{ProductID: item.ProductID, Quantity: item.Quantity}
+	if item.VariantID != uuid.Nil {
+		variantID := item.VariantID
+		line.VariantID = &variantID
+	}
+	return line
+}
+
+func invalidQuantity(productID uuid.UUID, quantity int) error {
+	return &CheckoutError{Lines: []LineError{{ProductID: productID, Code: LineInvalidQuantity, Message: "quantity must be positive", Requested: quantity}}}
+}
+
+func cartExpiry(userID *uuid.UUID) time.Time {
+	if userID != nil {
+		return time.Now().Add(UserCartTTL)
+	}
+	return time.Now().Add(AnonymousCartTTL)
+}
+
+func newCartToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cart: generating token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
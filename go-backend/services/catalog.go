@@ -0,0 +1,385 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/search"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// popularityWindow is how far back units sold count towards popularity
+const popularityWindow = 90 * 24 * time.Hour
+
+var (
+	// ErrCategoryNotFound is returned for unknown category IDs and slugs
+	ErrCategoryNotFound = errors.New("category not found")
+	// ErrInvalidSort is returned for sort orders the search doesn't know
+	ErrInvalidSort = errors.New("invalid sort order")
+)
+
+// ProductQuery is a catalog search. Category is a slug and matches its
+// whole subtree; the price bounds may be in any currency with a rate.
+type ProductQuery struct {
+	Text       string
+	Category   string
+	MinPrice   *money.Money
+	MaxPrice   *money.Money
+	InStock    bool
+	Attributes map[string][]string
+	Sort       string
+	Page       int
+	Limit      int
+}
+
+// CategoryCount is how many matches a category's subtree holds
+type CategoryCount struct {
+	ID    uuid.UUID `json:"id"`
+	Name  string    `json:"name"`
+	Slug  string    `json:"slug"`
+	Path  string    `json:"path"`
+	Count int       `json:"count"`
+}
+
+// ProductResults is one page of search results with the facets of all
+// matches
+type ProductResults struct {
+	Products   []models.Product
+	Total      int
+	Attributes map[string][]search.FacetCount
+	Categories []CategoryCount
+}
+
+// Catalog keeps the search index in step with the products table. Edits
+// refresh single products; a periodic reindex catches stock and
+// popularity changes.
+type Catalog struct {
+	db       *gorm.DB
+	index    search.Index
+	currency string
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewCatalog indexes prices in currency, the shop's base currency
+func NewCatalog(db *gorm.DB, index search.Index, currency string) *Catalog {
+	return &Catalog{db: db, index: index, currency: currency}
+}
+
+// Reindex rebuilds the whole index and returns how many products it holds
+func (s *Catalog) Reindex(ctx context.Context) (int, error) {
+	docs, err := s.documents(s.db.WithContext(ctx).Where("is_active = ?", true))
+	if err != nil {
+		return 0, err
+	}
+	return len(docs), s.index.Replace(ctx, docs)
+}
+
+// Refresh reindexes products after they changed, dropping those that were
+// deleted or deactivated
+func (s *Catalog) Refresh(ctx context.Context, ids ...uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	docs, err := s.documents(s.db.WithContext(ctx).Where("id IN ? AND is_active = ?", ids, true))
+	if err != nil {
+		return err
+	}
+	indexed := map[string]bool{}
+	for _, d := range docs {
+		indexed[d.ID] = true
+	}
+	var gone []string
+	for _, id := range ids {
+		if !indexed[id.String()] {
+			gone = append(gone, id.String())
+		}
+	}
+	if err := s.index.Delete(ctx, gone...); err != nil {
+		return err
+	}
+	return s.index.Upsert(ctx, docs...)
+}
+
+// Search runs a query against the index and loads the page of products
+func (s *Catalog) Search(ctx context.Context, q ProductQuery) (*ProductResults, error) {
+	db := s.db.WithContext(ctx)
+	switch q.Sort {
+	case "", search.SortRelevance, search.SortPriceAsc, search.SortPriceDesc, search.SortNewest, search.SortPopularity, search.SortRating:
+	default:
+		return nil, ErrInvalidSort
+	}
+	query := search.Query{
+		Text:       q.Text,
+		InStock:    q.InStock,
+		Attributes: q.Attributes,
+		Sort:       q.Sort,
+		Offset:     (q.Page - 1) * q.Limit,
+		Limit:      q.Limit,
+	}
+	if q.Category != "" {
+		var category models.Category
+		if err := db.Where("slug = ?", q.Category).First(&category).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrCategoryNotFound
+			}
+			return nil, err
+		}
+		query.Category = category.Path
+	}
+	if q.MinPrice != nil || q.MaxPrice != nil {
+		rates, err := LoadRates(db)
+		if err != nil {
+			return nil, err
+		}
+		for _, bound := range []struct {
+			price *money.Money
+			dest  **int64
+		}{{q.MinPrice, &query.MinPrice}, {q.MaxPrice, &query.MaxPrice}} {
+			if bound.price == nil {
+				continue
+			}
+			converted, err := rates.Convert(bound.price.OrCurrency(s.currency), s.currency)
+			if err != nil {
+				return nil, err
+			}
+			*bound.dest = &converted.Amount
+		}
+	}
+
+	found, err := s.index.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	res := &ProductResults{Products: []models.Product{}, Total: found.Total, Attributes: found.Attributes, Categories: []CategoryCount{}}
+
+	if len(found.IDs) > 0 {
+		var products []models.Product
+		if err := db.Preload("Prices").Scopes(WithVariants).Where("id IN ?", found.IDs).Find(&products).Error; err != nil {
+			return nil, err
+		}
+		byID := make(map[string]models.Product, len(products))
+		for _, p := range products {
+			byID[p.ID.String()] = p
+		}
+		// Keep the index's order; products deleted since it was built drop out
+		for _, id := range found.IDs {
+			if p, ok := byID[id]; ok {
+				res.Products = append(res.Products, p)
+			}
+		}
+	}
+
+	if len(found.Categories) > 0 {
+		counts := map[string]int{}
+		paths := make([]string, 0, len(found.Categories))
+		for _, f := range found.Categories {
+			counts[f.Value] = f.Count
+			paths = append(paths, f.Value)
+		}
+		var categories []models.Category
+		if err := db.Where("path IN ?", paths).Order("path").Find(&categories).Error; err != nil {
+			return nil, err
+		}
+		for _, c := range categories {
+			res.Categories = append(res.Categories, CategoryCount{ID: c.ID, Name: c.Name, Slug: c.Slug, Path: c.Path, Count: counts[c.Path]})
+		}
+	}
+	return res, nil
+}
+
+// StartIndexer reindexes right away and then every interval until
+// StopIndexer is called
+func (s *Catalog) StartIndexer(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if n, err := s.Reindex(context.Background()); err != nil {
+				log.Printf("ERROR: failed to reindex the catalog - %v", err)
+			} else {
+				log.Printf("INFO: indexed %d products for search", n)
+			}
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopIndexer ends the reindex loop
+func (s *Catalog) StopIndexer() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.stopped
+	s.stop = nil
+}
+
+// documents builds index documents for the products query selects
+func (s *Catalog) documents(query *gorm.DB) ([]search.Document, error) {
+	var products []models.Product
+	if err := query.Preload("Prices").Scopes(WithVariants).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, nil
+	}
+	db := query.Session(&gorm.Session{NewDB: true})
+
+	var categories []models.Category
+	if err := db.Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	paths := make(map[uuid.UUID]string, len(categories))
+	for _, c := range categories {
+		paths[c.ID] = c.Path
+	}
+
+	ids := make([]uuid.UUID, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	var sold []struct {
+		ProductID uuid.UUID
+		Units     int
+	}
+	if err := db.Model(&models.OrderItem{}).
+		Select("order_items.product_id, SUM(order_items.quantity) AS units").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.product_id IN ? AND orders.created_at >= ? AND orders.deleted_at IS NULL AND orders.status IN ?", ids, time.Now().Add(-popularityWindow),
+			[]string{models.OrderStatusPaid, models.OrderStatusFulfilling, models.OrderStatusShipped, models.OrderStatusDelivered}).
+		Group("order_items.product_id").
+		Scan(&sold).Error; err != nil {
+		return nil, err
+	}
+	popularity := make(map[uuid.UUID]float64, len(sold))
+	for _, row := range sold {
+		popularity[row.ProductID] = float64(row.Units)
+	}
+
+	rates, err := LoadRates(db)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]search.Document, 0, len(products))
+	for _, p := range products {
+		doc := s.document(p, rates)
+		if p.CategoryID != nil {
+			doc.Categories = ancestorPaths(paths[*p.CategoryID])
+		}
+		doc.Popularity = popularity[p.ID]
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// document describes one product: its lowest price in the base currency,
+// whether anything of it is in stock, and its variants' option values as
+// attributes
+func (s *Catalog) document(p models.Product, rates *money.Rates) search.Document {
+	doc := search.Document{
+		ID:          p.ID.String(),
+		Title:       p.Name,
+		Rating:      p.RatingAverage,
+		RatingCount: p.RatingCount,
+		CreatedAt:   p.CreatedAt,
+		Attributes:  map[string][]string{},
+	}
+	text := []string{p.SKU, p.Description, p.Category}
+
+	var prices []money.Money
+	if len(p.Variants) == 0 {
+		if price, err := PriceFor(p, s.currency, rates); err == nil {
+			prices = append(prices, price)
+		}
+		doc.InStock = p.Stock > 0
+	}
+	for i := range p.Variants {
+		v := &p.Variants[i]
+		if !v.IsActive {
+			continue
+		}
+		if price, err := VariantPriceFor(p, v, s.currency, rates); err == nil {
+			prices = append(prices, price)
+		}
+		doc.InStock = doc.InStock || v.Stock > 0
+		text = append(text, v.SKU, v.Barcode)
+		for _, o := range p.Options {
+			if value := v.Options[o.Name]; value != "" && !slices.Contains(doc.Attributes[o.Name], value) {
+				doc.Attributes[o.Name] = append(doc.Attributes[o.Name], value)
+			}
+		}
+	}
+	for i, price := range prices {
+		if i == 0 || price.Amount < doc.Price {
+			doc.Price = price.Amount
+		}
+	}
+	doc.Text = strings.Join(text, " ")
+	return doc
+}
+
+// ancestorPaths lists a category path and those of its ancestors:
+// "a/b/c" -> "a", "a/b", "a/b/c"
+func ancestorPaths(path string) []string {
+	if path == "" {
+		return nil
+	}
+	parts := strings.Split(path, "/")
+	paths := make([]string, len(parts))
+	for i := range parts {
+		paths[i] = strings.Join(parts[:i+1], "/")
+	}
+	return paths
+}
+
+// FileProduct files a product under its category: CategoryID must exist,
+// and without one a Category name finds or creates a top level category,
+// as free text categories did before the tree. Category is set to the
+// category's name.
+func FileProduct(tx *gorm.DB, product *models.Product) error {
+	var category models.Category
+	switch {
+	case product.CategoryID != nil:
+		if err := tx.First(&category, *product.CategoryID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCategoryNotFound
+			}
+			return err
+		}
+	case strings.TrimSpace(product.Category) != "":
+		name := strings.TrimSpace(product.Category)
+		err := tx.Where("LOWER(name) = LOWER(?) OR slug = ?", name, search.Slug(name)).Order("path").First(&category).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			category = models.Category{Name: name, Slug: search.Slug(name), Path: search.Slug(name)}
+			if category.Slug == "" {
+				return ErrCategoryNotFound
+			}
+			err = tx.Create(&category).Error
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		product.Category = ""
+		return nil
+	}
+	product.CategoryID = &category.ID
+	product.Category = category.Name
+	return nil
+}
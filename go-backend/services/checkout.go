@@ -0,0 +1,539 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/tax"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Line error codes reported by checkout
+const (
+	LineInvalidQuantity = "invalid_quantity"
+	LineNotFound        = "not_found"
+	LineInactive        = "inactive"
+	LineOutOfStock      = "out_of_stock"
+	LineVariantRequired = "variant_required"
+)
+
+// ErrEmptyOrder is returned for a checkout without lines
+var ErrEmptyOrder = errors.New("checkout: order has no items")
+
+// CheckoutLine is one requested product and quantity. Products with
+// variants also need the VariantID being bought.
+type CheckoutLine struct {
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	Quantity  int        `json:"quantity"`
+}
+
+// stockKey is what a line takes stock from: a variant, or the product
+// itself when VariantID is uuid.Nil
+type stockKey struct {
+	ProductID uuid.UUID
+	VariantID uuid.UUID
+}
+
+func (l CheckoutLine) key() stockKey {
+	k := stockKey{ProductID: l.ProductID}
+	if l.VariantID != nil {
+		k.VariantID = *l.VariantID
+	}
+	return k
+}
+
+// LineError explains why a line can't be fulfilled. Line is the index in
+// the request.
+type LineError struct {
+	Line      int        `json:"line"`
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	Code      string     `json:"code"`
+	Message   string     `json:"message"`
+	Requested int        `json:"requested,omitempty"`
+	Available int        `json:"available,omitempty"`
+}
+
+// CheckoutOptions are the order-level choices made at checkout. Currency
+// is locked onto the order; empty means the shop's base currency.
+//
+// The order ships to AddressID from the shopper's address book or to an
+// inline ShippingAddress, by ShippingMethodID. Without an address, Country
+// and Region still pick the tax rates.
+type CheckoutOptions struct {
+	Currency         string                `json:"currency"`
+	PromoCodes       []string              `json:"promo_codes"`
+	AddressID        *uuid.UUID            `json:"address_id"`
+	ShippingAddress  *models.PostalAddress `json:"shipping_address"`
+	ShippingMethodID *uuid.UUID            `json:"shipping_method_id"`
+	Country          string                `json:"country"`
+	Region           string                `json:"region"`
+}
+
+// CheckoutError carries every line that failed; nothing was written
+type CheckoutError struct {
+	Lines []LineError
+}
+
+func (e *CheckoutError) Error() string {
+	msgs := make([]string, len(e.Lines))
+	for i, l := range e.Lines {
+		msgs[i] = fmt.Sprintf("line %d: %s", l.Line, l.Message)
+	}
+	return "checkout: " + strings.Join(msgs, "; ")
+}
+
+// Checkout turns requested lines into an order. Prices and totals are taken
+// from the database, never from the client.
+type Checkout struct {
+	db         *gorm.DB
+	currency   string
+	taxes      TaxPolicy
+	reserveFor time.Duration
+}
+
+// NewCheckout prices orders without an explicit currency in currency,
+// taxes them by taxes and holds their stock for reserveFor while they
+// await payment
+func NewCheckout(db *gorm.DB, currency string, taxes TaxPolicy, reserveFor time.Duration) *Checkout {
+	return &Checkout{db: db, currency: currency, taxes: taxes, reserveFor: reserveFor}
+}
+
+// PlaceOrder creates an order for userID in one transaction: it locks the
+// product rows, checks every line, snapshots prices in the order currency
+// into the items, applies promotion codes, prices the shipping method,
+// taxes the discounted lines and shipping, computes the totals and
+// reserves the stock in the warehouses. Any failing line
+// aborts the whole order with a *CheckoutError listing all of them; a
+// rejected code aborts it with a *PromotionError.
+func (s *Checkout) PlaceOrder(ctx context.Context, userID uuid.UUID, lines []CheckoutLine, opts CheckoutOptions) (*models.Order, error) {
+	if opts.Currency == "" {
+		opts.Currency = s.currency
+	}
+	var order *models.Order
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, err = s.PlaceOrderTx(tx, userID, lines, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// PlaceOrderTx is PlaceOrder inside the caller's transaction; opts.Currency
+// is required
+func (s *Checkout) PlaceOrderTx(tx *gorm.DB, userID uuid.UUID, lines []CheckoutLine, opts CheckoutOptions) (*models.Order, error) {
+	if len(lines) == 0 {
+		return nil, ErrEmptyOrder
+	}
+	currency, err := CheckCurrency(opts.Currency, "")
+	if err != nil {
+		return nil, err
+	}
+	var invalid []LineError
+	for i, line := range lines {
+		if line.Quantity <= 0 {
+			invalid = append(invalid, LineError{Line: i, ProductID: line.ProductID, Code: LineInvalidQuantity, Message: "quantity must be positive", Requested: line.Quantity})
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, &CheckoutError{Lines: invalid}
+	}
+
+	products, err := lockProducts(tx, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	if lineErrs := checkAvailability(lines, products); len(lineErrs) > 0 {
+		return nil, &CheckoutError{Lines: lineErrs}
+	}
+
+	rates, err := LoadRates(tx)
+	if err != nil {
+		return nil, err
+	}
+	priced, err := priceLines(lines, products, currency, rates)
+	if err != nil {
+		return nil, err
+	}
+
+	ship, err := shippingAddress(tx, &userID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if ship == nil && opts.ShippingMethodID != nil {
+		return nil, ErrAddressRequired
+	}
+	addr := s.taxes.address(ship, opts)
+	order := &models.Order{
+		UserID:           userID,
+		Status:           models.OrderStatusPending,
+		Currency:         currency,
+		Subtotal:         money.Zero(currency),
+		RefundedTotal:    money.Zero(currency),
+		PricesIncludeTax: s.taxes.PricesIncludeTax,
+		TaxCountry:       addr.Country,
+		TaxRegion:        addr.Region,
+	}
+	for i, line := range lines {
+		product := products[line.ProductID]
+		item := models.OrderItem{
+			ProductID:   line.ProductID,
+			ProductName: product.Name,
+			Quantity:    line.Quantity,
+			Price:       priced[i].UnitPrice,
+			LineTotal:   priced[i].LineTotal,
+			TaxCategory: priced[i].TaxCategory,
+		}
+		if variant := lineVariant(product, line); variant != nil {
+			item.VariantID = &variant.ID
+			item.SKU = variant.SKU
+			item.VariantName = variant.Title(product.Options)
+		}
+		order.Items = append(order.Items, item)
+		order.Subtotal = order.Subtotal.Add(item.LineTotal)
+	}
+
+	discounts, err := ApplyPromotions(tx, &userID, opts.PromoCodes, priced, currency, rates, true)
+	if err != nil {
+		return nil, err
+	}
+	order.ShippingTotal = money.Zero(currency)
+	if ship != nil {
+		parcel := parcelFor(priced, products, order.Subtotal.Sub(discounts.Total))
+		options, err := ShippingOptions(tx, *ship, parcel, currency, rates)
+		if err != nil {
+			return nil, err
+		}
+		method, err := pickShipping(options, opts.ShippingMethodID)
+		if err != nil {
+			return nil, err
+		}
+		order.ShippingAddress = *ship
+		if method != nil {
+			order.ShippingMethodID = &method.MethodID
+			order.ShippingMethodName = method.Name
+			order.Carrier = method.Carrier
+			if !discounts.FreeShipping {
+				order.ShippingTotal = method.Price
+			}
+		}
+	}
+
+	taxes, err := s.taxes.taxLines(tx.Statement.Context, addr, currency, priced, discounts.LineDiscounts, order.ShippingTotal)
+	if err != nil {
+		return nil, err
+	}
+	for i := range order.Items {
+		item := &order.Items[i]
+		item.Discount = discounts.LineDiscounts[i]
+		item.TaxRate = taxes.Lines[i].Rate
+		item.Tax = taxes.Lines[i].Tax
+		item.TaxBreakdown = taxes.Lines[i].Breakdown
+	}
+	order.DiscountTotal = discounts.Total
+	order.FreeShipping = discounts.FreeShipping
+	order.TaxTotal = taxes.Total
+	order.ShippingTax = shippingTax(taxes, priced, currency)
+	order.TotalAmount = s.taxes.total(order.Subtotal, order.DiscountTotal, order.ShippingTotal, order.TaxTotal)
+
+	if err := tx.Create(order).Error; err != nil {
+		return nil, err
+	}
+	if err := reserveOrder(tx, order, time.Now().Add(s.reserveFor)); err != nil {
+		return nil, err
+	}
+	if err := redeemPromotions(tx, order, discounts); err != nil {
+		return nil, err
+	}
+	data := map[string]any{"items": len(order.Items), "total_amount": order.TotalAmount.String()}
+	if order.DiscountTotal.IsPositive() {
+		data["discount_total"] = order.DiscountTotal.String()
+	}
+	if order.TaxTotal.IsPositive() {
+		data["tax_total"] = order.TaxTotal.String()
+	}
+	err = RecordOrderEvent(tx, &models.OrderEvent{
+		OrderID:  order.ID,
+		Type:     models.OrderEventCreated,
+		ToStatus: order.Status,
+		ActorID:  &userID,
+		Data:     data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// lockProducts selects the requested products FOR UPDATE in ID order, so
+// concurrent checkouts of overlapping carts always lock in the same order
+// and can't deadlock. The product lock also covers its variants' stock.
+func lockProducts(tx *gorm.DB, lines []CheckoutLine) (map[uuid.UUID]models.Product, error) {
+	ids := productIDs(lines)
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	var rows []models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Prices").
+		Scopes(WithVariants).
+		Where("id IN ?", ids).
+		Order("id").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	products := make(map[uuid.UUID]models.Product, len(rows))
+	for _, p := range rows {
+		products[p.ID] = p
+	}
+	return products, nil
+}
+
+// checkAvailability reports every line that can't be fulfilled. Lines for
+// the same product or variant are checked against their combined quantity.
+func checkAvailability(lines []CheckoutLine, products map[uuid.UUID]models.Product) []LineError {
+	totals := requestedQuantities(lines)
+
+	var errs []LineError
+	for i, line := range lines {
+		product, ok := products[line.ProductID]
+		variant := lineVariant(product, line)
+		name, stock := product.Name, product.Stock
+		if variant != nil {
+			name, stock = product.Name+" ("+variant.Title(product.Options)+")", variant.Stock
+		}
+		lineErr := LineError{Line: i, ProductID: line.ProductID, VariantID: line.VariantID}
+		switch {
+		case !ok:
+			lineErr.Code, lineErr.Message = LineNotFound, "product does not exist"
+		case !product.IsActive:
+			lineErr.Code, lineErr.Message = LineInactive, product.Name+" is no longer available"
+		case len(product.Variants) > 0 && line.VariantID == nil:
+			lineErr.Code, lineErr.Message = LineVariantRequired, "choose a variant of "+product.Name
+		case line.VariantID != nil && variant == nil:
+			lineErr.Code, lineErr.Message = LineNotFound, "variant does not exist"
+		case variant != nil && !variant.IsActive:
+			lineErr.Code, lineErr.Message = LineInactive, name+" is no longer available"
+		case stock < totals[line.key()]:
+			lineErr.Code = LineOutOfStock
+			lineErr.Message = fmt.Sprintf("only %d of %s in stock", stock, name)
+			lineErr.Requested = totals[line.key()]
+			lineErr.Available = stock
+		default:
+			continue
+		}
+		errs = append(errs, lineErr)
+	}
+	return errs
+}
+
+func requestedQuantities(lines []CheckoutLine) map[stockKey]int {
+	totals := map[stockKey]int{}
+	for _, line := range lines {
+		totals[line.key()] += line.Quantity
+	}
+	return totals
+}
+
+// productIDs lists the distinct products of lines
+func productIDs(lines []CheckoutLine) []uuid.UUID {
+	seen := map[uuid.UUID]bool{}
+	var ids []uuid.UUID
+	for _, line := range lines {
+		if !seen[line.ProductID] {
+			seen[line.ProductID] = true
+			ids = append(ids, line.ProductID)
+		}
+	}
+	return ids
+}
+
+// lineVariant is the variant a line asks for, or nil for lines without one
+// or whose variant isn't the product's
+func lineVariant(product models.Product, line CheckoutLine) *models.ProductVariant {
+	if line.VariantID == nil {
+		return nil
+	}
+	variant, _ := product.Variant(*line.VariantID)
+	return variant
+}
+
+// WithVariants preloads a product's options and variants in display order
+func WithVariants(db *gorm.DB) *gorm.DB {
+	return db.Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("position, name") }).
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("position, sku") })
+}
+
+// priceLines prices each line in currency; products without a price in
+// it are reported as line errors
+func priceLines(lines []CheckoutLine, products map[uuid.UUID]models.Product, currency string, rates *money.Rates) ([]PricedLine, error) {
+	priced := make([]PricedLine, len(lines))
+	var errs []LineError
+	for i, line := range lines {
+		product := products[line.ProductID]
+		unit, err := VariantPriceFor(product, lineVariant(product, line), currency, rates)
+		if err != nil {
+			errs = append(errs, LineError{Line: i, ProductID: line.ProductID, VariantID: line.VariantID, Code: LineNoPrice, Message: product.Name + " can't be bought in " + currency})
+			continue
+		}
+		priced[i] = PricedLine{
+			ProductID:   product.ID,
+			VariantID:   line.VariantID,
+			CategoryID:  product.CategoryID,
+			Category:    product.Category,
+			TaxCategory: product.TaxCategory,
+			UnitPrice:   unit,
+			Quantity:    line.Quantity,
+			LineTotal:   unit.Mul(line.Quantity),
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &CheckoutError{Lines: errs}
+	}
+	return priced, nil
+}
+
+// Quote is a priced order that hasn't been placed
+type Quote struct {
+	Currency  string          `json:"currency"`
+	Lines     []LineQuote     `json:"lines"`
+	Subtotal  money.Money     `json:"subtotal"`
+	Discounts *DiscountResult `json:"discounts"`
+	// ShippingOptions are the methods for the shipping address, if one was
+	// given; ShippingMethod is the chosen one
+	ShippingOptions  []ShippingOption `json:"shipping_options,omitempty"`
+	ShippingMethod   *ShippingOption  `json:"shipping_method,omitempty"`
+	ShippingTotal    money.Money      `json:"shipping_total"`
+	TaxAddress       tax.Address      `json:"tax_address"`
+	PricesIncludeTax bool             `json:"prices_include_tax"`
+	TaxTotal         money.Money      `json:"tax_total"`
+	Total            money.Money      `json:"total"`
+}
+
+// LineQuote is one quoted line
+type LineQuote struct {
+	ProductID    uuid.UUID       `json:"product_id"`
+	VariantID    *uuid.UUID      `json:"variant_id,omitempty"`
+	SKU          string          `json:"sku,omitempty"`
+	Name         string          `json:"name"`
+	VariantName  string          `json:"variant_name,omitempty"`
+	Quantity     int             `json:"quantity"`
+	UnitPrice    money.Money     `json:"unit_price"`
+	LineTotal    money.Money     `json:"line_total"`
+	Discount     money.Money     `json:"discount"`
+	TaxRate      float64         `json:"tax_rate"`
+	Tax          money.Money     `json:"tax"`
+	TaxBreakdown []tax.Component `json:"tax_breakdown"`
+}
+
+// Quote prices lines, promotion codes and shipping the way PlaceOrder
+// would, without locking or writing anything. With an address it lists the
+// shipping options, and prices the chosen method if there is one. userID
+// may be nil for anonymous shoppers, who can only give inline addresses.
+func (s *Checkout) Quote(ctx context.Context, userID *uuid.UUID, lines []CheckoutLine, opts CheckoutOptions) (*Quote, error) {
+	if len(lines) == 0 {
+		return nil, ErrEmptyOrder
+	}
+	currency, err := CheckCurrency(opts.Currency, s.currency)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range lines {
+		if line.Quantity <= 0 {
+			return nil, &CheckoutError{Lines: []LineError{{Line: i, ProductID: line.ProductID, Code: LineInvalidQuantity, Message: "quantity must be positive", Requested: line.Quantity}}}
+		}
+	}
+
+	db := s.db.WithContext(ctx)
+	var rows []models.Product
+	if err := db.Preload("Prices").Scopes(WithVariants).Where("id IN ?", productIDs(lines)).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	products := make(map[uuid.UUID]models.Product, len(rows))
+	for _, p := range rows {
+		products[p.ID] = p
+	}
+	if lineErrs := checkAvailability(lines, products); len(lineErrs) > 0 {
+		return nil, &CheckoutError{Lines: lineErrs}
+	}
+
+	rates, err := LoadRates(db)
+	if err != nil {
+		return nil, err
+	}
+	priced, err := priceLines(lines, products, currency, rates)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &Quote{Currency: currency, Lines: make([]LineQuote, len(lines)), Subtotal: money.Zero(currency)}
+	for i, line := range priced {
+		product := products[line.ProductID]
+		quote.Lines[i] = LineQuote{ProductID: line.ProductID, Name: product.Name, Quantity: line.Quantity, UnitPrice: line.UnitPrice, LineTotal: line.LineTotal}
+		if variant := lineVariant(product, lines[i]); variant != nil {
+			quote.Lines[i].VariantID = &variant.ID
+			quote.Lines[i].SKU = variant.SKU
+			quote.Lines[i].VariantName = variant.Title(product.Options)
+		}
+		quote.Subtotal = quote.Subtotal.Add(line.LineTotal)
+	}
+
+	discounts, err := ApplyPromotions(db, userID, opts.PromoCodes, priced, currency, rates, false)
+	if err != nil {
+		return nil, err
+	}
+	ship, err := shippingAddress(db, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+	quote.ShippingTotal = money.Zero(currency)
+	if ship != nil {
+		parcel := parcelFor(priced, products, quote.Subtotal.Sub(discounts.Total))
+		if quote.ShippingOptions, err = ShippingOptions(db, *ship, parcel, currency, rates); err != nil {
+			return nil, err
+		}
+		if opts.ShippingMethodID != nil {
+			if quote.ShippingMethod, err = pickShipping(quote.ShippingOptions, opts.ShippingMethodID); err != nil {
+				return nil, err
+			}
+			if !discounts.FreeShipping {
+				quote.ShippingTotal = quote.ShippingMethod.Price
+			}
+		}
+	} else if opts.ShippingMethodID != nil {
+		return nil, ErrAddressRequired
+	}
+
+	addr := s.taxes.address(ship, opts)
+	taxes, err := s.taxes.taxLines(ctx, addr, currency, priced, discounts.LineDiscounts, quote.ShippingTotal)
+	if err != nil {
+		return nil, err
+	}
+	for i := range quote.Lines {
+		line := &quote.Lines[i]
+		line.Discount = discounts.LineDiscounts[i]
+		line.TaxRate = taxes.Lines[i].Rate
+		line.Tax = taxes.Lines[i].Tax
+		line.TaxBreakdown = taxes.Lines[i].Breakdown
+	}
+	quote.Discounts = discounts
+	quote.TaxAddress = addr
+	quote.PricesIncludeTax = s.taxes.PricesIncludeTax
+	quote.TaxTotal = taxes.Total
+	quote.Total = s.taxes.total(quote.Subtotal, discounts.Total, quote.ShippingTotal, taxes.Total)
+	return quote, nil
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LineNoPrice is the line error for products that can't be priced in the
+// requested currency
+const LineNoPrice = "price_unavailable"
+
+// ErrUnsupportedCurrency is returned for malformed currency codes
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// LoadRates reads the exchange rate table
+func LoadRates(db *gorm.DB) (*money.Rates, error) {
+	var rows []models.ExchangeRate
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	rates := money.NewRates()
+	for _, r := range rows {
+		rates.Set(r.Base, r.Quote, r.Rate)
+	}
+	return rates, nil
+}
+
+// PriceFor returns the product's price in currency: its price list entry
+// when there is one (Prices must be loaded), otherwise the base price
+// converted at the current rate
+func PriceFor(p models.Product, currency string, rates *money.Rates) (money.Money, error) {
+	for _, entry := range p.Prices {
+		if entry.Price.Currency == currency {
+			return entry.Price, nil
+		}
+	}
+	return rates.Convert(p.Price, currency)
+}
+
+// VariantPriceFor is PriceFor for a variant: its own price converted to
+// currency when it overrides the product's, the product's price otherwise
+func VariantPriceFor(p models.Product, v *models.ProductVariant, currency string, rates *money.Rates) (money.Money, error) {
+	if v == nil || v.Price.Currency == "" {
+		return PriceFor(p, currency, rates)
+	}
+	return rates.Convert(v.Price, currency)
+}
+
+// CheckCurrency normalizes a currency code, falling back to def when empty
+func CheckCurrency(code, def string) (string, error) {
+	code = money.NormalizeCurrency(code)
+	if code == "" {
+		code = def
+	}
+	if !money.ValidCurrency(code) {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedCurrency, code)
+	}
+	return code, nil
+}
+
+// Currencies manages the exchange rate table
+type Currencies struct {
+	db   *gorm.DB
+	base string
+}
+
+func NewCurrencies(db *gorm.DB, base string) *Currencies {
+	return &Currencies{db: db, base: base}
+}
+
+// Base is the shop's base currency
+func (s *Currencies) Base() string {
+	return s.base
+}
+
+// Rates lists the stored rates
+func (s *Currencies) Rates(ctx context.Context) ([]models.ExchangeRate, error) {
+	rates := []models.ExchangeRate{}
+	err := s.db.WithContext(ctx).Order("base, quote").Find(&rates).Error
+	return rates, err
+}
+
+// Convert converts m to currency at the stored rates
+func (s *Currencies) Convert(ctx context.Context, m money.Money, to string) (money.Money, error) {
+	rates, err := LoadRates(s.db.WithContext(ctx))
+	if err != nil {
+		return money.Money{}, err
+	}
+	return rates.Convert(m, to)
+}
+
+// Import upserts rates by currency pair and returns how many were stored
+func (s *Currencies) Import(ctx context.Context, rates []models.ExchangeRate) (int, error) {
+	if len(rates) == 0 {
+		return 0, nil
+	}
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "base"}, {Name: "quote"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "source", "effective_at", "updated_at"}),
+	}).Create(&rates).Error
+	if err != nil {
+		return 0, err
+	}
+	return len(rates), nil
+}
+
+// ImportFile imports a CSV or JSON rate file, picking the format from the
+// file extension
+func (s *Currencies) ImportFile(ctx context.Context, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	format := "json"
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		format = "csv"
+	}
+	rates, err := ParseRates(f, format, filepath.Base(path))
+	if err != nil {
+		return 0, err
+	}
+	return s.Import(ctx, rates)
+}
+
+// ParseRates reads exchange rates in one of two formats:
+//
+//	csv:  base,quote,rate[,effective_at] rows, with an optional header
+//	json: {"base": "USD", "date": "2024-05-01", "rates": {"EUR": 0.92}}
+//	      or a list of {"base", "quote", "rate"} objects
+func ParseRates(r io.Reader, format, source string) ([]models.ExchangeRate, error) {
+	var rates []models.ExchangeRate
+	now := time.Now()
+
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, rec := range records {
+			if len(rec) < 3 {
+				return nil, fmt.Errorf("rates: row %d: want base,quote,rate", i+1)
+			}
+			if i == 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "base") {
+				continue
+			}
+			rate, err := strconv.ParseFloat(strings.TrimSpace(rec[2]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("rates: row %d: invalid rate %q", i+1, rec[2])
+			}
+			effective := now
+			if len(rec) > 3 && strings.TrimSpace(rec[3]) != "" {
+				if effective, err = parseRateDate(rec[3]); err != nil {
+					return nil, fmt.Errorf("rates: row %d: %w", i+1, err)
+				}
+			}
+			rates = append(rates, models.ExchangeRate{Base: rec[0], Quote: rec[1], Rate: rate, EffectiveAt: effective})
+		}
+
+	case "json":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		var table struct {
+			Base  string             `json:"base"`
+			Date  string             `json:"date"`
+			Rates map[string]float64 `json:"rates"`
+		}
+		if err := json.Unmarshal(data, &rates); err != nil {
+			if err := json.Unmarshal(data, &table); err != nil {
+				return nil, fmt.Errorf("rates: invalid JSON: %w", err)
+			}
+			effective := now
+			if table.Date != "" {
+				if effective, err = parseRateDate(table.Date); err != nil {
+					return nil, fmt.Errorf("rates: %w", err)
+				}
+			}
+			for quote, rate := range table.Rates {
+				rates = append(rates, models.ExchangeRate{Base: table.Base, Quote: quote, Rate: rate, EffectiveAt: effective})
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("rates: unknown format %q", format)
+	}
+
+	for i := range rates {
+		rate := &rates[i]
+		rate.Base = money.NormalizeCurrency(rate.Base)
+		rate.Quote = money.NormalizeCurrency(rate.Quote)
+		if !money.ValidCurrency(rate.Base) || !money.ValidCurrency(rate.Quote) || rate.Base == rate.Quote {
+			return nil, fmt.Errorf("rates: invalid currency pair %s/%s", rate.Base, rate.Quote)
+		}
+		if rate.Rate <= 0 {
+			return nil, fmt.Errorf("rates: %s/%s rate must be positive", rate.Base, rate.Quote)
+		}
+		if rate.EffectiveAt.IsZero() {
+			rate.EffectiveAt = now
+		}
+		rate.Source = source
+	}
+	return rates, nil
+}
+
+func parseRateDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// idempotencyLockTimeout is how long a claimed key may go without a
+// response before another request may take it over, e.g. after a crash
+const idempotencyLockTimeout = time.Minute
+
+var (
+	// ErrIdempotencyMismatch is returned when a key is reused for a
+	// different request
+	ErrIdempotencyMismatch = errors.New("idempotency key was used with a different request")
+	// ErrIdempotencyInProgress is returned while the first request with a
+	// key is still running
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// IdempotencyKeys remembers the response to each Idempotency-Key so a
+// retried request gets the same answer instead of running twice
+type IdempotencyKeys struct {
+	db      *gorm.DB
+	ttl     time.Duration
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewIdempotencyKeys keeps keys and their responses for ttl
+func NewIdempotencyKeys(db *gorm.DB, ttl time.Duration) *IdempotencyKeys {
+	return &IdempotencyKeys{db: db, ttl: ttl}
+}
+
+// Claim reserves key in scope for a request with fingerprint. It returns
+// nil with a nil error when the caller should run the request and then
+// Complete or Release the key; the stored key when there is a response to
+// replay; or ErrIdempotencyMismatch / ErrIdempotencyInProgress. The unique
+// index on scope and key makes concurrent duplicates race for one row, so
+// only one of them runs.
+func (s *IdempotencyKeys) Claim(ctx context.Context, scope, key, method, path, fingerprint string) (*models.IdempotencyKey, error) {
+	db := s.db.WithContext(ctx)
+	// Expired or abandoned claims are cleared and the claim retried once
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now()
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.IdempotencyKey{
+			Scope:       scope,
+			Key:         key,
+			Method:      method,
+			Path:        path,
+			Fingerprint: fingerprint,
+			ExpiresAt:   now.Add(s.ttl),
+		})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return nil, nil
+		}
+
+		var stored models.IdempotencyKey
+		err := db.Where("scope = ? AND key = ?", scope, key).First(&stored).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Released between our insert and this read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stale := stored.ExpiresAt.Before(now) ||
+			(stored.ResponseStatus == 0 && stored.UpdatedAt.Before(now.Add(-idempotencyLockTimeout)))
+		switch {
+		case stale:
+			if err := db.Where("id = ? AND updated_at = ?", stored.ID, stored.UpdatedAt).Delete(&models.IdempotencyKey{}).Error; err != nil {
+				return nil, err
+			}
+		case stored.Fingerprint != fingerprint:
+			return nil, ErrIdempotencyMismatch
+		case stored.ResponseStatus == 0:
+			return nil, ErrIdempotencyInProgress
+		default:
+			return &stored, nil
+		}
+	}
+	return nil, ErrIdempotencyInProgress
+}
+
+// Complete stores the response for a claimed key
+func (s *IdempotencyKeys) Complete(ctx context.Context, scope, key string, status int, contentType string, body []byte) error {
+	return s.db.WithContext(ctx).Model(&models.IdempotencyKey{}).
+		Where("scope = ? AND key = ?", scope, key).
+		Updates(map[string]any{
+			"response_status":       status,
+			"response_content_type": contentType,
+			"response_body":         body,
+			"updated_at":            time.Now(),
+		}).Error
+}
+
+// Release forgets a claimed key so the request can be retried, used when
+// it failed in a way a retry might fix
+func (s *IdempotencyKeys) Release(ctx context.Context, scope, key string) error {
+	return s.db.WithContext(ctx).
+		Where("scope = ? AND key = ? AND response_status = 0", scope, key).
+		Delete(&models.IdempotencyKey{}).Error
+}
+
+// Sweep deletes expired keys and returns how many were removed
+func (s *IdempotencyKeys) Sweep(ctx context.Context) (int64, error) {
+	res := s.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{})
+	return res.RowsAffected, res.Error
+}
+
+// StartSweeper runs Sweep every interval until StopSweeper is called
+func (s *IdempotencyKeys) StartSweeper(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := s.Sweep(context.Background()); err != nil {
+					log.Printf("ERROR: failed to sweep idempotency keys - %v", err)
+				} else if n > 0 {
+					log.Printf("INFO: swept %d expired idempotency keys", n)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopSweeper ends the sweep loop
+func (s *IdempotencyKeys) StopSweeper() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.stopped
+	s.stop = nil
+}
@@ -0,0 +1,839 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrWarehouseNotFound is returned for unknown or inactive warehouses
+	ErrWarehouseNotFound = errors.New("warehouse not found")
+	// ErrNoWarehouse is returned when stock has nowhere to go because no
+	// warehouse is active
+	ErrNoWarehouse = errors.New("no active warehouse")
+	// ErrInvalidMovement is returned for bad stock movements
+	ErrInvalidMovement = errors.New("invalid stock movement")
+	// ErrInsufficientStock is returned when a warehouse doesn't have the
+	// units a movement takes out
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// StockChange is a stock movement made by staff: a receipt of Quantity
+// units, an adjustment by Quantity (or to a counted OnHand), or a transfer
+// of Quantity units to ToWarehouseID. Receipts and adjustments without a
+// WarehouseID go to the default warehouse. Sales and returns are recorded
+// by checkout and the returns process.
+type StockChange struct {
+	Type          string     `json:"type"`
+	WarehouseID   uuid.UUID  `json:"warehouse_id"`
+	ToWarehouseID *uuid.UUID `json:"to_warehouse_id"`
+	ProductID     uuid.UUID  `json:"product_id"`
+	VariantID     *uuid.UUID `json:"variant_id"`
+	Quantity      int        `json:"quantity"`
+	OnHand        *int       `json:"on_hand"`
+	Note          string     `json:"note"`
+	ActorID       *uuid.UUID `json:"-"`
+}
+
+// Inventory keeps stock per warehouse through the stock movements ledger,
+// expires stale checkout reservations and reports low stock
+type Inventory struct {
+	db            *gorm.DB
+	lowStockHooks []func(models.StockAlert)
+	stop          chan struct{}
+	stopped       chan struct{}
+}
+
+func NewInventory(db *gorm.DB) *Inventory {
+	return &Inventory{db: db}
+}
+
+// OnLowStock registers fn to be called once for every new low stock alert
+func (s *Inventory) OnLowStock(fn func(models.StockAlert)) {
+	s.lowStockHooks = append(s.lowStockHooks, fn)
+}
+
+// Move records a receipt, adjustment or transfer and returns its ledger
+// entries
+func (s *Inventory) Move(ctx context.Context, change StockChange) ([]models.StockMovement, error) {
+	var movements []models.StockMovement
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		movements, err = MoveStockTx(tx, change)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// MoveStockTx is Move inside the caller's transaction
+func MoveStockTx(tx *gorm.DB, change StockChange) ([]models.StockMovement, error) {
+	key := stockKey{ProductID: change.ProductID}
+	if change.VariantID != nil {
+		key.VariantID = *change.VariantID
+	}
+	if err := checkStockItem(tx, key); err != nil {
+		return nil, err
+	}
+	if err := lockProductRows(tx, []uuid.UUID{change.ProductID}); err != nil {
+		return nil, err
+	}
+
+	from := change.WarehouseID
+	if from == uuid.Nil && change.Type != models.StockTransfer {
+		w, err := defaultWarehouse(tx)
+		if err != nil {
+			return nil, err
+		}
+		from = w.ID
+	} else if err := checkWarehouse(tx, from); err != nil {
+		return nil, err
+	}
+
+	movement := models.StockMovement{
+		WarehouseID: from,
+		ProductID:   change.ProductID,
+		VariantID:   change.VariantID,
+		Type:        change.Type,
+		Quantity:    change.Quantity,
+		ActorID:     change.ActorID,
+		Note:        change.Note,
+	}
+	var movements []models.StockMovement
+	switch change.Type {
+	case models.StockReceipt:
+		if change.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: receipts need a positive quantity", ErrInvalidMovement)
+		}
+		if _, err := moveStock(tx, &movement); err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+
+	case models.StockAdjustment:
+		level, err := lockLevel(tx, from, key)
+		if err != nil {
+			return nil, err
+		}
+		if change.OnHand != nil {
+			if *change.OnHand < 0 {
+				return nil, fmt.Errorf("%w: on hand can't be negative", ErrInvalidMovement)
+			}
+			movement.Quantity = *change.OnHand - level.OnHand
+		}
+		if movement.Quantity == 0 {
+			return nil, fmt.Errorf("%w: the adjustment doesn't change stock", ErrInvalidMovement)
+		}
+		if level.OnHand+movement.Quantity < 0 {
+			return nil, fmt.Errorf("%w: only %d on hand", ErrInsufficientStock, level.OnHand)
+		}
+		if _, err := moveStock(tx, &movement); err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+
+	case models.StockTransfer:
+		if change.ToWarehouseID == nil || *change.ToWarehouseID == from {
+			return nil, fmt.Errorf("%w: transfers need a different destination warehouse", ErrInvalidMovement)
+		}
+		if err := checkWarehouse(tx, *change.ToWarehouseID); err != nil {
+			return nil, err
+		}
+		if change.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: transfers need a positive quantity", ErrInvalidMovement)
+		}
+		level, err := lockLevel(tx, from, key)
+		if err != nil {
+			return nil, err
+		}
+		// Reserved units are promised to orders and stay where they are
+		if available := level.OnHand - level.Reserved; available < change.Quantity {
+			return nil, fmt.Errorf("%w: only %d available to transfer", ErrInsufficientStock, max(available, 0))
+		}
+		transferID := uuid.New()
+		out, in := movement, movement
+		out.Quantity, out.TransferID = -change.Quantity, &transferID
+		in.WarehouseID, in.TransferID = *change.ToWarehouseID, &transferID
+		if _, err := moveStock(tx, &out); err != nil {
+			return nil, err
+		}
+		if _, err := moveStock(tx, &in); err != nil {
+			return nil, err
+		}
+		movements = append(movements, out, in)
+
+	default:
+		return nil, fmt.Errorf("%w: type must be receipt, adjustment or transfer", ErrInvalidMovement)
+	}
+
+	if err := syncStock(tx, change.ProductID); err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// SetThreshold sets the low stock threshold of an item in a warehouse;
+// zero turns alerts off
+func (s *Inventory) SetThreshold(ctx context.Context, warehouseID, productID uuid.UUID, variantID *uuid.UUID, threshold int) (*models.InventoryLevel, error) {
+	if threshold < 0 {
+		return nil, fmt.Errorf("%w: the threshold can't be negative", ErrInvalidMovement)
+	}
+	key := stockKey{ProductID: productID}
+	if variantID != nil {
+		key.VariantID = *variantID
+	}
+	var level *models.InventoryLevel
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkWarehouse(tx, warehouseID); err != nil {
+			return err
+		}
+		if err := checkStockItem(tx, key); err != nil {
+			return err
+		}
+		var err error
+		if level, err = lockLevel(tx, warehouseID, key); err != nil {
+			return err
+		}
+		level.LowStockThreshold = threshold
+		if err := tx.Model(level).Update("low_stock_threshold", threshold).Error; err != nil {
+			return err
+		}
+		return checkLowStock(tx, level)
+	})
+	if err != nil {
+		return nil, err
+	}
+	level.Available = level.OnHand - level.Reserved
+	return level, nil
+}
+
+// SyncWarehouse recounts Product.Stock and ProductVariant.Stock for
+// everything stocked in a warehouse, e.g. after it was (de)activated
+func (s *Inventory) SyncWarehouse(ctx context.Context, warehouseID uuid.UUID) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var productIDs []uuid.UUID
+		if err := tx.Model(&models.InventoryLevel{}).Where("warehouse_id = ?", warehouseID).
+			Distinct().Pluck("product_id", &productIDs).Error; err != nil {
+			return err
+		}
+		if len(productIDs) == 0 {
+			return nil
+		}
+		if err := lockProductRows(tx, productIDs); err != nil {
+			return err
+		}
+		return syncStock(tx, productIDs...)
+	})
+}
+
+// ExpireReservations gives the stock held by reservations past their
+// expiry back, and returns how many orders it released
+func (s *Inventory) ExpireReservations(ctx context.Context) (int, error) {
+	db := s.db.WithContext(ctx)
+	var orderIDs []uuid.UUID
+	if err := db.Model(&models.StockReservation{}).
+		Where("status = ? AND expires_at <= ?", models.ReservationActive, time.Now()).
+		Distinct().Pluck("order_id", &orderIDs).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, orderID := range orderIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Lock the order first, like status transitions do, so a
+			// payment arriving now either commits the reservations or
+			// finds them expired. Orders in the trash still hold stock until
+			// their reservations expire.
+			var order models.Order
+			if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+				return err
+			}
+			released, err := releaseReservations(tx, orderID, models.ReservationExpired, time.Now())
+			if err != nil || len(released) == 0 {
+				return err
+			}
+			expired++
+			return RecordOrderEvent(tx, &models.OrderEvent{
+				OrderID: orderID,
+				Type:    models.OrderEventStock,
+				Note:    "Stock reservation expired",
+				Data:    map[string]any{"released": released},
+			})
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// NotifyLowStock passes alerts no hook has seen yet to the OnLowStock
+// hooks and returns how many there were
+func (s *Inventory) NotifyLowStock(ctx context.Context) (int, error) {
+	db := s.db.WithContext(ctx)
+	var alerts []models.StockAlert
+	if err := db.Where("notified_at IS NULL").Order("created_at").Limit(500).Find(&alerts).Error; err != nil {
+		return 0, err
+	}
+	for _, alert := range alerts {
+		for _, fn := range s.lowStockHooks {
+			fn(alert)
+		}
+		if err := db.Model(&alert).Update("notified_at", time.Now()).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(alerts), nil
+}
+
+// StartSweeper expires reservations and sends low stock notifications
+// every interval until StopSweeper is called
+func (s *Inventory) StartSweeper(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := s.ExpireReservations(context.Background()); err != nil {
+					log.Printf("ERROR: failed to expire stock reservations - %v", err)
+				} else if n > 0 {
+					log.Printf("INFO: released expired stock reservations of %d orders", n)
+				}
+				if _, err := s.NotifyLowStock(context.Background()); err != nil {
+					log.Printf("ERROR: failed to send low stock notifications - %v", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopSweeper ends the sweep loop
+func (s *Inventory) StopSweeper() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.stopped
+	s.stop = nil
+}
+
+// reserveOrder holds stock for every item of a new order until expiresAt,
+// taking it from active warehouses in priority order. Checkout has
+// already locked the products and checked Product.Stock, which is what
+// the warehouses have available.
+func reserveOrder(tx *gorm.DB, order *models.Order, expiresAt time.Time) error {
+	var productIDs []uuid.UUID
+	for _, item := range order.Items {
+		key := itemKey(item)
+		allocations, err := allocate(tx, key, item.Quantity, false)
+		if err != nil {
+			return err
+		}
+		for _, a := range allocations {
+			if err := reserveLevel(tx, a.level, a.quantity); err != nil {
+				return err
+			}
+			if err := checkLowStock(tx, a.level); err != nil {
+				return err
+			}
+			err := tx.Create(&models.StockReservation{
+				OrderID:     order.ID,
+				OrderItemID: item.ID,
+				WarehouseID: a.level.WarehouseID,
+				ProductID:   item.ProductID,
+				VariantID:   item.VariantID,
+				Quantity:    a.quantity,
+				Status:      models.ReservationActive,
+				ExpiresAt:   expiresAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		productIDs = append(productIDs, item.ProductID)
+	}
+	return syncStock(tx, productIDs...)
+}
+
+// commitOrder turns a paid order's reservations into sales. Units whose
+// reservation expired before the payment arrived are taken from whatever
+// is available, and the first warehouse goes negative for any shortfall;
+// the returned quantities per product or variant were oversold. Orders
+// without any reservations predate warehouses and had their stock taken
+// at checkout.
+func commitOrder(tx *gorm.DB, order *models.Order) (map[string]int, error) {
+	var reservations []models.StockReservation
+	if err := tx.Where("order_id = ?", order.ID).Order("created_at").Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+	if len(reservations) == 0 {
+		return nil, nil
+	}
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	if err := lockProductRows(tx, orderProductIDs(items)); err != nil {
+		return nil, err
+	}
+
+	committed := map[uuid.UUID]int{}
+	for i := range reservations {
+		r := &reservations[i]
+		if r.Status != models.ReservationActive {
+			continue
+		}
+		level, err := lockLevel(tx, r.WarehouseID, reservationKey(*r))
+		if err != nil {
+			return nil, err
+		}
+		if err := reserveLevel(tx, level, -r.Quantity); err != nil {
+			return nil, err
+		}
+		if _, err := moveStock(tx, &models.StockMovement{
+			WarehouseID: r.WarehouseID,
+			ProductID:   r.ProductID,
+			VariantID:   r.VariantID,
+			Type:        models.StockSale,
+			Quantity:    -r.Quantity,
+			OrderID:     &order.ID,
+		}); err != nil {
+			return nil, err
+		}
+		if err := tx.Model(r).Update("status", models.ReservationCommitted).Error; err != nil {
+			return nil, err
+		}
+		committed[r.OrderItemID] += r.Quantity
+	}
+
+	oversold := map[string]int{}
+	for _, item := range items {
+		missing := item.Quantity - committed[item.ID]
+		if missing <= 0 {
+			continue
+		}
+		allocations, err := allocate(tx, itemKey(item), missing, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range allocations {
+			if a.level.OnHand-a.level.Reserved < a.quantity {
+				oversold[stockRef(item.ProductID, item.VariantID)] += a.quantity - max(a.level.OnHand-a.level.Reserved, 0)
+			}
+			if _, err := moveStock(tx, &models.StockMovement{
+				WarehouseID: a.level.WarehouseID,
+				ProductID:   item.ProductID,
+				VariantID:   item.VariantID,
+				Type:        models.StockSale,
+				Quantity:    -a.quantity,
+				OrderID:     &order.ID,
+			}); err != nil {
+				return nil, err
+			}
+			err := tx.Create(&models.StockReservation{
+				OrderID:     order.ID,
+				OrderItemID: item.ID,
+				WarehouseID: a.level.WarehouseID,
+				ProductID:   item.ProductID,
+				VariantID:   item.VariantID,
+				Quantity:    a.quantity,
+				Status:      models.ReservationCommitted,
+				ExpiresAt:   time.Now(),
+			}).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return oversold, syncStock(tx, orderProductIDs(items)...)
+}
+
+// restockOrder gives a cancelled or refunded order's stock back: active
+// reservations are released and sold units go back to the warehouses they
+// left. It returns the quantities per product or variant that went back
+// on hand. Orders from before warehouses are restocked into the default
+// warehouse.
+func restockOrder(tx *gorm.DB, order *models.Order) (map[string]int, error) {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	if err := lockProductRows(tx, orderProductIDs(items)); err != nil {
+		return nil, err
+	}
+	var reservations []models.StockReservation
+	if err := tx.Where("order_id = ?", order.ID).Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+
+	restocked := map[string]int{}
+	if len(reservations) == 0 {
+		for _, item := range items {
+			if err := restock(tx, item.ProductID, item.VariantID, item.Quantity, nil, &models.StockMovement{
+				Type:    models.StockCancellation,
+				OrderID: &order.ID,
+			}); err != nil {
+				return nil, err
+			}
+			restocked[stockRef(item.ProductID, item.VariantID)] += item.Quantity
+		}
+		return restocked, syncStock(tx, orderProductIDs(items)...)
+	}
+
+	if _, err := releaseReservations(tx, order.ID, models.ReservationReleased, time.Time{}); err != nil {
+		return nil, err
+	}
+	for _, r := range reservations {
+		if r.Status != models.ReservationCommitted {
+			continue
+		}
+		if _, err := moveStock(tx, &models.StockMovement{
+			WarehouseID: r.WarehouseID,
+			ProductID:   r.ProductID,
+			VariantID:   r.VariantID,
+			Type:        models.StockCancellation,
+			Quantity:    r.Quantity,
+			OrderID:     &order.ID,
+		}); err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&r).Update("status", models.ReservationReleased).Error; err != nil {
+			return nil, err
+		}
+		restocked[stockRef(r.ProductID, r.VariantID)] += r.Quantity
+	}
+	return restocked, syncStock(tx, orderProductIDs(items)...)
+}
+
+// releaseReservations ends an order's active reservations with status,
+// only those expired by before unless it is zero, and returns the
+// quantities released per product or variant
+func releaseReservations(tx *gorm.DB, orderID uuid.UUID, status string, before time.Time) (map[string]int, error) {
+	query := tx.Where("order_id = ? AND status = ?", orderID, models.ReservationActive)
+	if !before.IsZero() {
+		query = query.Where("expires_at <= ?", before)
+	}
+	var reservations []models.StockReservation
+	if err := query.Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+	if len(reservations) == 0 {
+		return nil, nil
+	}
+
+	var productIDs []uuid.UUID
+	for _, r := range reservations {
+		productIDs = append(productIDs, r.ProductID)
+	}
+	if err := lockProductRows(tx, productIDs); err != nil {
+		return nil, err
+	}
+	released := map[string]int{}
+	for i := range reservations {
+		r := &reservations[i]
+		level, err := lockLevel(tx, r.WarehouseID, reservationKey(*r))
+		if err != nil {
+			return nil, err
+		}
+		if err := reserveLevel(tx, level, -r.Quantity); err != nil {
+			return nil, err
+		}
+		if err := checkLowStock(tx, level); err != nil {
+			return nil, err
+		}
+		if err := tx.Model(r).Update("status", status).Error; err != nil {
+			return nil, err
+		}
+		released[stockRef(r.ProductID, r.VariantID)] += r.Quantity
+	}
+	return released, syncStock(tx, productIDs...)
+}
+
+// restock puts quantity units back on hand in warehouseID, or the default
+// warehouse when it is nil, recording movement with the item filled in
+func restock(tx *gorm.DB, productID uuid.UUID, variantID *uuid.UUID, quantity int, warehouseID *uuid.UUID, movement *models.StockMovement) error {
+	if warehouseID == nil {
+		w, err := defaultWarehouse(tx)
+		if err != nil {
+			return err
+		}
+		warehouseID = &w.ID
+	} else if err := checkWarehouse(tx, *warehouseID); err != nil {
+		return err
+	}
+	movement.WarehouseID = *warehouseID
+	movement.ProductID = productID
+	movement.VariantID = variantID
+	movement.Quantity = quantity
+	_, err := moveStock(tx, movement)
+	return err
+}
+
+// allocation is part of a quantity taken from one warehouse
+type allocation struct {
+	level    *models.InventoryLevel
+	quantity int
+}
+
+// allocate splits quantity of an item across active warehouses in priority
+// order, using what each has available. With short, what no warehouse has
+// is put on the first one; otherwise it is an ErrInsufficientStock.
+func allocate(tx *gorm.DB, key stockKey, quantity int, short bool) ([]allocation, error) {
+	var warehouses []models.Warehouse
+	if err := tx.Where("is_active = ?", true).Order("priority, code").Find(&warehouses).Error; err != nil {
+		return nil, err
+	}
+	if len(warehouses) == 0 {
+		return nil, ErrNoWarehouse
+	}
+	var levels []models.InventoryLevel
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND variant_id = ?", key.ProductID, key.VariantID).
+		Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	byWarehouse := map[uuid.UUID]*models.InventoryLevel{}
+	for i := range levels {
+		byWarehouse[levels[i].WarehouseID] = &levels[i]
+	}
+
+	var allocations []allocation
+	remaining := quantity
+	for _, w := range warehouses {
+		level, ok := byWarehouse[w.ID]
+		if !ok {
+			continue
+		}
+		take := min(max(level.OnHand-level.Reserved, 0), remaining)
+		if take == 0 {
+			continue
+		}
+		allocations = append(allocations, allocation{level: level, quantity: take})
+		remaining -= take
+		if remaining == 0 {
+			return allocations, nil
+		}
+	}
+	if !short {
+		return nil, fmt.Errorf("%w: %d units of product %s missing across warehouses", ErrInsufficientStock, remaining, key.ProductID)
+	}
+
+	first := warehouses[0].ID
+	for i := range allocations {
+		if allocations[i].level.WarehouseID == first {
+			allocations[i].quantity += remaining
+			return allocations, nil
+		}
+	}
+	level, err := lockLevel(tx, first, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(allocations, allocation{level: level, quantity: remaining}), nil
+}
+
+// lockLevel returns the inventory level of an item in a warehouse locked
+// for update, creating it empty when the item was never stocked there
+func lockLevel(tx *gorm.DB, warehouseID uuid.UUID, key stockKey) (*models.InventoryLevel, error) {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.InventoryLevel{
+		WarehouseID: warehouseID,
+		ProductID:   key.ProductID,
+		VariantID:   key.VariantID,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	var level models.InventoryLevel
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("warehouse_id = ? AND product_id = ? AND variant_id = ?", warehouseID, key.ProductID, key.VariantID).
+		First(&level).Error; err != nil {
+		return nil, err
+	}
+	return &level, nil
+}
+
+// moveStock applies a movement to its inventory level and appends it to
+// the ledger. Callers check that the result makes sense and sync the
+// product's stock afterwards.
+func moveStock(tx *gorm.DB, m *models.StockMovement) (*models.InventoryLevel, error) {
+	key := stockKey{ProductID: m.ProductID}
+	if m.VariantID != nil {
+		key.VariantID = *m.VariantID
+	}
+	level, err := lockLevel(tx, m.WarehouseID, key)
+	if err != nil {
+		return nil, err
+	}
+	level.OnHand += m.Quantity
+	if err := tx.Model(level).UpdateColumn("on_hand", level.OnHand).Error; err != nil {
+		return nil, err
+	}
+	m.OnHandAfter = level.OnHand
+	if err := tx.Create(m).Error; err != nil {
+		return nil, err
+	}
+	return level, checkLowStock(tx, level)
+}
+
+// reserveLevel holds (or, with a negative quantity, gives back) units of a
+// locked level. Callers check for low stock once the level has its final
+// counts, so committing a sale doesn't resolve and re-raise an alert.
+func reserveLevel(tx *gorm.DB, level *models.InventoryLevel, quantity int) error {
+	level.Reserved = max(level.Reserved+quantity, 0)
+	return tx.Model(level).UpdateColumn("reserved", level.Reserved).Error
+}
+
+// checkLowStock raises an alert when a level's available stock is at or
+// below its threshold and none is open, and resolves the open one once it
+// is back above
+func checkLowStock(tx *gorm.DB, level *models.InventoryLevel) error {
+	var variantID *uuid.UUID
+	if level.VariantID != uuid.Nil {
+		variantID = &level.VariantID
+	}
+	var open models.StockAlert
+	err := tx.Where("warehouse_id = ? AND product_id = ? AND resolved_at IS NULL", level.WarehouseID, level.ProductID).
+		Where(variantCondition(variantID)).
+		First(&open).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	hasOpen := err == nil
+
+	available := level.OnHand - level.Reserved
+	low := level.LowStockThreshold > 0 && available <= level.LowStockThreshold
+	switch {
+	case low && !hasOpen:
+		return tx.Create(&models.StockAlert{
+			WarehouseID: level.WarehouseID,
+			ProductID:   level.ProductID,
+			VariantID:   variantID,
+			Available:   available,
+			Threshold:   level.LowStockThreshold,
+		}).Error
+	case !low && hasOpen:
+		return tx.Model(&open).Update("resolved_at", time.Now()).Error
+	}
+	return nil
+}
+
+// syncStock recounts Product.Stock and ProductVariant.Stock of products
+// from what their active warehouses have available. Callers hold the
+// product row locks.
+func syncStock(tx *gorm.DB, productIDs ...uuid.UUID) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	const available = `COALESCE((SELECT SUM(GREATEST(l.on_hand - l.reserved, 0))
+		FROM inventory_levels l JOIN warehouses w ON w.id = l.warehouse_id
+		WHERE w.is_active AND l.product_id = %s AND l.variant_id = %s), 0)`
+	if err := tx.Exec(fmt.Sprintf("UPDATE products p SET stock = "+available+" WHERE p.id IN ?", "p.id", "?"),
+		uuid.Nil, productIDs).Error; err != nil {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf("UPDATE product_variants v SET stock = "+available+" WHERE v.product_id IN ?", "v.product_id", "v.id"),
+		productIDs).Error
+}
+
+// lockProductRows locks products in ID order, like checkout does, so stock
+// changes for the same product never interleave
+func lockProductRows(tx *gorm.DB, ids []uuid.UUID) error {
+	ids = append([]uuid.UUID(nil), ids...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	var locked []uuid.UUID
+	return tx.Model(&models.Product{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", ids).Order("id").Pluck("id", &locked).Error
+}
+
+// checkStockItem checks that the product, and the variant when given,
+// exist and belong together
+func checkStockItem(tx *gorm.DB, key stockKey) error {
+	var count int64
+	query := tx.Model(&models.Product{}).Where("id = ?", key.ProductID)
+	if key.VariantID != uuid.Nil {
+		query = tx.Model(&models.ProductVariant{}).Where("id = ? AND product_id = ?", key.VariantID, key.ProductID)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: unknown product or variant", ErrInvalidMovement)
+	}
+	return nil
+}
+
+// checkWarehouse checks that a warehouse exists and is active
+func checkWarehouse(tx *gorm.DB, id uuid.UUID) error {
+	var count int64
+	if err := tx.Model(&models.Warehouse{}).Where("id = ? AND is_active = ?", id, true).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrWarehouseNotFound
+	}
+	return nil
+}
+
+// defaultWarehouse is the active warehouse with the highest priority
+func defaultWarehouse(tx *gorm.DB) (*models.Warehouse, error) {
+	var w models.Warehouse
+	if err := tx.Where("is_active = ?", true).Order("priority, code").First(&w).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoWarehouse
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+func itemKey(item models.OrderItem) stockKey {
+	return CheckoutLine{ProductID: item.ProductID, VariantID: item.VariantID}.key()
+}
+
+func reservationKey(r models.StockReservation) stockKey {
+	return CheckoutLine{ProductID: r.ProductID, VariantID: r.VariantID}.key()
+}
+
+// stockRef is the ID restock and release summaries are keyed by: the
+// variant's, or the product's for products without variants
+func stockRef(productID uuid.UUID, variantID *uuid.UUID) string {
+	if variantID != nil {
+		return variantID.String()
+	}
+	return productID.String()
+}
+
+func orderProductIDs(items []models.OrderItem) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+	}
+	return ids
+}
+
+// variantCondition matches rows for variantID, or rows without a variant
+// when it is nil
+func variantCondition(variantID *uuid.UUID) clause.Expr {
+	if variantID == nil {
+		return clause.Expr{SQL: "variant_id IS NULL"}
+	}
+	return clause.Expr{SQL: "variant_id = ?", Vars: []any{*variantID}}
+}
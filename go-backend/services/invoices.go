@@ -0,0 +1,289 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go-backend/invoice"
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/storage"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Rendered invoice formats
+const (
+	InvoiceFormatPDF  = "pdf"
+	InvoiceFormatHTML = "html"
+)
+
+// ErrInvoiceNotFound is returned when an order has no such invoice
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+// Invoices renders issued invoices and credit notes and keeps the files in
+// a blob store. Documents are issued inside order transactions by
+// IssueInvoiceTx and IssueCreditNoteTx.
+type Invoices struct {
+	db     *gorm.DB
+	store  storage.BlobStore
+	seller invoice.Seller
+}
+
+// NewInvoices stores rendered documents in store, which must not be
+// publicly served
+func NewInvoices(db *gorm.DB, store storage.BlobStore, seller invoice.Seller) *Invoices {
+	return &Invoices{db: db, store: store, seller: seller}
+}
+
+// ForOrder returns the order's invoice and credit notes, oldest first
+func (s *Invoices) ForOrder(ctx context.Context, orderID uuid.UUID) ([]models.Invoice, error) {
+	docs := []models.Invoice{}
+	err := s.db.WithContext(ctx).Where("order_id = ?", orderID).Order("issued_at ASC, sequence ASC").Find(&docs).Error
+	return docs, err
+}
+
+// Find returns the order's invoice, or the credit note with number when
+// number isn't empty
+func (s *Invoices) Find(ctx context.Context, orderID uuid.UUID, number string) (*models.Invoice, error) {
+	q := s.db.WithContext(ctx).Where("order_id = ?", orderID)
+	if number == "" {
+		q = q.Where("kind = ?", models.InvoiceKindInvoice)
+	} else {
+		q = q.Where("number = ?", number)
+	}
+	var doc models.Invoice
+	if err := q.First(&doc).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Open returns the rendered document in format, rendering and storing it
+// the first time, along with the format actually served: documents with
+// text the PDF can't show are only available as HTML. Documents are
+// immutable, so a stored file is never rendered again.
+func (s *Invoices) Open(ctx context.Context, doc *models.Invoice, format string) (io.ReadCloser, string, error) {
+	// PDFs stored before unsupported text was detected show it as '?'
+	if format == InvoiceFormatPDF && !invoice.CanRenderPDF(s.document(doc)) {
+		format = InvoiceFormatHTML
+	}
+	key := doc.PDFKey
+	if format == InvoiceFormatHTML {
+		key = doc.HTMLKey
+	}
+	if key != "" {
+		r, err := s.store.Get(ctx, key)
+		if !errors.Is(err, storage.ErrNotFound) {
+			return r, format, err
+		}
+	}
+
+	if err := s.render(ctx, doc); err != nil {
+		return nil, "", err
+	}
+	if format == InvoiceFormatHTML {
+		r, err := s.store.Get(ctx, doc.HTMLKey)
+		return r, format, err
+	}
+	r, err := s.store.Get(ctx, doc.PDFKey)
+	return r, format, err
+}
+
+func (s *Invoices) document(doc *models.Invoice) invoice.Document {
+	return invoice.Document{Seller: s.seller, Invoice: *doc}
+}
+
+// render stores the document's HTML, and its PDF unless it has text the
+// PDF can't show
+func (s *Invoices) render(ctx context.Context, doc *models.Invoice) error {
+	rendered := s.document(doc)
+	html, err := invoice.RenderHTML(rendered)
+	if err != nil {
+		return err
+	}
+	pdf, err := invoice.RenderPDF(rendered)
+	if err != nil && !errors.Is(err, invoice.ErrUnsupportedText) {
+		return err
+	}
+
+	base := fmt.Sprintf("invoices/%d/%s", doc.Year, doc.Number)
+	if err := s.store.Put(ctx, base+".html", bytes.NewReader(html), "text/html; charset=utf-8"); err != nil {
+		return err
+	}
+	doc.HTMLKey, doc.PDFKey = base+".html", ""
+	if pdf != nil {
+		if err := s.store.Put(ctx, base+".pdf", bytes.NewReader(pdf), "application/pdf"); err != nil {
+			return err
+		}
+		doc.PDFKey = base + ".pdf"
+	}
+	return s.db.WithContext(ctx).Model(doc).Updates(map[string]any{"html_key": doc.HTMLKey, "pdf_key": doc.PDFKey}).Error
+}
+
+// IssueInvoiceTx issues the order's invoice unless it already has one. It
+// runs in the transaction that marks the order paid, so a rollback also
+// gives the number back.
+func IssueInvoiceTx(tx *gorm.DB, order *models.Order) (*models.Invoice, error) {
+	var existing models.Invoice
+	err := tx.Where("order_id = ? AND kind = ?", order.ID, models.InvoiceKindInvoice).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	doc, err := newDocument(tx, order, models.InvoiceKindInvoice)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		discount := item.Discount.OrCurrency(order.Currency)
+		doc.Lines = append(doc.Lines, models.InvoiceLine{
+			Description: item.ProductName,
+			Quantity:    item.Quantity,
+			UnitPrice:   item.Price,
+			Discount:    discount,
+			TaxRate:     item.TaxRate,
+			Tax:         item.Tax.OrCurrency(order.Currency),
+			Amount:      item.LineTotal.Sub(discount),
+		})
+	}
+	doc.Subtotal = order.Subtotal
+	doc.DiscountTotal = order.DiscountTotal
+	doc.ShippingTotal = order.ShippingTotal.OrCurrency(order.Currency)
+	doc.TaxTotal = order.TaxTotal.OrCurrency(order.Currency)
+	doc.Total = order.TotalAmount
+
+	if err := issue(tx, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// IssueCreditNoteTx issues a credit note for amount refunded on the order.
+// The tax it reverses is the refund's share of the order's tax.
+func IssueCreditNoteTx(tx *gorm.DB, orderID uuid.UUID, paymentID *uuid.UUID, amount money.Money, reason string) (*models.Invoice, error) {
+	var order models.Order
+	if err := tx.First(&order, orderID).Error; err != nil {
+		return nil, err
+	}
+	// Orders paid before invoicing existed get their invoice now
+	inv, err := IssueInvoiceTx(tx, &order)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := newDocument(tx, &order, models.InvoiceKindCreditNote)
+	if err != nil {
+		return nil, err
+	}
+	doc.InvoiceID = &inv.ID
+	doc.PaymentID = paymentID
+	doc.Reason = reason
+
+	taxShare := money.Zero(order.Currency)
+	if order.TotalAmount.IsPositive() {
+		rest := max(order.TotalAmount.Amount-amount.Amount, 0)
+		taxShare = order.TaxTotal.OrCurrency(order.Currency).Allocate([]int64{amount.Amount, rest})[0]
+	}
+	net := amount
+	if !order.PricesIncludeTax {
+		net = amount.Sub(taxShare)
+	}
+	doc.Lines = []models.InvoiceLine{{
+		Description: "Refund against invoice " + inv.Number,
+		Quantity:    1,
+		UnitPrice:   net,
+		Discount:    money.Zero(order.Currency),
+		Tax:         taxShare,
+		Amount:      net,
+	}}
+	doc.Subtotal = net
+	doc.DiscountTotal = money.Zero(order.Currency)
+	doc.ShippingTotal = money.Zero(order.Currency)
+	doc.TaxTotal = taxShare
+	doc.Total = amount
+
+	if err := issue(tx, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// newDocument starts a document for order with the customer snapshot
+func newDocument(tx *gorm.DB, order *models.Order, kind string) (*models.Invoice, error) {
+	var user models.User
+	if err := tx.Select("id", "email", "first_name", "last_name", "username").First(&user, order.UserID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	name := order.ShippingAddress.Name
+	if name == "" {
+		name = user.FirstName + " " + user.LastName
+		if user.FirstName == "" && user.LastName == "" {
+			name = user.Username
+		}
+	}
+	return &models.Invoice{
+		Kind:             kind,
+		OrderID:          order.ID,
+		IssuedAt:         time.Now(),
+		CustomerName:     name,
+		CustomerEmail:    user.Email,
+		BillTo:           order.ShippingAddress,
+		Currency:         order.Currency,
+		PricesIncludeTax: order.PricesIncludeTax,
+	}, nil
+}
+
+// issue numbers doc and saves it
+func issue(tx *gorm.DB, doc *models.Invoice) error {
+	doc.Year = doc.IssuedAt.Year()
+	seq, err := nextDocumentNumber(tx, doc.Kind, doc.Year)
+	if err != nil {
+		return err
+	}
+	prefix := "INV"
+	if doc.Kind == models.InvoiceKindCreditNote {
+		prefix = "CN"
+	}
+	doc.Sequence = seq
+	doc.Number = fmt.Sprintf("%s-%d-%06d", prefix, doc.Year, seq)
+	return tx.Create(doc).Error
+}
+
+// nextDocumentNumber bumps the kind's counter for year under a row lock.
+// Concurrent issuers queue on the lock, and because the bump commits or
+// rolls back with the document, numbers have no gaps.
+func nextDocumentNumber(tx *gorm.DB, kind string, year int) (int, error) {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.DocumentSequence{Kind: kind, Year: year}).Error
+	if err != nil {
+		return 0, err
+	}
+	var seq models.DocumentSequence
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("kind = ? AND year = ?", kind, year).
+		First(&seq).Error
+	if err != nil {
+		return 0, err
+	}
+	seq.Last++
+	err = tx.Model(&models.DocumentSequence{}).
+		Where("kind = ? AND year = ?", kind, year).
+		Update("last", seq.Last).Error
+	return seq.Last, err
+}
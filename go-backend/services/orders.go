@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrderTransitions lists the statuses each order status may move to. Only
+// unpaid orders can be cancelled; paid ones are called off by refunding
+// their payments, which moves them to refunded.
+var OrderTransitions = map[string][]string{
+	models.OrderStatusPending:       {models.OrderStatusPaid, models.OrderStatusPaymentFailed, models.OrderStatusCancelled},
+	models.OrderStatusPaymentFailed: {models.OrderStatusPaid, models.OrderStatusCancelled},
+	models.OrderStatusPaid:          {models.OrderStatusFulfilling, models.OrderStatusRefunded},
+	models.OrderStatusFulfilling:    {models.OrderStatusShipped, models.OrderStatusRefunded},
+	models.OrderStatusShipped:       {models.OrderStatusDelivered},
+	models.OrderStatusDelivered:     {models.OrderStatusRefunded},
+	models.OrderStatusCancelled:     {},
+	models.OrderStatusRefunded:      {},
+}
+
+// paymentStatuses are only reached through payment events, which capture
+// or refund the money and issue the invoice or credit note that goes with
+// them; staff can't set them by hand
+var paymentStatuses = []string{models.OrderStatusPaid, models.OrderStatusRefunded}
+
+var (
+	// ErrOrderNotFound is returned for unknown order IDs
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrTrackingRequired is returned when shipping without a tracking number
+	ErrTrackingRequired = errors.New("a tracking number is required to ship an order")
+)
+
+// TransitionError reports a move the state machine doesn't allow
+type TransitionError struct {
+	From    string
+	To      string
+	Allowed []string
+}
+
+func (e *TransitionError) Error() string {
+	if _, known := OrderTransitions[e.To]; !known {
+		return fmt.Sprintf("unknown order status %q", e.To)
+	}
+	if CanTransition(e.From, e.To) {
+		return fmt.Sprintf("orders only become %s through their payments", e.To)
+	}
+	return fmt.Sprintf("cannot move order from %s to %s", e.From, e.To)
+}
+
+// Transition is a requested status change
+type Transition struct {
+	To             string     `json:"to"`
+	Note           string     `json:"note"`
+	TrackingNumber string     `json:"tracking_number"`
+	Carrier        string     `json:"carrier"`
+	ActorID        *uuid.UUID `json:"-"`
+	// FromPayment is set by payment events, the only source of paid and
+	// refunded
+	FromPayment bool `json:"-"`
+}
+
+// Orders runs the order lifecycle
+type Orders struct {
+	db *gorm.DB
+}
+
+func NewOrders(db *gorm.DB) *Orders {
+	return &Orders{db: db}
+}
+
+// CanTransition reports whether from -> to is allowed
+func CanTransition(from, to string) bool {
+	return slices.Contains(OrderTransitions[from], to)
+}
+
+// ManualTransitions lists the statuses staff may move an order in status
+// from to by hand
+func ManualTransitions(from string) []string {
+	allowed := []string{}
+	for _, to := range OrderTransitions[from] {
+		if !slices.Contains(paymentStatuses, to) {
+			allowed = append(allowed, to)
+		}
+	}
+	return allowed
+}
+
+// Transition moves an order to a new status with its side effects and
+// records an order event, all in one transaction:
+//   - shipped requires a tracking number and stamps ShippedAt
+//   - delivered stamps DeliveredAt
+//   - paid turns the order's stock reservations into sales
+//   - cancelled, and refunded before shipping, put the items back in stock
+//   - cancelled gives back the order's promotion code uses
+func (s *Orders) Transition(ctx context.Context, orderID uuid.UUID, t Transition) (*models.Order, error) {
+	var order *models.Order
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, err = TransitionTx(tx, orderID, t)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// TransitionTx is Transition inside the caller's transaction
+func TransitionTx(tx *gorm.DB, orderID uuid.UUID, t Transition) (*models.Order, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+
+	from := order.Status
+	if !CanTransition(from, t.To) || (!t.FromPayment && slices.Contains(paymentStatuses, t.To)) {
+		allowed := OrderTransitions[from]
+		if !t.FromPayment {
+			allowed = ManualTransitions(from)
+		}
+		return nil, &TransitionError{From: from, To: t.To, Allowed: allowed}
+	}
+
+	now := time.Now()
+	data := map[string]any{}
+	switch t.To {
+	case models.OrderStatusShipped:
+		if strings.TrimSpace(t.TrackingNumber) == "" {
+			return nil, ErrTrackingRequired
+		}
+		order.TrackingNumber = strings.TrimSpace(t.TrackingNumber)
+		order.Carrier = strings.TrimSpace(t.Carrier)
+		order.ShippedAt = &now
+		data["tracking_number"] = order.TrackingNumber
+		if order.Carrier != "" {
+			data["carrier"] = order.Carrier
+		}
+	case models.OrderStatusDelivered:
+		order.DeliveredAt = &now
+	case models.OrderStatusCancelled:
+		order.CancelledAt = &now
+	}
+
+	if t.To == models.OrderStatusPaid {
+		oversold, err := commitOrder(tx, &order)
+		if err != nil {
+			return nil, err
+		}
+		if len(oversold) > 0 {
+			data["oversold"] = oversold
+		}
+	}
+	if restocks(from, t.To) {
+		restocked, err := restockOrder(tx, &order)
+		if err != nil {
+			return nil, err
+		}
+		data["restocked"] = restocked
+	}
+
+	if t.To == models.OrderStatusCancelled {
+		if err := releasePromotions(tx, order.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	order.Status = t.To
+	if err := tx.Save(&order).Error; err != nil {
+		return nil, err
+	}
+
+	if t.To == models.OrderStatusPaid {
+		inv, err := IssueInvoiceTx(tx, &order)
+		if err != nil {
+			return nil, err
+		}
+		data["invoice"] = inv.Number
+	}
+
+	err := RecordOrderEvent(tx, &models.OrderEvent{
+		OrderID:    order.ID,
+		Type:       models.OrderEventTransition,
+		FromStatus: from,
+		ToStatus:   t.To,
+		ActorID:    t.ActorID,
+		Note:       t.Note,
+		Data:       data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// restocks reports whether moving from -> to returns goods to inventory:
+// always on cancellation, and on refunds of orders that never shipped
+func restocks(from, to string) bool {
+	switch to {
+	case models.OrderStatusCancelled:
+		return true
+	case models.OrderStatusRefunded:
+		return from == models.OrderStatusPaid || from == models.OrderStatusFulfilling
+	}
+	return false
+}
+
+// RecordOrderEvent appends an event to an order's timeline
+func RecordOrderEvent(tx *gorm.DB, event *models.OrderEvent) error {
+	return tx.Create(event).Error
+}
+
+// Events returns an order's timeline, oldest first
+func (s *Orders) Events(ctx context.Context, orderID uuid.UUID) ([]models.OrderEvent, error) {
+	events := []models.OrderEvent{}
+	err := s.db.WithContext(ctx).
+		Preload("Actor", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "username", "first_name", "last_name")
+		}).
+		Where("order_id = ?", orderID).
+		Order("created_at ASC").
+		Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/payments"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNotPayable is returned when an order isn't awaiting payment
+	ErrNotPayable = errors.New("order is not awaiting payment")
+	// ErrPaymentNotFound is returned for unknown payment IDs
+	ErrPaymentNotFound = errors.New("payment not found")
+)
+
+// Payments connects orders to a payment provider. Payments are made in
+// the order's currency.
+type Payments struct {
+	db       *gorm.DB
+	provider payments.PaymentProvider
+}
+
+func NewPayments(db *gorm.DB, provider payments.PaymentProvider) *Payments {
+	return &Payments{db: db, provider: provider}
+}
+
+// Provider returns the configured payment provider
+func (s *Payments) Provider() payments.PaymentProvider {
+	return s.provider
+}
+
+// StartPayment creates a provider intent for the order's total. Calling it
+// again while a payment is still open returns the same intent; after a
+// failed attempt a new intent is created.
+func (s *Payments) StartPayment(ctx context.Context, order *models.Order) (*models.Payment, error) {
+	if order.Status != models.OrderStatusPending && order.Status != models.OrderStatusPaymentFailed {
+		return nil, ErrNotPayable
+	}
+
+	var failed int64
+	if err := s.db.WithContext(ctx).Model(&models.Payment{}).
+		Where("order_id = ? AND status = ?", order.ID, models.PaymentStatusFailed).
+		Count(&failed).Error; err != nil {
+		return nil, err
+	}
+
+	total := order.TotalAmount
+	intent, err := s.provider.CreateIntent(ctx, payments.IntentParams{
+		Amount:         total.Amount,
+		Currency:       total.Currency,
+		OrderID:        order.ID.String(),
+		IdempotencyKey: "order-" + order.ID.String() + "-attempt-" + strconv.FormatInt(failed+1, 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	payment := models.Payment{
+		OrderID:               order.ID,
+		Provider:              s.provider.Name(),
+		ProviderRef:           intent.ID,
+		Status:                models.PaymentStatusPending,
+		Amount:                money.New(intent.Amount, total.Currency),
+		AmountCaptured:        money.Zero(total.Currency),
+		AmountRefunded:        money.Zero(total.Currency),
+		AmountRefundRequested: money.Zero(total.Currency),
+		Currency:              total.Currency,
+	}
+	err = s.db.WithContext(ctx).
+		Where(models.Payment{Provider: payment.Provider, ProviderRef: payment.ProviderRef}).
+		FirstOrCreate(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	payment.ClientSecret = intent.ClientSecret
+	return &payment, nil
+}
+
+// Capture collects an authorised payment; the provider confirms it with a
+// payment.succeeded webhook
+func (s *Payments) Capture(ctx context.Context, paymentID uuid.UUID) (*models.Payment, error) {
+	payment, err := s.find(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.provider.Capture(ctx, payment.ProviderRef, 0); err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// Refund asks the provider to refund amount (the whole remaining captured
+// amount when zero); the provider confirms it with a payment.refunded
+// webhook. An amount without a currency is taken to be in the payment's.
+// The amount is counted against the payment before the provider is asked,
+// so concurrent refunds can't exceed what was captured; idempotencyKey,
+// when set, makes retries of the same refund safe.
+func (s *Payments) Refund(ctx context.Context, paymentID uuid.UUID, amount money.Money, reason, idempotencyKey string) (*payments.Refund, error) {
+	var payment models.Payment
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, paymentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPaymentNotFound
+			}
+			return err
+		}
+		amount = amount.OrCurrency(payment.Currency)
+		if amount.Currency != payment.Currency {
+			return fmt.Errorf("refund must be in %s, the payment currency", payment.Currency)
+		}
+		remaining := refundableOf(payment)
+		if !remaining.IsPositive() {
+			return fmt.Errorf("payment %s has nothing left to refund", payment.ID)
+		}
+		if amount.IsZero() {
+			amount = remaining
+		}
+		if amount.Amount > remaining.Amount {
+			return fmt.Errorf("refund of %s exceeds the refundable %s", amount, remaining)
+		}
+		return tx.Model(&payment).Updates(map[string]any{
+			"refund_requested_amount":   gorm.Expr("COALESCE(refund_requested_amount, 0) + ?", amount.Amount),
+			"refund_requested_currency": payment.Currency,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refund, err := s.provider.Refund(ctx, payment.ProviderRef, amount.Amount, reason, idempotencyKey)
+	if err != nil {
+		// The provider turned it down, so it no longer counts
+		if err := s.db.WithContext(ctx).Model(&models.Payment{}).Where("id = ?", payment.ID).
+			Update("refund_requested_amount", gorm.Expr("refund_requested_amount - ?", amount.Amount)).Error; err != nil {
+			log.Printf("ERROR: failed to release refund of %s on payment %s - %v", amount, payment.ID, err)
+		}
+		return nil, err
+	}
+	return refund, nil
+}
+
+func (s *Payments) find(ctx context.Context, paymentID uuid.UUID) (*models.Payment, error) {
+	var payment models.Payment
+	if err := s.db.WithContext(ctx).First(&payment, paymentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// HandleWebhook verifies and applies a provider event. Each event ID is
+// applied at most once: the event row is inserted in the same transaction
+// as its effects, so a redelivery is reported as a duplicate and a failed
+// attempt leaves nothing behind for the provider's retry.
+func (s *Payments) HandleWebhook(ctx context.Context, payload []byte, signature string) (duplicate bool, err error) {
+	event, err := s.provider.VerifyWebhook(payload, signature)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WebhookEvent{
+			Provider: s.provider.Name(),
+			EventID:  event.ID,
+			Type:     event.ProviderType,
+			Payload:  string(event.Payload),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			duplicate = true
+			return nil
+		}
+		return s.apply(tx, event)
+	})
+	return duplicate, err
+}
+
+func (s *Payments) apply(tx *gorm.DB, event *payments.Event) error {
+	if event.Type == payments.EventIgnored {
+		return nil
+	}
+
+	var payment models.Payment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("provider = ? AND provider_ref = ?", s.provider.Name(), event.IntentID).
+		First(&payment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Not one of ours (e.g. created from the provider dashboard)
+		log.Printf("WARN: webhook %s for unknown payment %s", event.ID, event.IntentID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var next string
+	note := fmt.Sprintf("%s payment %s", s.provider.Name(), payment.ProviderRef)
+	switch event.Type {
+	case payments.EventPaymentAuthorized:
+		payment.Status = models.PaymentStatusAuthorized
+		note += " authorized"
+	case payments.EventPaymentSucceeded:
+		payment.AmountCaptured = money.New(event.Amount, cmp.Or(event.Currency, payment.Currency))
+		payment.FailureReason = ""
+		// Only the exact total in the order's currency pays for it; anything
+		// else stays captured on the payment for staff to refund or settle
+		var order models.Order
+		if err := tx.Select("id", "total_amount", "total_currency").First(&order, payment.OrderID).Error; err != nil {
+			return err
+		}
+		if payment.AmountCaptured != order.TotalAmount {
+			payment.Status = models.PaymentStatusNeedsReview
+			payment.FailureReason = fmt.Sprintf("captured %s but the order total is %s", payment.AmountCaptured, order.TotalAmount)
+			note += " needs review: " + payment.FailureReason
+			break
+		}
+		payment.Status = models.PaymentStatusSucceeded
+		next, note = models.OrderStatusPaid, note+" succeeded"
+	case payments.EventPaymentFailed:
+		payment.Status = models.PaymentStatusFailed
+		payment.FailureReason = event.FailureReason
+		next, note = models.OrderStatusPaymentFailed, note+" failed: "+event.FailureReason
+	case payments.EventPaymentRefunded:
+		// Providers report the cumulative refunded amount; what is new since
+		// the last event gets a credit note
+		refunded := money.New(event.Amount, payment.Currency)
+		var credit *models.Invoice
+		if delta := refunded.Sub(payment.AmountRefunded.OrCurrency(payment.Currency)); delta.IsPositive() {
+			if credit, err = IssueCreditNoteTx(tx, payment.OrderID, &payment.ID, delta, "Refund of "+s.provider.Name()+" payment "+payment.ProviderRef); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Order{}).Where("id = ?", payment.OrderID).Updates(map[string]any{
+				"refunded_total_amount":   gorm.Expr("COALESCE(refunded_total_amount, 0) + ?", delta.Amount),
+				"refunded_total_currency": delta.Currency,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		payment.AmountRefunded = refunded
+		payment.Status = models.PaymentStatusPartiallyRefunded
+		if payment.AmountRefunded.Amount >= payment.AmountCaptured.Amount {
+			payment.Status = models.PaymentStatusRefunded
+			next = models.OrderStatusRefunded
+		}
+		note += " refunded " + payment.AmountRefunded.String()
+		if credit != nil {
+			note += ", credit note " + credit.Number
+		}
+	}
+	if err := tx.Save(&payment).Error; err != nil {
+		return err
+	}
+
+	var order models.Order
+	if err := tx.Select("id", "status").First(&order, payment.OrderID).Error; err != nil {
+		return err
+	}
+	if next == "" || !CanTransition(order.Status, next) {
+		// Late or out-of-order events still update the payment, but only
+		// move the order along paths the state machine allows
+		return RecordOrderEvent(tx, &models.OrderEvent{
+			OrderID: order.ID,
+			Type:    models.OrderEventPayment,
+			Note:    note,
+			Data:    map[string]any{"event_id": event.ID, "payment_status": payment.Status},
+		})
+	}
+
+	_, err = TransitionTx(tx, order.ID, Transition{To: next, Note: note, FromPayment: true})
+	return err
+}
@@ -0,0 +1,506 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LowestPriceWindow is how far back the lowest recent price looks, as EU
+// price reduction rules require
+const LowestPriceWindow = 30 * 24 * time.Hour
+
+var (
+	// ErrPriceScheduleNotFound is returned for unknown schedule IDs
+	ErrPriceScheduleNotFound = errors.New("price schedule not found")
+	// ErrPriceScheduleConflict is returned for schedules that overlap
+	// another pending or running one of the same product or variant
+	ErrPriceScheduleConflict = errors.New("price schedule overlaps another one")
+	// ErrPriceScheduleEnded is returned when cancelling a finished schedule
+	ErrPriceScheduleEnded = errors.New("price schedule has already ended")
+)
+
+// PriceTarget is a product's own price, or one of its variants' when
+// VariantID is set
+type PriceTarget struct {
+	ProductID uuid.UUID
+	VariantID uuid.UUID
+}
+
+func historyTarget(h models.PriceHistory) PriceTarget {
+	t := PriceTarget{ProductID: h.ProductID}
+	if h.VariantID != nil {
+		t.VariantID = *h.VariantID
+	}
+	return t
+}
+
+// RecordPrices adds a history entry for the product's price and each of
+// its variants' that changed since their last entry. Call it in the
+// transaction that changed them.
+func RecordPrices(tx *gorm.DB, productID uuid.UUID, source string, actorID, scheduleID *uuid.UUID) error {
+	var product models.Product
+	if err := tx.Preload("Variants").First(&product, "id = ?", productID).Error; err != nil {
+		return err
+	}
+	var latest []models.PriceHistory
+	if err := tx.Raw(`SELECT DISTINCT ON (variant_id) * FROM price_histories
+		WHERE product_id = ? ORDER BY variant_id, effective_at DESC, created_at DESC`, productID).
+		Scan(&latest).Error; err != nil {
+		return err
+	}
+	last := make(map[PriceTarget]money.Money, len(latest))
+	for _, h := range latest {
+		last[historyTarget(h)] = h.Price
+	}
+
+	now := time.Now()
+	var entries []models.PriceHistory
+	add := func(variantID *uuid.UUID, price money.Money) {
+		t := PriceTarget{ProductID: product.ID}
+		if variantID != nil {
+			t.VariantID = *variantID
+		}
+		if prev, ok := last[t]; ok && prev == price {
+			return
+		}
+		entries = append(entries, models.PriceHistory{
+			ProductID:   product.ID,
+			VariantID:   variantID,
+			Price:       price,
+			Source:      source,
+			ScheduleID:  scheduleID,
+			ChangedByID: actorID,
+			EffectiveAt: now,
+		})
+	}
+	add(nil, product.Price)
+	for i := range product.Variants {
+		v := &product.Variants[i]
+		price := v.Price
+		if price.Currency == "" {
+			price = product.Price
+		}
+		add(&v.ID, price)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.Create(&entries).Error
+}
+
+// PriceHistoryFor returns the history of a product's price, or of one of
+// its variants', since the given time in the order it took effect. The
+// first entry is the one that was in effect at since, so a chart of it
+// covers the whole period.
+func PriceHistoryFor(db *gorm.DB, productID uuid.UUID, variantID *uuid.UUID, since time.Time) ([]models.PriceHistory, error) {
+	target := func() *gorm.DB {
+		query := db.Model(&models.PriceHistory{}).Where("product_id = ?", productID)
+		if variantID != nil {
+			return query.Where("variant_id = ?", *variantID)
+		}
+		return query.Where("variant_id IS NULL")
+	}
+	history := []models.PriceHistory{}
+	var before models.PriceHistory
+	err := target().Where("effective_at < ?", since).Order("effective_at DESC, created_at DESC").First(&before).Error
+	switch {
+	case err == nil:
+		history = append(history, before)
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	var recent []models.PriceHistory
+	if err := target().Where("effective_at >= ?", since).Order("effective_at, created_at").Find(&recent).Error; err != nil {
+		return nil, err
+	}
+	return append(history, recent...), nil
+}
+
+// LowestPrices returns, for each of the products and their variants, the
+// lowest price they had in the LowestPriceWindow before their current
+// price took effect, in the currency of the current price. This is the
+// reference a price reduction is measured against, so a running sale
+// doesn't count itself. Targets without an earlier price are left out.
+func LowestPrices(db *gorm.DB, productIDs []uuid.UUID) (map[PriceTarget]money.Money, error) {
+	if len(productIDs) == 0 {
+		return map[PriceTarget]money.Money{}, nil
+	}
+	// The current entry of each target, those in the window before it and
+	// the one in effect when the window opened
+	window := LowestPriceWindow.Seconds()
+	var entries []models.PriceHistory
+	if err := db.Raw(`WITH current AS (
+			SELECT DISTINCT ON (product_id, variant_id) product_id, variant_id, effective_at FROM price_histories
+			WHERE product_id IN ? ORDER BY product_id, variant_id, effective_at DESC, created_at DESC
+		)
+		SELECT h.* FROM price_histories h
+		JOIN current c ON h.product_id = c.product_id AND h.variant_id IS NOT DISTINCT FROM c.variant_id
+		WHERE h.effective_at >= c.effective_at - make_interval(secs => ?)
+		UNION ALL
+		SELECT * FROM (
+			SELECT DISTINCT ON (h.product_id, h.variant_id) h.* FROM price_histories h
+			JOIN current c ON h.product_id = c.product_id AND h.variant_id IS NOT DISTINCT FROM c.variant_id
+			WHERE h.effective_at < c.effective_at - make_interval(secs => ?)
+			ORDER BY h.product_id, h.variant_id, h.effective_at DESC, h.created_at DESC
+		) opening`, productIDs, window, window).
+		Scan(&entries).Error; err != nil {
+		return nil, err
+	}
+	return lowestBefore(entries, LowestPriceWindow), nil
+}
+
+// lowestBefore finds in history entries, in any order, the lowest price of
+// each target in the window before its current entry took effect: the
+// price in effect when the window opened and those set during it, not the
+// current one
+func lowestBefore(entries []models.PriceHistory, window time.Duration) map[PriceTarget]money.Money {
+	byTarget := map[PriceTarget][]models.PriceHistory{}
+	for _, h := range entries {
+		t := historyTarget(h)
+		byTarget[t] = append(byTarget[t], h)
+	}
+
+	lowest := map[PriceTarget]money.Money{}
+	for t, history := range byTarget {
+		slices.SortFunc(history, func(a, b models.PriceHistory) int {
+			if c := a.EffectiveAt.Compare(b.EffectiveAt); c != 0 {
+				return c
+			}
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+		current := history[len(history)-1]
+		opens := current.EffectiveAt.Add(-window)
+		for i, h := range history[:len(history)-1] {
+			// Entries before the window only count while still in effect
+			// when it opened
+			if h.EffectiveAt.Before(opens) && !history[i+1].EffectiveAt.After(opens) {
+				continue
+			}
+			if h.Price.Currency != current.Price.Currency {
+				continue
+			}
+			if low, ok := lowest[t]; !ok || h.Price.Amount < low.Amount {
+				lowest[t] = h.Price
+			}
+		}
+	}
+	return lowest
+}
+
+// FillLowestPrices sets LowestPrice30d of the products and their variants
+func FillLowestPrices(db *gorm.DB, products []models.Product) error {
+	ids := make([]uuid.UUID, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	lowest, err := LowestPrices(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		p := &products[i]
+		if low, ok := lowest[PriceTarget{ProductID: p.ID}]; ok {
+			p.LowestPrice30d = &low
+		}
+		for j := range p.Variants {
+			v := &p.Variants[j]
+			if low, ok := lowest[PriceTarget{ProductID: p.ID, VariantID: v.ID}]; ok {
+				v.LowestPrice30d = &low
+			}
+		}
+	}
+	return nil
+}
+
+// Prices runs scheduled price changes and sales: a periodic pass starts
+// the schedules that are due and reverts the sales that are over
+type Prices struct {
+	db      *gorm.DB
+	catalog *Catalog
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewPrices(db *gorm.DB, catalog *Catalog) *Prices {
+	return &Prices{db: db, catalog: catalog}
+}
+
+// Schedule queues a price change. The product must exist, the variant
+// belong to it, and the schedule may not overlap another pending or
+// running one of the same target.
+func (s *Prices) Schedule(ctx context.Context, schedule *models.PriceSchedule) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the product so two schedules for it can't both pass the
+		// overlap check
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&product, "id = ?", schedule.ProductID).Error; err != nil {
+			return err
+		}
+		if schedule.VariantID != nil {
+			var count int64
+			if err := tx.Model(&models.ProductVariant{}).Where("id = ? AND product_id = ?", *schedule.VariantID, schedule.ProductID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return gorm.ErrRecordNotFound
+			}
+		}
+
+		var others []models.PriceSchedule
+		if err := scheduleTarget(tx, schedule.ProductID, schedule.VariantID).
+			Where("status IN ?", []string{models.PriceScheduleScheduled, models.PriceScheduleActive}).
+			Find(&others).Error; err != nil {
+			return err
+		}
+		start, end := scheduleWindow(schedule)
+		for _, o := range others {
+			oStart, oEnd := scheduleWindow(&o)
+			if !start.After(oEnd) && !oStart.After(end) {
+				return ErrPriceScheduleConflict
+			}
+		}
+
+		schedule.Status = models.PriceScheduleScheduled
+		return tx.Create(schedule).Error
+	})
+}
+
+// Cancel drops a schedule that hasn't started; cancelling a running sale
+// ends it now
+func (s *Prices) Cancel(ctx context.Context, productID, id uuid.UUID) (*models.PriceSchedule, error) {
+	var schedule models.PriceSchedule
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&schedule, "id = ? AND product_id = ?", id, productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPriceScheduleNotFound
+			}
+			return err
+		}
+		switch schedule.Status {
+		case models.PriceScheduleScheduled:
+			now := time.Now()
+			schedule.Status, schedule.EndedAt = models.PriceScheduleCancelled, &now
+			return tx.Save(&schedule).Error
+		case models.PriceScheduleActive:
+			return s.end(tx, &schedule, models.PriceScheduleCancelled)
+		default:
+			return ErrPriceScheduleEnded
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.refresh(schedule.ProductID)
+	return &schedule, nil
+}
+
+// ApplyDue starts the schedules whose time has come and ends the sales
+// that are over, and returns how many it handled. Products in the trash
+// wait until they are restored.
+func (s *Prices) ApplyDue(ctx context.Context) (int, error) {
+	db := s.db.WithContext(ctx)
+	now := time.Now()
+	var ids []uuid.UUID
+	if err := db.Model(&models.PriceSchedule{}).
+		Where("(status = ? AND starts_at <= ?) OR (status = ? AND ends_at <= ?)",
+			models.PriceScheduleScheduled, now, models.PriceScheduleActive, now).
+		Where("product_id IN (?)", db.Model(&models.Product{}).Select("id")).
+		Order("starts_at").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	touched := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		var schedule models.PriceSchedule
+		handled := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Cancels may have got to it first
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&schedule, "id = ?", id).Error; err != nil {
+				return err
+			}
+			switch {
+			case schedule.Status == models.PriceScheduleScheduled && !schedule.StartsAt.After(now):
+				handled = true
+				return s.start(tx, &schedule, now)
+			case schedule.Status == models.PriceScheduleActive && schedule.EndsAt != nil && !schedule.EndsAt.After(now):
+				handled = true
+				return s.end(tx, &schedule, models.PriceScheduleCompleted)
+			}
+			return nil
+		})
+		if err != nil {
+			return applied, err
+		}
+		if handled {
+			applied++
+			touched[schedule.ProductID] = true
+		}
+	}
+	for productID := range touched {
+		s.refresh(productID)
+	}
+	return applied, nil
+}
+
+// start puts a schedule's price in place. A sale remembers the price it
+// replaces; one whose whole window passed while nothing ran is skipped.
+func (s *Prices) start(tx *gorm.DB, schedule *models.PriceSchedule, now time.Time) error {
+	schedule.StartedAt = &now
+	if schedule.EndsAt != nil && !schedule.EndsAt.After(now) {
+		schedule.Status, schedule.EndedAt = models.PriceScheduleCompleted, &now
+		schedule.Note = appendNote(schedule.Note, "Skipped: the sale window passed before it could start")
+		return tx.Save(schedule).Error
+	}
+	current, found, err := ownPrice(tx, schedule.ProductID, schedule.VariantID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		schedule.Status, schedule.EndedAt = models.PriceScheduleCancelled, &now
+		schedule.Note = appendNote(schedule.Note, "Cancelled: the variant no longer exists")
+		return tx.Save(schedule).Error
+	}
+
+	if err := setOwnPrice(tx, schedule.ProductID, schedule.VariantID, schedule.Price); err != nil {
+		return err
+	}
+	if err := RecordPrices(tx, schedule.ProductID, models.PriceSourceSchedule, schedule.CreatedByID, &schedule.ID); err != nil {
+		return err
+	}
+	if schedule.EndsAt != nil {
+		schedule.Status, schedule.RevertPrice = models.PriceScheduleActive, current
+	} else {
+		schedule.Status, schedule.EndedAt = models.PriceScheduleCompleted, &now
+	}
+	return tx.Save(schedule).Error
+}
+
+// end closes a running sale with status, putting the price it replaced
+// back unless the price was changed since the sale started
+func (s *Prices) end(tx *gorm.DB, schedule *models.PriceSchedule, status string) error {
+	now := time.Now()
+	current, found, err := ownPrice(tx, schedule.ProductID, schedule.VariantID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !found:
+	case current != schedule.Price:
+		schedule.Note = appendNote(schedule.Note, "The price changed during the sale and was kept")
+	default:
+		if err := setOwnPrice(tx, schedule.ProductID, schedule.VariantID, schedule.RevertPrice); err != nil {
+			return err
+		}
+		if err := RecordPrices(tx, schedule.ProductID, models.PriceSourceSaleEnd, schedule.CreatedByID, &schedule.ID); err != nil {
+			return err
+		}
+	}
+	schedule.Status, schedule.EndedAt = status, &now
+	return tx.Save(schedule).Error
+}
+
+// StartScheduler applies due schedules every interval until
+// StopScheduler is called
+func (s *Prices) StartScheduler(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := s.ApplyDue(context.Background()); err != nil {
+					log.Printf("ERROR: failed to apply scheduled price changes - %v", err)
+				} else if n > 0 {
+					log.Printf("INFO: applied %d scheduled price changes", n)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopScheduler ends the schedule loop
+func (s *Prices) StopScheduler() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.stopped
+	s.stop = nil
+}
+
+func (s *Prices) refresh(productID uuid.UUID) {
+	if err := s.catalog.Refresh(context.Background(), productID); err != nil {
+		log.Printf("ERROR: failed to refresh the search index - %v", err)
+	}
+}
+
+// scheduleTarget selects the schedules of a product's own price, or of a
+// variant's
+func scheduleTarget(db *gorm.DB, productID uuid.UUID, variantID *uuid.UUID) *gorm.DB {
+	query := db.Model(&models.PriceSchedule{}).Where("product_id = ?", productID)
+	if variantID != nil {
+		return query.Where("variant_id = ?", *variantID)
+	}
+	return query.Where("variant_id IS NULL")
+}
+
+// scheduleWindow is when a schedule holds its target: a plain change only
+// at its start, a sale until it ends
+func scheduleWindow(s *models.PriceSchedule) (time.Time, time.Time) {
+	if s.EndsAt == nil {
+		return s.StartsAt, s.StartsAt
+	}
+	return s.StartsAt, *s.EndsAt
+}
+
+// ownPrice reads the price set on a product or variant itself; a variant
+// without one has a zero price. found is false for deleted variants.
+func ownPrice(tx *gorm.DB, productID uuid.UUID, variantID *uuid.UUID) (money.Money, bool, error) {
+	if variantID == nil {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "price_amount", "price_currency").
+			First(&product, "id = ?", productID).Error; err != nil {
+			return money.Money{}, false, err
+		}
+		return product.Price, true, nil
+	}
+	var variant models.ProductVariant
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "price_amount", "price_currency").
+		First(&variant, "id = ? AND product_id = ?", *variantID, productID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return money.Money{}, false, nil
+	}
+	return variant.Price, err == nil, err
+}
+
+func setOwnPrice(tx *gorm.DB, productID uuid.UUID, variantID *uuid.UUID, price money.Money) error {
+	columns := map[string]any{"price_amount": price.Amount, "price_currency": price.Currency}
+	if variantID == nil {
+		return tx.Model(&models.Product{}).Where("id = ?", productID).Updates(columns).Error
+	}
+	return tx.Model(&models.ProductVariant{}).Where("id = ?", *variantID).Updates(columns).Error
+}
+
+func appendNote(note, line string) string {
+	if note == "" {
+		return line
+	}
+	return note + "\n" + line
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+
+	"github.com/google/uuid"
+)
+
+func TestLowestBefore(t *testing.T) {
+	product := uuid.New()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	entry := func(ago time.Duration, amount int64, currency string) models.PriceHistory {
+		at := now.Add(-ago)
+		return models.PriceHistory{ProductID: product, Price: money.New(amount, currency), EffectiveAt: at, CreatedAt: at}
+	}
+
+	tests := []struct {
+		name    string
+		entries []models.PriceHistory
+		want    int64 // 0 when there is no reference price
+	}{
+		{
+			name:    "sale running",
+			entries: []models.PriceHistory{entry(90*day, 10000, "EUR"), entry(5*day, 8000, "EUR")},
+			want:    10000,
+		},
+		{
+			name: "sale after a lower price in the window",
+			entries: []models.PriceHistory{
+				entry(90*day, 10000, "EUR"), entry(20*day, 9000, "EUR"), entry(10*day, 10000, "EUR"), entry(2*day, 7000, "EUR"),
+			},
+			want: 9000,
+		},
+		{
+			name: "window measured from the current price",
+			entries: []models.PriceHistory{
+				entry(120*day, 5000, "EUR"), entry(90*day, 10000, "EUR"), entry(50*day, 8000, "EUR"),
+			},
+			want: 10000,
+		},
+		{
+			name: "price in effect when the window opened",
+			entries: []models.PriceHistory{
+				entry(100*day, 9500, "EUR"), entry(20*day, 12000, "EUR"), entry(day, 9900, "EUR"),
+			},
+			want: 9500,
+		},
+		{
+			name:    "other currencies are ignored",
+			entries: []models.PriceHistory{entry(60*day, 500, "USD"), entry(20*day, 11000, "EUR"), entry(day, 9000, "EUR")},
+			want:    11000,
+		},
+		{
+			name:    "no earlier price",
+			entries: []models.PriceHistory{entry(3*day, 8000, "EUR")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Order must not matter
+			entries := append([]models.PriceHistory{}, tt.entries...)
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+			got, ok := lowestBefore(entries, LowestPriceWindow)[PriceTarget{ProductID: product}]
+			if tt.want == 0 {
+				if ok {
+					t.Fatalf("got %s, want no reference price", got)
+				}
+				return
+			}
+			if !ok || got.Amount != tt.want {
+				t.Fatalf("got %v (found %v), want %d", got, ok, tt.want)
+			}
+		})
+	}
+}
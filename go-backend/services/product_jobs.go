@@ -0,0 +1,1082 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/storage"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// productJobBatch is how many rows are processed between progress updates
+const productJobBatch = 100
+
+var (
+	// ErrProductJobNotFound is returned for unknown job IDs
+	ErrProductJobNotFound = errors.New("product job not found")
+	// ErrInvalidJobFormat is returned for formats other than csv and jsonl
+	ErrInvalidJobFormat = errors.New("format must be csv or jsonl")
+	// ErrJobNotFinished is returned when downloading an export that hasn't
+	// completed
+	ErrJobNotFinished = errors.New("the export hasn't completed")
+)
+
+// ProductColumns are the columns of product files, in export order.
+// Product rows have no parent_sku and list their options as
+// "Size=S|M|L;Color=Red|Blue"; variant rows name their product's SKU in
+// parent_sku and their values as "Size=M;Color=Red". Variant rows only use
+// sku, parent_sku, options, price, currency, barcode, weight_grams and
+// is_active. stock is exported for reference; imports ignore it since
+// stock moves through the inventory.
+var ProductColumns = []string{
+	"sku", "parent_sku", "name", "description", "price", "currency", "category", "tax_category",
+	"options", "barcode", "weight_grams", "length_cm", "width_cm", "height_cm", "image_url", "is_active", "stock",
+}
+
+// RowError is a problem with one import row; Field is the column at fault
+type RowError struct {
+	Field   string
+	Message string
+}
+
+func (e *RowError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+func rowError(field, format string, args ...any) *RowError {
+	return &RowError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// ProductJobs runs product imports and exports in the background, one at
+// a time. Files live in a store that isn't publicly served.
+type ProductJobs struct {
+	db       *gorm.DB
+	store    storage.BlobStore
+	catalog  *Catalog
+	currency string
+	wake     chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewProductJobs prices new products in currency, the shop's base currency
+func NewProductJobs(db *gorm.DB, store storage.BlobStore, catalog *Catalog, currency string) *ProductJobs {
+	return &ProductJobs{db: db, store: store, catalog: catalog, currency: currency, wake: make(chan struct{}, 1)}
+}
+
+// Import stores an uploaded file and queues its import. A dry run reports
+// what would fail without keeping any change.
+func (s *ProductJobs) Import(ctx context.Context, format, fileName string, r io.Reader, dryRun bool, actorID *uuid.UUID) (*models.ProductJob, error) {
+	if format != models.ProductJobCSV && format != models.ProductJobJSONL {
+		return nil, ErrInvalidJobFormat
+	}
+	job := models.ProductJob{
+		ID:          uuid.New(),
+		Kind:        models.ProductJobImport,
+		Format:      format,
+		Status:      models.ProductJobQueued,
+		DryRun:      dryRun,
+		FileName:    fileName,
+		CreatedByID: actorID,
+	}
+	job.FileKey = "imports/" + job.ID.String() + "." + format
+	if err := s.store.Put(ctx, job.FileKey, r, contentTypes[format]); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(&job).Error; err != nil {
+		s.store.Delete(ctx, job.FileKey)
+		return nil, err
+	}
+	s.notify()
+	return &job, nil
+}
+
+// Export queues an export of the products filter selects: "category" (a
+// slug, with its subcategories), "q" (in the name or SKU) and "is_active"
+func (s *ProductJobs) Export(ctx context.Context, format string, filter map[string]string, actorID *uuid.UUID) (*models.ProductJob, error) {
+	if format != models.ProductJobCSV && format != models.ProductJobJSONL {
+		return nil, ErrInvalidJobFormat
+	}
+	// Reject bad filters now rather than failing the job later
+	if _, err := s.exportQuery(s.db.WithContext(ctx), filter); err != nil {
+		return nil, err
+	}
+	job := models.ProductJob{
+		ID:          uuid.New(),
+		Kind:        models.ProductJobExport,
+		Format:      format,
+		Status:      models.ProductJobQueued,
+		Filter:      filter,
+		FileName:    "products-" + time.Now().UTC().Format("20060102-150405") + "." + format,
+		CreatedByID: actorID,
+	}
+	job.FileKey = "exports/" + job.ID.String() + "." + format
+	if err := s.db.WithContext(ctx).Create(&job).Error; err != nil {
+		return nil, err
+	}
+	s.notify()
+	return &job, nil
+}
+
+// Find returns a job with its progress
+func (s *ProductJobs) Find(ctx context.Context, id uuid.UUID) (*models.ProductJob, error) {
+	var job models.ProductJob
+	if err := s.db.WithContext(ctx).First(&job, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// OpenExport returns the file a completed export wrote
+func (s *ProductJobs) OpenExport(ctx context.Context, job *models.ProductJob) (io.ReadCloser, error) {
+	if job.Kind != models.ProductJobExport || job.Status != models.ProductJobCompleted {
+		return nil, ErrJobNotFinished
+	}
+	return s.store.Get(ctx, job.FileKey)
+}
+
+// ProductFileContentType is the MIME type of a product file format
+func ProductFileContentType(format string) string {
+	return contentTypes[format]
+}
+
+var contentTypes = map[string]string{
+	models.ProductJobCSV:   "text/csv; charset=utf-8",
+	models.ProductJobJSONL: "application/x-ndjson",
+}
+
+// Start runs queued jobs until Stop is called, checking for new ones every
+// poll interval and whenever one is queued. Jobs that were running when the
+// server stopped start over, which is safe with a single server since
+// imports upsert.
+func (s *ProductJobs) Start(poll time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		if err := s.requeueInterrupted(); err != nil {
+			log.Printf("ERROR: failed to requeue interrupted product jobs - %v", err)
+		}
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		for {
+			for {
+				job, err := s.claim()
+				if err != nil {
+					log.Printf("ERROR: failed to fetch queued product jobs - %v", err)
+				}
+				if job == nil {
+					break
+				}
+				s.run(job)
+				select {
+				case <-s.stop:
+					return
+				default:
+				}
+			}
+			select {
+			case <-s.wake:
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the running job to finish and ends the worker
+func (s *ProductJobs) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.stopped
+	s.stop = nil
+}
+
+func (s *ProductJobs) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *ProductJobs) requeueInterrupted() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		interrupted := tx.Model(&models.ProductJob{}).Select("id").Where("status = ?", models.ProductJobRunning)
+		if err := tx.Where("job_id IN (?)", interrupted).Delete(&models.ProductJobError{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.ProductJob{}).Where("status = ?", models.ProductJobRunning).Updates(map[string]any{
+			"status": models.ProductJobQueued, "total_rows": 0, "processed_rows": 0,
+			"created_rows": 0, "updated_rows": 0, "failed_rows": 0, "started_at": nil,
+		}).Error
+	})
+}
+
+// claim marks the oldest queued job running and returns it, or nil when
+// none is queued
+func (s *ProductJobs) claim() (*models.ProductJob, error) {
+	var job models.ProductJob
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", models.ProductJobQueued).Order("created_at").First(&job).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		job.Status, job.StartedAt = models.ProductJobRunning, &now
+		return tx.Model(&job).Updates(map[string]any{"status": job.Status, "started_at": now}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *ProductJobs) run(job *models.ProductJob) {
+	ctx := context.Background()
+	var err error
+	if job.Kind == models.ProductJobExport {
+		err = s.runExport(ctx, job)
+	} else {
+		err = s.runImport(ctx, job)
+	}
+
+	job.Status = models.ProductJobCompleted
+	if err != nil {
+		log.Printf("ERROR: product %s %s failed - %v", job.Kind, job.ID, err)
+		job.Status, job.Error = models.ProductJobFailed, err.Error()
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+	if err := s.db.Model(job).Select("status", "error", "finished_at", "total_rows", "processed_rows",
+		"created_rows", "updated_rows", "failed_rows").Updates(job).Error; err != nil {
+		log.Printf("ERROR: failed to finish product job %s - %v", job.ID, err)
+	}
+}
+
+// progress saves the job's counters
+func (s *ProductJobs) progress(job *models.ProductJob) {
+	if err := s.db.Model(job).Select("total_rows", "processed_rows", "created_rows", "updated_rows", "failed_rows").
+		Updates(job).Error; err != nil {
+		log.Printf("ERROR: failed to save progress of product job %s - %v", job.ID, err)
+	}
+}
+
+// runImport applies the file row by row, each row in its own transaction so
+// one bad row doesn't stop the rest. A dry run nests them in one
+// transaction that is rolled back at the end, so later rows still see
+// products created by earlier ones.
+func (s *ProductJobs) runImport(ctx context.Context, job *models.ProductJob) error {
+	total, err := s.countRows(ctx, job)
+	if err != nil {
+		return err
+	}
+	job.TotalRows = total
+	s.progress(job)
+
+	f, err := s.store.Get(ctx, job.FileKey)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rows, err := newRowReader(f, job.Format)
+	if err != nil {
+		return err
+	}
+
+	db := s.db.WithContext(ctx)
+	if job.DryRun {
+		db = db.Begin()
+		if db.Error != nil {
+			return db.Error
+		}
+		defer db.Rollback()
+	}
+
+	var failures []models.ProductJobError
+	touched := map[uuid.UUID]bool{}
+	flush := func() error {
+		if len(failures) > 0 {
+			if err := s.db.WithContext(ctx).Create(&failures).Error; err != nil {
+				return err
+			}
+			failures = failures[:0]
+		}
+		s.progress(job)
+		return nil
+	}
+	for n := 1; ; n++ {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return err
+		}
+		if err == nil {
+			var productID uuid.UUID
+			var created bool
+			err = db.Transaction(func(tx *gorm.DB) error {
+				productID, created, err = s.applyRow(tx, row)
+				if err != nil {
+					return err
+				}
+				return RecordPrices(tx, productID, models.PriceSourceImport, job.CreatedByID, nil)
+			})
+			switch {
+			case err == nil && created:
+				job.CreatedRows++
+				touched[productID] = true
+			case err == nil:
+				job.UpdatedRows++
+				touched[productID] = true
+			case !errors.As(err, &rowErr):
+				rowErr = &RowError{Message: err.Error()}
+			}
+		}
+		if rowErr != nil {
+			job.FailedRows++
+			failures = append(failures, models.ProductJobError{
+				JobID: job.ID, Row: n, SKU: strings.TrimSpace(row["sku"]), Field: rowErr.Field, Message: rowErr.Message,
+			})
+		}
+		job.ProcessedRows++
+		if job.ProcessedRows%productJobBatch == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if !job.DryRun && len(touched) > 0 {
+		ids := make([]uuid.UUID, 0, len(touched))
+		for id := range touched {
+			ids = append(ids, id)
+		}
+		if err := s.catalog.Refresh(ctx, ids...); err != nil {
+			log.Printf("ERROR: failed to refresh the search index after import %s - %v", job.ID, err)
+		}
+	}
+	return nil
+}
+
+// countRows counts the data rows of an import file for its progress
+func (s *ProductJobs) countRows(ctx context.Context, job *models.ProductJob) (int, error) {
+	f, err := s.store.Get(ctx, job.FileKey)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	rows, err := newRowReader(f, job.Format)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		_, err := rows.next()
+		if err == io.EOF {
+			return n, nil
+		}
+		var rowErr *RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return 0, err
+		}
+		n++
+	}
+}
+
+// applyRow upserts the product or variant of one row by SKU, returning the
+// product it belongs to and whether the row created something
+func (s *ProductJobs) applyRow(tx *gorm.DB, row map[string]string) (uuid.UUID, bool, error) {
+	sku := strings.TrimSpace(row["sku"])
+	if sku == "" {
+		return uuid.Nil, false, rowError("sku", "is required")
+	}
+	if len(sku) > 64 {
+		return uuid.Nil, false, rowError("sku", "is longer than 64 characters")
+	}
+
+	var product models.Product
+	err := tx.Unscoped().Scopes(WithVariants).Where("sku = ?", sku).First(&product).Error
+	switch {
+	case err == nil && product.DeletedAt.Valid:
+		return uuid.Nil, false, rowError("sku", "%s belongs to a product in the trash", sku)
+	case err == nil:
+		if strings.TrimSpace(row["parent_sku"]) != "" {
+			return uuid.Nil, false, rowError("parent_sku", "%s is a product, not a variant", sku)
+		}
+		return product.ID, false, s.applyProduct(tx, &product, row)
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return uuid.Nil, false, err
+	}
+
+	var variant models.ProductVariant
+	err = tx.Where("sku = ?", sku).First(&variant).Error
+	switch {
+	case err == nil:
+		return variant.ProductID, false, s.applyVariant(tx, &variant, row)
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return uuid.Nil, false, err
+	}
+
+	if strings.TrimSpace(row["parent_sku"]) != "" {
+		variant = models.ProductVariant{ID: uuid.New(), SKU: sku, IsActive: true, Images: []string{}}
+		err := s.applyVariant(tx, &variant, row)
+		return variant.ProductID, true, err
+	}
+	product = models.Product{ID: uuid.New(), SKU: sku, IsActive: true}
+	err = s.applyProduct(tx, &product, row)
+	return product.ID, true, err
+}
+
+// applyProduct sets the row's non-empty cells on a product and saves it;
+// a new product (without CreatedAt) needs a name and a price
+func (s *ProductJobs) applyProduct(tx *gorm.DB, p *models.Product, row map[string]string) error {
+	isNew := p.CreatedAt.IsZero()
+	if isNew && (strings.TrimSpace(row["name"]) == "" || strings.TrimSpace(row["price"]) == "") {
+		return rowError("", "new products need a name and a price")
+	}
+	if v := strings.TrimSpace(row["name"]); v != "" {
+		p.Name = v
+	}
+	if v := strings.TrimSpace(row["description"]); v != "" {
+		p.Description = v
+	}
+	currency := p.Price.Currency
+	if currency == "" {
+		currency = s.currency
+	}
+	price, err := rowPrice(row, currency)
+	if err != nil {
+		return err
+	}
+	if price != nil {
+		p.Price = *price
+	}
+	if v := strings.TrimSpace(row["tax_category"]); v != "" {
+		p.TaxCategory = v
+	}
+	if v := strings.TrimSpace(row["image_url"]); v != "" {
+		p.ImageURL = v
+	}
+	if err := rowInt(row, "weight_grams", &p.WeightGrams); err != nil {
+		return err
+	}
+	for column, dest := range map[string]*float64{"length_cm": &p.LengthCm, "width_cm": &p.WidthCm, "height_cm": &p.HeightCm} {
+		if err := rowFloat(row, column, dest); err != nil {
+			return err
+		}
+	}
+	if err := rowBool(row, "is_active", &p.IsActive); err != nil {
+		return err
+	}
+	if v := strings.TrimSpace(row["category"]); v != "" {
+		p.CategoryID, p.Category = nil, v
+	}
+	if err := FileProduct(tx, p); err != nil {
+		if errors.Is(err, ErrCategoryNotFound) {
+			return rowError("category", "%q is not a category", row["category"])
+		}
+		return err
+	}
+
+	var options []models.ProductOption
+	setOptions := strings.TrimSpace(row["options"]) != ""
+	if setOptions {
+		if options, err = parseOptionTypes(row["options"]); err != nil {
+			return err
+		}
+		for _, v := range p.Variants {
+			if _, msg := VariantOptionKey(options, v.Options); msg != "" {
+				return rowError("options", "variant %s doesn't fit the new options: %s", v.SKU, msg)
+			}
+		}
+	}
+
+	if isNew {
+		if taken, err := SKUTaken(tx, p.SKU, p.ID); err != nil {
+			return err
+		} else if taken {
+			return rowError("sku", "%s is already a variant's SKU", p.SKU)
+		}
+		if err := tx.Omit("Prices", "Options", "Variants").Create(p).Error; err != nil {
+			return err
+		}
+		// Create leaves false to the column default
+		if !p.IsActive {
+			if err := tx.Model(p).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+	} else if err := tx.Omit("Prices", "Options", "Variants", "Stock", "RatingAverage", "RatingCount", "RatingHistogram").Save(p).Error; err != nil {
+		return err
+	}
+
+	if !setOptions {
+		return nil
+	}
+	if err := tx.Where("product_id = ?", p.ID).Delete(&models.ProductOption{}).Error; err != nil {
+		return err
+	}
+	for i := range options {
+		options[i].ID = uuid.New()
+		options[i].ProductID = p.ID
+		options[i].Position = i
+	}
+	return tx.Create(&options).Error
+}
+
+// applyVariant sets the row's non-empty cells on a variant of the
+// parent_sku product and saves it; a new variant needs options
+func (s *ProductJobs) applyVariant(tx *gorm.DB, v *models.ProductVariant, row map[string]string) error {
+	var product models.Product
+	query := tx.Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("position, name") })
+	if parent := strings.TrimSpace(row["parent_sku"]); parent != "" {
+		if err := query.Where("sku = ?", parent).First(&product).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return rowError("parent_sku", "no product has SKU %s", parent)
+			}
+			return err
+		}
+		if v.ProductID != uuid.Nil && v.ProductID != product.ID {
+			return rowError("parent_sku", "%s is a variant of another product", v.SKU)
+		}
+	} else if err := query.First(&product, v.ProductID).Error; err != nil {
+		return err
+	}
+	isNew := v.ProductID == uuid.Nil
+	v.ProductID = product.ID
+
+	if raw := strings.TrimSpace(row["options"]); raw != "" {
+		values, err := parseOptionValues(raw)
+		if err != nil {
+			return err
+		}
+		v.Options = values
+	} else if isNew {
+		return rowError("options", "new variants need a value for each of the product's options")
+	}
+	if len(product.Options) == 0 {
+		return rowError("parent_sku", "product %s has no options to make variants of", product.SKU)
+	}
+	key, msg := VariantOptionKey(product.Options, v.Options)
+	if msg != "" {
+		return rowError("options", "%s", msg)
+	}
+	v.OptionKey = key
+	var count int64
+	if err := tx.Model(&models.ProductVariant{}).
+		Where("product_id = ? AND option_key = ? AND id <> ?", v.ProductID, v.OptionKey, v.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return rowError("options", "the product already has a variant with these options")
+	}
+
+	currency := v.Price.Currency
+	if currency == "" {
+		currency = product.Price.Currency
+	}
+	price, err := rowPrice(row, currency)
+	if err != nil {
+		return err
+	}
+	if price != nil {
+		v.Price = *price
+	}
+	if b := strings.TrimSpace(row["barcode"]); b != "" {
+		if len(b) > 64 {
+			return rowError("barcode", "is longer than 64 characters")
+		}
+		v.Barcode = b
+	}
+	if err := rowInt(row, "weight_grams", &v.WeightGrams); err != nil {
+		return err
+	}
+	if err := rowBool(row, "is_active", &v.IsActive); err != nil {
+		return err
+	}
+
+	if !isNew {
+		return tx.Omit("Stock").Save(v).Error
+	}
+	if taken, err := SKUTaken(tx, v.SKU, v.ID); err != nil {
+		return err
+	} else if taken {
+		return rowError("sku", "%s is already a product's SKU", v.SKU)
+	}
+	if err := tx.Create(v).Error; err != nil {
+		return err
+	}
+	if !v.IsActive {
+		return tx.Model(v).Update("is_active", false).Error
+	}
+	return nil
+}
+
+// rowPrice reads the price cell in the currency cell's currency, or in
+// currency when that is empty; nil means the price is left as is
+func rowPrice(row map[string]string, currency string) (*money.Money, error) {
+	raw := strings.TrimSpace(row["price"])
+	if c := strings.TrimSpace(row["currency"]); c != "" {
+		currency = money.NormalizeCurrency(c)
+		if !money.ValidCurrency(currency) {
+			return nil, rowError("currency", "%q is not a currency", c)
+		}
+		if raw == "" {
+			return nil, rowError("price", "is required with a currency")
+		}
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	price, err := money.Parse(raw, currency)
+	if err != nil {
+		return nil, rowError("price", "%q is not an amount in %s", raw, currency)
+	}
+	if price.IsNegative() {
+		return nil, rowError("price", "can't be negative")
+	}
+	return &price, nil
+}
+
+func rowInt(row map[string]string, column string, dest *int) error {
+	raw := strings.TrimSpace(row[column])
+	if raw == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return rowError(column, "%q is not a whole number of at least 0", raw)
+	}
+	*dest = n
+	return nil
+}
+
+func rowFloat(row map[string]string, column string, dest *float64) error {
+	raw := strings.TrimSpace(row[column])
+	if raw == "" {
+		return nil
+	}
+	n, err := strconv.ParseFloat(raw, 64)
+	if err != nil || n < 0 {
+		return rowError(column, "%q is not a number of at least 0", raw)
+	}
+	*dest = n
+	return nil
+}
+
+func rowBool(row map[string]string, column string, dest *bool) error {
+	raw := strings.TrimSpace(row[column])
+	if raw == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(strings.ToLower(raw))
+	if err != nil {
+		return rowError(column, "%q is not true or false", raw)
+	}
+	*dest = b
+	return nil
+}
+
+// parseOptionTypes reads "Size=S|M|L;Color=Red|Blue"
+func parseOptionTypes(raw string) ([]models.ProductOption, error) {
+	var options []models.ProductOption
+	names := map[string]bool{}
+	for _, part := range strings.Split(raw, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, rowError("options", "%q is not Name=Value|Value", part)
+		}
+		o := models.ProductOption{Name: name, Values: strings.Split(values, "|")}
+		if msg := ValidateProductOption(&o); msg != "" {
+			return nil, rowError("options", "%s", msg)
+		}
+		if names[strings.ToLower(o.Name)] {
+			return nil, rowError("options", "option %s is listed twice", o.Name)
+		}
+		names[strings.ToLower(o.Name)] = true
+		options = append(options, o)
+	}
+	return options, nil
+}
+
+// parseOptionValues reads "Size=M;Color=Red"
+func parseOptionValues(raw string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, part := range strings.Split(raw, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, rowError("options", "%q is not Name=Value", part)
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// rowReader reads import rows as column -> cell maps. Malformed rows are
+// returned as *RowError so the import can skip them.
+type rowReader struct {
+	csv    *csv.Reader
+	header []string
+	lines  *json.Decoder
+}
+
+func newRowReader(r io.Reader, format string) (*rowReader, error) {
+	if format == models.ProductJobJSONL {
+		return &rowReader{lines: json.NewDecoder(r)}, nil
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	known := map[string]bool{}
+	for _, c := range ProductColumns {
+		known[c] = true
+	}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !known[name] {
+			return nil, fmt.Errorf("unknown column %q; columns are %s", header[i], strings.Join(ProductColumns, ","))
+		}
+		header[i] = name
+	}
+	return &rowReader{csv: reader, header: header}, nil
+}
+
+func (r *rowReader) next() (map[string]string, error) {
+	if r.lines != nil {
+		var obj map[string]any
+		if err := r.lines.Decode(&obj); err != nil {
+			if err == io.EOF {
+				return nil, err
+			}
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return map[string]string{}, rowError("", "is not a JSON object")
+			}
+			// The decoder can't resync after malformed JSON, so the rest
+			// of the file is lost; fail the job instead
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+			return nil, err
+		}
+		row := make(map[string]string, len(obj))
+		for name, value := range obj {
+			row[strings.ToLower(name)] = cellText(value)
+		}
+		return row, nil
+	}
+
+	record, err := r.csv.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return map[string]string{}, &RowError{Message: parseErr.Err.Error()}
+		}
+		return nil, err
+	}
+	if len(record) > len(r.header) {
+		return map[string]string{}, rowError("", "has %d cells but the header has %d columns", len(record), len(r.header))
+	}
+	row := make(map[string]string, len(record))
+	for i, cell := range record {
+		row[r.header[i]] = cell
+	}
+	return row, nil
+}
+
+// cellText turns a JSON value into the text a CSV cell would hold.
+// Product options may be a list, [{"name": "Size", "values": ["S", "M"]}],
+// and variant options an object, {"Size": "M"}.
+func cellText(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			o, _ := item.(map[string]any)
+			values, _ := o["values"].([]any)
+			texts := make([]string, len(values))
+			for i, value := range values {
+				texts[i] = cellText(value)
+			}
+			parts = append(parts, cellText(o["name"])+"="+strings.Join(texts, "|"))
+		}
+		return strings.Join(parts, ";")
+	case map[string]any:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := make([]string, 0, len(v))
+		for _, name := range names {
+			parts = append(parts, name+"="+cellText(v[name]))
+		}
+		return strings.Join(parts, ";")
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// exportQuery selects the products of an export filter
+func (s *ProductJobs) exportQuery(db *gorm.DB, filter map[string]string) (*gorm.DB, error) {
+	query := db.Model(&models.Product{})
+	if slug := filter["category"]; slug != "" {
+		var category models.Category
+		if err := db.Where("slug = ?", slug).First(&category).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrCategoryNotFound
+			}
+			return nil, err
+		}
+		subtree := db.Model(&models.Category{}).Select("id").Where("path = ? OR path LIKE ?", category.Path, category.Path+"/%")
+		query = query.Where("category_id IN (?)", subtree)
+	}
+	if text := strings.TrimSpace(filter["q"]); text != "" {
+		like := "%" + strings.ToLower(text) + "%"
+		query = query.Where("LOWER(name) LIKE ? OR LOWER(sku) LIKE ?", like, like)
+	}
+	if raw := filter["is_active"]; raw != "" {
+		active, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, rowError("is_active", "must be true or false")
+		}
+		query = query.Where("is_active = ?", active)
+	}
+	return query, nil
+}
+
+// runExport streams the selected products into the job's file, each
+// product followed by its variants. Progress counts products.
+func (s *ProductJobs) runExport(ctx context.Context, job *models.ProductJob) error {
+	db := s.db.WithContext(ctx)
+	query, err := s.exportQuery(db, job.Filter)
+	if err != nil {
+		return err
+	}
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return err
+	}
+	job.TotalRows = int(total)
+	s.progress(job)
+
+	var categories []models.Category
+	if err := db.Find(&categories).Error; err != nil {
+		return err
+	}
+	slugs := make(map[uuid.UUID]string, len(categories))
+	for _, c := range categories {
+		slugs[c.ID] = c.Slug
+	}
+
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := s.writeExport(query, job, slugs, pw)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	err = s.store.Put(ctx, job.FileKey, pr, contentTypes[job.Format])
+	// Unblock the writer if storing gave up early
+	pr.CloseWithError(errors.New("export aborted"))
+	if werr := <-written; werr != nil {
+		return werr
+	}
+	return err
+}
+
+func (s *ProductJobs) writeExport(query *gorm.DB, job *models.ProductJob, slugs map[uuid.UUID]string, w io.Writer) error {
+	var write func(r exportRow) error
+	var flush func() error
+	if job.Format == models.ProductJobJSONL {
+		enc := json.NewEncoder(w)
+		write = func(r exportRow) error { return enc.Encode(r) }
+		flush = func() error { return nil }
+	} else {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(ProductColumns); err != nil {
+			return err
+		}
+		write = func(r exportRow) error { return cw.Write(r.record()) }
+		flush = func() error { cw.Flush(); return cw.Error() }
+	}
+
+	var products []models.Product
+	err := query.Scopes(WithVariants).FindInBatches(&products, productJobBatch, func(tx *gorm.DB, _ int) error {
+		for _, p := range products {
+			if err := write(productRow(p, slugs)); err != nil {
+				return err
+			}
+			for _, v := range p.Variants {
+				if err := write(variantRow(p, v)); err != nil {
+					return err
+				}
+			}
+		}
+		job.ProcessedRows += len(products)
+		s.progress(job)
+		return flush()
+	}).Error
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// exportOption is an option type of an exported product, in the shape
+// the options endpoint takes
+type exportOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// exportRow is one line of an export; JSON Lines keep options structured
+type exportRow struct {
+	SKU         string  `json:"sku"`
+	ParentSKU   string  `json:"parent_sku,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Price       string  `json:"price,omitempty"`
+	Currency    string  `json:"currency,omitempty"`
+	Category    string  `json:"category,omitempty"`
+	TaxCategory string  `json:"tax_category,omitempty"`
+	Options     any     `json:"options,omitempty"`
+	Barcode     string  `json:"barcode,omitempty"`
+	WeightGrams int     `json:"weight_grams"`
+	LengthCm    float64 `json:"length_cm,omitempty"`
+	WidthCm     float64 `json:"width_cm,omitempty"`
+	HeightCm    float64 `json:"height_cm,omitempty"`
+	ImageURL    string  `json:"image_url,omitempty"`
+	IsActive    bool    `json:"is_active"`
+	Stock       int     `json:"stock"`
+}
+
+func productRow(p models.Product, slugs map[uuid.UUID]string) exportRow {
+	r := exportRow{
+		SKU:         p.SKU,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price.Decimal(),
+		Currency:    p.Price.Currency,
+		TaxCategory: p.TaxCategory,
+		WeightGrams: p.WeightGrams,
+		LengthCm:    p.LengthCm,
+		WidthCm:     p.WidthCm,
+		HeightCm:    p.HeightCm,
+		ImageURL:    p.ImageURL,
+		IsActive:    p.IsActive,
+		Stock:       p.Stock,
+	}
+	if p.CategoryID != nil {
+		r.Category = slugs[*p.CategoryID]
+	}
+	if len(p.Options) > 0 {
+		options := make([]exportOption, len(p.Options))
+		for i, o := range p.Options {
+			options[i] = exportOption{Name: o.Name, Values: o.Values}
+		}
+		r.Options = options
+	}
+	return r
+}
+
+func variantRow(p models.Product, v models.ProductVariant) exportRow {
+	r := exportRow{
+		SKU:         v.SKU,
+		ParentSKU:   p.SKU,
+		Options:     v.Options,
+		Barcode:     v.Barcode,
+		WeightGrams: v.WeightGrams,
+		IsActive:    v.IsActive,
+		Stock:       v.Stock,
+	}
+	if v.Price.Currency != "" {
+		r.Price, r.Currency = v.Price.Decimal(), v.Price.Currency
+	}
+	return r
+}
+
+// record returns the row's CSV cells in ProductColumns order
+func (r exportRow) record() []string {
+	var options string
+	switch o := r.Options.(type) {
+	case []exportOption:
+		parts := make([]string, len(o))
+		for i, option := range o {
+			parts[i] = option.Name + "=" + strings.Join(option.Values, "|")
+		}
+		options = strings.Join(parts, ";")
+	case map[string]string:
+		parts := make([]string, 0, len(o))
+		for name, value := range o {
+			parts = append(parts, name+"="+value)
+		}
+		sort.Strings(parts)
+		options = strings.Join(parts, ";")
+	}
+	number := func(f float64) string {
+		if f == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return []string{
+		r.SKU, r.ParentSKU, r.Name, r.Description, r.Price, r.Currency, r.Category, r.TaxCategory,
+		options, r.Barcode, strconv.Itoa(r.WeightGrams), number(r.LengthCm), number(r.WidthCm), number(r.HeightCm),
+		r.ImageURL, strconv.FormatBool(r.IsActive), strconv.Itoa(r.Stock),
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"sort"
+	"strings"
+
+	"go-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// VariantOptionKey checks that values has one allowed value for each
+// option, and returns them in a canonical form ("color=red;size=m"). The
+// message describes the first problem, or is "" when values fit.
+func VariantOptionKey(options []models.ProductOption, values map[string]string) (string, string) {
+	if len(values) != len(options) {
+		return "", "Variants need exactly one value for each of the product's options"
+	}
+	parts := make([]string, 0, len(options))
+	for _, o := range options {
+		value, ok := values[o.Name]
+		if !ok {
+			return "", "Missing a value for option " + o.Name
+		}
+		allowed := false
+		for _, v := range o.Values {
+			allowed = allowed || v == value
+		}
+		if !allowed {
+			return "", value + " is not a value of option " + o.Name
+		}
+		parts = append(parts, strings.ToLower(o.Name)+"="+strings.ToLower(value))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";"), ""
+}
+
+// ValidateProductOption trims the option and returns a message describing
+// the first problem, or "" when it is valid
+func ValidateProductOption(o *models.ProductOption) string {
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" {
+		return "Option names are required"
+	}
+	if len(o.Values) == 0 {
+		return "Option " + o.Name + " needs at least one value"
+	}
+	seen := map[string]bool{}
+	for i, v := range o.Values {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[strings.ToLower(v)] {
+			return "Values of option " + o.Name + " must be non-empty and distinct"
+		}
+		seen[strings.ToLower(v)] = true
+		o.Values[i] = v
+	}
+	return ""
+}
+
+// SKUTaken reports whether a product or variant other than except already
+// uses sku, counting products in the trash; products and variants share
+// one SKU namespace
+func SKUTaken(db *gorm.DB, sku string, except uuid.UUID) (bool, error) {
+	var count int64
+	if err := db.Unscoped().Model(&models.Product{}).Where("sku = ? AND id <> ?", sku, except).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		if err := db.Model(&models.ProductVariant{}).Where("sku = ? AND id <> ?", sku, except).Count(&count).Error; err != nil {
+			return false, err
+		}
+	}
+	return count > 0, nil
+}
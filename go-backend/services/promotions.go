@@ -0,0 +1,441 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reasons a promotion code can't be used
+const (
+	PromoNotFound      = "not_found"
+	PromoInactive      = "inactive"
+	PromoNotStarted    = "not_started"
+	PromoExpired       = "expired"
+	PromoUsageLimit    = "usage_limit_reached"
+	PromoUserLimit     = "user_limit_reached"
+	PromoMinOrderValue = "min_order_value"
+	PromoNotApplicable = "not_applicable"
+	PromoNotStackable  = "not_stackable"
+)
+
+// PromotionError explains why a code was rejected
+type PromotionError struct {
+	Code    string `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *PromotionError) Error() string {
+	return fmt.Sprintf("promotion %s: %s", e.Code, e.Message)
+}
+
+// PricedLine is an order line as seen by the promotions engine, priced in
+// the order's currency
+type PricedLine struct {
+	ProductID   uuid.UUID
+	VariantID   *uuid.UUID
+	CategoryID  *uuid.UUID
+	Category    string
+	TaxCategory string
+	UnitPrice   money.Money
+	Quantity    int
+	LineTotal   money.Money
+	// lineage names the line's category and its ancestors, loaded when a
+	// promotion is limited to categories
+	lineage []string
+}
+
+// AppliedDiscount is one promotion's effect. Lines holds its share of the
+// amount per order line, index-aligned with the priced lines.
+type AppliedDiscount struct {
+	Promotion    *models.Promotion `json:"-"`
+	Code         string            `json:"code"`
+	Type         string            `json:"type"`
+	Description  string            `json:"description"`
+	Amount       money.Money       `json:"amount"`
+	FreeShipping bool              `json:"free_shipping,omitempty"`
+	Lines        []money.Money     `json:"-"`
+}
+
+// DiscountResult is every promotion applied to an order
+type DiscountResult struct {
+	Applied       []AppliedDiscount `json:"applied"`
+	LineDiscounts []money.Money     `json:"line_discounts"`
+	Total         money.Money       `json:"total"`
+	FreeShipping  bool              `json:"free_shipping"`
+}
+
+// NormalizePromoCode is how codes are stored and compared
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ApplyPromotions loads the promotions for codes, checks their validity
+// window and usage limits, and evaluates them in order, each on what the
+// previous ones left of every line. Promotion amounts are converted to
+// currency with rates. With lock set the promotion rows are locked so
+// concurrent orders can't both take a code's last use. userID may be nil
+// for previews, which skips the per-user limit.
+func ApplyPromotions(tx *gorm.DB, userID *uuid.UUID, codes []string, lines []PricedLine, currency string, rates *money.Rates, lock bool) (*DiscountResult, error) {
+	result := &DiscountResult{Applied: []AppliedDiscount{}, LineDiscounts: make([]money.Money, len(lines)), Total: money.Zero(currency)}
+	for i := range result.LineDiscounts {
+		result.LineDiscounts[i] = money.Zero(currency)
+	}
+
+	var normalized []string
+	for _, code := range codes {
+		if code = NormalizePromoCode(code); code != "" && !slices.Contains(normalized, code) {
+			normalized = append(normalized, code)
+		}
+	}
+	if len(normalized) == 0 {
+		return result, nil
+	}
+	sort.Strings(normalized)
+
+	q := tx.Where("code IN ?", normalized).Order("code")
+	if lock {
+		q = q.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var promos []models.Promotion
+	if err := q.Find(&promos).Error; err != nil {
+		return nil, err
+	}
+	byCode := make(map[string]*models.Promotion, len(promos))
+	for i := range promos {
+		byCode[promos[i].Code] = &promos[i]
+	}
+	if slices.ContainsFunc(promos, func(p models.Promotion) bool { return len(p.Categories) > 0 }) {
+		var err error
+		if lines, err = withCategoryLineage(tx, lines); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	remaining := make([]money.Money, len(lines))
+	subtotal := money.Zero(currency)
+	for i, l := range lines {
+		remaining[i] = l.LineTotal
+		subtotal = subtotal.Add(l.LineTotal)
+	}
+
+	for _, code := range normalized {
+		p, ok := byCode[code]
+		if !ok {
+			return nil, &PromotionError{Code: code, Reason: PromoNotFound, Message: "code does not exist"}
+		}
+		if len(normalized) > 1 && !p.Stackable {
+			return nil, &PromotionError{Code: code, Reason: PromoNotStackable, Message: "code can't be combined with other codes"}
+		}
+		if err := checkPromotionWindow(p, now); err != nil {
+			return nil, err
+		}
+		if err := checkPromotionLimits(tx, p, userID); err != nil {
+			return nil, err
+		}
+		if p.MinOrderValue.IsPositive() {
+			minimum, err := rates.Convert(p.MinOrderValue, currency)
+			if err != nil {
+				return nil, &PromotionError{Code: code, Reason: PromoNotApplicable, Message: "code can't be used in " + currency}
+			}
+			if subtotal.Cmp(minimum) < 0 {
+				return nil, &PromotionError{Code: code, Reason: PromoMinOrderValue, Message: "order must be at least " + minimum.String()}
+			}
+		}
+
+		applied, err := EvaluatePromotion(p, lines, remaining, currency, rates)
+		if err != nil {
+			return nil, err
+		}
+		for i, share := range applied.Lines {
+			remaining[i] = remaining[i].Sub(share)
+			result.LineDiscounts[i] = result.LineDiscounts[i].Add(share)
+		}
+		result.Total = result.Total.Add(applied.Amount)
+		result.FreeShipping = result.FreeShipping || applied.FreeShipping
+		result.Applied = append(result.Applied, *applied)
+	}
+	return result, nil
+}
+
+func checkPromotionWindow(p *models.Promotion, now time.Time) error {
+	switch {
+	case !p.IsActive:
+		return &PromotionError{Code: p.Code, Reason: PromoInactive, Message: "code is not active"}
+	case p.StartsAt != nil && now.Before(*p.StartsAt):
+		return &PromotionError{Code: p.Code, Reason: PromoNotStarted, Message: "code is not valid yet"}
+	case p.EndsAt != nil && !now.Before(*p.EndsAt):
+		return &PromotionError{Code: p.Code, Reason: PromoExpired, Message: "code has expired"}
+	}
+	return nil
+}
+
+func checkPromotionLimits(tx *gorm.DB, p *models.Promotion, userID *uuid.UUID) error {
+	if p.UsageLimit > 0 && p.UsedCount >= p.UsageLimit {
+		return &PromotionError{Code: p.Code, Reason: PromoUsageLimit, Message: "code has been used up"}
+	}
+	if p.PerUserLimit > 0 && userID != nil {
+		var used int64
+		if err := tx.Model(&models.PromotionRedemption{}).
+			Where("promotion_id = ? AND user_id = ?", p.ID, *userID).
+			Count(&used).Error; err != nil {
+			return err
+		}
+		if int(used) >= p.PerUserLimit {
+			return &PromotionError{Code: p.Code, Reason: PromoUserLimit, Message: "you have already used this code"}
+		}
+	}
+	return nil
+}
+
+// EvaluatePromotion computes one promotion's discount against remaining,
+// the still undiscounted amount of each line in currency. It does not
+// check validity or limits.
+func EvaluatePromotion(p *models.Promotion, lines []PricedLine, remaining []money.Money, currency string, rates *money.Rates) (*AppliedDiscount, error) {
+	applied := &AppliedDiscount{Promotion: p, Code: p.Code, Type: p.Type, Amount: money.Zero(currency), Lines: make([]money.Money, len(lines))}
+	for i := range applied.Lines {
+		applied.Lines[i] = money.Zero(currency)
+	}
+
+	eligible := make([]int, 0, len(lines))
+	for i, l := range lines {
+		if promotionCovers(p, l) && remaining[i].IsPositive() {
+			eligible = append(eligible, i)
+		}
+	}
+
+	switch p.Type {
+	case models.PromotionFreeShipping:
+		applied.FreeShipping = true
+		applied.Description = "Free shipping"
+		return applied, nil
+
+	case models.PromotionPercentage:
+		if len(eligible) == 0 {
+			return nil, notApplicable(p)
+		}
+		pct := min(max(p.Value, 0), 100)
+		for _, i := range eligible {
+			applied.Lines[i] = remaining[i].Percent(pct)
+		}
+		applied.Description = fmt.Sprintf("%g%% off", pct)
+
+	case models.PromotionFixedAmount:
+		if len(eligible) == 0 {
+			return nil, notApplicable(p)
+		}
+		off, err := rates.Convert(p.AmountOff, currency)
+		if err != nil {
+			return nil, &PromotionError{Code: p.Code, Reason: PromoNotApplicable, Message: "code can't be used in " + currency}
+		}
+		weights := make([]int64, len(eligible))
+		base := money.Zero(currency)
+		for n, i := range eligible {
+			weights[n] = remaining[i].Amount
+			base = base.Add(remaining[i])
+		}
+		if off.IsNegative() {
+			off = money.Zero(currency)
+		}
+		for n, share := range off.Min(base).Allocate(weights) {
+			applied.Lines[eligible[n]] = share
+		}
+		applied.Description = off.String() + " off"
+
+	case models.PromotionBuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			return nil, notApplicable(p)
+		}
+		if !buyXGetY(p, lines, remaining, eligible, applied.Lines) {
+			return nil, &PromotionError{Code: p.Code, Reason: PromoNotApplicable, Message: fmt.Sprintf("buy %d eligible items to use this code", p.BuyQuantity+p.GetQuantity)}
+		}
+		applied.Description = fmt.Sprintf("Buy %d get %d", p.BuyQuantity, p.GetQuantity)
+
+	default:
+		return nil, notApplicable(p)
+	}
+
+	for _, share := range applied.Lines {
+		applied.Amount = applied.Amount.Add(share)
+	}
+	if !applied.Amount.IsPositive() {
+		return nil, notApplicable(p)
+	}
+	if p.Name != "" {
+		applied.Description = p.Name
+	}
+	return applied, nil
+}
+
+// buyXGetY discounts the cheapest eligible units: for every Buy+Get units
+// in the order, Get of them are Value percent off (free when Value is 0 or
+// 100). It reports whether any unit qualified.
+func buyXGetY(p *models.Promotion, lines []PricedLine, remaining []money.Money, eligible []int, shares []money.Money) bool {
+	units := 0
+	for _, i := range eligible {
+		units += lines[i].Quantity
+	}
+	free := units / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+	if free == 0 {
+		return false
+	}
+
+	pct := p.Value
+	if pct <= 0 || pct > 100 {
+		pct = 100
+	}
+
+	// Cheapest units first; earlier discounts reduce a line's units evenly
+	byPrice := slices.Clone(eligible)
+	sort.SliceStable(byPrice, func(a, b int) bool {
+		i, j := byPrice[a], byPrice[b]
+		return remaining[i].Amount*int64(lines[j].Quantity) < remaining[j].Amount*int64(lines[i].Quantity)
+	})
+	for _, i := range byPrice {
+		if free == 0 {
+			break
+		}
+		n := min(free, lines[i].Quantity)
+		free -= n
+		freeUnits := remaining[i].Allocate([]int64{int64(n), int64(lines[i].Quantity - n)})[0]
+		shares[i] = freeUnits.Percent(pct)
+	}
+	return true
+}
+
+func promotionCovers(p *models.Promotion, l PricedLine) bool {
+	if len(p.ProductIDs) == 0 && len(p.Categories) == 0 {
+		return true
+	}
+	if slices.Contains(p.ProductIDs, l.ProductID) {
+		return true
+	}
+	return slices.ContainsFunc(p.Categories, func(c string) bool {
+		if l.Category != "" && strings.EqualFold(c, l.Category) {
+			return true
+		}
+		return slices.ContainsFunc(l.lineage, func(name string) bool { return strings.EqualFold(c, name) })
+	})
+}
+
+// withCategoryLineage returns lines with the names and slugs of their
+// category and its ancestors in the category tree, so that a promotion on
+// a category covers the products of its subcategories too
+func withCategoryLineage(tx *gorm.DB, lines []PricedLine) ([]PricedLine, error) {
+	var ids []uuid.UUID
+	for _, l := range lines {
+		if l.CategoryID != nil {
+			ids = append(ids, *l.CategoryID)
+		}
+	}
+	if len(ids) == 0 {
+		return lines, nil
+	}
+
+	var filed []models.Category
+	if err := tx.Select("id", "path").Where("id IN ?", ids).Find(&filed).Error; err != nil {
+		return nil, err
+	}
+	pathOf := make(map[uuid.UUID]string, len(filed))
+	var paths []string
+	for _, c := range filed {
+		pathOf[c.ID] = c.Path
+		paths = append(paths, ancestorPaths(c.Path)...)
+	}
+	var ancestors []models.Category
+	if err := tx.Select("name", "slug", "path").Where("path IN ?", paths).Find(&ancestors).Error; err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]models.Category, len(ancestors))
+	for _, c := range ancestors {
+		byPath[c.Path] = c
+	}
+
+	lines = slices.Clone(lines)
+	for i, l := range lines {
+		if l.CategoryID == nil {
+			continue
+		}
+		for _, path := range ancestorPaths(pathOf[*l.CategoryID]) {
+			if c, ok := byPath[path]; ok {
+				lines[i].lineage = append(lines[i].lineage, c.Name, c.Slug)
+			}
+		}
+	}
+	return lines, nil
+}
+
+func notApplicable(p *models.Promotion) error {
+	return &PromotionError{Code: p.Code, Reason: PromoNotApplicable, Message: "code doesn't apply to any item in the order"}
+}
+
+// redeemPromotions stores the discount breakdown on the order and counts
+// each promotion use
+func redeemPromotions(tx *gorm.DB, order *models.Order, result *DiscountResult) error {
+	for _, applied := range result.Applied {
+		discount := models.OrderDiscount{
+			OrderID:     order.ID,
+			PromotionID: applied.Promotion.ID,
+			Code:        applied.Code,
+			Type:        applied.Type,
+			Description: applied.Description,
+			Amount:      applied.Amount,
+		}
+		if err := tx.Create(&discount).Error; err != nil {
+			return err
+		}
+		order.Discounts = append(order.Discounts, discount)
+
+		redemption := models.PromotionRedemption{PromotionID: applied.Promotion.ID, OrderID: order.ID, UserID: order.UserID}
+		if err := tx.Create(&redemption).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Promotion{}).Where("id = ?", applied.Promotion.ID).
+			UpdateColumn("used_count", gorm.Expr("used_count + 1")).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePromotions gives a cancelled order's code uses back. The discount
+// breakdown stays on the order.
+func releasePromotions(tx *gorm.DB, orderID uuid.UUID) error {
+	var redemptions []models.PromotionRedemption
+	if err := tx.Where("order_id = ?", orderID).Find(&redemptions).Error; err != nil {
+		return err
+	}
+	for _, r := range redemptions {
+		if err := tx.Model(&models.Promotion{}).Where("id = ? AND used_count > 0", r.PromotionID).
+			UpdateColumn("used_count", gorm.Expr("used_count - 1")).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Where("order_id = ?", orderID).Delete(&models.PromotionRedemption{}).Error
+}
+
+// ProrateRefund is what refunding quantity units of item gives back: the
+// line's price after its share of the order discounts, per unit, plus its
+// tax when the tax was charged on top of the prices
+func ProrateRefund(item models.OrderItem, quantity int, pricesIncludeTax bool) money.Money {
+	net := item.LineTotal.Sub(item.Discount.OrCurrency(item.LineTotal.Currency))
+	if !pricesIncludeTax {
+		net = net.Add(item.Tax.OrCurrency(net.Currency))
+	}
+	if item.Quantity <= 0 || quantity <= 0 {
+		return money.Zero(net.Currency)
+	}
+	quantity = min(quantity, item.Quantity)
+	return net.Allocate([]int64{int64(quantity), int64(item.Quantity - quantity)})[0]
+}
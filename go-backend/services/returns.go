@@ -0,0 +1,518 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go-backend/models"
+	"go-backend/money"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReturnTransitions lists the statuses each return status may move to.
+// Approved returns can be refunded without waiting for the goods, e.g.
+// when the customer is told to keep a damaged item.
+var ReturnTransitions = map[string][]string{
+	models.ReturnStatusRequested: {models.ReturnStatusApproved, models.ReturnStatusRejected, models.ReturnStatusCancelled},
+	models.ReturnStatusApproved:  {models.ReturnStatusReceived, models.ReturnStatusRefunded},
+	models.ReturnStatusReceived:  {models.ReturnStatusRefunded},
+	models.ReturnStatusRejected:  {},
+	models.ReturnStatusCancelled: {},
+	models.ReturnStatusRefunded:  {},
+}
+
+// ReturnReasons are the reasons a customer can give for each item
+var ReturnReasons = []string{
+	models.ReturnReasonDamaged,
+	models.ReturnReasonDefective,
+	models.ReturnReasonWrongItem,
+	models.ReturnReasonNotAsDescribed,
+	models.ReturnReasonNoLongerNeeded,
+	models.ReturnReasonOther,
+}
+
+var (
+	// ErrReturnNotFound is returned for unknown return IDs
+	ErrReturnNotFound = errors.New("return not found")
+	// ErrNotReturnable is returned for orders that aren't delivered or are
+	// past the return window
+	ErrNotReturnable = errors.New("order can't be returned")
+	// ErrInvalidReturn is returned for bad return items
+	ErrInvalidReturn = errors.New("invalid return")
+	// ErrReturnStatus is returned for moves ReturnTransitions doesn't allow
+	ErrReturnStatus = errors.New("return status change not allowed")
+	// ErrNothingToRefund is returned when a refund would be zero or the
+	// order's payments have nothing captured left
+	ErrNothingToRefund = errors.New("nothing to refund")
+)
+
+// ReturnLine asks to return quantity units of an order item
+type ReturnLine struct {
+	OrderItemID uuid.UUID `json:"order_item_id"`
+	Quantity    int       `json:"quantity"`
+	Reason      string    `json:"reason"`
+	Comment     string    `json:"comment"`
+}
+
+// ReturnInput is a customer's return request
+type ReturnInput struct {
+	Items []ReturnLine `json:"items"`
+	Note  string       `json:"note"`
+}
+
+// Returns runs return requests (RMAs) for delivered orders
+type Returns struct {
+	db       *gorm.DB
+	payments *Payments
+	window   time.Duration
+}
+
+// NewReturns accepts returns for window after delivery; zero means no limit
+func NewReturns(db *gorm.DB, payments *Payments, window time.Duration) *Returns {
+	return &Returns{db: db, payments: payments, window: window}
+}
+
+// Request opens a return for the user's delivered order. Each item can be
+// returned up to the quantity bought, less what other open or completed
+// returns already cover.
+func (s *Returns) Request(ctx context.Context, userID, orderID uuid.UUID, input ReturnInput) (*models.ReturnRequest, error) {
+	if len(input.Items) == 0 {
+		return nil, fmt.Errorf("%w: at least one item is required", ErrInvalidReturn)
+	}
+
+	var ret *models.ReturnRequest
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The order lock serializes returns of the same order so the
+		// quantity checks below can't race
+		var order models.Order
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, orderID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && order.UserID != userID) {
+			return ErrOrderNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if order.Status != models.OrderStatusDelivered {
+			return fmt.Errorf("%w: it is %s, not delivered", ErrNotReturnable, order.Status)
+		}
+		if s.window > 0 && order.DeliveredAt != nil && time.Since(*order.DeliveredAt) > s.window {
+			return fmt.Errorf("%w: the %d day return window has passed", ErrNotReturnable, int(s.window.Hours()/24))
+		}
+
+		open, err := returnedQuantities(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		items := map[uuid.UUID]models.OrderItem{}
+		for _, item := range order.Items {
+			items[item.ID] = item
+		}
+
+		ret = &models.ReturnRequest{
+			OrderID:      order.ID,
+			UserID:       userID,
+			Status:       models.ReturnStatusRequested,
+			Note:         strings.TrimSpace(input.Note),
+			RefundAmount: money.Zero(order.Currency),
+		}
+		quantities := map[string]int{}
+		for _, line := range input.Items {
+			item, ok := items[line.OrderItemID]
+			switch {
+			case !ok:
+				return fmt.Errorf("%w: item %s is not part of this order", ErrInvalidReturn, line.OrderItemID)
+			case quantities[item.ID.String()] > 0:
+				return fmt.Errorf("%w: item %s is listed twice", ErrInvalidReturn, item.ID)
+			case line.Quantity <= 0:
+				return fmt.Errorf("%w: quantity must be positive", ErrInvalidReturn)
+			case line.Quantity > item.Quantity-open[item.ID]:
+				return fmt.Errorf("%w: only %d of %s can still be returned", ErrInvalidReturn, max(item.Quantity-open[item.ID], 0), item.ProductName)
+			case !slices.Contains(ReturnReasons, line.Reason):
+				return fmt.Errorf("%w: reason must be one of %s", ErrInvalidReturn, strings.Join(ReturnReasons, ", "))
+			}
+			quantities[item.ID.String()] = line.Quantity
+			ret.Items = append(ret.Items, models.ReturnItem{
+				OrderItemID: item.ID,
+				ProductID:   item.ProductID,
+				VariantID:   item.VariantID,
+				ProductName: item.ProductName,
+				Quantity:    line.Quantity,
+				Reason:      line.Reason,
+				Comment:     strings.TrimSpace(line.Comment),
+			})
+		}
+		if err := tx.Create(ret).Error; err != nil {
+			return err
+		}
+
+		return RecordOrderEvent(tx, &models.OrderEvent{
+			OrderID: order.ID,
+			Type:    models.OrderEventReturn,
+			ActorID: &userID,
+			Note:    "Return requested",
+			Data:    map[string]any{"return_id": ret.ID, "status": ret.Status, "items": quantities},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Approve accepts a requested return; note tells the customer how to send
+// the goods back
+func (s *Returns) Approve(ctx context.Context, returnID uuid.UUID, actorID *uuid.UUID, note string) (*models.ReturnRequest, error) {
+	return s.decide(ctx, returnID, models.ReturnStatusApproved, actorID, note)
+}
+
+// Reject declines a requested return; note should say why
+func (s *Returns) Reject(ctx context.Context, returnID uuid.UUID, actorID *uuid.UUID, note string) (*models.ReturnRequest, error) {
+	return s.decide(ctx, returnID, models.ReturnStatusRejected, actorID, note)
+}
+
+// Cancel withdraws the user's own return before staff have decided on it
+func (s *Returns) Cancel(ctx context.Context, returnID, userID uuid.UUID) (*models.ReturnRequest, error) {
+	var ret *models.ReturnRequest
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if ret, err = lockReturn(tx, returnID); err != nil {
+			return err
+		}
+		if ret.UserID != userID {
+			return ErrReturnNotFound
+		}
+		return moveReturn(tx, ret, models.ReturnStatusCancelled, &userID, "Return cancelled by the customer", nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *Returns) decide(ctx context.Context, returnID uuid.UUID, to string, actorID *uuid.UUID, note string) (*models.ReturnRequest, error) {
+	var ret *models.ReturnRequest
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if ret, err = lockReturn(tx, returnID); err != nil {
+			return err
+		}
+		now := time.Now()
+		ret.StaffNote = strings.TrimSpace(note)
+		ret.DecidedBy = actorID
+		ret.DecidedAt = &now
+		return moveReturn(tx, ret, to, actorID, "Return "+to, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Receive marks an approved return as back in warehouseID, or the default
+// warehouse when it is nil. resellable maps return item IDs to how many of
+// their units can be sold again; items not in it are restocked in full
+// unless they came back damaged or defective.
+func (s *Returns) Receive(ctx context.Context, returnID uuid.UUID, actorID *uuid.UUID, warehouseID *uuid.UUID, resellable map[uuid.UUID]int) (*models.ReturnRequest, error) {
+	var ret *models.ReturnRequest
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if ret, err = lockReturn(tx, returnID); err != nil {
+			return err
+		}
+		if !slices.Contains(ReturnTransitions[ret.Status], models.ReturnStatusReceived) {
+			return fmt.Errorf("%w: cannot move return from %s to %s", ErrReturnStatus, ret.Status, models.ReturnStatusReceived)
+		}
+
+		var productIDs []uuid.UUID
+		for _, item := range ret.Items {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		if err := lockProductRows(tx, productIDs); err != nil {
+			return err
+		}
+
+		restocked := map[string]int{}
+		for i := range ret.Items {
+			item := &ret.Items[i]
+			qty, ok := resellable[item.ID]
+			if !ok && item.Reason != models.ReturnReasonDamaged && item.Reason != models.ReturnReasonDefective {
+				qty = item.Quantity
+			}
+			if qty < 0 || qty > item.Quantity {
+				return fmt.Errorf("%w: restock quantity for %s must be between 0 and %d", ErrInvalidReturn, item.ProductName, item.Quantity)
+			}
+			item.RestockedQuantity = qty
+			if err := tx.Model(item).Update("restocked_quantity", qty).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.OrderItem{}).Where("id = ?", item.OrderItemID).
+				UpdateColumn("returned_quantity", gorm.Expr("returned_quantity + ?", item.Quantity)).Error; err != nil {
+				return err
+			}
+			if qty == 0 {
+				continue
+			}
+			if err := restock(tx, item.ProductID, item.VariantID, qty, warehouseID, &models.StockMovement{
+				Type:     models.StockReturn,
+				OrderID:  &ret.OrderID,
+				ReturnID: &ret.ID,
+				ActorID:  actorID,
+			}); err != nil {
+				return err
+			}
+			restocked[stockRef(item.ProductID, item.VariantID)] += qty
+		}
+		if err := syncStock(tx, productIDs...); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		ret.ReceivedAt = &now
+		return moveReturn(tx, ret, models.ReturnStatusReceived, actorID, "Return received", map[string]any{"restocked": restocked})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Refund pays back an approved or received return through the order's
+// payments, oldest first. A zero amount refunds the returned items'
+// share of what was paid (see ProrateRefund) less anything already sent;
+// any amount is bounded by that and by what the payments have captured and
+// not yet refunded. The amount is reserved on the locked return before the
+// provider is asked, so concurrent or repeated calls can't refund twice.
+// The order's refunded total, credit note and status follow when the
+// provider confirms the refund.
+func (s *Returns) Refund(ctx context.Context, returnID uuid.UUID, actorID *uuid.UUID, amount money.Money, reason string) (*models.ReturnRequest, error) {
+	db := s.db.WithContext(ctx)
+	var ret models.ReturnRequest
+	var paid []models.Payment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockReturn(tx, returnID)
+		if err != nil {
+			return err
+		}
+		ret = *locked
+		if !slices.Contains(ReturnTransitions[ret.Status], models.ReturnStatusRefunded) {
+			return fmt.Errorf("%w: cannot move return from %s to %s", ErrReturnStatus, ret.Status, models.ReturnStatusRefunded)
+		}
+
+		var order models.Order
+		if err := tx.Preload("Items").First(&order, ret.OrderID).Error; err != nil {
+			return err
+		}
+		sent := ret.RefundAmount.OrCurrency(order.Currency)
+		amount = amount.OrCurrency(order.Currency)
+		if amount.Currency != order.Currency {
+			return fmt.Errorf("%w: refunds must be in %s, the order currency", ErrInvalidReturn, order.Currency)
+		}
+		if amount.IsNegative() {
+			return fmt.Errorf("%w: amount can't be negative", ErrInvalidReturn)
+		}
+		owed := s.returnValue(&ret, &order).Sub(sent)
+		if amount.IsZero() {
+			amount = owed
+		}
+		if !amount.IsPositive() || !owed.IsPositive() {
+			return ErrNothingToRefund
+		}
+		if amount.Amount > owed.Amount {
+			return fmt.Errorf("%w: %s exceeds the %s the returned items are still owed", ErrInvalidReturn, amount, owed)
+		}
+
+		if err := tx.Where("order_id = ? AND status IN ?", order.ID,
+			[]string{models.PaymentStatusSucceeded, models.PaymentStatusPartiallyRefunded}).
+			Order("created_at ASC").Find(&paid).Error; err != nil {
+			return err
+		}
+		refundable := money.Zero(order.Currency)
+		for _, p := range paid {
+			refundable = refundable.Add(refundableOf(p))
+		}
+		if !refundable.IsPositive() {
+			return ErrNothingToRefund
+		}
+		if amount.Amount > refundable.Amount {
+			return fmt.Errorf("%w: %s exceeds the %s captured and not yet refunded", ErrInvalidReturn, amount, refundable)
+		}
+
+		ret.RefundAmount = sent.Add(amount)
+		ret.RefundAttempts++
+		return tx.Model(&ret).Updates(map[string]any{
+			"refund_amount":   ret.RefundAmount.Amount,
+			"refund_currency": ret.RefundAmount.Currency,
+			"refund_attempts": ret.RefundAttempts,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reason = strings.TrimSpace(reason); reason == "" {
+		reason = "Return " + ret.ID.String()
+	}
+	left := amount
+	var refunds []string
+	var refundErr error
+	for _, p := range paid {
+		part := left.Min(refundableOf(p))
+		if !part.IsPositive() {
+			continue
+		}
+		key := fmt.Sprintf("return-%s-attempt-%d-payment-%s", ret.ID, ret.RefundAttempts, p.ID)
+		refund, err := s.payments.Refund(ctx, p.ID, part, reason, key)
+		if err != nil {
+			refundErr = err
+			break
+		}
+		refunds = append(refunds, refund.ID)
+		left = left.Sub(part)
+		if !left.IsPositive() {
+			break
+		}
+	}
+
+	// What the provider accepted stays recorded even when a later payment
+	// failed, so a retry only asks for the rest; the unsent part of the
+	// reservation is given back
+	err = db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockReturn(tx, ret.ID)
+		if err != nil {
+			return err
+		}
+		ret = *locked
+		ret.RefundAmount = ret.RefundAmount.Sub(left)
+		if len(refunds) == 0 {
+			return tx.Save(&ret).Error
+		}
+		data := map[string]any{"refund": amount.Sub(left).String(), "provider_refunds": refunds}
+		if refundErr != nil {
+			data["return_id"] = ret.ID
+			data["status"] = ret.Status
+			data["error"] = refundErr.Error()
+			if err := tx.Save(&ret).Error; err != nil {
+				return err
+			}
+			return RecordOrderEvent(tx, &models.OrderEvent{
+				OrderID: ret.OrderID,
+				Type:    models.OrderEventReturn,
+				ActorID: actorID,
+				Note:    "Return partially refunded",
+				Data:    data,
+			})
+		}
+		now := time.Now()
+		ret.RefundedAt = &now
+		return moveReturn(tx, &ret, models.ReturnStatusRefunded, actorID, "Return refunded", data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if refundErr != nil {
+		return nil, refundErr
+	}
+	return &ret, nil
+}
+
+// returnValue is what the returned units were paid for, with their share
+// of discounts and tax
+func (s *Returns) returnValue(ret *models.ReturnRequest, order *models.Order) money.Money {
+	items := map[uuid.UUID]models.OrderItem{}
+	for _, item := range order.Items {
+		items[item.ID] = item
+	}
+	total := money.Zero(order.Currency)
+	for _, ri := range ret.Items {
+		if item, ok := items[ri.OrderItemID]; ok {
+			total = total.Add(ProrateRefund(item, ri.Quantity, order.PricesIncludeTax))
+		}
+	}
+	return total
+}
+
+// refundableOf is what a payment has captured and not yet refunded,
+// counting refunds the provider hasn't confirmed yet
+func refundableOf(p models.Payment) money.Money {
+	refunded := p.AmountRefunded.OrCurrency(p.Currency)
+	if requested := p.AmountRefundRequested.OrCurrency(p.Currency); requested.Amount > refunded.Amount {
+		refunded = requested
+	}
+	return p.AmountCaptured.OrCurrency(p.Currency).Sub(refunded)
+}
+
+// returnedQuantities sums, per order item, the units in returns of the
+// order that haven't been rejected or cancelled
+func returnedQuantities(tx *gorm.DB, orderID uuid.UUID) (map[uuid.UUID]int, error) {
+	var rows []struct {
+		OrderItemID uuid.UUID
+		Quantity    int
+	}
+	err := tx.Model(&models.ReturnItem{}).
+		Select("return_items.order_item_id, SUM(return_items.quantity) AS quantity").
+		Joins("JOIN return_requests ON return_requests.id = return_items.return_id").
+		Where("return_requests.order_id = ? AND return_requests.status NOT IN ?", orderID,
+			[]string{models.ReturnStatusRejected, models.ReturnStatusCancelled}).
+		Group("return_items.order_item_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	open := map[uuid.UUID]int{}
+	for _, r := range rows {
+		open[r.OrderItemID] = r.Quantity
+	}
+	return open, nil
+}
+
+func lockReturn(tx *gorm.DB, returnID uuid.UUID) (*models.ReturnRequest, error) {
+	var ret models.ReturnRequest
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ret, returnID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReturnNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("return_id = ?", ret.ID).Find(&ret.Items).Error; err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// moveReturn saves ret in status to and adds the change to the order's
+// timeline
+func moveReturn(tx *gorm.DB, ret *models.ReturnRequest, to string, actorID *uuid.UUID, note string, data map[string]any) error {
+	if !slices.Contains(ReturnTransitions[ret.Status], to) {
+		return fmt.Errorf("%w: cannot move return from %s to %s", ErrReturnStatus, ret.Status, to)
+	}
+	from := ret.Status
+	ret.Status = to
+	if err := tx.Omit("Items").Save(ret).Error; err != nil {
+		return err
+	}
+
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["return_id"] = ret.ID
+	data["status"] = to
+	data["from_status"] = from
+	if ret.StaffNote != "" && (to == models.ReturnStatusApproved || to == models.ReturnStatusRejected) {
+		note += ": " + ret.StaffNote
+	}
+	return RecordOrderEvent(tx, &models.OrderEvent{
+		OrderID: ret.OrderID,
+		Type:    models.OrderEventReturn,
+		ActorID: actorID,
+		Note:    note,
+		Data:    data,
+	})
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"go-backend/models"
+	"go-backend/money"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrAddressNotFound is returned for an address_id that isn't in the
+	// shopper's address book
+	ErrAddressNotFound = errors.New("shipping: address not found")
+	// ErrInvalidAddress wraps what is missing from an inline address
+	ErrInvalidAddress = errors.New("shipping: invalid address")
+	// ErrAddressRequired is returned for a shipping method without an address
+	ErrAddressRequired = errors.New("shipping: a shipping address is required to choose a shipping method")
+	// ErrShippingMethodRequired is returned when an address has shipping
+	// options but none was chosen
+	ErrShippingMethodRequired = errors.New("shipping: choose a shipping method")
+	// ErrShippingUnavailable is returned when the chosen method can't
+	// deliver this order to this address
+	ErrShippingUnavailable = errors.New("shipping: method is not available for this address and order")
+)
+
+// ShippingOption is a method that can deliver an order, priced in the order
+// currency
+type ShippingOption struct {
+	MethodID uuid.UUID   `json:"method_id"`
+	Name     string      `json:"name"`
+	Carrier  string      `json:"carrier,omitempty"`
+	Price    money.Money `json:"price"`
+	MinDays  int         `json:"min_days,omitempty"`
+	MaxDays  int         `json:"max_days,omitempty"`
+}
+
+// Parcel is what an order ships: its weight and volume, and its value after
+// discounts for price-based rates
+type Parcel struct {
+	WeightGrams int64
+	VolumeCm3   float64
+	Value       money.Money
+}
+
+// NormalizeAddress trims the address and upper-cases its codes
+func NormalizeAddress(a models.PostalAddress) models.PostalAddress {
+	a.Name = strings.TrimSpace(a.Name)
+	a.Line1 = strings.TrimSpace(a.Line1)
+	a.Line2 = strings.TrimSpace(a.Line2)
+	a.City = strings.TrimSpace(a.City)
+	a.Region = strings.ToUpper(strings.TrimSpace(a.Region))
+	a.PostalCode = strings.ToUpper(strings.TrimSpace(a.PostalCode))
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	a.Phone = strings.TrimSpace(a.Phone)
+	return a
+}
+
+// ValidateAddress normalizes a and reports the first missing field wrapped
+// in ErrInvalidAddress
+func ValidateAddress(a *models.PostalAddress) error {
+	*a = NormalizeAddress(*a)
+	switch {
+	case a.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidAddress)
+	case a.Line1 == "":
+		return fmt.Errorf("%w: line1 is required", ErrInvalidAddress)
+	case a.City == "":
+		return fmt.Errorf("%w: city is required", ErrInvalidAddress)
+	case len(a.Country) != 2:
+		return fmt.Errorf("%w: country must be a two-letter ISO code", ErrInvalidAddress)
+	}
+	return nil
+}
+
+// shippingAddress resolves where an order ships: an entry of the user's
+// address book, or an inline address. It returns nil when opts has neither.
+func shippingAddress(db *gorm.DB, userID *uuid.UUID, opts CheckoutOptions) (*models.PostalAddress, error) {
+	switch {
+	case opts.AddressID != nil:
+		if userID == nil {
+			return nil, ErrAddressNotFound
+		}
+		var entry models.Address
+		err := db.Where("id = ? AND user_id = ?", *opts.AddressID, *userID).First(&entry).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAddressNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		addr := NormalizeAddress(entry.PostalAddress)
+		return &addr, nil
+	case opts.ShippingAddress != nil:
+		addr := *opts.ShippingAddress
+		if err := ValidateAddress(&addr); err != nil {
+			return nil, err
+		}
+		return &addr, nil
+	}
+	return nil, nil
+}
+
+// parcelFor sums the weight and volume of the priced lines
+func parcelFor(lines []PricedLine, products map[uuid.UUID]models.Product, value money.Money) Parcel {
+	parcel := Parcel{Value: value}
+	for _, line := range lines {
+		p := products[line.ProductID]
+		weight := p.WeightGrams
+		if line.VariantID != nil {
+			if v, ok := p.Variant(*line.VariantID); ok && v.WeightGrams > 0 {
+				weight = v.WeightGrams
+			}
+		}
+		parcel.WeightGrams += int64(weight) * int64(line.Quantity)
+		parcel.VolumeCm3 += p.LengthCm * p.WidthCm * p.HeightCm * float64(line.Quantity)
+	}
+	return parcel
+}
+
+// ShippingOptions lists the active methods that deliver to addr, priced for
+// parcel in currency, cheapest first. Methods whose rate table has no
+// bracket for the parcel, or whose prices can't be converted, are left out.
+func ShippingOptions(db *gorm.DB, addr models.PostalAddress, parcel Parcel, currency string, rates *money.Rates) ([]ShippingOption, error) {
+	var zones []models.ShippingZone
+	err := db.Preload("Methods", "is_active = ?", true).
+		Preload("Methods.Rates", func(db *gorm.DB) *gorm.DB { return db.Order("range_from") }).
+		Find(&zones).Error
+	if err != nil {
+		return nil, err
+	}
+
+	options := []ShippingOption{}
+	for _, zone := range matchZones(zones, addr) {
+		for _, m := range zone.Methods {
+			price, ok := methodPrice(m, parcel, currency, rates)
+			if !ok {
+				continue
+			}
+			options = append(options, ShippingOption{
+				MethodID: m.ID,
+				Name:     m.Name,
+				Carrier:  m.Carrier,
+				Price:    price,
+				MinDays:  m.MinDays,
+				MaxDays:  m.MaxDays,
+			})
+		}
+	}
+	sort.SliceStable(options, func(i, j int) bool { return options[i].Price.Amount < options[j].Price.Amount })
+	return options, nil
+}
+
+// pickShipping returns the chosen option. Without a choice it is an error
+// only when there was something to choose from.
+func pickShipping(options []ShippingOption, methodID *uuid.UUID) (*ShippingOption, error) {
+	if methodID == nil {
+		if len(options) > 0 {
+			return nil, ErrShippingMethodRequired
+		}
+		return nil, nil
+	}
+	for i := range options {
+		if options[i].MethodID == *methodID {
+			return &options[i], nil
+		}
+	}
+	return nil, ErrShippingUnavailable
+}
+
+// matchZones picks the zones for addr: zones naming its region win over
+// zones naming its country, which win over "*"
+func matchZones(zones []models.ShippingZone, addr models.PostalAddress) []models.ShippingZone {
+	keys := []string{addr.Country + "-" + addr.Region, addr.Country, "*"}
+	if addr.Region == "" {
+		keys = keys[1:]
+	}
+	for _, key := range keys {
+		var matched []models.ShippingZone
+		for _, zone := range zones {
+			for _, c := range zone.Countries {
+				if strings.EqualFold(strings.TrimSpace(c), key) {
+					matched = append(matched, zone)
+					break
+				}
+			}
+		}
+		if len(matched) > 0 {
+			return matched
+		}
+	}
+	return nil
+}
+
+// methodPrice finds the bracket for the parcel and converts its price
+func methodPrice(m models.ShippingMethod, parcel Parcel, currency string, rates *money.Rates) (money.Money, bool) {
+	var measure int64
+	switch m.RateBasis {
+	case models.ShippingByPrice:
+		value, err := rates.Convert(parcel.Value, m.Currency)
+		if err != nil {
+			return money.Money{}, false
+		}
+		measure = value.Amount
+	default:
+		measure = billableWeight(m, parcel)
+	}
+
+	for _, r := range m.Rates {
+		if measure < r.From || (r.To > 0 && measure >= r.To) {
+			continue
+		}
+		price, err := rates.Convert(r.Price, currency)
+		if err != nil {
+			return money.Money{}, false
+		}
+		return price, true
+	}
+	return money.Money{}, false
+}
+
+// billableWeight is the greater of the actual and the volumetric weight
+func billableWeight(m models.ShippingMethod, parcel Parcel) int64 {
+	if m.VolumetricDivisor <= 0 {
+		return parcel.WeightGrams
+	}
+	volumetric := int64(math.Ceil(parcel.VolumeCm3 * 1000 / float64(m.VolumetricDivisor)))
+	return max(parcel.WeightGrams, volumetric)
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+
+	"go-backend/models"
+	"go-backend/money"
+	"go-backend/tax"
+
+	"gorm.io/gorm"
+)
+
+// TaxPolicy is how checkout taxes orders. A nil Calculator charges no tax.
+// DefaultCountry is used when the shopper gives no address.
+type TaxPolicy struct {
+	Calculator       tax.Calculator
+	PricesIncludeTax bool
+	DefaultCountry   string
+}
+
+// TaxRates is the tax.Calculator over the tax_rates table
+type TaxRates struct {
+	db *gorm.DB
+}
+
+func NewTaxRates(db *gorm.DB) *TaxRates {
+	return &TaxRates{db: db}
+}
+
+func (s *TaxRates) Name() string {
+	return "rates"
+}
+
+func (s *TaxRates) Calculate(ctx context.Context, req tax.Request) (*tax.Result, error) {
+	var rows []models.TaxRate
+	addr := req.Address.Normalize()
+	if err := s.db.WithContext(ctx).Where("country = ?", addr.Country).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	table := tax.Table{Rates: make([]tax.Rate, len(rows))}
+	for i, r := range rows {
+		table.Rates[i] = tax.Rate{Name: r.Name, Country: r.Country, Region: r.Region, Category: r.Category, Rate: r.Rate}
+	}
+	return table.Calculate(ctx, req)
+}
+
+// ShippingTaxCategory is the tax category shipping charges are taxed in;
+// without a rate for it they get the standard rate
+const ShippingTaxCategory = "shipping"
+
+// taxLines taxes each priced line after its discount, and then shipping.
+// The result lines follow priced, with the shipping line last when
+// shipping isn't zero.
+func (p TaxPolicy) taxLines(ctx context.Context, addr tax.Address, currency string, priced []PricedLine, discounts []money.Money, shipping money.Money) (*tax.Result, error) {
+	lines := make([]tax.Line, len(priced), len(priced)+1)
+	for i, line := range priced {
+		lines[i] = tax.Line{Ref: line.ProductID.String(), Category: line.TaxCategory, Amount: line.LineTotal.Sub(discounts[i])}
+	}
+	if !shipping.IsZero() {
+		lines = append(lines, tax.Line{Ref: "shipping", Category: ShippingTaxCategory, Amount: shipping})
+	}
+	calculator := p.Calculator
+	if calculator == nil {
+		// An empty table matches nothing
+		calculator = tax.Table{}
+	}
+	return calculator.Calculate(ctx, tax.Request{
+		Address:          addr,
+		Currency:         currency,
+		PricesIncludeTax: p.PricesIncludeTax,
+		Lines:            lines,
+	})
+}
+
+// address is where the order ships, else the country and region in opts,
+// else the default country
+func (p TaxPolicy) address(ship *models.PostalAddress, opts CheckoutOptions) tax.Address {
+	if ship != nil {
+		return tax.Address{Country: ship.Country, Region: ship.Region}.Normalize()
+	}
+	addr := tax.Address{Country: opts.Country, Region: opts.Region}.Normalize()
+	if addr.Country == "" {
+		addr = tax.Address{Country: p.DefaultCountry}.Normalize()
+	}
+	return addr
+}
+
+// shippingTax is the tax on the shipping line of a taxLines result
+func shippingTax(result *tax.Result, priced []PricedLine, currency string) money.Money {
+	if len(result.Lines) > len(priced) {
+		return result.Lines[len(priced)].Tax
+	}
+	return money.Zero(currency)
+}
+
+// total is what the customer pays for an order: tax is inside the prices
+// when they include it and on top otherwise
+func (p TaxPolicy) total(subtotal, discount, shipping, taxTotal money.Money) money.Money {
+	total := subtotal.Sub(discount).Add(shipping)
+	if !p.PricesIncludeTax {
+		total = total.Add(taxTotal)
+	}
+	return total
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"go-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of rows that go to the trash when deleted
+const (
+	TrashProducts = "products"
+	TrashPosts    = "posts"
+	TrashOrders   = "orders"
+	TrashUsers    = "users"
+)
+
+var (
+	// ErrUnknownTrashKind is returned for kinds not listed above
+	ErrUnknownTrashKind = errors.New("unknown trash kind")
+	// ErrNotInTrash is returned for rows that don't exist or weren't deleted
+	ErrNotInTrash = errors.New("not in the trash")
+)
+
+// ReferencedError blocks purging a row that other rows still refer to;
+// References counts them per table
+type ReferencedError struct {
+	References map[string]int64
+}
+
+func (e *ReferencedError) Error() string {
+	tables := make([]string, 0, len(e.References))
+	for table := range e.References {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+	parts := make([]string, len(tables))
+	for i, table := range tables {
+		parts[i] = fmt.Sprintf("%d %s", e.References[table], table)
+	}
+	return "still referenced by " + strings.Join(parts, ", ")
+}
+
+// reference is a table whose rows matching where keep a row from being
+// purged; where takes the row's ID for every ?
+type reference struct {
+	table string
+	where string
+}
+
+// trashKind describes a soft deleted model. References keep history such
+// as orders intact; dependents are the statements that purge the rows that
+// only make sense with it, run in order with its ID for every ?.
+type trashKind struct {
+	model      func() any
+	list       func() any
+	columns    []string
+	references []reference
+	dependents []string
+}
+
+var trashKinds = map[string]trashKind{
+	TrashProducts: {
+		model: func() any { return &models.Product{} },
+		list:  func() any { return &[]models.Product{} },
+		references: []reference{
+			{"order_items", "product_id = ?"},
+			{"return_items", "product_id = ?"},
+		},
+		dependents: []string{
+			"DELETE FROM review_votes WHERE review_id IN (SELECT id FROM reviews WHERE product_id = ?)",
+			"DELETE FROM reviews WHERE product_id = ?",
+			"DELETE FROM cart_items WHERE product_id = ?",
+			"DELETE FROM stock_alerts WHERE product_id = ?",
+			"DELETE FROM stock_movements WHERE product_id = ?",
+			"DELETE FROM inventory_levels WHERE product_id = ?",
+			"DELETE FROM product_variants WHERE product_id = ?",
+			"DELETE FROM product_options WHERE product_id = ?",
+			"DELETE FROM product_prices WHERE product_id = ?",
+			"DELETE FROM price_schedules WHERE product_id = ?",
+			"DELETE FROM price_histories WHERE product_id = ?",
+		},
+	},
+	TrashPosts: {
+		model: func() any { return &models.Post{} },
+		list:  func() any { return &[]models.Post{} },
+		columns: []string{"id", "title", "slug", "locale", "author_id", "status", "published_at",
+			"created_at", "updated_at", "deleted_at"},
+		dependents: []string{
+			"DELETE FROM reactions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?)",
+			"DELETE FROM reactions WHERE target_type = 'post' AND target_id = ?",
+			"DELETE FROM comments WHERE post_id = ?",
+			"DELETE FROM bookmarks WHERE post_id = ?",
+			"DELETE FROM media_references WHERE post_id = ?",
+			"DELETE FROM post_translations WHERE post_id = ?",
+			"DELETE FROM post_tags WHERE post_id = ?",
+		},
+	},
+	TrashOrders: {
+		model: func() any { return &models.Order{} },
+		list:  func() any { return &[]models.Order{} },
+		references: []reference{
+			{"payments", "order_id = ?"},
+			{"invoices", "order_id = ?"},
+			{"return_requests", "order_id = ?"},
+			{"stock_reservations", "order_id = ? AND status = 'active'"},
+		},
+		dependents: []string{
+			"UPDATE stock_movements SET order_id = NULL WHERE order_id = ?",
+			"DELETE FROM stock_reservations WHERE order_id = ?",
+			"DELETE FROM promotion_redemptions WHERE order_id = ?",
+			"DELETE FROM order_discounts WHERE order_id = ?",
+			"DELETE FROM order_events WHERE order_id = ?",
+			"DELETE FROM order_items WHERE order_id = ?",
+		},
+	},
+	TrashUsers: {
+		model: func() any { return &models.User{} },
+		list:  func() any { return &[]models.User{} },
+		columns: []string{"id", "email", "username", "first_name", "last_name", "avatar", "role", "is_active",
+			"created_at", "updated_at", "deleted_at"},
+		// Orders in the trash count too: they still belong to the user. Staff
+		// who appear in audit trails stay for the record.
+		references: []reference{
+			{"orders", "user_id = ?"},
+			{"posts", "author_id = ?"},
+			{"comments", "author_id = ?"},
+			{"reviews", "user_id = ?"},
+			{"return_requests", "user_id = ? OR decided_by = ?"},
+			{"media_assets", "uploader_id = ?"},
+			{"order_events", "actor_id = ?"},
+			{"stock_movements", "actor_id = ?"},
+			{"price_histories", "changed_by_id = ?"},
+			{"price_schedules", "created_by_id = ?"},
+		},
+		// The user's reactions and votes come off the denormalized counters
+		// before they go
+		dependents: []string{
+			`UPDATE posts SET reaction_count = posts.reaction_count - r.n
+			FROM (SELECT target_id, COUNT(*) AS n FROM reactions WHERE target_type = 'post' AND user_id = ? GROUP BY target_id) r
+			WHERE posts.id = r.target_id`,
+			"DELETE FROM reactions WHERE user_id = ?",
+			`UPDATE reviews SET helpful_count = reviews.helpful_count - v.helpful, unhelpful_count = reviews.unhelpful_count - v.unhelpful
+			FROM (SELECT review_id, COUNT(*) FILTER (WHERE helpful) AS helpful, COUNT(*) FILTER (WHERE NOT helpful) AS unhelpful
+				FROM review_votes WHERE user_id = ? GROUP BY review_id) v
+			WHERE reviews.id = v.review_id`,
+			"DELETE FROM review_votes WHERE user_id = ?",
+			"DELETE FROM bookmarks WHERE user_id = ?",
+			"DELETE FROM cart_items WHERE cart_id IN (SELECT id FROM carts WHERE user_id = ?)",
+			"DELETE FROM carts WHERE user_id = ?",
+			"DELETE FROM addresses WHERE user_id = ?",
+			"UPDATE analytics_events SET user_id = NULL WHERE user_id = ?",
+			"UPDATE product_jobs SET created_by_id = NULL WHERE created_by_id = ?",
+		},
+	},
+}
+
+// TrashKinds lists the kinds the trash holds
+func TrashKinds() []string {
+	kinds := make([]string, 0, len(trashKinds))
+	for kind := range trashKinds {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+// Trash lists, restores and purges soft deleted rows. Rows that have been
+// in the trash longer than the retention are purged by a periodic sweep,
+// except those that are still referenced.
+type Trash struct {
+	db        *gorm.DB
+	retention time.Duration
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func NewTrash(db *gorm.DB, retention time.Duration) *Trash {
+	return &Trash{db: db, retention: retention}
+}
+
+// List returns a page of the kind's rows in the trash, most recently
+// deleted first, as a pointer to a slice of its model
+func (s *Trash) List(ctx context.Context, kind string, page, limit int) (any, int64, error) {
+	k, ok := trashKinds[kind]
+	if !ok {
+		return nil, 0, ErrUnknownTrashKind
+	}
+	query := s.db.WithContext(ctx).Unscoped().Model(k.model()).Where("deleted_at IS NOT NULL")
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if len(k.columns) > 0 {
+		query = query.Select(k.columns)
+	}
+	rows := k.list()
+	if err := query.Order("deleted_at DESC").Offset((page - 1) * limit).Limit(limit).Find(rows).Error; err != nil {
+		return nil, 0, err
+	}
+	return rows, total, nil
+}
+
+// Restore takes a row out of the trash
+func (s *Trash) Restore(ctx context.Context, kind string, id uuid.UUID) error {
+	k, ok := trashKinds[kind]
+	if !ok {
+		return ErrUnknownTrashKind
+	}
+	res := s.db.WithContext(ctx).Unscoped().Model(k.model()).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotInTrash
+	}
+	return nil
+}
+
+// Purge deletes a row in the trash for good along with its dependent rows.
+// It returns a *ReferencedError while other rows still refer to it.
+func (s *Trash) Purge(ctx context.Context, kind string, id uuid.UUID) error {
+	k, ok := trashKinds[kind]
+	if !ok {
+		return ErrUnknownTrashKind
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the row so a restore can't slip in between the checks and
+		// the delete
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+			Where("id = ? AND deleted_at IS NOT NULL", id).First(k.model()).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotInTrash
+			}
+			return err
+		}
+
+		refs := map[string]int64{}
+		for _, ref := range k.references {
+			var count int64
+			if err := tx.Table(ref.table).Where(ref.where, placeholderArgs(ref.where, id)...).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				refs[ref.table] = count
+			}
+		}
+		if len(refs) > 0 {
+			return &ReferencedError{References: refs}
+		}
+
+		for _, stmt := range k.dependents {
+			if err := tx.Exec(stmt, placeholderArgs(stmt, id)...).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(k.model(), "id = ?", id).Error
+	})
+}
+
+// PurgeExpired purges every row that has been in the trash longer than the
+// retention and returns how many it purged and how many it had to keep
+// because they are still referenced or failed to purge. A row that fails
+// is logged and skipped so it can't hold up the rest of the sweep.
+func (s *Trash) PurgeExpired(ctx context.Context) (purged, kept int, err error) {
+	cutoff := time.Now().Add(-s.retention)
+	for _, kind := range TrashKinds() {
+		var ids []uuid.UUID
+		if err := s.db.WithContext(ctx).Unscoped().Model(trashKinds[kind].model()).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Pluck("id", &ids).Error; err != nil {
+			return purged, kept, err
+		}
+		for _, id := range ids {
+			err := s.Purge(ctx, kind, id)
+			var refErr *ReferencedError
+			switch {
+			case errors.As(err, &refErr), errors.Is(err, ErrNotInTrash):
+				kept++
+			case err != nil:
+				log.Printf("ERROR: failed to purge %s %s from the trash - %v", kind, id, err)
+				kept++
+			default:
+				purged++
+			}
+		}
+	}
+	return purged, kept, nil
+}
+
+// StartSweeper purges expired rows every interval until StopSweeper is
+// called
+func (s *Trash) StartSweeper(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				purged, kept, err := s.PurgeExpired(context.Background())
+				if err != nil {
+					log.Printf("ERROR: failed to empty the trash - %v", err)
+				} else if purged > 0 || kept > 0 {
+					log.Printf("INFO: purged %d rows from the trash, kept %d that are still referenced or failed", purged, kept)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopSweeper ends the sweep loop
+func (s *Trash) StopSweeper() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.stopped
+	s.stop = nil
+}
+
+// placeholderArgs repeats id for every ? in stmt
+func placeholderArgs(stmt string, id uuid.UUID) []any {
+	args := make([]any, strings.Count(stmt, "?"))
+	for i := range args {
+		args[i] = id
+	}
+	return args
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"go-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EventPostView is the analytics event type recorded for post views
+const EventPostView = "post_view"
+
+// ViewCounter deduplicates post views per viewer and buffers them in memory.
+// Flush writes the buffered analytics events in one batch and bumps each
+// post's view_count once with the summed delta, so a hot post costs one
+// UPDATE per flush instead of one per view.
+type ViewCounter struct {
+	db *gorm.DB
+	// DedupeWindow is how long repeat views by the same viewer are ignored
+	DedupeWindow time.Duration
+	// MaxPending forces a flush once this many views are buffered
+	MaxPending int
+
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	deltas  map[uuid.UUID]int64
+	events  []models.AnalyticsEvent
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewViewCounter(db *gorm.DB) *ViewCounter {
+	return &ViewCounter{
+		db:           db,
+		DedupeWindow: 30 * time.Minute,
+		MaxPending:   500,
+		seen:         map[string]time.Time{},
+		deltas:       map[uuid.UUID]int64{},
+	}
+}
+
+// ViewerKey identifies a viewer by user ID when signed in, otherwise by a
+// hash of IP and user agent so anonymous views dedupe without storing either
+func ViewerKey(userID *uuid.UUID, ip, userAgent string) string {
+	if userID != nil {
+		return "user:" + userID.String()
+	}
+	sum := sha256.Sum256([]byte(ip + "|" + userAgent))
+	return "anon:" + hex.EncodeToString(sum[:16])
+}
+
+// Record counts a view of postID described by event unless the same viewer
+// already viewed the post within DedupeWindow. It reports whether the view
+// was counted.
+func (v *ViewCounter) Record(postID uuid.UUID, event models.AnalyticsEvent) bool {
+	now := time.Now()
+	key := postID.String() + "|" + ViewerKey(event.UserID, event.IPAddress, event.UserAgent)
+
+	v.mu.Lock()
+	if last, ok := v.seen[key]; ok && now.Sub(last) < v.DedupeWindow {
+		v.mu.Unlock()
+		return false
+	}
+	v.seen[key] = now
+
+	event.EventType = EventPostView
+	if event.EventData == "" {
+		data, _ := json.Marshal(map[string]string{"post_id": postID.String()})
+		event.EventData = string(data)
+	}
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	event.CreatedAt = now
+	v.events = append(v.events, event)
+	v.deltas[postID]++
+	full := len(v.events) >= v.MaxPending
+	v.mu.Unlock()
+
+	if full {
+		go v.flushLogged()
+	}
+	return true
+}
+
+// Pending returns the buffered, not yet flushed view count for postID
+func (v *ViewCounter) Pending(postID uuid.UUID) int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.deltas[postID]
+}
+
+// Flush writes buffered views to the database. On failure the views are put
+// back so the next flush retries them.
+func (v *ViewCounter) Flush() error {
+	v.mu.Lock()
+	events, deltas := v.events, v.deltas
+	v.events, v.deltas = nil, map[uuid.UUID]int64{}
+	v.pruneSeen(time.Now())
+	v.mu.Unlock()
+
+	if len(events) == 0 || v.db == nil {
+		return nil
+	}
+
+	err := v.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(&events, 200).Error; err != nil {
+			return err
+		}
+		for postID, n := range deltas {
+			if err := tx.Model(&models.Post{}).Where("id = ?", postID).
+				UpdateColumn("view_count", gorm.Expr("view_count + ?", n)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		v.mu.Lock()
+		v.events = append(events, v.events...)
+		for postID, n := range deltas {
+			v.deltas[postID] += n
+		}
+		v.mu.Unlock()
+	}
+	return err
+}
+
+// Start flushes every interval until Stop is called
+func (v *ViewCounter) Start(interval time.Duration) {
+	v.stop = make(chan struct{})
+	v.stopped = make(chan struct{})
+	go func() {
+		defer close(v.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				v.flushLogged()
+			case <-v.stop:
+				v.flushLogged()
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the flush loop after a final flush
+func (v *ViewCounter) Stop() {
+	if v.stop == nil {
+		return
+	}
+	close(v.stop)
+	<-v.stopped
+	v.stop = nil
+}
+
+func (v *ViewCounter) flushLogged() {
+	if err := v.Flush(); err != nil {
+		log.Printf("ERROR: failed to flush post views - %v", err)
+	}
+}
+
+// pruneSeen forgets viewers outside the dedupe window; callers hold mu
+func (v *ViewCounter) pruneSeen(now time.Time) {
+	for key, at := range v.seen {
+		if now.Sub(at) >= v.DedupeWindow {
+			delete(v.seen, key)
+		}
+	}
+}
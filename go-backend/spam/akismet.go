@@ -0,0 +1,122 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAkismetURL is the public Akismet REST endpoint
+const DefaultAkismetURL = "https://rest.akismet.com"
+
+// AkismetChecker calls an Akismet-compatible comment-check API. BaseURL can
+// point at FakeAkismet (or any compatible service) for local development.
+type AkismetChecker struct {
+	BaseURL string
+	APIKey  string
+	Blog    string
+	Client  *http.Client
+}
+
+func NewAkismetChecker(baseURL, apiKey, blog string) *AkismetChecker {
+	if baseURL == "" {
+		baseURL = DefaultAkismetURL
+	}
+	return &AkismetChecker{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		APIKey:  apiKey,
+		Blog:    blog,
+		Client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *AkismetChecker) Name() string {
+	return "akismet"
+}
+
+func (c *AkismetChecker) Check(ctx context.Context, in *Input) (Result, error) {
+	form := url.Values{
+		"api_key":              {c.APIKey},
+		"blog":                 {c.Blog},
+		"user_ip":              {in.IP},
+		"user_agent":           {in.UserAgent},
+		"referrer":             {in.Referrer},
+		"permalink":            {in.Permalink},
+		"comment_type":         {"comment"},
+		"comment_author":       {in.AuthorName},
+		"comment_author_email": {in.AuthorEmail},
+		"comment_content":      {in.Content},
+	}
+	if !in.CreatedAt.IsZero() {
+		form.Set("comment_date_gmt", in.CreatedAt.UTC().Format(time.RFC3339))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/1.1/comment-check", strings.NewReader(form.Encode()))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "nt-app/1.0 | Akismet/3.1")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return Result{}, err
+	}
+
+	switch strings.TrimSpace(string(body)) {
+	case "true":
+		if resp.Header.Get("X-akismet-pro-tip") == "discard" {
+			return Result{Verdict: Reject, Reason: "akismet: blatant spam"}, nil
+		}
+		return Result{Verdict: Spam, Reason: "akismet: spam"}, nil
+	case "false":
+		return Result{Verdict: Ham}, nil
+	}
+
+	if help := resp.Header.Get("X-akismet-debug-help"); help != "" {
+		return Result{}, fmt.Errorf("akismet: %s", help)
+	}
+	return Result{}, fmt.Errorf("akismet: unexpected response %d %q", resp.StatusCode, body)
+}
+
+// FakeAkismet is an http.Handler implementing the comment-check endpoint with
+// Akismet's documented test triggers: the author "akismet-guaranteed-spam"
+// is spam, and content containing "akismet-discard" is blatant spam. Any
+// other request is ham, and a missing api_key is answered like Akismet does
+// for an invalid key.
+func FakeAkismet() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/1.1/comment-check", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.PostForm.Get("api_key") == "" {
+			w.Header().Set("X-akismet-debug-help", "Empty \"api_key\" value")
+			io.WriteString(w, "invalid")
+			return
+		}
+
+		content := r.PostForm.Get("comment_content")
+		switch {
+		case strings.Contains(content, "akismet-discard"):
+			w.Header().Set("X-akismet-pro-tip", "discard")
+			io.WriteString(w, "true")
+		case r.PostForm.Get("comment_author") == "akismet-guaranteed-spam":
+			io.WriteString(w, "true")
+		default:
+			io.WriteString(w, "false")
+		}
+	})
+	return mux
+}
@@ -0,0 +1,127 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var linkRe = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.|\[[^\]]*\]\([^)]*\)`)
+
+// LinkChecker flags content with many links, the most common spam signal
+type LinkChecker struct {
+	// MaxLinks is the most links allowed before content is treated as spam
+	MaxLinks int
+	// ReviewLinks sends content with at least this many links to review
+	ReviewLinks int
+}
+
+func NewLinkChecker(reviewLinks, maxLinks int) *LinkChecker {
+	return &LinkChecker{ReviewLinks: reviewLinks, MaxLinks: maxLinks}
+}
+
+func (c *LinkChecker) Name() string {
+	return "links"
+}
+
+func (c *LinkChecker) Check(_ context.Context, in *Input) (Result, error) {
+	links := len(linkRe.FindAllStringIndex(in.Content, -1))
+	switch {
+	case links > c.MaxLinks:
+		return Result{Verdict: Spam, Reason: fmt.Sprintf("contains %d links", links)}, nil
+	case c.ReviewLinks > 0 && links >= c.ReviewLinks:
+		return Result{Verdict: Review, Reason: fmt.Sprintf("contains %d links", links)}, nil
+	}
+	return Result{Verdict: Ham}, nil
+}
+
+// BlocklistChecker rejects content containing blocked terms and senders on
+// blocked IPs or email domains
+type BlocklistChecker struct {
+	Terms        []string
+	IPs          []string
+	EmailDomains []string
+}
+
+// NewBlocklistChecker builds a checker from a single list where entries are
+// classified by shape: "ip:1.2.3.4", "@domain.com", anything else is a term
+func NewBlocklistChecker(entries []string) *BlocklistChecker {
+	c := &BlocklistChecker{}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.HasPrefix(entry, "ip:"):
+			c.IPs = append(c.IPs, strings.TrimPrefix(entry, "ip:"))
+		case strings.HasPrefix(entry, "@"):
+			c.EmailDomains = append(c.EmailDomains, entry)
+		default:
+			c.Terms = append(c.Terms, entry)
+		}
+	}
+	return c
+}
+
+func (c *BlocklistChecker) Name() string {
+	return "blocklist"
+}
+
+func (c *BlocklistChecker) Check(_ context.Context, in *Input) (Result, error) {
+	for _, ip := range c.IPs {
+		if in.IP == ip || (strings.HasSuffix(ip, ".") && strings.HasPrefix(in.IP, ip)) {
+			return Result{Verdict: Reject, Reason: "sender IP is blocked"}, nil
+		}
+	}
+	email := strings.ToLower(in.AuthorEmail)
+	for _, domain := range c.EmailDomains {
+		if strings.HasSuffix(email, domain) {
+			return Result{Verdict: Reject, Reason: "email domain " + domain + " is blocked"}, nil
+		}
+	}
+	content := strings.ToLower(in.Content)
+	for _, term := range c.Terms {
+		if strings.Contains(content, term) {
+			return Result{Verdict: Spam, Reason: fmt.Sprintf("contains blocked term %q", term)}, nil
+		}
+	}
+	return Result{Verdict: Ham}, nil
+}
+
+// FingerprintCounter counts earlier content with in's fingerprint from the
+// same sender - the same author anywhere, or the same IP on the same post -
+// other than in itself
+type FingerprintCounter func(ctx context.Context, in *Input, since time.Time) (int64, error)
+
+// DuplicateChecker marks content as spam when its sender already posted the
+// same normalised text within Window. Text shorter than MinLength once
+// normalised ("thanks!", "+1") is too common to count as a repost
+type DuplicateChecker struct {
+	Count     FingerprintCounter
+	Window    time.Duration
+	MinLength int
+}
+
+func NewDuplicateChecker(count FingerprintCounter, window time.Duration, minLength int) *DuplicateChecker {
+	return &DuplicateChecker{Count: count, Window: window, MinLength: minLength}
+}
+
+func (c *DuplicateChecker) Name() string {
+	return "duplicate"
+}
+
+func (c *DuplicateChecker) Check(ctx context.Context, in *Input) (Result, error) {
+	if utf8.RuneCountInString(normalise(in.Content)) < c.MinLength {
+		return Result{Verdict: Ham}, nil
+	}
+	n, err := c.Count(ctx, in, time.Now().Add(-c.Window))
+	if err != nil {
+		return Result{}, err
+	}
+	if n > 0 {
+		return Result{Verdict: Spam, Reason: "duplicate of an earlier comment"}, nil
+	}
+	return Result{Verdict: Ham}, nil
+}
@@ -0,0 +1,110 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limit allows Max events per sliding Window
+type Limit struct {
+	Max    int
+	Window time.Duration
+}
+
+// RateLimiter throttles senders per IP and per user with in-memory sliding
+// windows. Every attempt counts, including throttled ones, so a flood keeps
+// the sender blocked until it stops.
+type RateLimiter struct {
+	PerIP   []Limit
+	PerUser []Limit
+
+	mu    sync.Mutex
+	hits  map[string][]time.Time
+	calls int
+}
+
+func NewRateLimiter(perIP, perUser []Limit) *RateLimiter {
+	return &RateLimiter{PerIP: perIP, PerUser: perUser, hits: map[string][]time.Time{}}
+}
+
+func (l *RateLimiter) Name() string {
+	return "rate_limit"
+}
+
+func (l *RateLimiter) Check(_ context.Context, in *Input) (Result, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls++
+	if l.calls%1000 == 0 {
+		l.sweep(now)
+	}
+
+	if in.IP != "" {
+		if limit, exceeded := l.record("ip:"+in.IP, l.PerIP, now); exceeded {
+			return Result{Verdict: Throttle, Reason: fmt.Sprintf("more than %d comments per %s from this IP", limit.Max, limit.Window)}, nil
+		}
+	}
+	if in.AuthorID != "" {
+		if limit, exceeded := l.record("user:"+in.AuthorID, l.PerUser, now); exceeded {
+			return Result{Verdict: Throttle, Reason: fmt.Sprintf("more than %d comments per %s from this user", limit.Max, limit.Window)}, nil
+		}
+	}
+	return Result{Verdict: Ham}, nil
+}
+
+// record adds a hit for key and reports the first limit it now exceeds
+func (l *RateLimiter) record(key string, limits []Limit, now time.Time) (Limit, bool) {
+	if len(limits) == 0 {
+		return Limit{}, false
+	}
+
+	longest := time.Duration(0)
+	for _, limit := range limits {
+		longest = max(longest, limit.Window)
+	}
+
+	hits := append(prune(l.hits[key], now.Add(-longest)), now)
+	l.hits[key] = hits
+
+	for _, limit := range limits {
+		cutoff := now.Add(-limit.Window)
+		count := 0
+		for _, t := range hits {
+			if t.After(cutoff) {
+				count++
+			}
+		}
+		if count > limit.Max {
+			return limit, true
+		}
+	}
+	return Limit{}, false
+}
+
+// sweep drops keys whose hits have all aged out of every window
+func (l *RateLimiter) sweep(now time.Time) {
+	longest := time.Duration(0)
+	for _, limit := range append(append([]Limit{}, l.PerIP...), l.PerUser...) {
+		longest = max(longest, limit.Window)
+	}
+	for key, hits := range l.hits {
+		if hits = prune(hits, now.Add(-longest)); len(hits) == 0 {
+			delete(l.hits, key)
+		} else {
+			l.hits[key] = hits
+		}
+	}
+}
+
+func prune(hits []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
@@ -0,0 +1,137 @@
+package spam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Verdict is the outcome of a spam check, ordered from least to most severe
+type Verdict int
+
+const (
+	// Ham means no checker objected
+	Ham Verdict = iota
+	// Review means the content looks suspicious and needs a human
+	Review
+	// Spam means the content is almost certainly spam
+	Spam
+	// Reject means the content must not be published at all
+	Reject
+	// Throttle means the sender exceeded a rate limit; the content should
+	// not be stored
+	Throttle
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Ham:
+		return "ham"
+	case Review:
+		return "review"
+	case Spam:
+		return "spam"
+	case Reject:
+		return "reject"
+	case Throttle:
+		return "throttle"
+	}
+	return fmt.Sprintf("verdict(%d)", int(v))
+}
+
+// Input is the content being checked plus what we know about its sender
+type Input struct {
+	// ID is set when re-checking edited content, so that it isn't counted
+	// as a duplicate of itself
+	ID          string
+	Content     string
+	Fingerprint string
+	// PostID is the post the content was left on
+	PostID      string
+	AuthorID    string
+	AuthorName  string
+	AuthorEmail string
+	IP          string
+	UserAgent   string
+	Referrer    string
+	Permalink   string
+	CreatedAt   time.Time
+}
+
+// Result is a checker's verdict and a human-readable reason
+type Result struct {
+	Verdict Verdict `json:"verdict"`
+	Reason  string  `json:"reason,omitempty"`
+	Checker string  `json:"checker,omitempty"`
+}
+
+// SpamChecker inspects content and returns a verdict
+type SpamChecker interface {
+	Name() string
+	Check(ctx context.Context, in *Input) (Result, error)
+}
+
+// Pipeline runs checkers in order and returns the most severe verdict. It
+// stops at the first Spam or worse, so cheap local checks should come before
+// remote ones.
+type Pipeline struct {
+	checkers []SpamChecker
+}
+
+func NewPipeline(checkers ...SpamChecker) *Pipeline {
+	return &Pipeline{checkers: checkers}
+}
+
+func (p *Pipeline) Name() string {
+	return "pipeline"
+}
+
+// Check never fails: a checker error downgrades the result to Review so a
+// broken remote service can't silently publish or drop comments
+func (p *Pipeline) Check(ctx context.Context, in *Input) (Result, error) {
+	if in.Fingerprint == "" {
+		in.Fingerprint = Fingerprint(in.Content)
+	}
+
+	worst := Result{Verdict: Ham}
+	for _, checker := range p.checkers {
+		res, err := checker.Check(ctx, in)
+		if err != nil {
+			log.Printf("ERROR: spam checker %s failed - %v", checker.Name(), err)
+			res = Result{Verdict: Review, Reason: checker.Name() + " unavailable"}
+		}
+		res.Checker = checker.Name()
+		if res.Verdict > worst.Verdict {
+			worst = res
+		}
+		if worst.Verdict >= Spam {
+			break
+		}
+	}
+	return worst, nil
+}
+
+// Fingerprint hashes content after normalising case, punctuation and
+// whitespace so trivially altered reposts still match
+func Fingerprint(content string) string {
+	sum := sha256.Sum256([]byte(normalise(content)))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalise lowercases content and reduces it to its words, each followed by
+// a single space
+func normalise(content string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		b.WriteString(word)
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
@@ -0,0 +1,175 @@
+// Package tax computes sales tax on order lines. Checkout talks to a
+// Calculator, so the rate table here can be swapped for an external tax
+// service without touching order code.
+package tax
+
+import (
+	"context"
+	"strings"
+
+	"go-backend/money"
+)
+
+// CategoryStandard is the tax category of products that don't name one
+const CategoryStandard = ""
+
+// Address is where the goods go; it picks the jurisdiction
+type Address struct {
+	Country string `json:"country"`
+	Region  string `json:"region,omitempty"`
+}
+
+// Normalize upper-cases the country and region codes
+func (a Address) Normalize() Address {
+	return Address{Country: strings.ToUpper(strings.TrimSpace(a.Country)), Region: strings.ToUpper(strings.TrimSpace(a.Region))}
+}
+
+// Line is one taxable amount: a product line after its discounts, or a
+// charge such as shipping
+type Line struct {
+	Ref      string      `json:"ref"`
+	Category string      `json:"category"`
+	Amount   money.Money `json:"amount"`
+}
+
+// Request asks for the tax on lines shipped to Address. With
+// PricesIncludeTax the line amounts are gross and the tax is the part of
+// them that is tax; otherwise tax is added on top.
+type Request struct {
+	Address          Address
+	Currency         string
+	PricesIncludeTax bool
+	Lines            []Line
+}
+
+// Component is one tax applied to a line, e.g. a state and a county tax
+type Component struct {
+	Name   string      `json:"name"`
+	Rate   float64     `json:"rate"` // percent
+	Amount money.Money `json:"amount"`
+}
+
+// LineResult is the tax on one request line. Net excludes tax and Gross
+// includes it, whichever way the amount was given.
+type LineResult struct {
+	Ref       string      `json:"ref"`
+	Rate      float64     `json:"rate"` // combined percent
+	Tax       money.Money `json:"tax"`
+	Net       money.Money `json:"net"`
+	Gross     money.Money `json:"gross"`
+	Breakdown []Component `json:"breakdown"`
+}
+
+// Result is index-aligned with the request lines
+type Result struct {
+	Lines []LineResult `json:"lines"`
+	Total money.Money  `json:"total"`
+}
+
+// Calculator computes tax for a request
+type Calculator interface {
+	Name() string
+	Calculate(ctx context.Context, req Request) (*Result, error)
+}
+
+// Rate is a percentage charged on a category of goods in a country, or a
+// region of it. Empty Region and Category are wildcards.
+type Rate struct {
+	Name     string
+	Country  string
+	Region   string
+	Category string
+	Rate     float64 // percent
+}
+
+// Table is a Calculator over a fixed list of rates. For each line the most
+// specific matching level wins, in this order:
+//
+//	country + region + category
+//	country + region
+//	country + category
+//	country
+//
+// Every rate at the winning level applies, so a region can charge a state
+// and a local tax side by side. Lines with no match are untaxed.
+type Table struct {
+	Rates []Rate
+}
+
+func (t Table) Name() string {
+	return "table"
+}
+
+func (t Table) Calculate(_ context.Context, req Request) (*Result, error) {
+	addr := req.Address.Normalize()
+	result := &Result{Lines: make([]LineResult, len(req.Lines)), Total: money.Zero(req.Currency)}
+	for i, line := range req.Lines {
+		res := Apply(line, t.Match(addr, line.Category), req.PricesIncludeTax)
+		result.Lines[i] = res
+		result.Total = result.Total.Add(res.Tax)
+	}
+	return result, nil
+}
+
+// Match returns the rates that apply to category at addr
+func (t Table) Match(addr Address, category string) []Rate {
+	levels := []struct{ region, category string }{
+		{addr.Region, category},
+		{addr.Region, CategoryStandard},
+		{"", category},
+		{"", CategoryStandard},
+	}
+	for _, level := range levels {
+		var matched []Rate
+		for _, r := range t.Rates {
+			if strings.EqualFold(r.Country, addr.Country) && strings.EqualFold(r.Region, level.region) && strings.EqualFold(r.Category, level.category) {
+				matched = append(matched, r)
+			}
+		}
+		if len(matched) > 0 {
+			return matched
+		}
+	}
+	return nil
+}
+
+// Apply taxes one line at rates. Each component is rounded on its own when
+// tax is added on top; when it is included, the combined tax is backed out
+// of the gross amount once and split between the components, so net + tax
+// always equals what the customer was shown.
+func Apply(line Line, rates []Rate, pricesIncludeTax bool) LineResult {
+	amount := line.Amount
+	res := LineResult{Ref: line.Ref, Tax: money.Zero(amount.Currency), Net: amount, Gross: amount, Breakdown: []Component{}}
+	if len(rates) == 0 {
+		return res
+	}
+
+	var combined float64
+	for _, r := range rates {
+		combined += r.Rate
+	}
+	res.Rate = combined
+
+	if !pricesIncludeTax {
+		for _, r := range rates {
+			c := Component{Name: r.Name, Rate: r.Rate, Amount: amount.Percent(r.Rate)}
+			res.Breakdown = append(res.Breakdown, c)
+			res.Tax = res.Tax.Add(c.Amount)
+		}
+		res.Gross = amount.Add(res.Tax)
+		return res
+	}
+
+	// net = gross / (1 + rate), rounded to the minor unit
+	net := amount.Percent(100 * 100 / (100 + combined))
+	res.Net = net
+	res.Tax = amount.Sub(net)
+	weights := make([]int64, len(rates))
+	for i, r := range rates {
+		weights[i] = int64(r.Rate * 10000)
+	}
+	for i, share := range res.Tax.Allocate(weights) {
+		res.Breakdown = append(res.Breakdown, Component{Name: rates[i].Name, Rate: rates[i].Rate, Amount: share})
+	}
+	return res
+}